@@ -1,8 +1,10 @@
 package h2load
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -13,17 +15,123 @@ type CLIConfig struct {
 	H2loadConf // Embedded struct for load testing configuration
 
 	// CLI-specific settings
-	ShowStats       bool
-	ShowClientStats bool
-	LogJSON         bool
-	LogFile         string
-	Duration        time.Duration
+	ShowStats        bool
+	ShowClientStats  bool
+	ShowConnStats    bool
+	OutputFormat     string
+	OutputCompat     string
+	Store            string
+	JUnitFile        string
+	HdrLog           string
+	HdrInterval      time.Duration
+	DebugFramesFile  string
+	KeyLogFile       string
+	NotifyURL        string
+	NotifyFormat     string
+	ShowCharts       bool
+	LogJSON          bool
+	LogFormat        string
+	LogFile          string
+	LogGzip          bool
+	LogShards        int
+	LogFlushInterval time.Duration
+	LogSlowerThan    time.Duration
+	LogStatus        string
+	Duration         time.Duration
+	WaitTimeout      time.Duration // Bound of the wait for clients to finish after Duration
+
+	// Live tuning
+	ControlSocket string
+	RpsStep       float64
+
+	// Address serving the generator's own pprof profiles
+	PprofAddr string
+
+	// OTLP/HTTP traces URL the sampled request traces are exported to
+	OTLPEndpoint string
+
+	// Check the connection and print the test plan, without sending load
+	DryRun bool
+
+	// Probe sent before the load, enabled by PreflightEnabled or a path
+	PreflightEnabled bool
+	Preflight        PreflightConf
+
+	// Recovery probing after the load
+	Cooldown CooldownConf
+
+	// GraphQL operation sent as the body, read from files by Validate
+	GraphQLQueryFile string
+	GraphQLVarsFile  string
+
+	// CRUD workload, enabled by a create rate
+	Crud     CrudConf
+	CrudBody string
+
+	// Capacity search
+	FindCapacity   bool
+	CapacitySearch CapacitySearchConf
+
+	// Payload size sweep, enabled by sizes
+	SizeSweep SizeSweepConf
+
+	// Concurrency or RPS sweep, enabled by values
+	LoadSweep LoadSweepConf
+	SweepCSV  string
+
+	// A/B comparison, enabled by a variant B URL, header or server address
+	AB ABConf
+
+	// Requests sent in turn, loaded from URLFile into URLList by Validate
+	URLFile string
+
+	// Multi-phase run, loaded from PhasesFile by Validate
+	PhasesFile string
+	Phases     PhasePlan
+
+	// WebSocket soak, enabled by -websocket or a ws:// or wss:// URL
+	WebSocketMode bool
+	WebSocket     WebSocketConf
+
+	// gRPC streaming calls, enabled by a stream type
+	GRPCStream      string
+	GRPCMessageFile string
+	GRPC            GRPCStreamConf
+
+	// Server push run, enabled by accept or reject
+	Push string
+
+	// Stream priority run, enabled by classes
+	Priority PriorityConf
+
+	// Handshake-only run
+	Handshakes bool
+	Handshake  HandshakeConf
+
+	// Idle connection hold, enabled by a number of connections
+	Hold HoldConf
+
+	// Rapid reset security test, enabled by a rate
+	RapidReset RapidResetConf
+
+	// Slow body robustness test, enabled by a chunk interval
+	SlowBody SlowBodyConf
+
+	// Profile the options were loaded from, expanded before parsing
+	Profile string
 
 	// Help
-	ShowHelp bool
+	ShowHelp    bool
+	ShowVersion bool
 }
 
+// ParseFlags parses the run options from the command line
 func ParseFlags() *CLIConfig {
+	return ParseArgs(os.Args[1:])
+}
+
+// ParseArgs parses the run options from args
+func ParseArgs(args []string) *CLIConfig {
 	config := &CLIConfig{}
 
 	// Define flags for H2loadConf fields
@@ -33,66 +141,437 @@ func ParseFlags() *CLIConfig {
 	flag.IntVar(&config.Requests, "requests", 1, "Number of requests per client")
 	flag.IntVar(&config.Requests, "n", 1, "Number of requests per client (shorthand)")
 
-	flag.IntVar(&config.Clients, "clients", 1, "Number of concurrent clients")
-	flag.IntVar(&config.Clients, "c", 1, "Number of concurrent clients (shorthand)")
+	flag.IntVar(&config.Clients, "clients", 1, "Number of concurrent clients, each on one connection")
+	flag.IntVar(&config.Clients, "c", 1, "Number of concurrent clients, each on one connection (shorthand)")
 
 	flag.IntVar(&config.ConcurrentStreams, "streams", 1, "Number of concurrent streams per client")
 	flag.IntVar(&config.ConcurrentStreams, "s", 1, "Number of concurrent streams per client (shorthand)")
 
 	flag.IntVar(&config.Rps, "rps", 0, "Requests per second (0 = unlimited)")
 	flag.IntVar(&config.Rps, "r", 0, "Requests per second (shorthand)")
+	flag.IntVar(&config.TotalRps, "total-rps", 0, "Requests per second shared by all clients (0 = unlimited)")
+	flag.BoolVar(&config.ClosedLoop.Enabled, "closed-loop", false, "Run -s workers per client, each sending its next request once its last one completed, instead of at a rate")
+	flag.DurationVar(&config.ClosedLoop.ThinkTime, "think-time", 0, "Closed loop: pause of a worker between a response and its next request (0 = none)")
+	flag.IntVar(&config.Users, "users", 0, "Simulate this many virtual users, each with its own cookies and sending one request at a time, dealt out to the clients (0 = none)")
+	flag.Var(&extractorsValue{&config.Extract}, "extract", "Users: pull a value out of every response into a variable replacing {NAME} in the URL and headers of the user's next requests, given as NAME=json:PATH, NAME=regex:PATTERN or NAME=header:HEADER (repeatable)")
+
+	flag.Var(&headerValue{&config.Headers}, "header", "Header added to every request, e.g. 'Authorization: Bearer x' (repeatable)")
+	flag.Var(&headerValue{&config.Headers}, "H", "Header added to every request (shorthand)")
+	flag.StringVar(&config.RequestID.Header, "request-id-header", "", "Send a random UUID in this header with every request and log it, e.g. X-Request-Id")
+	flag.BoolVar(&config.RequestID.TraceParent, "traceparent", false, "Send a W3C traceparent starting a new trace with every request and log its trace ID")
+	flag.StringVar(&config.Method, "method", "", "Request method (default: GET, POST with -F)")
+	flag.StringVar(&config.URLFile, "url-file", "", "Send the requests of this file in turn, one '[METHOD] URL' per line, e.g. 'HEAD /health', relative URLs resolved against -url, POST, PUT and PATCH sending the -body-* or -F body")
+	flag.Var(&byteSizeValue{&config.Body.Size}, "body-size", "Send a generated body of this size with every request, e.g. 10MB, streamed as it is sent (default: no body)")
+	flag.StringVar(&config.Body.File, "body-file", "", "Stream this file as the body of every request")
+	flag.Var(&formFieldValue{&config.Body.Form}, "form", "Send a multipart/form-data body with this field, name=value or name=@file[;type=mime/type] (repeatable)")
+	flag.Var(&formFieldValue{&config.Body.Form}, "F", "Form field (shorthand)")
+	flag.Var(&sizeDistValue{&config.Body.Random}, "body-dist", "Send random bodies with sizes drawn from fixed:SIZE, uniform:MIN-MAX or lognormal:MEDIAN[,SIGMA[,MAX]]")
+	flag.StringVar(&config.GraphQLQueryFile, "graphql-query", "", "Send the GraphQL operation in this file as a JSON POST body, failing 2xx responses with GraphQL errors")
+	flag.StringVar(&config.GraphQLVarsFile, "graphql-vars", "", "GraphQL: file holding a JSON object of the operation's variables")
+	flag.StringVar(&config.GraphQL.OperationName, "graphql-operation", "", "GraphQL: operation run when the query holds several")
+	flag.Var(&headerValue{&config.Trailers.Send}, "trailer", "Trailer sent after every request body, e.g. 'x-checksum: abc' (repeatable, requires a body)")
+	flag.Var(&headerValue{&config.Trailers.Expect}, "expect-trailer", "Fail responses without this trailer and value, e.g. 'grpc-status: 0' (repeatable)")
+	flag.Var(&encodingsValue{&config.Compression.Encodings}, "compressed", "Request compressed responses with these encodings, e.g. gzip,br (default: none)")
+	flag.BoolVar(&config.Compression.Decompress, "decompress", true, "Decompress gzip and deflate responses, timing it (br responses are only counted)")
+	flag.BoolVar(&config.Revalidate, "revalidate", false, "Send the ETag and Last-Modified of every client's last response to a URL back as If-None-Match and If-Modified-Since, and report the 304 hit rate and bandwidth saved")
+	flag.BoolVar(&config.ExpectContinue.Enabled, "expect-continue", false, "Send Expect: 100-continue on requests with a body, timing the 100 Continue and counting the requests rejected before their body was sent")
+	flag.DurationVar(&config.ExpectContinue.Timeout, "expect-continue-timeout", time.Second, "Expect continue: how long to wait for 100 Continue before sending the body anyway, greater than 0")
+	flag.Var(&percentValue{&config.CORS.Fraction}, "cors", "Send a CORS preflight OPTIONS ahead of this percentage of the requests, timed apart from them, e.g. 30%")
+	flag.StringVar(&config.CORS.Origin, "cors-origin", "", "CORS: Origin of the simulated page, sent with every request, e.g. https://app.example.com")
+	flag.StringVar(&config.CORS.RequestMethod, "cors-method", "", "CORS: Access-Control-Request-Method of the preflights (default: the request's method)")
+	flag.Var(&headerNamesValue{&config.CORS.RequestHeaders}, "cors-headers", "CORS: Access-Control-Request-Headers of the preflights, comma-separated (default: the request's non-safelisted headers)")
+	flag.Var(&redirectsValue{&config.FollowRedirects}, "follow-redirects", "Follow up to this many redirects per request, 10 if given without a value (default: off, 3xx responses are recorded)")
 
 	var rpsMode string
-	flag.StringVar(&rpsMode, "rps-mode", "burst", "RPS mode: 'burst' or 'even'")
+	flag.StringVar(&rpsMode, "rps-mode", "burst", "RPS mode: 'burst', 'even' or 'jitter'")
+	flag.Var(newPercentValue(&config.IntervalJitter, 10), "interval-jitter", "Jitter mode: random skew of every interval between sends, e.g. 20%")
+	flag.Var(newPercentValue(&config.RpsJitter, 0), "rps-jitter", "Random per-client skew of -rps, e.g. 10% (0 = none)")
+	flag.BoolVar(&config.StrictSchedule, "strict-schedule", false, "Schedule every send of -rps or -total-rps ahead, sending late rather than skipping when the generator falls behind, and report the late sends")
+	flag.Var(&spikeValue{&config.Spike}, "spike", "Raise the rate of -rps or -total-rps by RPS for DURATION every EVERY, given as rps=RPS,duration=DURATION,every=EVERY, and report the spikes' requests separately")
+	flag.Var(&delayDistValue{&config.SendDelay}, "send-delay", "Hold every request back for a delay drawn from fixed:DELAY, uniform:MIN-MAX or lognormal:MEDIAN[,SIGMA[,MAX]] before sending it, emulating clients on a WAN")
+	flag.BoolVar(&config.Socket.Nagle, "nagle", false, "Enable Nagle's algorithm on every connection, clearing TCP_NODELAY")
+	flag.Var(&byteSizeValue{&config.Socket.SendBuffer}, "sndbuf", "SO_SNDBUF of every connection, e.g. 256KB (0 = the OS's)")
+	flag.Var(&byteSizeValue{&config.Socket.RecvBuffer}, "rcvbuf", "SO_RCVBUF of every connection, e.g. 4MB, set before connecting (0 = the OS's)")
+	flag.IntVar(&config.Socket.DSCP, "dscp", 0, "DSCP class the packets of every connection are marked with, 0-63, e.g. 46 for EF (0 = unmarked)")
+	flag.StringVar(&config.Throttle.Profile, "throttle", "", "Cap the bandwidth of every connection to a network profile: "+networkProfileNames())
+	flag.Var(&bitRateValue{&config.Throttle.ReadRate}, "throttle-down", "Cap the download bandwidth of every connection, e.g. 750kbit, overriding -throttle's")
+	flag.Var(&bitRateValue{&config.Throttle.WriteRate}, "throttle-up", "Cap the upload bandwidth of every connection, e.g. 250kbit, overriding -throttle's")
+	flag.Uint64Var(&config.Seed, "seed", 0, "Seed of the run's random choices, printed with the configuration to reproduce a run (0 = random)")
 	flag.StringVar(&config.ServerAddress, "server", "", "Server address override (host:port)")
+	flag.Var(&targetsValue{&config.Targets}, "targets", "Shard the clients over these server addresses, client i dialing address i mod N, given as HOST:PORT[,HOST:PORT]... (repeatable)")
+	flag.Var(&connectToValue{&config.ConnectTo}, "connect-to", "Dial HOST2:PORT2 for requests to HOST1:PORT1, given as HOST1:PORT1:HOST2:PORT2 (repeatable)")
+	flag.Var(&resolveValue{&config.Resolve}, "resolve", "Resolve HOST:PORT to these addresses, given as HOST:PORT:ADDR[,ADDR]... (repeatable)")
+	flag.Var(&ipVersionValue{&config.IPVersion}, "ip-version", "Dial only IPv4 (4) or IPv6 (6) addresses, or both with Happy Eyeballs (auto)")
+	flag.StringVar(&config.DNSServer, "dns-server", "", "DNS server resolving the target host, e.g. 10.0.0.53:53 (default: the system resolver)")
 	flag.StringVar(&config.Protocol, "protocol", "", "Protocol override")
+	flag.BoolVar(&config.Mesh.Enabled, "mesh", false, "Send h2c through a local service mesh sidecar, keeping the URL host as :authority")
+	flag.StringVar(&config.Mesh.SidecarAddress, "mesh-sidecar", DefaultMeshSidecarAddress, "Mesh: sidecar address to dial")
+	flag.DurationVar(&config.Mesh.UpstreamTimeout, "mesh-timeout", 0, "Mesh: upstream timeout sent as x-envoy-upstream-rq-timeout-ms (0 = not sent)")
+	flag.Var(&headerValue{&config.Mesh.Headers}, "mesh-header", "Mesh: extra header added to every request, e.g. 'x-envoy-max-retries: 0' (repeatable)")
+	flag.DurationVar(&config.CalmBackoff, "calm-backoff", 2*time.Second, "Pause a client's new requests for this long after ENHANCE_YOUR_CALM (0 = don't back off)")
+	flag.IntVar(&config.MaxStreamErrors, "max-stream-errors", 0, "Recycle a connection after this many stream errors or a GOAWAY with an error (0 = never)")
+	flag.BoolVar(&config.FlowStalls, "flow-stalls", false, "Time how long each request is blocked on HTTP/2 flow control windows, reported as stalled time")
+	flag.BoolVar(&config.RespectMaxStreams, "respect-max-streams", false, "Cap the concurrent streams per client to the server's MAX_CONCURRENT_STREAMS")
+	flag.IntVar(&config.DebugFrames.Streams, "debug-frames", 0, "Log the HTTP/2 frames sent and received on the run's first N streams")
+	flag.Float64Var(&config.DebugFrames.Sample, "debug-frames-sample", 0, "Debug frames: also log the frames of this fraction of the other streams, e.g. 0.001")
+	flag.StringVar(&config.DebugFramesFile, "debug-frames-file", "", "Debug frames: write the frames to this file (default: stderr)")
+	flag.StringVar(&config.KeyLogFile, "keylog", "", "Append the TLS secrets of every connection to this file in NSS key log format, to decrypt captures in Wireshark")
 
 	// CLI-specific flags
+	flag.StringVar(&config.Capture.Dir, "capture-dir", "", "Write the responses of failed requests to this directory (default: disabled)")
+	flag.IntVar(&config.Capture.Max, "capture-max", 20, "Capture: most failed responses written per run")
+	flag.IntVar(&config.Capture.Every, "capture-every", 1, "Capture: write one in every N failed responses")
+	config.Capture.MaxBodySize = 64 << 10
+	flag.Var(&byteSizeValue{&config.Capture.MaxBodySize}, "capture-max-body", "Capture: bytes of each response body kept")
+	flag.Var(&byteSizeValue{&config.MaxMemory}, "max-memory", "Cap the generator's memory, e.g. 2GB, shedding log lines and traces near it (0 = no cap)")
+	flag.IntVar(&config.LatencyProfileRate, "profile-latency", 0, "Attribute the latency of one in every N requests to generator-side and network/server phases (0 = disabled)")
+	flag.StringVar(&config.PprofAddr, "pprof-addr", "", "Serve the generator's net/http/pprof profiles on this address during the run, e.g. localhost:6060")
+	flag.StringVar(&config.OTLPEndpoint, "otlp-endpoint", "", "Export sampled request traces as client spans to this OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces")
+	flag.IntVar(&config.TraceSamplesPerMinute, "trace-samples", 600, "OTLP: requests traced per minute over all clients (0 = every request)")
 	flag.BoolVar(&config.ShowStats, "stats", true, "Show aggregated statistics")
 	flag.BoolVar(&config.ShowClientStats, "client-stats", false, "Show individual client statistics")
+	flag.BoolVar(&config.ShowConnStats, "connection-stats", false, "Show the statistics of every connection")
+	flag.BoolVar(&config.ShowCharts, "charts", true, "Show a latency histogram and an RPS sparkline")
+	flag.StringVar(&config.Store, "store", "", "Append the run's config, stats and per-second series to this SQLite file")
+	flag.StringVar(&config.HdrLog, "hdr-log", "", "Write the raw latency histograms to this HdrHistogram interval log")
+	flag.DurationVar(&config.HdrInterval, "hdr-interval", time.Second, "Interval of the histograms written to -hdr-log")
+	flag.StringVar(&config.JUnitFile, "junit", "", "Write the run's threshold checks to this JUnit XML file")
+	flag.StringVar(&config.NotifyURL, "notify-url", "", "POST the summary to this webhook when the run finishes or aborts")
+	flag.StringVar(&config.NotifyFormat, "notify-format", "json", "Webhook payload: 'json' summary or 'slack' message")
+	flag.StringVar(&config.OutputFormat, "o", "", "Write a json or yaml summary to stdout, other output goes to stderr")
+	flag.StringVar(&config.OutputCompat, "output-compat", "", "Print the summary to stdout in the layout of another tool: 'h2load' (nghttp2)")
 	flag.BoolVar(&config.LogJSON, "json", false, "Output logs in JSON format")
+	flag.StringVar(&config.LogFormat, "log-format", "", "Custom log line template, e.g. '{{.Timestamp}} {{.Status}} {{.LatencyMs}} {{.ClientID}}'")
 	flag.StringVar(&config.LogFile, "log-file", "", "Log file path (logs to stdout if not specified)")
+	flag.BoolVar(&config.LogGzip, "log-gzip", false, "Gzip-compress the log file")
+	flag.IntVar(&config.LogShards, "log-shards", 1, "Split the log file across this many files, each with its own writer")
+	flag.DurationVar(&config.LogSlowerThan, "log-slower-than", 0, "Only log requests slower than this (0 = log all)")
+	flag.StringVar(&config.LogStatus, "log-status", "", "Only log these statuses, e.g. 5xx,429,0 (0 = no response)")
+	flag.DurationVar(&config.LogFlushInterval, "log-flush-interval", 100*time.Millisecond, "Interval at which buffered log lines are flushed")
 	flag.DurationVar(&config.Duration, "duration", 0, "Test duration (overrides -n requests)")
+	flag.BoolVar(&config.Close.Graceful, "graceful-close", false, "At the end, send GOAWAY and close connections once their streams finish, reporting the close latency")
+	flag.DurationVar(&config.Close.WaitGoAway, "close-wait-goaway", 0, "Graceful close: wait up to this long for the server's GOAWAY before closing (0 = don't wait)")
+	flag.DurationVar(&config.WaitTimeout, "wait-timeout", 30*time.Second, "After -duration, give up on clients that don't finish within this long, printing what is stuck (0 = wait forever)")
+	flag.DurationVar(&config.RampDown, "ramp-down", 0, "At the end, wait up to this long for requests in flight, then abandon them (0 = wait for all)")
+
+	flag.IntVar(&config.Retry.Max, "retries", 0, "Retry a failed request up to this many times (0 = don't retry)")
+	flag.DurationVar(&config.Retry.Backoff, "retry-backoff", 100*time.Millisecond, "Delay before the first retry, doubled before each next one")
+	config.Retry.On = RetryOnAll
+	flag.Var(&retryOnValue{&config.Retry.On}, "retry-on", "Failures retried: comma-separated 5xx, timeout, reset")
+	flag.DurationVar(&config.Retry.Timeout, "retry-timeout", 0, "Timeout of each attempt, retried as a timeout (0 = none)")
+
+	flag.Var(newPercentValue(&config.Abort.ErrorRate, 0), "abort-on-error-rate", "Stop the run when the error rate over a window exceeds this, e.g. 10% (0 = disabled)")
+	flag.DurationVar(&config.Abort.P99, "abort-on-p99", 0, "Stop the run when the p99 latency over a window exceeds this (0 = disabled)")
+	flag.DurationVar(&config.Abort.Window, "abort-window", 5*time.Second, "Length of the window the abort thresholds are checked over")
+	flag.Int64Var(&config.Abort.MinRequests, "abort-min-requests", 20, "Requests needed in a window before the abort thresholds are checked")
 
+	flag.DurationVar(&config.LatencyTarget.Target, "latency-target", 0, "Adjust -total-rps during the run to hold a latency percentile at this value")
+	flag.Float64Var(&config.LatencyTarget.Percentile, "latency-target-percentile", 99, "Latency target: percentile to control")
+	flag.DurationVar(&config.LatencyTarget.Interval, "latency-target-interval", time.Second, "Latency target: how often the RPS limit is adjusted")
+	flag.IntVar(&config.LatencyTarget.MinRps, "latency-target-min-rps", 1, "Latency target: lowest RPS limit")
+	flag.IntVar(&config.LatencyTarget.MaxRps, "latency-target-max-rps", 0, "Latency target: highest RPS limit (0 = unbounded)")
+
+	flag.BoolVar(&config.DryRun, "dry-run", false, "Resolve the host, complete the TLS and HTTP/2 handshakes, print the test plan and exit without sending load")
+	flag.BoolVar(&config.PreflightEnabled, "preflight", false, "Send a single GET before starting the clients and abort the run if it fails")
+	flag.StringVar(&config.Preflight.Path, "preflight-path", "", "Pre-flight: path probed, e.g. /healthz, implies -preflight (default: -url)")
+	flag.DurationVar(&config.Preflight.Timeout, "preflight-timeout", defaultPreflightTimeout, "Pre-flight: bound of the probe, connecting included")
+
+	flag.DurationVar(&config.Cooldown.Duration, "cooldown", 0, "After the load, probe for up to this long and report when latency returns to baseline (0 = disabled)")
+	flag.IntVar(&config.Cooldown.Rate, "cooldown-rate", 5, "Cooldown: probes per second")
+	flag.Var(newPercentValue(&config.Cooldown.Tolerance, 50), "cooldown-tolerance", "Cooldown: allowed slowdown over the baseline latency")
+
+	flag.IntVar(&config.Crud.CreateRps, "crud-create-rps", 0, "CRUD: POST new resources to -url at this rate, enabling the CRUD workload")
+	flag.IntVar(&config.Crud.ReadRps, "crud-read-rps", 0, "CRUD: GET previously created resources at this rate")
+	flag.IntVar(&config.Crud.DeleteRps, "crud-delete-rps", 0, "CRUD: DELETE previously created resources at this rate")
+	flag.StringVar(&config.CrudBody, "crud-body", "", "CRUD: body of create requests, or @file to read it from a file")
+	flag.StringVar(&config.Crud.ContentType, "crud-content-type", "application/json", "CRUD: Content-Type of create requests")
+	flag.StringVar(&config.Crud.ResourceURL, "crud-resource-url", "", "CRUD: resource URL with an {id} placeholder (default: -url/{id})")
+	flag.StringVar(&config.Crud.IDField, "crud-id-field", "id", "CRUD: JSON field of the create response holding the ID (falls back to the Location header)")
+
+	flag.Var(&sizesValue{&config.SizeSweep.Sizes}, "sweep-sizes", "Run the test once per size, e.g. 1KB,10KB,100KB,1MB, as request bodies with -sweep-body or in place of {size} in the URL")
+	flag.BoolVar(&config.SizeSweep.Body, "sweep-body", false, "Size sweep: send a generated request body of each size")
+	flag.Var(&sweepValuesValue{&config.LoadSweep, SweepStreams}, "sweep-streams", "Run the test once per concurrent streams value, e.g. 1,2,4,8 or 10-100:10")
+	flag.Var(&sweepValuesValue{&config.LoadSweep, SweepRps}, "sweep-rps", "Run the test once per total RPS value, e.g. 100,200,400 or 100-1000:100")
+	flag.StringVar(&config.SweepCSV, "sweep-csv", "", "Load sweep: also write the steps as CSV to this file")
+	flag.StringVar(&config.AB.URL, "ab-url", "", "A/B: split the clients between -url (A) and this URL (B) and compare them")
+	flag.Var(&headerValue{&config.AB.Headers}, "ab-header", "A/B: header of variant B, replacing A's of the same name, e.g. 'x-canary: 1' (repeatable)")
+	flag.StringVar(&config.AB.ServerAddress, "ab-server", "", "A/B: server address (host:port) variant B dials")
+	flag.StringVar(&config.PhasesFile, "phases", "", "Run the phases of this JSON plan one after the other, each with its duration, rps, clients, streams and scenario")
+	flag.BoolVar(&config.WebSocketMode, "websocket", false, "Open WebSocket streams over HTTP/2 (RFC 8441) and send messages on them instead of requests, implied by a ws:// or wss:// URL")
+	flag.IntVar(&config.WebSocket.Rate, "ws-rate", 10, "WebSocket: messages per second per stream (0 = as fast as the stream allows)")
+	config.WebSocket.Size = 64
+	flag.Var(&byteSizeValue{&config.WebSocket.Size}, "ws-size", "WebSocket: payload size of every message, at least 8 bytes (default: 64B)")
+	flag.IntVar(&config.WebSocket.Messages, "ws-messages", 0, "WebSocket: messages per stream (default: -n, or until -duration)")
+	flag.StringVar(&config.WebSocket.Subprotocol, "ws-subprotocol", "", "WebSocket: Sec-WebSocket-Protocol requested")
+	flag.StringVar(&config.GRPCStream, "grpc-stream", "", "Make gRPC streaming calls of this type to the method at -url instead of requests: bidi, server or client")
+	flag.StringVar(&config.GRPCMessageFile, "grpc-message", "", "gRPC: file holding the serialized request message (default: an empty message)")
+	flag.IntVar(&config.GRPC.Messages, "grpc-messages", 10, "gRPC: messages sent per bidi or client streaming call (0 = until -duration)")
+	flag.IntVar(&config.GRPC.Rate, "grpc-rate", 10, "gRPC: messages per second per call (0 = as fast as the stream allows)")
+	flag.StringVar(&config.Push, "push", "", "Send requests with server push enabled and accept or reject the pushed streams: accept or reject")
+	flag.Var(&priorityClassValue{&config.Priority.Classes}, "priority-class", "Send GETs of this stream priority class instead of requests: name=weight[,parent=class][,exclusive][,url=URL] (repeatable)")
+	flag.BoolVar(&config.Handshakes, "handshakes", false, "Only open connections, completing the TCP, TLS and HTTP/2 handshakes, and close them instead of sending requests")
+	flag.IntVar(&config.Handshake.Rate, "handshake-rate", 0, "Handshakes: handshakes per second started by the whole run (0 = as fast as they complete)")
+	flag.IntVar(&config.Hold.Connections, "hold", 0, "Open this many connections over all clients and hold them idle for -duration instead of sending requests")
+	flag.IntVar(&config.Hold.Rate, "hold-rate", 0, "Hold: connections opened per second (0 = all at once)")
+	flag.DurationVar(&config.Hold.Ping, "hold-ping", 0, "Hold: send a PING on every connection at this interval (0 = none)")
+	flag.IntVar(&config.RapidReset.Rate, "rapid-reset", 0, "Security test, only against servers you operate: open and immediately reset this many streams per second for -duration, reporting the server's mitigations")
+	flag.DurationVar(&config.SlowBody.Interval, "slow-body", 0, "Robustness test: keep -s streams per client sending their bodies a chunk at this interval for -duration, reporting how the server ends them")
+	flag.IntVar(&config.SlowBody.Chunk, "slow-body-chunk", 1, "Slow body: bytes sent per interval on each stream")
+	flag.BoolVar(&config.FindCapacity, "find-capacity", false, "Search for the highest sustainable total RPS instead of running a single test")
+	flag.IntVar(&config.CapacitySearch.StartRps, "capacity-start-rps", 100, "Capacity search: first total RPS tried")
+	flag.IntVar(&config.CapacitySearch.MaxRps, "capacity-max-rps", 0, "Capacity search: upper bound for the total RPS (0 = unbounded)")
+	flag.IntVar(&config.CapacitySearch.Precision, "capacity-precision", 10, "Capacity search: stop once the pass/fail gap is within this many RPS")
+	flag.DurationVar(&config.CapacitySearch.StepDuration, "capacity-step", 10*time.Second, "Capacity search: how long each rate is held")
+	flag.Var(newPercentValue(&config.CapacitySearch.MaxErrorRate, 1), "capacity-max-error-rate", "Capacity search: highest acceptable error rate")
+	flag.DurationVar(&config.CapacitySearch.MaxP99, "capacity-max-p99", 0, "Capacity search: highest acceptable p99 latency (0 = not checked)")
+	flag.Var(newPercentValue(&config.CapacitySearch.MinAchieved, 90), "capacity-min-achieved", "Capacity search: lowest acceptable achieved/target RPS")
+
+	flag.StringVar(&config.ControlSocket, "control-socket", "", "Unix socket accepting commands to retune the running test (e.g. 'rps 500')")
+	flag.Var(newPercentValue(&config.RpsStep, 10), "rps-step", "How much SIGUSR1/SIGUSR2 raise/lower the RPS limit")
+
+	flag.StringVar(&config.Profile, "profile", "", "Load the options saved with save-profile under this name")
 	flag.BoolVar(&config.ShowHelp, "help", false, "Show help message")
 	flag.BoolVar(&config.ShowHelp, "h", false, "Show help message (shorthand)")
+	flag.BoolVar(&config.ShowVersion, "version", false, "Show the version and build information")
 
 	// Custom usage function
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "H2loadClient - HTTP/2 Load Testing Tool\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [run] [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s <command> [arguments]\n\n", os.Args[0])
+		printSubcommands()
 		fmt.Fprintf(os.Stderr, "Required:\n")
 		fmt.Fprintf(os.Stderr, "  -url, -u <url>          Target URL to test\n\n")
 		fmt.Fprintf(os.Stderr, "Load Options:\n")
 		fmt.Fprintf(os.Stderr, "  -requests, -n <int>     Number of requests per client (default: 1)\n")
-		fmt.Fprintf(os.Stderr, "  -clients, -c <int>      Number of concurrent clients (default: 1)\n")
-		fmt.Fprintf(os.Stderr, "  -streams, -s <int>      Number of concurrent streams per client (default: 1)\n")
+		fmt.Fprintf(os.Stderr, "  -clients, -c <int>      Number of concurrent clients, each on one connection (default: 1)\n")
+		fmt.Fprintf(os.Stderr, "  -streams, -s <int>      Number of concurrent streams per client, those above the server's MAX_CONCURRENT_STREAMS queue on its connection (default: 1)\n")
 		fmt.Fprintf(os.Stderr, "  -rps, -r <int>          Requests per second limit (0 = unlimited, default: 0)\n")
-		fmt.Fprintf(os.Stderr, "  -rps-mode <mode>        RPS mode: 'burst' or 'even' (default: burst)\n")
-		fmt.Fprintf(os.Stderr, "  -duration <duration>    Test duration (e.g. 30s, 1m) - overrides -n\n\n")
+		fmt.Fprintf(os.Stderr, "  -total-rps <int>        Requests per second shared by all clients (0 = unlimited, default: 0)\n")
+		fmt.Fprintf(os.Stderr, "  -rps-mode <mode>        RPS mode: 'burst', 'even' or 'jitter' (default: burst)\n")
+		fmt.Fprintf(os.Stderr, "  -interval-jitter <pct>  Jitter mode: random skew of every interval between sends, e.g. 20%% (default: 10%%)\n")
+		fmt.Fprintf(os.Stderr, "  -rps-jitter <pct>       Random per-client skew of -rps, e.g. 10%% (default: 0)\n")
+		fmt.Fprintf(os.Stderr, "  -strict-schedule        Schedule every send of -rps or -total-rps ahead, sending late rather than skipping when behind, and report the late sends\n")
+		fmt.Fprintf(os.Stderr, "  -spike <spec>           Periodic bursts over -rps or -total-rps, e.g. rps=5000,duration=10s,every=2m, reported separately\n")
+		fmt.Fprintf(os.Stderr, "  -send-delay <dist>      Hold every request back for a delay drawn from fixed:DELAY, uniform:MIN-MAX or lognormal:MEDIAN[,SIGMA[,MAX]], emulating WAN clients\n")
+		fmt.Fprintf(os.Stderr, "  -nagle                  Enable Nagle's algorithm on every connection, clearing TCP_NODELAY (default: off)\n")
+		fmt.Fprintf(os.Stderr, "  -sndbuf <size>          SO_SNDBUF of every connection, e.g. 256KB (default: the OS's)\n")
+		fmt.Fprintf(os.Stderr, "  -rcvbuf <size>          SO_RCVBUF of every connection, e.g. 4MB, set before connecting (default: the OS's)\n")
+		fmt.Fprintf(os.Stderr, "  -dscp <int>             DSCP class the packets of every connection are marked with, 0-63, e.g. 46 for EF (default: unmarked)\n")
+		fmt.Fprintf(os.Stderr, "  -throttle <profile>     Cap the bandwidth of every connection to a network profile: %s\n", networkProfileNames())
+		fmt.Fprintf(os.Stderr, "  -throttle-down <rate>   Cap the download bandwidth of every connection, e.g. 750kbit (default: the profile's, or unlimited)\n")
+		fmt.Fprintf(os.Stderr, "  -throttle-up <rate>     Cap the upload bandwidth of every connection, e.g. 250kbit (default: the profile's, or unlimited)\n")
+		fmt.Fprintf(os.Stderr, "  -closed-loop            Run -s workers per client, each sending its next request once its last one completed, instead of at a rate\n")
+		fmt.Fprintf(os.Stderr, "  -think-time <duration>  Closed loop: pause of a worker between a response and its next request (default: none)\n")
+		fmt.Fprintf(os.Stderr, "  -users <int>            Virtual users, each with its own cookies and sending one request at a time, at least -c (default: none)\n")
+		fmt.Fprintf(os.Stderr, "  -extract <spec>         Pull a value of every response into a user variable replacing {NAME} in its next requests' URL and headers,\n")
+		fmt.Fprintf(os.Stderr, "                          given as NAME=json:PATH, NAME=regex:PATTERN or NAME=header:HEADER (repeatable, requires -users)\n")
+		fmt.Fprintf(os.Stderr, "  -seed <int>             Seed of the run's random choices, printed with the configuration to reproduce a run (default: random)\n")
+		fmt.Fprintf(os.Stderr, "  -duration <duration>    Test duration (e.g. 30s, 1m) - overrides -n\n")
+		fmt.Fprintf(os.Stderr, "  -ramp-down <duration>   At the end, wait up to this long for requests in flight, then abandon them rather than fail them (default: wait for all)\n")
+		fmt.Fprintf(os.Stderr, "  -wait-timeout <d>       After -duration, give up on clients that don't finish within this long, printing what is stuck (default: 30s)\n")
+		fmt.Fprintf(os.Stderr, "  -graceful-close         At the end, send GOAWAY and close connections once their streams finish, reporting the close latency\n")
+		fmt.Fprintf(os.Stderr, "  -close-wait-goaway <d>  Graceful close: wait up to this long for the server's GOAWAY before closing (default: don't wait)\n")
+		fmt.Fprintf(os.Stderr, "  -header, -H <header>    Header added to every request, e.g. 'Authorization: Bearer x' (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -request-id-header <name> Send a random UUID in this header with every request and log it, e.g. X-Request-Id\n")
+		fmt.Fprintf(os.Stderr, "  -traceparent            Send a W3C traceparent starting a new trace with every request and log its trace ID\n")
+		fmt.Fprintf(os.Stderr, "  -method <method>        Request method (default: GET, POST with -F)\n")
+		fmt.Fprintf(os.Stderr, "  -url-file <path>        Send the requests of this file in turn, one '[METHOD] URL' per line, e.g. 'HEAD /health'\n")
+		fmt.Fprintf(os.Stderr, "  -body-size <size>       Send a generated body of this size with every request, e.g. 10MB, streamed as it is sent (default: no body)\n")
+		fmt.Fprintf(os.Stderr, "  -body-file <path>       Stream this file as the body of every request\n")
+		fmt.Fprintf(os.Stderr, "  -form, -F <field>       Send a multipart/form-data body with this field, name=value or name=@file[;type=mime/type] (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -body-dist <dist>       Send random bodies with sizes drawn from fixed:SIZE, uniform:MIN-MAX or lognormal:MEDIAN[,SIGMA[,MAX]]\n")
+		fmt.Fprintf(os.Stderr, "  -graphql-query <path>   Send the GraphQL operation in this file as a JSON POST body, failing 2xx responses with GraphQL errors\n")
+		fmt.Fprintf(os.Stderr, "  -graphql-vars <path>    File holding a JSON object of the operation's variables\n")
+		fmt.Fprintf(os.Stderr, "  -graphql-operation <name> Operation run when the query holds several\n")
+		fmt.Fprintf(os.Stderr, "  -trailer <header>       Trailer sent after every request body, e.g. 'x-checksum: abc' (repeatable, requires a body)\n")
+		fmt.Fprintf(os.Stderr, "  -expect-trailer <header> Fail responses without this trailer and value, e.g. 'grpc-status: 0' (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -compressed <list>      Request compressed responses with these encodings: gzip, deflate, br (default: none)\n")
+		fmt.Fprintf(os.Stderr, "  -decompress             Decompress gzip and deflate responses, timing it; br is only counted (default: true)\n")
+		fmt.Fprintf(os.Stderr, "  -revalidate             Revalidate with If-None-Match and If-Modified-Since, reporting the 304 hit rate and bandwidth saved (default: false)\n")
+		fmt.Fprintf(os.Stderr, "  -expect-continue        Send Expect: 100-continue on requests with a body, timing the 100 and counting early rejections (default: false)\n")
+		fmt.Fprintf(os.Stderr, "  -expect-continue-timeout Wait for 100 Continue before sending the body anyway (default: 1s)\n")
+		fmt.Fprintf(os.Stderr, "  -cors <percent>         Send a CORS preflight OPTIONS ahead of this percentage of the requests, timed apart from them\n")
+		fmt.Fprintf(os.Stderr, "  -cors-origin <origin>   CORS: Origin of the simulated page, sent with every request, e.g. https://app.example.com\n")
+		fmt.Fprintf(os.Stderr, "  -cors-method <method>   CORS: Access-Control-Request-Method of the preflights (default: the request's method)\n")
+		fmt.Fprintf(os.Stderr, "  -cors-headers <names>   CORS: Access-Control-Request-Headers of the preflights (default: the request's non-safelisted headers)\n")
+		fmt.Fprintf(os.Stderr, "  -follow-redirects[=max] Follow up to max redirects per request, 10 if no max is given (default: off, 3xx responses are recorded)\n\n")
+		fmt.Fprintf(os.Stderr, "Retries:\n")
+		fmt.Fprintf(os.Stderr, "  -retries <int>              Retry a failed request up to this many times (default: 0, don't retry)\n")
+		fmt.Fprintf(os.Stderr, "  -retry-backoff <duration>   Delay before the first retry, doubled before each next one (default: 100ms)\n")
+		fmt.Fprintf(os.Stderr, "  -retry-on <list>            Failures retried: comma-separated 5xx, timeout, reset (default: all)\n")
+		fmt.Fprintf(os.Stderr, "  -retry-timeout <duration>   Timeout of each attempt, retried as a timeout (default: none)\n\n")
+		fmt.Fprintf(os.Stderr, "Auto Abort:\n")
+		fmt.Fprintf(os.Stderr, "  -abort-on-error-rate <pct>  Stop when the error rate over a window exceeds this, e.g. 10%% (default: disabled)\n")
+		fmt.Fprintf(os.Stderr, "  -abort-on-p99 <duration>    Stop when the p99 latency over a window exceeds this (default: disabled)\n")
+		fmt.Fprintf(os.Stderr, "  -abort-window <duration>    Length of the window the thresholds are checked over (default: 5s)\n")
+		fmt.Fprintf(os.Stderr, "  -abort-min-requests <int>   Requests needed in a window before it is checked (default: 20)\n\n")
+		fmt.Fprintf(os.Stderr, "Latency Target:\n")
+		fmt.Fprintf(os.Stderr, "  -latency-target <duration>        Adjust -total-rps during the run to hold a latency percentile at this value\n")
+		fmt.Fprintf(os.Stderr, "  -latency-target-percentile <num>  Percentile to control (default: 99)\n")
+		fmt.Fprintf(os.Stderr, "  -latency-target-interval <d>      How often the RPS limit is adjusted (default: 1s)\n")
+		fmt.Fprintf(os.Stderr, "  -latency-target-min-rps <int>     Lowest RPS limit (default: 1)\n")
+		fmt.Fprintf(os.Stderr, "  -latency-target-max-rps <int>     Highest RPS limit (0 = unbounded, default: 0)\n\n")
+		fmt.Fprintf(os.Stderr, "Pre-flight:\n")
+		fmt.Fprintf(os.Stderr, "  -dry-run                   Resolve the host, complete the TLS and HTTP/2 handshakes, print the test plan\n")
+		fmt.Fprintf(os.Stderr, "                             and exit without sending load\n")
+		fmt.Fprintf(os.Stderr, "  -preflight                 Send a single GET before starting the clients and abort the run if it fails\n")
+		fmt.Fprintf(os.Stderr, "                             (no response, or a status of 400 or more)\n")
+		fmt.Fprintf(os.Stderr, "  -preflight-path <path>     Path probed, e.g. /healthz, implies -preflight (default: -url)\n")
+		fmt.Fprintf(os.Stderr, "  -preflight-timeout <dur>   Bound of the probe, connecting included (default: 10s)\n\n")
+		fmt.Fprintf(os.Stderr, "Cooldown:\n")
+		fmt.Fprintf(os.Stderr, "  -cooldown <duration>       After the load, probe for up to this long and report when latency returns to baseline (default: disabled)\n")
+		fmt.Fprintf(os.Stderr, "  -cooldown-rate <int>       Probes per second (default: 5)\n")
+		fmt.Fprintf(os.Stderr, "  -cooldown-tolerance <pct>  Allowed slowdown over the baseline latency (default: 50%%)\n\n")
+		fmt.Fprintf(os.Stderr, "CRUD Workload:\n")
+		fmt.Fprintf(os.Stderr, "  -crud-create-rps <int>     POST new resources to -url at this rate, enabling the workload\n")
+		fmt.Fprintf(os.Stderr, "  -crud-read-rps <int>       GET previously created resources at this rate\n")
+		fmt.Fprintf(os.Stderr, "  -crud-delete-rps <int>     DELETE previously created resources at this rate\n")
+		fmt.Fprintf(os.Stderr, "  -crud-body <body|@file>    Body of create requests\n")
+		fmt.Fprintf(os.Stderr, "  -crud-content-type <type>  Content-Type of create requests (default: application/json)\n")
+		fmt.Fprintf(os.Stderr, "  -crud-resource-url <url>   Resource URL with an {id} placeholder (default: -url/{id})\n")
+		fmt.Fprintf(os.Stderr, "  -crud-id-field <name>      JSON field of the create response holding the ID (default: id)\n\n")
+		fmt.Fprintf(os.Stderr, "Size Sweep:\n")
+		fmt.Fprintf(os.Stderr, "  -sweep-sizes <list>     Run the test once per size, e.g. 1KB,10KB,100KB,1MB; each runs for -duration or -n\n")
+		fmt.Fprintf(os.Stderr, "  -sweep-body             Send a generated request body of each size (default: false)\n")
+		fmt.Fprintf(os.Stderr, "                          A {size} placeholder in the URL is replaced by the size in bytes\n\n")
+		fmt.Fprintf(os.Stderr, "Load Sweep:\n")
+		fmt.Fprintf(os.Stderr, "  -sweep-streams <values> Run the test once per concurrent streams value, e.g. 1,2,4,8 or 10-100:10\n")
+		fmt.Fprintf(os.Stderr, "  -sweep-rps <values>     Run the test once per total RPS value, e.g. 100,200,400 or 100-1000:100\n")
+		fmt.Fprintf(os.Stderr, "  -sweep-csv <path>       Also write the achieved RPS and latencies per step as CSV\n")
+		fmt.Fprintf(os.Stderr, "                          Each step runs for -duration or -n\n\n")
+		fmt.Fprintf(os.Stderr, "A/B Comparison:\n")
+		fmt.Fprintf(os.Stderr, "  -ab-url <url>           Split the clients between -url (A) and this URL (B), side by side\n")
+		fmt.Fprintf(os.Stderr, "  -ab-header <header>     Header of variant B, replacing A's of the same name (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -ab-server <host:port>  Server address variant B dials (default: A's)\n")
+		fmt.Fprintf(os.Stderr, "                          Both run for -duration or -n, then their RPS, latency and errors are compared\n\n")
+		fmt.Fprintf(os.Stderr, "Phases:\n")
+		fmt.Fprintf(os.Stderr, "  -phases <path>          Run the phases of this JSON plan one after the other, each with its duration, rps,\n")
+		fmt.Fprintf(os.Stderr, "                          clients, streams and scenario, on the same connections\n\n")
+		fmt.Fprintf(os.Stderr, "WebSocket:\n")
+		fmt.Fprintf(os.Stderr, "  -websocket              Open -s WebSocket streams per client over HTTP/2 (RFC 8441) instead of sending requests\n")
+		fmt.Fprintf(os.Stderr, "                          Implied by a ws:// or wss:// URL; latency is measured on messages the server echoes\n")
+		fmt.Fprintf(os.Stderr, "  -ws-rate <int>          Messages per second per stream (0 = as fast as the stream allows, default: 10)\n")
+		fmt.Fprintf(os.Stderr, "  -ws-size <size>         Payload size of every message, at least 8 bytes (default: 64B)\n")
+		fmt.Fprintf(os.Stderr, "  -ws-messages <int>      Messages per stream (default: -n, or until -duration)\n")
+		fmt.Fprintf(os.Stderr, "  -ws-subprotocol <name>  Sec-WebSocket-Protocol requested (default: none)\n\n")
+		fmt.Fprintf(os.Stderr, "gRPC Streaming:\n")
+		fmt.Fprintf(os.Stderr, "  -grpc-stream <type>     Make bidi, server or client streaming calls to the method at -url instead of requests\n")
+		fmt.Fprintf(os.Stderr, "                          -s calls per client run back to back, for -duration or -n calls per client\n")
+		fmt.Fprintf(os.Stderr, "  -grpc-message <path>    File holding the serialized request message (default: an empty message)\n")
+		fmt.Fprintf(os.Stderr, "  -grpc-messages <int>    Messages sent per bidi or client streaming call (0 = until -duration, default: 10)\n")
+		fmt.Fprintf(os.Stderr, "  -grpc-rate <int>        Messages per second per call (0 = as fast as the stream allows, default: 10)\n\n")
+		fmt.Fprintf(os.Stderr, "Server Push:\n")
+		fmt.Fprintf(os.Stderr, "  -push <mode>            Send GETs with server push enabled, accepting or rejecting pushed streams\n")
+		fmt.Fprintf(os.Stderr, "                          accept reads pushes to the end, reject resets them when promised\n\n")
+		fmt.Fprintf(os.Stderr, "Stream Priority:\n")
+		fmt.Fprintf(os.Stderr, "  -priority-class <class> Send GETs of this priority class, as name=weight[,parent=class][,exclusive][,url=URL]\n")
+		fmt.Fprintf(os.Stderr, "                          Weight is 1-256; parent depends on that class's latest stream; url is a URL\n")
+		fmt.Fprintf(os.Stderr, "                          or path on the host of -url. Streams cycle through the classes (repeatable)\n\n")
+		fmt.Fprintf(os.Stderr, "Handshakes:\n")
+		fmt.Fprintf(os.Stderr, "  -handshakes             Open connections, complete the TCP, TLS and HTTP/2 handshakes and close them\n")
+		fmt.Fprintf(os.Stderr, "                          -s at a time per client, for -duration or -n handshakes per client\n")
+		fmt.Fprintf(os.Stderr, "  -handshake-rate <int>   Handshakes per second started by the whole run (0 = as fast as they complete, default: 0)\n\n")
+		fmt.Fprintf(os.Stderr, "Connection Hold:\n")
+		fmt.Fprintf(os.Stderr, "  -hold <int>             Open this many connections over all clients and hold them idle for -duration\n")
+		fmt.Fprintf(os.Stderr, "  -hold-rate <int>        Connections opened per second (0 = all at once, default: 0)\n")
+		fmt.Fprintf(os.Stderr, "  -hold-ping <duration>   Send a PING on every connection at this interval (0 = none, default: 0)\n\n")
+		fmt.Fprintf(os.Stderr, "Security Tests (only against servers you operate):\n")
+		fmt.Fprintf(os.Stderr, "  -rapid-reset <int>      Open streams and reset them right away (HTTP/2 rapid reset, CVE-2023-44487) at this\n")
+		fmt.Fprintf(os.Stderr, "                          many per second over all clients, for -duration, reporting GOAWAYs,\n")
+		fmt.Fprintf(os.Stderr, "                          ENHANCE_YOUR_CALM and dropped connections\n")
+		fmt.Fprintf(os.Stderr, "  -slow-body <duration>   Keep -s streams per client sending their bodies a chunk at this interval (slowloris)\n")
+		fmt.Fprintf(os.Stderr, "                          for -duration, reporting the streams and connections the server ends; -body-size\n")
+		fmt.Fprintf(os.Stderr, "                          sets the content-length (default: sent until the server ends the stream), -method\n")
+		fmt.Fprintf(os.Stderr, "                          the method (default: POST)\n")
+		fmt.Fprintf(os.Stderr, "  -slow-body-chunk <int>  Bytes sent per interval on each stream (default: 1)\n\n")
+		fmt.Fprintf(os.Stderr, "Capacity Search:\n")
+		fmt.Fprintf(os.Stderr, "  -find-capacity          Search for the highest sustainable total RPS\n")
+		fmt.Fprintf(os.Stderr, "  -capacity-start-rps <int>  First total RPS tried (default: 100)\n")
+		fmt.Fprintf(os.Stderr, "  -capacity-max-rps <int>    Upper bound for the total RPS (0 = unbounded, default: 0)\n")
+		fmt.Fprintf(os.Stderr, "  -capacity-precision <int>  Stop once the pass/fail gap is within this many RPS (default: 10)\n")
+		fmt.Fprintf(os.Stderr, "  -capacity-step <duration>  How long each rate is held (default: 10s)\n")
+		fmt.Fprintf(os.Stderr, "  -capacity-max-error-rate <pct>  Highest acceptable error rate (default: 1%%)\n")
+		fmt.Fprintf(os.Stderr, "  -capacity-max-p99 <duration>   Highest acceptable p99 latency (0 = not checked, default: 0)\n")
+		fmt.Fprintf(os.Stderr, "  -capacity-min-achieved <pct>    Lowest acceptable achieved/target RPS (default: 90%%)\n\n")
+		fmt.Fprintf(os.Stderr, "Live Tuning:\n")
+		fmt.Fprintf(os.Stderr, "  -control-socket <path>  Unix socket accepting commands to retune the running test (default: disabled)\n")
+		fmt.Fprintf(os.Stderr, "  -rps-step <pct>         How much SIGUSR1/SIGUSR2 raise/lower the RPS limit (default: 10%%)\n\n")
 		fmt.Fprintf(os.Stderr, "Connection Options:\n")
 		fmt.Fprintf(os.Stderr, "  -server <host:port>     Override server address\n")
-		fmt.Fprintf(os.Stderr, "  -protocol <protocol>    Protocol override\n\n")
+		fmt.Fprintf(os.Stderr, "  -targets <addrs>        Shard the clients over these server addresses, client i dialing address i mod N, with per-target stats (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -connect-to <map>       Dial HOST2:PORT2 for requests to HOST1:PORT1, given as HOST1:PORT1:HOST2:PORT2 (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -resolve <entry>        Resolve HOST:PORT to these addresses, given as HOST:PORT:ADDR[,ADDR]... (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -ip-version <4|6|auto>  Dial only IPv4 or IPv6 addresses, or both with Happy Eyeballs; the families used are reported (default: auto)\n")
+		fmt.Fprintf(os.Stderr, "  -dns-server <host:port> DNS server resolving the target host, lookup times are reported (default: the system resolver)\n")
+		fmt.Fprintf(os.Stderr, "  -protocol <protocol>    Protocol override\n")
+		fmt.Fprintf(os.Stderr, "  -mesh                   Send h2c through a local service mesh sidecar, keeping the URL host as :authority\n")
+		fmt.Fprintf(os.Stderr, "  -mesh-sidecar <host:port>   Sidecar address to dial (default: %s)\n", DefaultMeshSidecarAddress)
+		fmt.Fprintf(os.Stderr, "  -mesh-timeout <duration>    Upstream timeout sent as x-envoy-upstream-rq-timeout-ms (default: not sent)\n")
+		fmt.Fprintf(os.Stderr, "  -mesh-header <header>       Extra header added to every request, e.g. 'x-envoy-max-retries: 0' (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -calm-backoff <duration>    Pause a client's new requests after ENHANCE_YOUR_CALM (0 = don't back off, default: 2s)\n")
+		fmt.Fprintf(os.Stderr, "  -max-stream-errors <int>  Recycle a connection after this many stream errors or a GOAWAY with an error (0 = never)\n")
+		fmt.Fprintf(os.Stderr, "  -flow-stalls            Time how long each request is blocked on the connection's or its stream's flow control window\n")
+		fmt.Fprintf(os.Stderr, "  -respect-max-streams    Cap the concurrent streams per client to the server's MAX_CONCURRENT_STREAMS\n")
+		fmt.Fprintf(os.Stderr, "  -debug-frames <int>     Log the HTTP/2 frames sent and received on the run's first N streams, one line per\n")
+		fmt.Fprintf(os.Stderr, "                          frame with its type, stream, flags and length, and those of their connections\n")
+		fmt.Fprintf(os.Stderr, "  -debug-frames-sample <fraction>  Also log the frames of this fraction of the other streams, e.g. 0.001\n")
+		fmt.Fprintf(os.Stderr, "  -debug-frames-file <path>        Write the frames to this file (default: stderr)\n")
+		fmt.Fprintf(os.Stderr, "  -keylog <path>          Append the TLS secrets of every connection to this file in NSS key log format,\n")
+		fmt.Fprintf(os.Stderr, "                          to decrypt captures in Wireshark. Anyone with the file can decrypt the traffic\n\n")
 		fmt.Fprintf(os.Stderr, "Output Options:\n")
 		fmt.Fprintf(os.Stderr, "  -stats                  Show aggregated statistics (default: true)\n")
 		fmt.Fprintf(os.Stderr, "  -client-stats           Show individual client statistics (default: false)\n")
+		fmt.Fprintf(os.Stderr, "  -connection-stats       Show the streams, resets, bytes, latency and lifetime of every connection (default: false)\n")
+		fmt.Fprintf(os.Stderr, "  -charts                 Show a latency histogram and an RPS sparkline (default: true)\n")
+		fmt.Fprintf(os.Stderr, "  -store <path>           Append the run's config, stats and per-second series to this SQLite file, see 'history' and 'compare'\n")
+		fmt.Fprintf(os.Stderr, "  -hdr-log <path>         Write the raw latency histograms (microseconds) to this HdrHistogram interval log\n")
+		fmt.Fprintf(os.Stderr, "  -hdr-interval <dur>     Interval of the histograms written to -hdr-log (default: 1s)\n")
+		fmt.Fprintf(os.Stderr, "  -junit <path>           Write the run's threshold checks to this JUnit XML file, one test case each\n")
+		fmt.Fprintf(os.Stderr, "  -notify-url <url>       POST the summary to this webhook when the run finishes or aborts\n")
+		fmt.Fprintf(os.Stderr, "  -notify-format <fmt>    Webhook payload: 'json' summary or 'slack' message (default: json)\n")
+		fmt.Fprintf(os.Stderr, "  -o <format>             Write a 'json' or 'yaml' summary with per-second stats to stdout, other output goes to stderr\n")
+		fmt.Fprintf(os.Stderr, "  -output-compat <tool>   Print the summary to stdout in the layout of 'h2load' (nghttp2), other output goes to stderr\n")
 		fmt.Fprintf(os.Stderr, "  -json                   Output logs in JSON format (default: false)\n")
-		fmt.Fprintf(os.Stderr, "  -log-file <path>        Log file path (logs to stdout if not specified)\n\n")
+		fmt.Fprintf(os.Stderr, "  -log-format <template>  Custom log line template, e.g. '{{.Timestamp}} {{.Status}} {{.LatencyMs}} {{.ClientID}}'\n")
+		fmt.Fprintf(os.Stderr, "  -log-file <path>        Log file path (logs to stdout if not specified)\n")
+		fmt.Fprintf(os.Stderr, "  -log-gzip               Gzip-compress the log file (default: false)\n")
+		fmt.Fprintf(os.Stderr, "  -log-shards <int>       Split the log file across this many files, e.g. results.0.log (default: 1)\n")
+		fmt.Fprintf(os.Stderr, "  -capture-dir <path>     Write the responses of failed requests to this directory (default: disabled)\n")
+		fmt.Fprintf(os.Stderr, "  -capture-max <int>      Most failed responses written per run (default: 20)\n")
+		fmt.Fprintf(os.Stderr, "  -capture-every <int>    Write one in every N failed responses (default: 1)\n")
+		fmt.Fprintf(os.Stderr, "  -capture-max-body <size>  Bytes of each response body kept (default: 64KiB)\n")
+		fmt.Fprintf(os.Stderr, "  -max-memory <size>      Cap the generator's memory, e.g. 2GB, shedding log lines and traces near it (default: no cap)\n")
+		fmt.Fprintf(os.Stderr, "  -profile-latency <int>  Attribute the latency of one in every N requests to generator vs network/server (default: disabled)\n")
+		fmt.Fprintf(os.Stderr, "  -pprof-addr <addr>      Serve the generator's pprof profiles on this address during the run, e.g. localhost:6060\n")
+		fmt.Fprintf(os.Stderr, "  -otlp-endpoint <url>    Export sampled request traces to this OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces\n")
+		fmt.Fprintf(os.Stderr, "  -trace-samples <int>    OTLP: requests traced per minute over all clients (0 = every request, default: 600)\n")
+		fmt.Fprintf(os.Stderr, "  -log-slower-than <d>    Only log requests slower than this (default: log all)\n")
+		fmt.Fprintf(os.Stderr, "  -log-status <list>      Only log these statuses, e.g. 5xx,429,0 where 0 is no response (default: log all)\n")
+		fmt.Fprintf(os.Stderr, "  -log-flush-interval <d> Interval at which buffered log lines are flushed (default: 100ms)\n\n")
+		fmt.Fprintf(os.Stderr, "Profiles:\n")
+		fmt.Fprintf(os.Stderr, "  -profile <name>         Load the options saved with 'save-profile <name>', later options override them\n\n")
 		fmt.Fprintf(os.Stderr, "Help:\n")
-		fmt.Fprintf(os.Stderr, "  -help, -h               Show this help message\n\n")
+		fmt.Fprintf(os.Stderr, "  -help, -h               Show this help message\n")
+		fmt.Fprintf(os.Stderr, "  -version                Show the version, commit, and Go and HTTP/2 library versions\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  %s -url https://example.com -n 100 -c 10\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -u https://api.example.com -n 1000 -c 50 -s 20 -rps 100\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -url https://example.com -duration 30s -c 10 -rps-mode even\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -url https://example.com -n 100 -c 10 -log-file results.log -json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -url http://reviews.default.svc.cluster.local:9080/ -mesh -mesh-timeout 5s -duration 1m\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -url https://example.com -c 10 -s 50 -find-capacity -capacity-max-p99 200ms\n", os.Args[0])
 	}
 
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
 	// Convert RPS mode string to enum
-	if strings.ToLower(rpsMode) == "even" {
+	switch strings.ToLower(rpsMode) {
+	case "even":
 		config.RpsMode = RpsModeEven
-	} else {
+	case "jitter":
+		config.RpsMode = RpsModeJitter
+	default:
 		config.RpsMode = RpsModeBurst
 	}
 
@@ -100,69 +579,913 @@ func ParseFlags() *CLIConfig {
 }
 
 func (c *CLIConfig) Validate() error {
-	return c.H2loadConf.Validate()
+	if err := c.loadGraphQL(); err != nil {
+		return err
+	}
+	if c.URLFile != "" {
+		list, err := LoadURLList(c.URLFile)
+		if err != nil {
+			return fmt.Errorf("url file: %w", err)
+		}
+		c.URLList = list
+	}
+	mode, err := c.activeMode()
+	if err != nil {
+		return err
+	}
+	if err := c.loadPhases(); err != nil {
+		return err
+	}
+	if err := c.H2loadConf.Validate(); err != nil {
+		return err
+	}
+	if len(c.Trailers.Send) > 0 && !c.Body.enabled() {
+		return fmt.Errorf("-trailer requires a request body")
+	}
+	if c.LogSlowerThan < 0 {
+		return fmt.Errorf("log-slower-than must be greater than 0")
+	}
+	if c.LogStatus != "" {
+		if _, err := LogStatus(c.LogStatus); err != nil {
+			return err
+		}
+	}
+	if c.OutputFormat != "" && c.OutputFormat != "json" && c.OutputFormat != "yaml" {
+		return fmt.Errorf("output format must be 'json' or 'yaml'")
+	}
+	if c.OutputCompat != "" && c.OutputCompat != "h2load" {
+		return fmt.Errorf("output compat must be 'h2load'")
+	}
+	if c.OutputCompat != "" && c.OutputFormat != "" {
+		return fmt.Errorf("-output-compat and -o both write to stdout, use one")
+	}
+	if c.NotifyFormat != "json" && c.NotifyFormat != "slack" {
+		return fmt.Errorf("notify format must be 'json' or 'slack'")
+	}
+	if c.HdrInterval <= 0 {
+		return fmt.Errorf("hdr interval must be greater than 0")
+	}
+	if c.LogGzip && c.LogFile == "" {
+		return fmt.Errorf("-log-gzip requires -log-file")
+	}
+	if c.KeyLogFile != "" && !strings.HasPrefix(c.URL, "https://") && !strings.HasPrefix(c.URL, "wss://") {
+		return fmt.Errorf("-keylog requires an https:// or wss:// URL, there are no TLS secrets to log")
+	}
+	if c.preflight() {
+		if err := c.Preflight.Validate(c.H2loadConf); err != nil {
+			return err
+		}
+	}
+	if c.DebugFramesFile != "" && !c.DebugFrames.enabled() {
+		return fmt.Errorf("-debug-frames-file requires -debug-frames or -debug-frames-sample")
+	}
+	if mode != nil && mode.ownConns && c.DebugFrames.enabled() {
+		return fmt.Errorf("-debug-frames cannot be used with %s", mode.flags)
+	}
+	if mode != nil && !mode.loadTest && c.Store != "" {
+		return fmt.Errorf("-store saves load test results, it cannot be used with %s", mode.flags)
+	}
+	if c.OTLPEndpoint != "" {
+		if mode != nil && !mode.loadTest {
+			return fmt.Errorf("-otlp-endpoint traces load test requests, it cannot be used with %s", mode.flags)
+		}
+		if err := validateOTLPEndpoint(c.OTLPEndpoint); err != nil {
+			return err
+		}
+	}
+	if c.LogShards < 1 {
+		return fmt.Errorf("log-shards must be greater than 0")
+	}
+	if c.LogShards > 1 && c.LogFile == "" {
+		return fmt.Errorf("-log-shards requires -log-file")
+	}
+	if c.LogFormat != "" {
+		if c.LogJSON {
+			return fmt.Errorf("-json and -log-format cannot be used together")
+		}
+		if _, err := ParseLogTemplate(c.LogFormat); err != nil {
+			return err
+		}
+	}
+	if len(c.SizeSweep.Sizes) > 0 {
+		sweep := c.SizeSweep
+		sweep.StepDuration = c.Duration
+		if err := sweep.Validate(c.H2loadConf); err != nil {
+			return err
+		}
+	}
+	if len(c.LoadSweep.Values) > 0 {
+		sweep := c.LoadSweep
+		sweep.StepDuration = c.Duration
+		if err := sweep.Validate(c.H2loadConf); err != nil {
+			return err
+		}
+	}
+	if c.AB.enabled() {
+		ab := c.AB
+		ab.Duration = c.Duration
+		if err := ab.Validate(c.H2loadConf); err != nil {
+			return err
+		}
+	}
+	if c.SweepCSV != "" && len(c.LoadSweep.Values) == 0 {
+		return fmt.Errorf("-sweep-csv requires -sweep-streams or -sweep-rps")
+	}
+	if c.SlowBody.Interval != 0 {
+		if c.Body.File != "" || c.Body.Random != nil || len(c.Body.Form) > 0 {
+			return fmt.Errorf("-slow-body sends generated bodies, only -body-size can be used with it")
+		}
+		sb := c.slowBodyConf()
+		if err := sb.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.RapidReset.Rate != 0 {
+		rr := c.RapidReset
+		rr.Duration = c.Duration
+		if err := rr.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Hold.Connections > 0 {
+		hold := c.Hold
+		hold.Duration = c.Duration
+		if err := hold.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Handshakes {
+		hs := c.Handshake
+		hs.Duration = c.Duration
+		if err := hs.Validate(c.H2loadConf); err != nil {
+			return err
+		}
+	}
+	if len(c.Priority.Classes) > 0 {
+		p := c.Priority
+		p.Duration = c.Duration
+		if err := p.Validate(c.H2loadConf); err != nil {
+			return err
+		}
+	}
+	if c.Push != "" {
+		push, err := c.pushConf()
+		if err != nil {
+			return err
+		}
+		if err := push.Validate(c.H2loadConf); err != nil {
+			return err
+		}
+	}
+	if c.GRPCStream != "" {
+		g, err := c.grpcConf()
+		if err != nil {
+			return err
+		}
+		if c.Duration > 0 {
+			// The run's requests are cleared once validated
+			g.Duration = c.Duration
+		}
+		if err := g.Validate(c.H2loadConf); err != nil {
+			return err
+		}
+	}
+	if c.webSocket() {
+		ws := c.webSocketConf()
+		if err := ws.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.Cooldown.Validate(); err != nil {
+		return err
+	}
+	if c.Crud.CreateRps > 0 {
+		c.Crud.CreateURL = c.URL
+		if err := c.Crud.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webSocket reports whether the run opens WebSocket streams
+func (c *CLIConfig) webSocket() bool {
+	return c.WebSocketMode || IsWebSocketURL(c.URL)
+}
+
+// webSocketConf returns the WebSocket conf of the run, sending -n messages per
+// stream unless a count or -duration is given
+func (c *CLIConfig) webSocketConf() WebSocketConf {
+	ws := c.WebSocket
+	ws.Duration = c.Duration
+	if ws.Messages == 0 && ws.Duration == 0 {
+		ws.Messages = c.Requests
+	}
+	return ws
+}
+
+// loadPhases reads the phase plan of PhasesFile into Phases, and sizes the
+// run for it: its clients are the most any phase uses, its duration the
+// plan's
+func (c *CLIConfig) loadPhases() error {
+	if c.PhasesFile == "" {
+		return nil
+	}
+	if c.Duration > 0 || c.TotalRps > 0 || c.Spike.enabled() {
+		return fmt.Errorf("-phases sets the duration and rate of the run, -duration, -total-rps and -spike cannot be used with it")
+	}
+	plan, err := LoadPhasePlan(c.PhasesFile)
+	if err != nil {
+		return fmt.Errorf("phases: %w", err)
+	}
+	if err := plan.Validate(c.H2loadConf); err != nil {
+		return fmt.Errorf("phases: %w", err)
+	}
+	c.Phases = plan
+	c.Clients = max(c.Clients, plan.MaxClients(c.H2loadConf))
+	c.Duration = plan.Duration()
+	return nil
+}
+
+// loadGraphQL reads the GraphQL query and variables files into GraphQL
+func (c *CLIConfig) loadGraphQL() error {
+	if c.GraphQLQueryFile != "" {
+		query, err := os.ReadFile(c.GraphQLQueryFile)
+		if err != nil {
+			return fmt.Errorf("graphql query: %w", err)
+		}
+		c.GraphQL.Query = string(query)
+	}
+	if c.GraphQLVarsFile != "" {
+		vars, err := os.ReadFile(c.GraphQLVarsFile)
+		if err != nil {
+			return fmt.Errorf("graphql variables: %w", err)
+		}
+		c.GraphQL.Variables = vars
+	}
+	return nil
+}
+
+// pushConf returns the server push conf of the run
+func (c *CLIConfig) pushConf() (PushConf, error) {
+	push := PushConf{Duration: c.Duration}
+	switch c.Push {
+	case "accept":
+		push.Accept = true
+	case "reject":
+	default:
+		return push, fmt.Errorf("push must be 'accept' or 'reject'")
+	}
+	return push, nil
+}
+
+// preflight reports whether a pre-flight request is sent
+func (c *CLIConfig) preflight() bool {
+	return c.PreflightEnabled || c.Preflight.Path != ""
+}
+
+// effectiveConfig returns the value of every option by long name, defaults
+// and values set by runMain included, for run metadata. Secrets are
+// redacted.
+func (c *CLIConfig) effectiveConfig() map[string]string {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasSuffix(f.Usage, "(shorthand)") {
+			config[f.Name] = f.Value.String()
+		}
+	})
+	headers := redactHeaders(c.Headers)
+	config["header"] = (&headerValue{&headers}).String()
+	abHeaders := redactHeaders(c.AB.Headers)
+	config["ab-header"] = (&headerValue{&abHeaders}).String()
+	if c.NotifyURL != "" {
+		// Webhook URLs, e.g. Slack's, carry their token
+		config["notify-url"] = "[redacted]"
+	}
+	delete(config, "help")
+	delete(config, "version")
+	return config
+}
+
+// runMode is a mode of the run, selected by its flags. A run is in one mode
+// at most, and a plain load test in none
+type runMode struct {
+	name     string // As printed in the test plan
+	flags    string // Flags selecting the mode, for errors
+	active   func(c *CLIConfig) bool
+	ownConns bool // Writes its frames on connections of its own, unseen by -debug-frames
+	loadTest bool // Runs as a load test, which -store and -otlp-endpoint hook into
+}
+
+// runModes lists the modes in runMain's order
+var runModes = []runMode{
+	{name: "size sweep", flags: "-sweep-sizes",
+		active: func(c *CLIConfig) bool { return len(c.SizeSweep.Sizes) > 0 }},
+	{name: "load sweep", flags: "-sweep-streams or -sweep-rps",
+		active: func(c *CLIConfig) bool { return len(c.LoadSweep.Values) > 0 }},
+	{name: "A/B comparison", flags: "-ab-url, -ab-header or -ab-server",
+		active: func(c *CLIConfig) bool { return c.AB.enabled() }},
+	{name: "slow body test", flags: "-slow-body", ownConns: true,
+		active: func(c *CLIConfig) bool { return c.SlowBody.Interval != 0 }},
+	{name: "rapid reset test", flags: "-rapid-reset", ownConns: true,
+		active: func(c *CLIConfig) bool { return c.RapidReset.Rate != 0 }},
+	{name: "connection hold", flags: "-hold", ownConns: true,
+		active: func(c *CLIConfig) bool { return c.Hold.Connections > 0 }},
+	{name: "handshakes", flags: "-handshakes", ownConns: true,
+		active: func(c *CLIConfig) bool { return c.Handshakes }},
+	{name: "stream priority", flags: "-priority-class", ownConns: true,
+		active: func(c *CLIConfig) bool { return len(c.Priority.Classes) > 0 }},
+	{name: "server push", flags: "-push", ownConns: true,
+		active: func(c *CLIConfig) bool { return c.Push != "" }},
+	{name: "gRPC streaming", flags: "-grpc-stream",
+		active: func(c *CLIConfig) bool { return c.GRPCStream != "" }},
+	{name: "WebSocket", flags: "-websocket or a ws:// URL",
+		active: func(c *CLIConfig) bool { return c.webSocket() }},
+	{name: "capacity search", flags: "-find-capacity",
+		active: func(c *CLIConfig) bool { return c.FindCapacity }},
+	{name: "CRUD workload", flags: "-crud-create-rps", loadTest: true,
+		active: func(c *CLIConfig) bool { return c.Crud.CreateRps > 0 }},
+	{name: "phased load test", flags: "-phases", loadTest: true,
+		active: func(c *CLIConfig) bool { return c.PhasesFile != "" }},
+}
+
+// activeMode returns the mode the run is in, nil for a plain load test, or
+// an error when more than one is selected
+func (c *CLIConfig) activeMode() (*runMode, error) {
+	var mode *runMode
+	for i := range runModes {
+		m := &runModes[i]
+		if !m.active(c) {
+			continue
+		}
+		if mode != nil {
+			return nil, fmt.Errorf("%s and %s cannot be used together", mode.flags, m.flags)
+		}
+		mode = m
+	}
+	return mode, nil
+}
+
+// mode names what the run does, the first mode set in runMain's order
+func (c *CLIConfig) mode() string {
+	for _, m := range runModes {
+		if m.active(c) {
+			return m.name
+		}
+	}
+	return "load test"
+}
+
+// testPlan formats what the run would send, for -dry-run
+func (c *CLIConfig) testPlan() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Test plan:\n")
+	fmt.Fprintf(&b, "  Mode: %s\n", c.mode())
+	fmt.Fprintf(&b, "  URL: %s %s\n", c.method(), c.URL)
+	for _, r := range c.URLList {
+		fmt.Fprintf(&b, "  Listed: %s\n", r)
+	}
+	fmt.Fprintf(&b, "  Connections: %d clients x %d streams = %d concurrent streams\n",
+		c.Clients, c.ConcurrentStreams, c.Clients*c.ConcurrentStreams)
+
+	total := c.Requests * c.Clients
+	if c.Duration > 0 {
+		fmt.Fprintf(&b, "  Requests: as many as fit in %v\n", c.Duration)
+	} else {
+		fmt.Fprintf(&b, "  Requests: %d per client x %d clients = %d\n", c.Requests, c.Clients, total)
+	}
+
+	rate := 0
+	switch {
+	case c.Crud.CreateRps > 0:
+		rate = c.Crud.CreateRps + c.Crud.ReadRps + c.Crud.DeleteRps
+		fmt.Fprintf(&b, "  Rate: create %d/s, read %d/s, delete %d/s (%s mode)\n",
+			c.Crud.CreateRps, c.Crud.ReadRps, c.Crud.DeleteRps, c.GetRpsModeString())
+	case c.TotalRps > 0:
+		rate = c.TotalRps
+		fmt.Fprintf(&b, "  Rate: %d/s shared by all clients (%s mode)\n", c.TotalRps, c.GetRpsModeString())
+		if c.LatencyTarget.Target > 0 {
+			fmt.Fprintf(&b, "  Rate control: from %d/s, adjusted every %v to hold p%g at %v\n",
+				c.TotalRps, c.LatencyTarget.Interval, c.LatencyTarget.Percentile, c.LatencyTarget.Target)
+		}
+	case c.Rps > 0:
+		rate = c.Rps * c.Clients
+		fmt.Fprintf(&b, "  Rate: %d/s per client, %d/s in total (%s mode)\n", c.Rps, rate, c.GetRpsModeString())
+		if c.RpsJitter > 0 {
+			fmt.Fprintf(&b, "  Rate jitter: ±%.1f%% per client\n", c.RpsJitter*100)
+		}
+	case c.PhasesFile != "":
+		fmt.Fprintf(&b, "  Rate: set by each phase\n")
+	case c.ClosedLoop.Enabled:
+		fmt.Fprintf(&b, "  Rate: closed loop, %d workers each sending once its last request completed", c.Clients*c.ConcurrentStreams)
+		if c.ClosedLoop.ThinkTime > 0 {
+			fmt.Fprintf(&b, ", after %v think time", c.ClosedLoop.ThinkTime)
+		}
+		fmt.Fprintf(&b, "\n")
+	default:
+		fmt.Fprintf(&b, "  Rate: unlimited, as fast as the streams complete\n")
+	}
+	if c.Spike.enabled() {
+		fmt.Fprintf(&b, "  Spikes: %v, the first after %v\n", c.Spike, c.Spike.Every)
+	}
+	if c.Users > 0 {
+		fmt.Fprintf(&b, "  Virtual users: %d, up to %d concurrent requests\n", c.Users, min(c.Users, c.Clients*c.ConcurrentStreams))
+	}
+	for _, e := range c.Extract {
+		fmt.Fprintf(&b, "  Extract: %v, into {%s}\n", e, e.Name)
+	}
+	if c.Revalidate {
+		fmt.Fprintf(&b, "  Revalidate: GET and HEAD requests sent conditionally once their URL returned validators\n")
+	}
+	if c.ExpectContinue.Enabled {
+		fmt.Fprintf(&b, "  Expect continue: bodies held back until 100 Continue or %v\n", c.ExpectContinue.Timeout)
+	}
+	if c.CORS.enabled() {
+		fmt.Fprintf(&b, "  CORS preflight: OPTIONS ahead of %.4g%% of the requests, from origin %s\n", c.CORS.Fraction*100, c.CORS.origin())
+	}
+	for i, target := range c.Targets {
+		fmt.Fprintf(&b, "  Target %s: %d clients\n", target, (c.Clients-i+len(c.Targets)-1)/len(c.Targets))
+	}
+	if c.AB.enabled() {
+		a, v := c.AB.variants(c.H2loadConf)
+		fmt.Fprintf(&b, "  Variant A: %d clients, %s\n", a.Clients, a.URL)
+		fmt.Fprintf(&b, "  Variant B: %d clients, %s", v.Clients, v.URL)
+		if len(c.AB.Headers) > 0 {
+			headers := redactHeaders(c.AB.Headers)
+			fmt.Fprintf(&b, " with %s", (&headerValue{&headers}).String())
+		}
+		if c.AB.ServerAddress != "" {
+			fmt.Fprintf(&b, " via %s", c.AB.ServerAddress)
+		}
+		fmt.Fprintf(&b, "\n")
+	}
+	if c.SendDelay != nil {
+		fmt.Fprintf(&b, "  Send delay: %v before every request, in its stream slot\n", c.SendDelay)
+	}
+	if opts := c.Socket.String(); opts != "" {
+		fmt.Fprintf(&b, "  Socket options: %s\n", opts)
+	}
+	if c.Throttle.enabled() {
+		fmt.Fprintf(&b, "  Throttle: %v\n", c.Throttle)
+	}
+
+	switch {
+	case c.Duration > 0:
+		fmt.Fprintf(&b, "  Duration: %v", c.Duration)
+		if rate > 0 {
+			fmt.Fprintf(&b, ", about %d requests", int64(c.Duration.Seconds()*float64(rate)))
+		}
+		fmt.Fprintf(&b, "\n")
+	case rate > 0:
+		fmt.Fprintf(&b, "  Duration: about %v\n", (time.Duration(total) * time.Second / time.Duration(rate)).Round(time.Millisecond))
+	}
+	for _, p := range c.Phases.Phases {
+		fmt.Fprintf(&b, "  Phase %s: %s\n", p.Name, c.Phases.result(p, c.H2loadConf).summary())
+	}
+	if c.preflight() {
+		// Already checked by Validate
+		u, _ := c.Preflight.url(c.URL)
+		fmt.Fprintf(&b, "  Pre-flight: GET %s\n", u)
+	}
+	if c.Cooldown.Duration > 0 {
+		fmt.Fprintf(&b, "  Cooldown: up to %v\n", c.Cooldown.Duration)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// slowBodyConf returns the slow body conf of the run, with its body size,
+// method and duration from the request options
+func (c *CLIConfig) slowBodyConf() SlowBodyConf {
+	sb := c.SlowBody
+	sb.Size = c.Body.Size
+	sb.Method = c.Method
+	sb.Duration = c.Duration
+	return sb
+}
+
+// grpcConf returns the gRPC streaming conf of the run, with its type parsed
+// and its message read from GRPCMessageFile
+func (c *CLIConfig) grpcConf() (GRPCStreamConf, error) {
+	g := c.GRPC
+	var err error
+	if g.Type, err = ParseGRPCStreamType(c.GRPCStream); err != nil {
+		return g, err
+	}
+	if c.GRPCMessageFile != "" {
+		if g.Message, err = os.ReadFile(c.GRPCMessageFile); err != nil {
+			return g, fmt.Errorf("gRPC message: %w", err)
+		}
+	}
+	g.Duration = c.Duration
+	return g, nil
+}
+
+// logFilter builds the filter selecting which requests are logged, nil if
+// every request is logged
+func (c *CLIConfig) logFilter() func(status int, latency time.Duration) bool {
+	var slower, status func(status int, latency time.Duration) bool
+	if c.LogSlowerThan > 0 {
+		slower = LogSlowerThan(c.LogSlowerThan)
+		fmt.Printf("Logging only requests slower than %v\n", c.LogSlowerThan)
+	}
+	if c.LogStatus != "" {
+		// Already checked by Validate
+		status, _ = LogStatus(c.LogStatus)
+		fmt.Printf("Logging only statuses %s\n", c.LogStatus)
+	}
+	return AllLogFilters(slower, status)
+}
+
+// runChecks returns the outcome of the run's thresholds: completing without
+// an abort, each abort threshold and, if probed, recovery after the load
+func (c *CLIConfig) runChecks(abortReason string, cooldown *CooldownResult) []CheckResult {
+	checks := []CheckResult{{Name: "run completed", Failure: abortReason}}
+	if c.Abort.ErrorRate > 0 {
+		check := CheckResult{Name: fmt.Sprintf("error rate <= %.2f%% (abort-on-error-rate)", c.Abort.ErrorRate*100)}
+		if strings.HasPrefix(abortReason, "error rate") {
+			check.Failure = abortReason
+		}
+		checks = append(checks, check)
+	}
+	if c.Abort.P99 > 0 {
+		check := CheckResult{Name: fmt.Sprintf("p99 latency <= %v (abort-on-p99)", c.Abort.P99)}
+		if strings.HasPrefix(abortReason, "p99 latency") {
+			check.Failure = abortReason
+		}
+		checks = append(checks, check)
+	}
+	if cooldown != nil {
+		check := CheckResult{Name: fmt.Sprintf("recovered within %v (cooldown)", c.Cooldown.Duration)}
+		if !cooldown.Recovered {
+			check.Failure = fmt.Sprintf("latency stayed above %v while probing", cooldown.Threshold)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// crudBody returns the body of CRUD create requests, reading it from a file
+// when CrudBody starts with @
+func (c *CLIConfig) crudBody() ([]byte, error) {
+	if file, ok := strings.CutPrefix(c.CrudBody, "@"); ok {
+		return os.ReadFile(file)
+	}
+	return []byte(c.CrudBody), nil
+}
+
+// notify sends report to the -notify webhook
+func (c *CLIConfig) notify(report Report) {
+	if err := NotifyWebhook(c.NotifyURL, c.NotifyFormat, report); err != nil {
+		log.Printf("Failed to notify %s: %v", c.NotifyURL, err)
+	} else {
+		fmt.Printf("Notified %s\n\n", c.NotifyURL)
+	}
 }
 
 func (c *CLIConfig) GetRpsModeString() string {
-	if c.RpsMode == RpsModeEven {
+	switch c.RpsMode {
+	case RpsModeEven:
 		return "even"
+	case RpsModeJitter:
+		return fmt.Sprintf("jitter ±%.0f%%", c.IntervalJitter*100)
 	}
 	return "burst"
 }
 
-func CLIMain() {
-	config := ParseFlags()
+// runMain runs a load test, the default subcommand
+func runMain(args []string) {
+	args, err := expandProfile(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		os.Exit(1)
+	}
+	config := ParseArgs(args)
 	if config.ShowHelp {
 		flag.Usage()
 		os.Exit(0)
 	}
+	if config.ShowVersion {
+		fmt.Print(ReadBuildInfo())
+		os.Exit(0)
+	}
 
 	if err := config.Validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
 		os.Exit(1)
 	}
 
+	if config.DryRun {
+		result, err := DryRun(config.H2loadConf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s\n\n%s\n", result, config.testPlan())
+		os.Exit(0)
+	}
+
+	// Set up before the pre-flight and the mode dispatch so every mode gets them
+	if config.KeyLogFile != "" {
+		f, err := os.OpenFile(config.KeyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatalf("Failed to open TLS key log: %v", err)
+		}
+		defer f.Close()
+		config.KeyLog = f
+	}
+
+	if config.DebugFramesFile != "" {
+		f, err := os.Create(config.DebugFramesFile)
+		if err != nil {
+			log.Fatalf("Failed to create frame log: %v", err)
+		}
+		defer f.Close()
+		config.DebugFrames.Out = f
+	}
+
+	// A broken target fails here, before any client is started
+	if config.preflight() {
+		result, err := Preflight(config.H2loadConf, config.Preflight)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\nCheck the URL and that the server is up; the run was not started\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s\n\n", result)
+	}
+
+	// Started before any mode runs, so every mode can be profiled; stdout may
+	// carry only the summary
+	if config.PprofAddr != "" {
+		pprof, err := listenPprof(config.PprofAddr)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer pprof.Close()
+		fmt.Fprintf(os.Stderr, "Serving pprof profiles on %s\n", pprof.URL())
+	}
+
+	// Drawn here so sweeps run every step with the same seed, and it can be
+	// printed
+	if config.Seed == 0 {
+		config.Seed = newSeed()
+	}
+	config.Crud.Seed = config.Seed
+
 	// Handle duration override
 	if config.Duration > 0 {
 		// When duration is specified, we'll run indefinitely and stop after duration
 		config.Requests = 0 // 0 means run indefinitely
 	}
 
+	if len(config.SizeSweep.Sizes) > 0 {
+		config.SizeSweep.StepDuration = config.Duration
+		fmt.Printf("Sweeping payload sizes against %s...\n\n", config.URL)
+		result, err := SweepSizes(config.H2loadConf, config.SizeSweep)
+		if err != nil {
+			log.Fatalf("Size sweep failed: %v", err)
+		}
+		fmt.Println(result)
+		return
+	}
+
+	if len(config.LoadSweep.Values) > 0 {
+		config.LoadSweep.StepDuration = config.Duration
+		fmt.Printf("Sweeping %s against %s...\n\n", config.LoadSweep.Param, config.URL)
+		sweepStart := time.Now()
+		result, err := SweepLoad(config.H2loadConf, config.LoadSweep)
+		if err != nil {
+			log.Fatalf("Load sweep failed: %v", err)
+		}
+		fmt.Println(result)
+		if config.SweepCSV != "" {
+			f, err := os.Create(config.SweepCSV)
+			if err != nil {
+				log.Fatalf("Failed to create sweep CSV: %v", err)
+			}
+			defer f.Close()
+			metadata := NewRunMetadata(sweepStart, time.Now())
+			metadata.Config = config.effectiveConfig()
+			if err := metadata.WriteCSVComments(f); err != nil {
+				log.Fatalf("Failed to write sweep CSV: %v", err)
+			}
+			if err := result.WriteCSV(f); err != nil {
+				log.Fatalf("Failed to write sweep CSV: %v", err)
+			}
+		}
+		return
+	}
+
+	if config.AB.enabled() {
+		config.AB.Duration = config.Duration
+		target := config.URL
+		if config.AB.URL != "" {
+			target = config.AB.URL
+		}
+		fmt.Printf("Comparing %s (A) and %s (B)...\n\n", config.URL, target)
+		result, err := RunAB(config.H2loadConf, config.AB)
+		if err != nil {
+			log.Fatalf("A/B comparison failed: %v", err)
+		}
+		fmt.Println(result)
+		return
+	}
+
+	if config.SlowBody.Interval > 0 {
+		sb := config.slowBodyConf()
+		fmt.Printf("Sending slow bodies to %s: %d bytes every %v on %d streams per client for %v...\n\n", config.URL, sb.Chunk, sb.Interval, config.ConcurrentStreams, sb.Duration)
+		stats, err := RunSlowBody(config.H2loadConf, sb)
+		if err != nil {
+			log.Fatalf("Slow body test failed: %v", err)
+		}
+		fmt.Println(stats)
+		return
+	}
+
+	if config.RapidReset.Rate > 0 {
+		rr := config.RapidReset
+		rr.Duration = config.Duration
+		fmt.Printf("Running the rapid reset security test against %s: %d streams reset per second for %v...\n\n", config.URL, rr.Rate, rr.Duration)
+		stats, err := RunRapidReset(config.H2loadConf, rr)
+		if err != nil {
+			log.Fatalf("Rapid reset test failed: %v", err)
+		}
+		fmt.Println(stats)
+		return
+	}
+
+	if config.Hold.Connections > 0 {
+		hold := config.Hold
+		hold.Duration = config.Duration
+		fmt.Printf("Holding %d connections to %s for %v...\n\n", hold.Connections, config.URL, hold.Duration)
+		stats, err := RunHold(config.H2loadConf, hold)
+		if err != nil {
+			log.Fatalf("Connection hold failed: %v", err)
+		}
+		fmt.Println(stats)
+		return
+	}
+
+	if config.Handshakes {
+		hs := config.Handshake
+		hs.Duration = config.Duration
+		fmt.Printf("Making handshakes with %s...\n\n", config.URL)
+		stats, err := RunHandshakes(config.H2loadConf, hs)
+		if err != nil {
+			log.Fatalf("Handshake run failed: %v", err)
+		}
+		fmt.Println(stats)
+		return
+	}
+
+	if len(config.Priority.Classes) > 0 {
+		p := config.Priority
+		p.Duration = config.Duration
+		fmt.Printf("Sending requests of %d priority classes to %s...\n\n", len(p.Classes), config.URL)
+		stats, err := RunPriority(config.H2loadConf, p)
+		if err != nil {
+			log.Fatalf("Stream priority run failed: %v", err)
+		}
+		fmt.Println(stats)
+		return
+	}
+
+	if config.Push != "" {
+		// Already checked by Validate
+		push, _ := config.pushConf()
+		fmt.Printf("Sending requests to %s with server push enabled (%s)...\n\n", config.URL, config.Push)
+		stats, err := RunPush(config.H2loadConf, push)
+		if err != nil {
+			log.Fatalf("Server push run failed: %v", err)
+		}
+		fmt.Println(stats)
+		return
+	}
+
+	if config.GRPCStream != "" {
+		// Already checked by Validate
+		g, _ := config.grpcConf()
+		fmt.Printf("Making gRPC %s streaming calls to %s...\n\n", g.Type, config.URL)
+		stats, err := RunGRPCStream(config.H2loadConf, g)
+		if err != nil {
+			log.Fatalf("gRPC streaming run failed: %v", err)
+		}
+		fmt.Println(stats)
+		return
+	}
+
+	if config.webSocket() {
+		ws := config.webSocketConf()
+		fmt.Printf("Opening WebSocket streams against %s...\n\n", config.URL)
+		stats, err := RunWebSocket(config.H2loadConf, ws)
+		if err != nil {
+			log.Fatalf("WebSocket run failed: %v", err)
+		}
+		fmt.Println(stats)
+		return
+	}
+
+	if config.FindCapacity {
+		fmt.Printf("Searching for the max sustainable RPS against %s...\n\n", config.URL)
+		result, err := SearchCapacity(config.H2loadConf, config.CapacitySearch)
+		if err != nil {
+			log.Fatalf("Capacity search failed: %v", err)
+		}
+		fmt.Println(result)
+		return
+	}
+
+	// With -o or -output-compat, stdout carries only the summary
+	stdout := os.Stdout
+	if config.OutputFormat != "" || config.OutputCompat != "" {
+		os.Stdout = os.Stderr
+		defer func() { os.Stdout = stdout }()
+	}
+
+	// Open the results database first, so a bad path fails before the run
+	var store *ResultStore
+	if config.Store != "" {
+		var err error
+		if store, err = OpenResultStore(config.Store); err != nil {
+			log.Fatalf("Failed to open results database: %v", err)
+		}
+		defer store.Close()
+	}
+
 	// Create client
 	client, err := NewH2loadClient(config.H2loadConf)
 	if err != nil {
 		log.Fatalf("Failed to create h2load client: %v", err)
 	}
 	defer client.Close()
+	if config.OutputFormat != "" || config.ShowCharts || config.Store != "" || config.NotifyURL != "" {
+		client.SetTimeSeries(time.Second)
+	}
+
+	if config.HdrLog != "" {
+		f, err := os.Create(config.HdrLog)
+		if err != nil {
+			log.Fatalf("Failed to create HdrHistogram log: %v", err)
+		}
+		defer f.Close()
+		client.SetHistogramStore(NewHistogramLogStore(f), config.HdrInterval)
+	}
+
+	var otlp *OTLPExporter
+	if config.OTLPEndpoint != "" {
+		if otlp, err = NewOTLPExporter(config.OTLPEndpoint); err != nil {
+			log.Fatalf("%v", err)
+		}
+		client.SetGlobalTraceFunc(otlp.Export)
+	}
+
+	run := client.Run
+	var crud *CrudWorkload
+	if config.Crud.CreateRps > 0 {
+		if config.Crud.CreateBody, err = config.crudBody(); err != nil {
+			log.Fatalf("Failed to read CRUD body: %v", err)
+		}
+		if crud, err = NewCrudWorkload(config.Crud); err != nil {
+			log.Fatalf("Failed to create CRUD workload: %v", err)
+		}
+		run = func() error { return client.RunCrud(crud) }
+	}
+	if config.PhasesFile != "" {
+		run = func() error { return client.RunPhases(config.Phases) }
+	}
 
 	// Set up logging if needed
-	var logger *log.Logger
-	var logFile *os.File
+	var logs *logFileSet
 
-	if config.LogFile != "" || config.LogJSON {
+	if config.LogFile != "" || config.LogJSON || config.LogFormat != "" {
+		logs = &logFileSet{outs: []io.Writer{os.Stderr}}
 		if config.LogFile != "" {
-			// Create or open log file
-			logFile, err = os.Create(config.LogFile)
-			if err != nil {
-				log.Fatalf("Failed to create log file %s: %v", config.LogFile, err)
+			if logs, err = createLogFiles(config.LogFile, config.LogShards, config.LogGzip); err != nil {
+				log.Fatalf("%v", err)
+			}
+			for _, f := range logs.files {
+				fmt.Printf("Logging to file: %s\n", f.Name())
 			}
-			defer logFile.Close()
-			logger = log.New(logFile, "", 0) // No prefix/timestamp for clean logs
-			fmt.Printf("Logging to file: %s\n", config.LogFile)
-		} else {
-			// Log to stdout
-			logger = log.Default()
 		}
-		logger.SetFlags(0)
-		client.SetGlobalLogger(logger)
+		defer logs.close()
+		// Each client gets its own buffer shard, each file is flushed by its
+		// own goroutine
+		logs.writers = client.SetGlobalMultiFileLogger(logs.outs, config.LogFlushInterval)
 
 		if config.LogJSON {
 			client.SetGlobalLogLineFunc(LogResultAsJSON)
 			fmt.Printf("Starting H2load test with JSON logging...\n")
+		} else if config.LogFormat != "" {
+			// Already checked by Validate
+			tmpl, _ := ParseLogTemplate(config.LogFormat)
+			client.SetGlobalLogTemplate(tmpl)
+			fmt.Printf("Starting H2load test with custom logging...\n")
 		} else {
 			client.SetGlobalLogLineFunc(LogResultAsText)
 			fmt.Printf("Starting H2load test with text logging...\n")
 		}
+		if filter := config.logFilter(); filter != nil {
+			client.SetGlobalLogFilter(filter)
+		}
 	} else {
 		fmt.Printf("Starting H2load test...\n")
 	}
@@ -173,48 +1496,256 @@ func CLIMain() {
 	fmt.Printf("  Clients: %d\n", config.Clients)
 	fmt.Printf("  Requests per client: %d\n", config.Requests)
 	fmt.Printf("  Concurrent streams per client: %d\n", config.ConcurrentStreams)
-	fmt.Printf("  RPS: %d (%s mode)\n", config.Rps, config.GetRpsModeString())
+	if crud != nil {
+		fmt.Printf("  CRUD: create %d/s, read %d/s, delete %d/s (%s mode)\n",
+			config.Crud.CreateRps, config.Crud.ReadRps, config.Crud.DeleteRps, config.GetRpsModeString())
+	} else if config.ClosedLoop.Enabled {
+		fmt.Printf("  Load model: closed loop, %d workers, %v think time\n", config.Clients*config.ConcurrentStreams, config.ClosedLoop.ThinkTime)
+	} else if config.TotalRps > 0 {
+		fmt.Printf("  Total RPS: %d (%s mode)\n", config.TotalRps, config.GetRpsModeString())
+	} else {
+		fmt.Printf("  RPS: %d (%s mode)\n", config.Rps, config.GetRpsModeString())
+	}
+	if config.RpsJitter > 0 {
+		fmt.Printf("  RPS jitter: ±%.1f%%\n", config.RpsJitter*100)
+	}
+	if config.StrictSchedule {
+		fmt.Printf("  Schedule: strict, late sends are counted\n")
+	}
+	if config.Spike.enabled() {
+		fmt.Printf("  Spikes: %v\n", config.Spike)
+	}
+	if config.Users > 0 {
+		fmt.Printf("  Virtual users: %d, each with its own cookies\n", config.Users)
+	}
+	for _, e := range config.Extract {
+		fmt.Printf("  Extract: %v\n", e)
+	}
+	if config.SendDelay != nil {
+		fmt.Printf("  Send delay: %v, holding the request's stream\n", config.SendDelay)
+	}
+	if opts := config.Socket.String(); opts != "" {
+		fmt.Printf("  Socket options: %s\n", opts)
+	}
+	if config.Throttle.enabled() {
+		fmt.Printf("  Throttle: %v\n", config.Throttle)
+	}
+	fmt.Printf("  Seed: %d\n", config.Seed)
 	if config.Duration > 0 {
 		fmt.Printf("  Duration: %v\n", config.Duration)
 	}
+	if config.RampDown > 0 {
+		fmt.Printf("  Ramp-down: up to %v\n", config.RampDown)
+	}
+	if config.Close.WaitGoAway > 0 {
+		fmt.Printf("  Connection close: graceful, waiting up to %v for the server's GOAWAY\n", config.Close.WaitGoAway)
+	} else if config.Close.Graceful {
+		fmt.Printf("  Connection close: graceful\n")
+	}
 	if config.LogFile != "" {
 		fmt.Printf("  Log file: %s\n", config.LogFile)
 	}
+	if config.HdrLog != "" {
+		fmt.Printf("  HdrHistogram log: %s (every %v)\n", config.HdrLog, config.HdrInterval)
+	}
+	if otlp != nil {
+		if config.TraceSamplesPerMinute > 0 {
+			fmt.Printf("  OTLP traces: %s, %d requests per minute\n", config.OTLPEndpoint, config.TraceSamplesPerMinute)
+		} else {
+			fmt.Printf("  OTLP traces: %s, every request\n", config.OTLPEndpoint)
+		}
+	}
+	if config.FollowRedirects > 0 {
+		fmt.Printf("  Follow redirects: up to %d\n", config.FollowRedirects)
+	}
+	if config.Method != "" {
+		fmt.Printf("  Method: %s\n", config.Method)
+	}
+	if len(config.URLList) > 0 {
+		fmt.Printf("  URL list: %s from %s\n", describeURLList(config.URLList), config.URLFile)
+	}
+	if config.Body.Size > 0 {
+		fmt.Printf("  Body: %s generated\n", formatBytes(config.Body.Size))
+	} else if config.Body.File != "" {
+		fmt.Printf("  Body: %s\n", config.Body.File)
+	} else if config.Body.Random != nil {
+		fmt.Printf("  Body: random, %s\n", config.Body.Random)
+	}
+	for _, f := range config.Body.Form {
+		fmt.Printf("  Form field: %s\n", f)
+	}
+	if config.GraphQLQueryFile != "" {
+		fmt.Printf("  GraphQL query: %s\n", config.GraphQLQueryFile)
+	}
+	for name, values := range config.Trailers.Send {
+		fmt.Printf("  Trailer: %s: %s\n", name, strings.Join(values, ", "))
+	}
+	for name, values := range config.Trailers.Expect {
+		fmt.Printf("  Expect trailer: %s: %s\n", name, strings.Join(values, " or "))
+	}
+	if len(config.Compression.Encodings) > 0 {
+		fmt.Printf("  Accept-Encoding: %s (decompress: %v)\n", config.Compression.acceptEncoding(), config.Compression.Decompress)
+	}
+	if config.Revalidate {
+		fmt.Printf("  Revalidate: If-None-Match and If-Modified-Since from each client's cached validators\n")
+	}
+	if config.ExpectContinue.Enabled {
+		fmt.Printf("  Expect: 100-continue (timeout: %v)\n", config.ExpectContinue.Timeout)
+	}
+	if config.CORS.enabled() {
+		fmt.Printf("  CORS preflight: %.4g%% of requests, Origin: %s\n", config.CORS.Fraction*100, config.CORS.origin())
+	}
+	if config.FlowStalls {
+		fmt.Printf("  Flow control stalls: timed\n")
+	}
+	if config.RespectMaxStreams {
+		fmt.Printf("  Concurrent streams: capped to the server's MAX_CONCURRENT_STREAMS\n")
+	}
+	if config.KeyLogFile != "" {
+		fmt.Printf("  TLS key log: %s (anyone with it can decrypt the traffic)\n", config.KeyLogFile)
+	}
+	if config.DebugFrames.Streams > 0 || config.DebugFrames.Sample > 0 {
+		fmt.Printf("  Frame debugging: first %d streams, %g of the others\n", config.DebugFrames.Streams, config.DebugFrames.Sample)
+	}
+	if config.Retry.Max > 0 {
+		fmt.Printf("  Retries: up to %d on %s (backoff %v)\n", config.Retry.Max, config.Retry.On, config.Retry.Backoff)
+	}
+	if len(config.Targets) > 0 {
+		fmt.Printf("  Targets: %s, client i dialing target i mod %d\n", strings.Join(config.Targets, ", "), len(config.Targets))
+	}
+	for _, c := range config.ConnectTo {
+		fmt.Printf("  Connect to: %s\n", c)
+	}
+	for _, r := range config.Resolve {
+		fmt.Printf("  Resolve: %s\n", r)
+	}
+	if config.DNSServer != "" {
+		fmt.Printf("  DNS server: %s\n", config.DNSServer)
+	}
+	if config.IPVersion != 0 {
+		fmt.Printf("  IP version: IPv%d only\n", config.IPVersion)
+	}
+	if config.Mesh.Enabled {
+		fmt.Printf("  Mesh sidecar: %s\n", client.ClientsConf.ServerAddress)
+	}
+	if config.LatencyTarget.Target > 0 {
+		fmt.Printf("  Latency target: p%g = %v\n", config.LatencyTarget.Percentile, config.LatencyTarget.Target)
+		client.SetLatencyTargetFunc(func(s LatencyTargetSample) {
+			fmt.Printf("Latency target: %s\n", s)
+		})
+	}
+
+	// abortRun ends a run that can't go on: the logs are finished, the webhook
+	// is told the run was aborted for reason, and the process exits with 1
+	abortRun := func(start time.Time, reason string) {
+		if logs != nil {
+			if err := logs.close(); err != nil {
+				log.Printf("Failed to write logs: %v", err)
+			}
+		}
+		if config.NotifyURL != "" {
+			report := NewReport(client, start)
+			report.AbortReason = reason
+			config.notify(report)
+		}
+		os.Exit(1)
+	}
+
+	// Live tuning through SIGUSR1/SIGUSR2 and the optional control socket
+	control := newLiveControl(client, config.RpsStep)
+	stopSignals := watchRpsSignals(control)
+	defer stopSignals()
+	if config.ControlSocket != "" {
+		socket, err := listenControlSocket(config.ControlSocket, control)
+		if err != nil {
+			log.Printf("Failed to start control socket: %v", err)
+			abortRun(time.Now(), fmt.Sprintf("failed to start control socket: %v", err))
+		}
+		defer socket.Close()
+		fmt.Printf("  Control socket: %s\n", config.ControlSocket)
+	}
 	fmt.Printf("\n")
 
+	if config.OutputCompat == "h2load" {
+		fmt.Fprintln(stdout, FormatH2loadHeader(config.Clients, config.Requests*config.Clients, config.Duration))
+	}
+
 	// Connect and start the test
 	if err := client.Connect(); err != nil {
-		log.Fatalf("Failed to connect: %v", err)
+		log.Printf("Failed to connect: %v", err)
+		abortRun(time.Now(), fmt.Sprintf("failed to connect: %v", err))
+	}
+
+	// Measure the idle latency the cooldown probe compares against
+	var cooldown *CooldownProbe
+	if config.Cooldown.Duration > 0 {
+		if cooldown, err = NewCooldownProbe(client.ClientsConf, config.Cooldown); err != nil {
+			log.Printf("Failed to start cooldown probe: %v", err)
+			abortRun(time.Now(), fmt.Sprintf("failed to start cooldown probe: %v", err))
+		}
+		defer cooldown.Close()
+		if err := cooldown.MeasureBaseline(); err != nil {
+			log.Printf("Failed to measure baseline latency: %v", err)
+			abortRun(time.Now(), fmt.Sprintf("failed to measure baseline latency: %v", err))
+		}
 	}
 
-	// Start the test
+	// Start the test, a SIGINT or SIGTERM stops it early but still reports it
+	stopInterrupt := abortOnInterrupt(client)
 	startTime := time.Now()
 
 	if config.Duration > 0 {
 		// Run for specified duration
-		go func() {
-			if err := client.Run(); err != nil {
-				log.Printf("Test error: %v", err)
-			}
-		}()
-
-		// Wait for duration
-		time.Sleep(config.Duration)
-		client.Stop()
+		err := client.runFor(config.Duration, config.WaitTimeout, run)
+		var waitErr *WaitTimeoutError
+		if errors.As(err, &waitErr) {
+			fmt.Fprintf(os.Stderr, "Error: %v, a stream may be wedged. Requests in flight and goroutines:\n\n%s", err, waitErr.Diagnostics)
+			abortRun(startTime, err.Error())
+		}
+		if err != nil {
+			log.Printf("Test error: %v", err)
+		}
 	} else {
 		// Run until requests are completed
-		if err := client.Start(); err != nil {
-			log.Fatalf("Test failed: %v", err)
+		if err := run(); err != nil {
+			log.Printf("Test error: %v", err)
 		}
 	}
 
 	// Wait for all operations to complete
 	client.Wait()
+	var otlpStats OTLPStats
+	if otlp != nil {
+		// Sends the spans still queued
+		otlpStats = otlp.Close()
+	}
+	stopInterrupt()
+	if logs != nil {
+		// Flush and finish the files now so the log is complete even if the
+		// process is killed while printing the results
+		if err := logs.close(); err != nil {
+			log.Printf("Failed to write logs: %v", err)
+		}
+	}
 
 	testDuration := time.Since(startTime)
-	fmt.Printf("\nTest completed in %v\n\n", testDuration)
 
-	if config.LogFile != "" {
+	var cooldownResult CooldownResult
+	if cooldown != nil {
+		fmt.Printf("\nProbing server recovery for up to %v...\n", config.Cooldown.Duration)
+		cooldownResult = cooldown.Run()
+	}
+	if reason := client.AbortReason(); reason != "" {
+		fmt.Printf("\nTest aborted after %v: %s\n\n", testDuration, reason)
+	} else {
+		fmt.Printf("\nTest completed in %v\n\n", testDuration)
+	}
+
+	if config.LogFile != "" && config.LogShards > 1 {
+		paths := logFilePaths(config.LogFile, config.LogShards)
+		fmt.Printf("Request logs written to: %s\n", strings.Join(paths, ", "))
+		fmt.Printf("Merge them by start time with: %s merge-logs -o %s %s\n\n", os.Args[0], config.LogFile, strings.Join(paths, " "))
+	} else if config.LogFile != "" {
 		fmt.Printf("Request logs written to: %s\n\n", config.LogFile)
 	}
 
@@ -224,6 +1755,132 @@ func CLIMain() {
 		fmt.Println()
 	}
 
+	fmt.Println(client.GetConcurrencyStats())
+	fmt.Println()
+
+	for _, p := range client.GetPhaseResults() {
+		fmt.Println(p)
+		fmt.Println()
+	}
+
+	if config.ShowCharts {
+		fmt.Println(FormatLatencyHistogram(client.Snapshot().Histogram))
+		fmt.Println()
+		fmt.Println(FormatRpsSparkline(client.GetTimeSeries()))
+		fmt.Println()
+	}
+
+	if config.RpsMode == RpsModeEven && (config.Rps > 0 || config.TotalRps > 0) {
+		fmt.Println(client.GetPacingReport())
+		fmt.Println()
+	}
+
+	if config.StrictSchedule {
+		fmt.Println(client.GetScheduleStats())
+		fmt.Println()
+	}
+
+	if spikes, ok := client.GetSpikeStats(); ok {
+		fmt.Println(spikes)
+		fmt.Println()
+	}
+
+	if users, ok := client.GetUserStats(); ok {
+		fmt.Println(users)
+		fmt.Println()
+	}
+
+	if revalidation, ok := client.GetRevalidationStats(); ok {
+		fmt.Println(revalidation)
+		fmt.Println()
+	}
+
+	if continues, ok := client.GetExpectContinueStats(); ok {
+		fmt.Println(continues)
+		fmt.Println()
+	}
+
+	if cors, ok := client.GetCORSStats(); ok {
+		fmt.Println(cors)
+		fmt.Println()
+	}
+
+	if config.DNSServer != "" {
+		fmt.Println(client.GetTrafficStats().LookupSummary())
+		fmt.Println()
+	}
+
+	if conns := client.GetConnectionStats(); len(conns) > 0 {
+		fmt.Println(FormatAddressFamilies(conns))
+		fmt.Println()
+	}
+
+	if settings := client.GetServerSettings(); settings != nil {
+		fmt.Printf("Server SETTINGS: %s\n", settings)
+		if limit, ok := settings.MaxConcurrentStreams(); ok && config.RespectMaxStreams && int(limit) < config.ConcurrentStreams {
+			fmt.Printf("Concurrent streams capped from %d to %d\n", config.ConcurrentStreams, limit)
+		}
+		fmt.Println()
+	}
+
+	if cooldown != nil {
+		fmt.Println(cooldownResult)
+		fmt.Println()
+	}
+
+	if targets := client.GetTargetStats(); len(targets) > 0 {
+		fmt.Println(FormatTargetStats(targets))
+		fmt.Println()
+	}
+
+	// A blended average hides differences between endpoints
+	if routes := client.GetRouteStats(); len(routes) > 1 {
+		fmt.Println(FormatRouteStats(routes))
+		fmt.Println()
+	}
+	if buckets := client.GetSizeBucketStats(); len(buckets) > 1 {
+		fmt.Println(FormatSizeBucketStats(buckets))
+		fmt.Println()
+	}
+
+	if crud != nil {
+		fmt.Println(crud.Stats())
+		fmt.Println()
+	}
+
+	if config.Capture.Dir != "" {
+		fmt.Println(client.GetCaptureStats())
+		fmt.Println()
+	}
+
+	if otlp != nil {
+		fmt.Println(otlpStats)
+		fmt.Println()
+	}
+
+	if config.MaxMemory > 0 {
+		fmt.Println(client.GetMemoryReport())
+		fmt.Println()
+	}
+
+	fmt.Println(client.GetGeneratorStats())
+	fmt.Println()
+
+	if config.Close.Graceful {
+		fmt.Println(client.GetCloseStats())
+		fmt.Println()
+	}
+
+	if config.LatencyProfileRate > 0 {
+		fmt.Println(client.GetLatencyProfile())
+		fmt.Println()
+	}
+
+	if config.ShowConnStats {
+		fmt.Println(FormatConnectionStats(client.GetConnectionStats()))
+		fmt.Println()
+	}
+
 	if config.ShowClientStats {
 		fmt.Println("Individual Client Statistics:")
 		fmt.Println("=" + strings.Repeat("=", 40))
@@ -232,4 +1889,41 @@ func CLIMain() {
 			fmt.Println(client.GetClientStats(i))
 		}
 	}
+
+	report := NewReport(client, startTime)
+	report.Metadata.Config = config.effectiveConfig()
+	if store != nil {
+		if id, err := store.SaveRun(args, report); err != nil {
+			log.Printf("Failed to save the run: %v", err)
+		} else {
+			fmt.Printf("Saved run %d to %s\n\n", id, config.Store)
+		}
+	}
+
+	if config.JUnitFile != "" {
+		var probed *CooldownResult
+		if cooldown != nil {
+			probed = &cooldownResult
+		}
+		checks := config.runChecks(client.AbortReason(), probed)
+		if err := WriteJUnitFile(config.JUnitFile, "h2load", startTime, testDuration, checks); err != nil {
+			log.Printf("Failed to write the JUnit report: %v", err)
+		} else {
+			fmt.Printf("JUnit report written to: %s\n\n", config.JUnitFile)
+		}
+	}
+
+	if config.NotifyURL != "" {
+		config.notify(report)
+	}
+
+	if config.OutputCompat == "h2load" {
+		fmt.Fprintf(stdout, "\n%s\n", FormatH2loadSummary(client, testDuration))
+	}
+
+	if config.OutputFormat != "" {
+		if err := WriteReport(stdout, report, config.OutputFormat); err != nil {
+			log.Fatalf("Failed to write the summary: %v", err)
+		}
+	}
 }
@@ -0,0 +1,153 @@
+package h2load
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ABConf splits the clients of a run between two variants running side by
+// side: A, the conf as is, and B, the conf with what is set here replaced.
+// Both see the same load at the same time, so their stats compare without
+// the drift of two separate runs.
+type ABConf struct {
+	URL           string        // URL of variant B (default: A's)
+	Headers       http.Header   // Headers of variant B, replacing A's of the same name
+	ServerAddress string        // Address variant B dials (default: A's)
+	Duration      time.Duration // How long both variants run (0 = the conf's Requests per client)
+}
+
+func (c *ABConf) enabled() bool {
+	return c.URL != "" || len(c.Headers) > 0 || c.ServerAddress != ""
+}
+
+func (c *ABConf) Validate(conf H2loadConf) error {
+	if !c.enabled() {
+		return fmt.Errorf("A/B comparison needs a URL, headers or a server address for variant B")
+	}
+	if c.ServerAddress != "" {
+		if _, _, err := net.SplitHostPort(c.ServerAddress); err != nil {
+			return fmt.Errorf("invalid A/B server address %q, expected host:port", c.ServerAddress)
+		}
+	}
+	if conf.Clients < 2 || conf.Clients%2 != 0 {
+		// Unequal halves would skew the comparison of RPS and requests
+		return fmt.Errorf("A/B comparison splits the clients evenly between the variants, it needs an even number, got %d", conf.Clients)
+	}
+	if len(conf.Targets) > 0 {
+		return fmt.Errorf("A/B comparison can't be used with targets")
+	}
+	if c.Duration < 0 {
+		return fmt.Errorf("A/B duration must be greater than 0")
+	}
+	if c.Duration == 0 && conf.Requests == 0 {
+		return fmt.Errorf("A/B comparison needs a duration or a number of requests")
+	}
+	return nil
+}
+
+// variants returns the confs of A and B, each with half the clients and of
+// TotalRps
+func (c *ABConf) variants(conf H2loadConf) (a, b H2loadConf) {
+	if c.Duration > 0 {
+		conf.Requests = 0
+	}
+	a, b = conf, conf
+	a.Clients = conf.Clients / 2
+	b.Clients = conf.Clients - a.Clients
+	if conf.TotalRps > 0 {
+		a.TotalRps = conf.TotalRps / 2
+		b.TotalRps = conf.TotalRps - a.TotalRps
+	}
+
+	if c.URL != "" {
+		b.URL = c.URL
+	}
+	if c.ServerAddress != "" {
+		b.ServerAddress = c.ServerAddress
+	}
+	if len(c.Headers) > 0 {
+		b.Headers = conf.Headers.Clone()
+		if b.Headers == nil {
+			b.Headers = make(http.Header)
+		}
+		for name, values := range c.Headers {
+			b.Headers[name] = values
+		}
+	}
+	return a, b
+}
+
+// ABResult is the outcome of an A/B comparison
+type ABResult struct {
+	A, B       RequestStats
+	AClients   int
+	BClients   int
+	Comparison Comparison // B against A
+}
+
+// String formats the stats of both variants and the comparison as tables
+func (r ABResult) String() string {
+	stats := formatStatsTable("A/B Statistics:", "Variant", []RouteStats{
+		{Route: fmt.Sprintf("A (%d clients)", r.AClients), RequestStats: r.A},
+		{Route: fmt.Sprintf("B (%d clients)", r.BClients), RequestStats: r.B},
+	})
+	return stats + "\n\n" + r.Comparison.String()
+}
+
+// RunAB runs the variants of ab side by side, each with a fresh
+// H2loadClient, and compares B against A
+func RunAB(conf H2loadConf, ab ABConf) (ABResult, error) {
+	if err := ab.Validate(conf); err != nil {
+		return ABResult{}, err
+	}
+	confA, confB := ab.variants(conf)
+	clientA, err := NewH2loadClient(confA)
+	if err != nil {
+		return ABResult{}, fmt.Errorf("variant A: %w", err)
+	}
+	defer clientA.Close()
+	clientB, err := NewH2loadClient(confB)
+	if err != nil {
+		return ABResult{}, fmt.Errorf("variant B: %w", err)
+	}
+	defer clientB.Close()
+	if err := clientA.Connect(); err != nil {
+		return ABResult{}, fmt.Errorf("variant A: connect failed: %w", err)
+	}
+	if err := clientB.Connect(); err != nil {
+		return ABResult{}, fmt.Errorf("variant B: connect failed: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, client := range []*H2loadClient{clientA, clientB} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ab.Duration > 0 {
+				errs[i] = client.runFor(ab.Duration, 0, client.Run)
+			} else {
+				errs[i] = client.Run()
+			}
+		}()
+	}
+	wg.Wait()
+	if errs[0] != nil {
+		return ABResult{}, fmt.Errorf("variant A: %w", errs[0])
+	}
+	if errs[1] != nil {
+		return ABResult{}, fmt.Errorf("variant B: %w", errs[1])
+	}
+
+	result := ABResult{
+		A:        clientA.GetTotalStats(),
+		B:        clientB.GetTotalStats(),
+		AClients: confA.Clients,
+		BClients: confB.Clients,
+	}
+	result.Comparison = CompareReports("A", Report{Total: NewStatsReport(result.A)}, "B", Report{Total: NewStatsReport(result.B)})
+	return result, nil
+}
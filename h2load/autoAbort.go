@@ -0,0 +1,84 @@
+package h2load
+
+import (
+	"fmt"
+	"time"
+)
+
+// AbortConf stops the run early when the server is clearly failing.
+// Thresholds are checked over consecutive windows of recent requests.
+type AbortConf struct {
+	ErrorRate   float64       // Abort when a window's error rate exceeds this fraction (0 = disabled)
+	P99         time.Duration // Abort when a window's p99 latency exceeds this (0 = disabled)
+	Window      time.Duration // Length of each evaluation window (default: 5s)
+	MinRequests int64         // Requests needed before a window is evaluated (default: 20)
+}
+
+func (c *AbortConf) Validate() error {
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("abort error rate must be between 0 and 1")
+	}
+	if c.P99 < 0 {
+		return fmt.Errorf("abort p99 must be greater than 0")
+	}
+	if c.Window < 0 {
+		return fmt.Errorf("abort window must be greater than 0")
+	}
+	if c.MinRequests < 0 {
+		return fmt.Errorf("abort min requests must be greater than 0")
+	}
+	return nil
+}
+
+func (c *AbortConf) enabled() bool {
+	return c.ErrorRate > 0 || c.P99 > 0
+}
+
+// abortMonitor evaluates windows of recent stats against the abort thresholds
+type abortMonitor struct {
+	conf   AbortConf
+	window *statsWindow
+}
+
+func newAbortMonitor(conf AbortConf) *abortMonitor {
+	if conf.Window == 0 {
+		conf.Window = 5 * time.Second
+	}
+	if conf.MinRequests == 0 {
+		conf.MinRequests = 20
+	}
+	return &abortMonitor{conf: conf, window: newStatsWindow()}
+}
+
+// run checks every window until done is closed, calling abort with the
+// reason the first time a threshold is exceeded
+func (m *abortMonitor) run(done <-chan struct{}, abort func(reason string)) {
+	ticker := time.NewTicker(m.conf.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if reason := m.check(); reason != "" {
+				abort(reason)
+				return
+			}
+		}
+	}
+}
+
+// check returns why the latest window breaks a threshold, or "" if it doesn't
+func (m *abortMonitor) check() string {
+	stats, _ := m.window.take()
+	if stats.TotalRequests < m.conf.MinRequests {
+		return ""
+	}
+	if m.conf.ErrorRate > 0 && stats.ErrorRate() > m.conf.ErrorRate {
+		return fmt.Sprintf("error rate %.2f%% exceeded %.2f%%", stats.ErrorRate()*100, m.conf.ErrorRate*100)
+	}
+	if m.conf.P99 > 0 && stats.P99Latency > m.conf.P99 {
+		return fmt.Sprintf("p99 latency %v exceeded %v", stats.P99Latency, m.conf.P99)
+	}
+	return ""
+}
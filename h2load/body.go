@@ -0,0 +1,174 @@
+package h2load
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// BodyFactory returns a fresh body for every request. Bodies are read while
+// the request is sent, so large uploads are streamed rather than buffered.
+// A body that is an io.ReadCloser is closed once sent.
+type BodyFactory func() io.Reader
+
+// BodyConf sets the body of the requests built from the URL
+type BodyConf struct {
+	Size   int64       // Bytes generated for each body (0 = no body)
+	File   string      // File streamed as each body, opened for every request
+	Random *SizeDist   // Random bodies, with sizes drawn from this distribution
+	Form   []FormField // multipart/form-data bodies, sent with POST unless another method is set
+}
+
+func (c *BodyConf) Validate() error {
+	if c.Size < 0 {
+		return fmt.Errorf("body size must be greater than 0")
+	}
+	sources := 0
+	for _, set := range []bool{c.Size > 0, c.File != "", c.Random != nil, len(c.Form) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("body size, body file, random body and form are mutually exclusive")
+	}
+	if c.Random != nil {
+		if err := c.Random.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, f := range c.Form {
+		if f.File == "" {
+			continue
+		}
+		if _, err := os.Stat(f.File); err != nil {
+			return fmt.Errorf("form field %s: %w", f.Name, err)
+		}
+	}
+	if c.File != "" {
+		if _, err := os.Stat(c.File); err != nil {
+			return fmt.Errorf("body file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *BodyConf) enabled() bool {
+	return c.Size > 0 || c.File != "" || c.Random != nil || len(c.Form) > 0
+}
+
+// requestFactory returns a request factory sending method requests to url
+// with the bodies of the conf, random ones drawn with seed
+func (c *BodyConf) requestFactory(method, url string, header http.Header, seed uint64) (func() *http.Request, error) {
+	switch {
+	case len(c.Form) > 0:
+		return MultipartRequestFactory(method, url, header, c.Form)
+	case c.Random != nil:
+		return randomRequestFactory(method, url, header, *c.Random, newLockedRand(seed, randBodies))
+	case c.File != "":
+		info, err := os.Stat(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("body file: %w", err)
+		}
+		return StreamingRequestFactory(method, url, header, FileBody(c.File), info.Size())
+	}
+	return StreamingRequestFactory(method, url, header, GeneratedBody(c.Size), c.Size)
+}
+
+// bodyPattern is repeated to fill generated bodies
+var bodyPattern = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789\n")
+
+// patternReader fills reads with bodyPattern, without allocating
+type patternReader struct {
+	offset int
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = bodyPattern[r.offset]
+		r.offset = (r.offset + 1) % len(bodyPattern)
+	}
+	return len(p), nil
+}
+
+// GeneratedBody returns bodies of n bytes, generated as they are read
+func GeneratedBody(n int64) BodyFactory {
+	return func() io.Reader {
+		return io.LimitReader(&patternReader{}, n)
+	}
+}
+
+// FileBody returns bodies streamed from the file at path. A file that can't
+// be opened fails the request.
+func FileBody(path string) BodyFactory {
+	return func() io.Reader {
+		f, err := os.Open(path)
+		if err != nil {
+			return errReader{err}
+		}
+		return f
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// StreamingRequestFactory returns a request factory for RunRequestsFactory
+// sending method requests to url, with the bodies of body. size is the length
+// of every body, sent as Content-Length, or -1 if unknown.
+func StreamingRequestFactory(method, url string, header http.Header, body BodyFactory, size int64) (func() *http.Request, error) {
+	return newBodyRequestFactory(method, url, header, func() (BodyFactory, int64) {
+		return body, size
+	})
+}
+
+// newBodyRequestFactory returns a request factory sending method requests to
+// url, each with the body and size returned by next. Retries resend a body
+// of the same size from the same BodyFactory.
+func newBodyRequestFactory(method, url string, header http.Header, next func() (BodyFactory, int64)) (func() *http.Request, error) {
+	template, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		template.Header[k] = v
+	}
+	return func() *http.Request {
+		req := template.Clone(template.Context())
+		body, size := next()
+		req.ContentLength = size
+		if size != 0 {
+			req.Body = readCloserOf(body())
+			req.GetBody = func() (io.ReadCloser, error) {
+				return readCloserOf(body()), nil
+			}
+		}
+		return req
+	}, nil
+}
+
+func readCloserOf(r io.Reader) io.ReadCloser {
+	if rc, ok := r.(io.ReadCloser); ok {
+		return rc
+	}
+	return io.NopCloser(r)
+}
+
+// uploadBody counts the bytes of a request body as they are sent
+type uploadBody struct {
+	io.ReadCloser
+	sent *int64
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.sent, int64(n))
+	return n, err
+}
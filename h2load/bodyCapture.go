@@ -0,0 +1,140 @@
+package h2load
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// CaptureConf configures capturing the responses of failed requests to a
+// debug directory
+type CaptureConf struct {
+	Dir         string // Directory the captures are written to (empty = disabled)
+	Max         int    // Most responses captured per run (default: 20)
+	Every       int    // Capture one in every this many failures (default: 1)
+	MaxBodySize int64  // Bytes of each body kept (default: 64KiB)
+}
+
+func (c *CaptureConf) Validate() error {
+	if c.Max < 0 {
+		return fmt.Errorf("capture max must be greater than 0")
+	}
+	if c.Every < 0 {
+		return fmt.Errorf("capture every must be greater than 0")
+	}
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("capture max body size must be greater than 0")
+	}
+	return nil
+}
+
+// CaptureStats counts the captures of a run
+type CaptureStats struct {
+	Dir      string
+	Captured int64 // Responses written to Dir
+	Shed     int64 // Captures skipped to stay under the memory cap
+	Errors   int64 // Captures that failed to be written
+}
+
+// String formats the CaptureStats as a readable string
+func (s CaptureStats) String() string {
+	summary := fmt.Sprintf("Captured %d failed responses to %s", s.Captured, s.Dir)
+	if s.Shed > 0 {
+		summary += fmt.Sprintf("\nCaptures Shed (memory cap): %d", s.Shed)
+	}
+	if s.Errors > 0 {
+		summary += fmt.Sprintf("\nCaptures Failed To Write: %d", s.Errors)
+	}
+	return summary
+}
+
+// bodyCapturer writes failed requests and their responses to files. A single
+// capturer is shared by all clients so Max and Every apply to the whole run.
+type bodyCapturer struct {
+	conf     CaptureConf
+	failures int64 // Failures seen, for Every
+	seq      int64 // Captures started, for Max and file names
+	shed     int64
+	errors   int64
+
+	dirOnce sync.Once
+	dirErr  error
+}
+
+func newBodyCapturer(conf CaptureConf) *bodyCapturer {
+	if conf.Dir == "" {
+		return nil
+	}
+	if conf.Max == 0 {
+		conf.Max = 20
+	}
+	if conf.Every == 0 {
+		conf.Every = 1
+	}
+	if conf.MaxBodySize == 0 {
+		conf.MaxBodySize = 64 << 10
+	}
+	return &bodyCapturer{conf: conf}
+}
+
+// claim reports whether a failure should be captured and returns its number
+func (c *bodyCapturer) claim(shedding bool) (int64, bool) {
+	if atomic.AddInt64(&c.failures, 1)%int64(c.conf.Every) != 0 {
+		return 0, false
+	}
+	if atomic.LoadInt64(&c.seq) >= int64(c.conf.Max) {
+		return 0, false
+	}
+	if shedding {
+		atomic.AddInt64(&c.shed, 1)
+		return 0, false
+	}
+	n := atomic.AddInt64(&c.seq, 1)
+	return n, n <= int64(c.conf.Max)
+}
+
+// captureResponse writes req and resp to a file. The captured part of the
+// body is put back in front of resp.Body so later readers still see it all.
+func (c *bodyCapturer) captureResponse(n int64, req *http.Request, resp *http.Response) {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, c.conf.MaxBodySize))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\n\n%s %s\n", req.Method, req.URL, resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	b.WriteString("\n")
+	b.Write(body)
+	c.write(fmt.Sprintf("capture-%04d-%d.txt", n, resp.StatusCode), b.Bytes())
+}
+
+// captureError writes req and the error it failed with to a file
+func (c *bodyCapturer) captureError(n int64, req *http.Request, err error) {
+	content := fmt.Sprintf("%s %s\n\nerror: %v\n", req.Method, req.URL, err)
+	c.write(fmt.Sprintf("capture-%04d-error.txt", n), []byte(content))
+}
+
+func (c *bodyCapturer) write(name string, content []byte) {
+	c.dirOnce.Do(func() {
+		c.dirErr = os.MkdirAll(c.conf.Dir, 0755)
+	})
+	if c.dirErr != nil || os.WriteFile(filepath.Join(c.conf.Dir, name), content, 0644) != nil {
+		atomic.AddInt64(&c.errors, 1)
+	}
+}
+
+func (c *bodyCapturer) stats() CaptureStats {
+	return CaptureStats{
+		Dir:      c.conf.Dir,
+		Captured: min(atomic.LoadInt64(&c.seq), int64(c.conf.Max)) - atomic.LoadInt64(&c.errors),
+		Shed:     atomic.LoadInt64(&c.shed),
+		Errors:   atomic.LoadInt64(&c.errors),
+	}
+}
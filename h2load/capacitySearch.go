@@ -0,0 +1,154 @@
+package h2load
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CapacitySearchConf configures the search for the highest sustainable total RPS
+type CapacitySearchConf struct {
+	StartRps     int           // First rate tried
+	MaxRps       int           // Upper bound for the search (0 = unbounded)
+	Precision    int           // Stop bisecting once the pass/fail gap is this small
+	StepDuration time.Duration // How long each rate is held
+	MaxErrorRate float64       // Highest acceptable failed/total ratio (0-1)
+	MaxP99       time.Duration // Highest acceptable p99 latency (0 = not checked)
+	MinAchieved  float64       // Lowest acceptable achieved/target RPS ratio (0-1)
+}
+
+func (c *CapacitySearchConf) Validate() error {
+	if c.StartRps <= 0 {
+		return fmt.Errorf("capacity search start rps must be greater than 0")
+	}
+	if c.MaxRps < 0 {
+		return fmt.Errorf("capacity search max rps must be greater than 0")
+	}
+	if c.StepDuration <= 0 {
+		return fmt.Errorf("capacity search step duration must be greater than 0")
+	}
+	if c.MaxErrorRate < 0 || c.MaxErrorRate > 1 {
+		return fmt.Errorf("capacity search max error rate must be between 0 and 1")
+	}
+	if c.MinAchieved < 0 || c.MinAchieved > 1 {
+		return fmt.Errorf("capacity search min achieved ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// CapacityStep is the outcome of holding one rate during the search
+type CapacityStep struct {
+	Rps    int
+	Stats  RequestStats
+	Passed bool
+	Reason string // Why the step failed, empty if it passed
+}
+
+// CapacityResult is the outcome of a capacity search
+type CapacityResult struct {
+	MaxRps int // Highest rate that passed, 0 if none did
+	Steps  []CapacityStep
+}
+
+// String formats the CapacityResult as a readable string
+func (r CapacityResult) String() string {
+	var b strings.Builder
+	b.WriteString("Capacity Search:\n")
+	for _, s := range r.Steps {
+		result := "pass"
+		if !s.Passed {
+			result = "fail (" + s.Reason + ")"
+		}
+		fmt.Fprintf(&b, "  %6d rps: achieved %.2f rps, p99 %v, errors %.2f%% - %s\n",
+			s.Rps, s.Stats.Rps(), s.Stats.P99Latency, s.Stats.ErrorRate()*100, result)
+	}
+	if r.MaxRps > 0 {
+		fmt.Fprintf(&b, "Max sustainable RPS: %d", r.MaxRps)
+	} else {
+		b.WriteString("Max sustainable RPS: none of the tried rates passed")
+	}
+	return b.String()
+}
+
+// SearchCapacity finds the highest total RPS at which the error rate and p99
+// stay under the configured thresholds. Rates are doubled from StartRps until a
+// step fails, then bisected between the last passing and first failing rate.
+// Each step runs a fresh H2loadClient built from conf with TotalRps set.
+func SearchCapacity(conf H2loadConf, search CapacitySearchConf) (CapacityResult, error) {
+	var result CapacityResult
+	if err := search.Validate(); err != nil {
+		return result, err
+	}
+	precision := search.Precision
+	if precision < 1 {
+		precision = 1
+	}
+
+	// Ramp up until the first failure
+	lo, hi := 0, 0
+	for rps := search.StartRps; ; rps *= 2 {
+		if search.MaxRps > 0 && rps > search.MaxRps {
+			rps = search.MaxRps
+		}
+		step, err := runCapacityStep(conf, search, rps)
+		if err != nil {
+			return result, err
+		}
+		result.Steps = append(result.Steps, step)
+		if !step.Passed {
+			hi = rps
+			break
+		}
+		lo = rps
+		if rps == search.MaxRps {
+			break
+		}
+	}
+
+	// Bisect between the last pass and the first failure
+	for hi > 0 && hi-lo > precision {
+		rps := lo + (hi-lo)/2
+		step, err := runCapacityStep(conf, search, rps)
+		if err != nil {
+			return result, err
+		}
+		result.Steps = append(result.Steps, step)
+		if step.Passed {
+			lo = rps
+		} else {
+			hi = rps
+		}
+	}
+
+	result.MaxRps = lo
+	return result, nil
+}
+
+func runCapacityStep(conf H2loadConf, search CapacitySearchConf, rps int) (CapacityStep, error) {
+	conf.Rps = 0
+	conf.TotalRps = rps
+	conf.Requests = 0
+
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return CapacityStep{}, err
+	}
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		return CapacityStep{}, fmt.Errorf("connect failed at %d rps: %w", rps, err)
+	}
+	client.runFor(search.StepDuration, 0, client.Run)
+
+	step := CapacityStep{Rps: rps, Stats: client.GetTotalStats(), Passed: true}
+	switch {
+	case step.Stats.TotalRequests == 0:
+		step.Passed, step.Reason = false, "no requests completed"
+	case step.Stats.ErrorRate() > search.MaxErrorRate:
+		step.Passed, step.Reason = false, fmt.Sprintf("error rate %.2f%%", step.Stats.ErrorRate()*100)
+	case search.MaxP99 > 0 && step.Stats.P99Latency > search.MaxP99:
+		step.Passed, step.Reason = false, fmt.Sprintf("p99 %v", step.Stats.P99Latency)
+	case step.Stats.Rps() < float64(rps)*search.MinAchieved:
+		step.Passed, step.Reason = false, fmt.Sprintf("achieved only %.2f rps", step.Stats.Rps())
+	}
+	return step, nil
+}
@@ -0,0 +1,127 @@
+package h2load
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	chartRows     = 12 // Rows of the latency histogram
+	chartBarWidth = 40 // Width of the longest histogram bar
+	sparkWidth    = 60 // Most points in a sparkline, longer series are averaged down
+)
+
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// FormatLatencyHistogram renders hist as a text histogram. Buckets are spaced
+// logarithmically when latencies span more than a factor of 10, so both a
+// fast mode and a slow tail stay visible.
+func FormatLatencyHistogram(hist *hdrhistogram.Histogram) string {
+	if hist.TotalCount() == 0 {
+		return "Latency Histogram: no requests"
+	}
+	lo, hi := float64(max(hist.Min(), 1)), float64(max(hist.Max(), 1))
+	rows := chartRows
+	if hi-lo < float64(rows) {
+		rows = max(int(hi-lo), 1)
+	}
+	logScale := hi/lo > 10
+	edge := func(i int) float64 {
+		f := float64(i) / float64(rows)
+		if logScale {
+			return lo * math.Pow(hi/lo, f)
+		}
+		return lo + (hi-lo)*f
+	}
+
+	counts := make([]int64, rows)
+	for _, bar := range hist.Distribution() {
+		if bar.Count == 0 {
+			continue
+		}
+		v := float64(bar.From+bar.To) / 2
+		i := rows - 1
+		for j := 1; j < rows; j++ {
+			if v < edge(j) {
+				i = j - 1
+				break
+			}
+		}
+		counts[i] += bar.Count
+	}
+	var peak int64
+	for _, c := range counts {
+		peak = max(peak, c)
+	}
+
+	var b strings.Builder
+	b.WriteString("Latency Histogram:\n")
+	for i, c := range counts {
+		from := time.Duration(edge(i)) * time.Microsecond
+		to := time.Duration(edge(i+1)) * time.Microsecond
+		width := int(math.Round(float64(c) / float64(peak) * chartBarWidth))
+		if c > 0 && width == 0 {
+			width = 1
+		}
+		fmt.Fprintf(&b, "  %9v - %-9v %-*s %d\n", roundLatency(from), roundLatency(to),
+			chartBarWidth, strings.Repeat("█", width), c)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Sparkline renders values as a line of block characters scaled between 0
+// and the largest value, averaging neighbours when there are more than width
+func Sparkline(values []float64, width int) string {
+	if len(values) > width {
+		merged := make([]float64, width)
+		for i := range merged {
+			from, to := i*len(values)/width, (i+1)*len(values)/width
+			var sum float64
+			for _, v := range values[from:to] {
+				sum += v
+			}
+			merged[i] = sum / float64(to-from)
+		}
+		values = merged
+	}
+	var peak float64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if peak > 0 {
+			level = int(math.Round(v / peak * float64(len(sparkLevels)-1)))
+		}
+		b.WriteRune(sparkLevels[level])
+	}
+	return b.String()
+}
+
+// FormatRpsSparkline renders the achieved RPS of each interval of a run
+func FormatRpsSparkline(points []TimeSeriesPoint) string {
+	if len(points) == 0 {
+		return "RPS Over Time: no data"
+	}
+	rps := make([]float64, len(points))
+	lo, hi := math.Inf(1), 0.0
+	for i, p := range points {
+		rps[i] = p.Rps
+		lo, hi = min(lo, p.Rps), max(hi, p.Rps)
+	}
+	return fmt.Sprintf("RPS Over Time: %s  (min %.1f, max %.1f)", Sparkline(rps, sparkWidth), lo, hi)
+}
+
+// roundLatency rounds d to 3 significant digits for display
+func roundLatency(d time.Duration) time.Duration {
+	unit := time.Duration(1)
+	for d/unit >= 1000 {
+		unit *= 10
+	}
+	return d.Round(unit)
+}
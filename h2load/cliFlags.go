@@ -0,0 +1,523 @@
+package h2load
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// percentValue is a flag.Value for percentages such as "10%" or "10",
+// stored as a fraction (0.1)
+type percentValue struct {
+	fraction *float64
+}
+
+func newPercentValue(fraction *float64, defaultPercent float64) *percentValue {
+	*fraction = defaultPercent / 100
+	return &percentValue{fraction: fraction}
+}
+
+func (p *percentValue) String() string {
+	if p.fraction == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*p.fraction*100, 'f', -1, 64) + "%"
+}
+
+func (p *percentValue) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return fmt.Errorf("invalid percentage %q", s)
+	}
+	if v < 0 || v > 100 {
+		return fmt.Errorf("percentage %q must be between 0%% and 100%%", s)
+	}
+	*p.fraction = v / 100
+	return nil
+}
+
+// headerValue is a repeatable flag.Value for "Name: value" headers
+type headerValue struct {
+	header *http.Header
+}
+
+func (h *headerValue) String() string {
+	if h.header == nil {
+		return ""
+	}
+	var parts []string
+	for k, vs := range *h.header {
+		for _, v := range vs {
+			parts = append(parts, k+": "+v)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h *headerValue) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header %q, expected \"Name: value\"", s)
+	}
+	if *h.header == nil {
+		*h.header = http.Header{}
+	}
+	h.header.Add(name, strings.TrimSpace(value))
+	return nil
+}
+
+// connectToValue is a repeatable flag.Value for HOST1:PORT1:HOST2:PORT2
+// mappings
+type connectToValue struct {
+	mappings *[]ConnectTo
+}
+
+func (c *connectToValue) String() string {
+	if c.mappings == nil {
+		return ""
+	}
+	var parts []string
+	for _, m := range *c.mappings {
+		parts = append(parts, m.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (c *connectToValue) Set(s string) error {
+	m, err := ParseConnectTo(s)
+	if err != nil {
+		return err
+	}
+	*c.mappings = append(*c.mappings, m)
+	return nil
+}
+
+// resolveValue is a repeatable flag.Value for HOST:PORT:ADDR[,ADDR]... entries
+type resolveValue struct {
+	entries *[]Resolve
+}
+
+func (r *resolveValue) String() string {
+	if r.entries == nil {
+		return ""
+	}
+	var parts []string
+	for _, e := range *r.entries {
+		parts = append(parts, e.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *resolveValue) Set(s string) error {
+	e, err := ParseResolve(s)
+	if err != nil {
+		return err
+	}
+	*r.entries = append(*r.entries, e)
+	return nil
+}
+
+// defaultMaxRedirects is the redirect limit of -follow-redirects given
+// without a value, the same as Go's http.Client
+const defaultMaxRedirects = 10
+
+// redirectsValue is a flag.Value for -follow-redirects[=max]: given alone it
+// follows up to defaultMaxRedirects redirects, "=false" or "=0" turns it off
+type redirectsValue struct {
+	max *int
+}
+
+func (r *redirectsValue) IsBoolFlag() bool { return true }
+
+func (r *redirectsValue) String() string {
+	if r.max == nil {
+		return ""
+	}
+	return strconv.Itoa(*r.max)
+}
+
+func (r *redirectsValue) Set(s string) error {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		*r.max = n
+		return nil
+	}
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid redirect limit %q", s)
+	}
+	*r.max = 0
+	if on {
+		*r.max = defaultMaxRedirects
+	}
+	return nil
+}
+
+// retryOnValue is a flag.Value for a comma-separated list of retried failures
+type retryOnValue struct {
+	on *RetryOn
+}
+
+func (r *retryOnValue) String() string {
+	if r.on == nil {
+		return ""
+	}
+	return r.on.String()
+}
+
+func (r *retryOnValue) Set(s string) error {
+	on, err := ParseRetryOn(s)
+	if err != nil {
+		return err
+	}
+	*r.on = on
+	return nil
+}
+
+// encodingsValue is a flag.Value for a comma-separated list of content codings
+type encodingsValue struct {
+	encodings *[]string
+}
+
+func (e *encodingsValue) String() string {
+	if e.encodings == nil {
+		return ""
+	}
+	return strings.Join(*e.encodings, ",")
+}
+
+func (e *encodingsValue) Set(s string) error {
+	encodings, err := ParseEncodings(s)
+	if err != nil {
+		return err
+	}
+	*e.encodings = encodings
+	return nil
+}
+
+// headerNamesValue is a flag.Value for a comma-separated list of header
+// names, appended to when repeated
+type headerNamesValue struct {
+	names *[]string
+}
+
+func (v *headerNamesValue) String() string {
+	if v.names == nil {
+		return ""
+	}
+	return strings.Join(*v.names, ",")
+}
+
+func (v *headerNamesValue) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*v.names = append(*v.names, strings.ToLower(name))
+		}
+	}
+	return nil
+}
+
+// sizeDistValue is a flag.Value for a SizeDist, setting it when given
+type sizeDistValue struct {
+	dist **SizeDist
+}
+
+func (d *sizeDistValue) String() string {
+	if d.dist == nil || *d.dist == nil {
+		return ""
+	}
+	return (*d.dist).String()
+}
+
+func (d *sizeDistValue) Set(s string) error {
+	dist, err := ParseSizeDist(s)
+	if err != nil {
+		return err
+	}
+	*d.dist = &dist
+	return nil
+}
+
+// delayDistValue is a flag.Value for a DelayDist, setting it when given
+type delayDistValue struct {
+	dist **DelayDist
+}
+
+func (d *delayDistValue) String() string {
+	if d.dist == nil || *d.dist == nil {
+		return ""
+	}
+	return (*d.dist).String()
+}
+
+func (d *delayDistValue) Set(s string) error {
+	dist, err := ParseDelayDist(s)
+	if err != nil {
+		return err
+	}
+	*d.dist = &dist
+	return nil
+}
+
+// targetsValue is a flag.Value for a comma-separated list of host:port
+// addresses, appended to when repeated
+type targetsValue struct {
+	targets *[]string
+}
+
+func (v *targetsValue) String() string {
+	if v.targets == nil {
+		return ""
+	}
+	return strings.Join(*v.targets, ",")
+}
+
+func (v *targetsValue) Set(s string) error {
+	for _, target := range strings.Split(s, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			*v.targets = append(*v.targets, target)
+		}
+	}
+	return nil
+}
+
+// ipVersionValue is a flag.Value for an IP version: 4, 6 or auto
+type ipVersionValue struct {
+	version *int
+}
+
+func (v *ipVersionValue) String() string {
+	if v.version == nil || *v.version == 0 {
+		return "auto"
+	}
+	return strconv.Itoa(*v.version)
+}
+
+func (v *ipVersionValue) Set(s string) error {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "4":
+		*v.version = 4
+	case "6":
+		*v.version = 6
+	case "auto":
+		*v.version = 0
+	default:
+		return fmt.Errorf("invalid IP version %q, expected 4, 6 or auto", s)
+	}
+	return nil
+}
+
+// bitRateValue is a flag.Value for a link speed in bits per second, such as
+// 750kbit, stored in bytes per second
+type bitRateValue struct {
+	rate *int64
+}
+
+func (v *bitRateValue) String() string {
+	if v.rate == nil || *v.rate == 0 {
+		return "0"
+	}
+	return FormatBitRate(*v.rate)
+}
+
+func (v *bitRateValue) Set(s string) error {
+	rate, err := ParseBitRate(s)
+	if err != nil {
+		return err
+	}
+	*v.rate = rate
+	return nil
+}
+
+// spikeValue is a flag.Value for a SpikeConf
+type spikeValue struct {
+	spike *SpikeConf
+}
+
+func (v *spikeValue) String() string {
+	if v.spike == nil || !v.spike.enabled() {
+		return ""
+	}
+	return fmt.Sprintf("rps=%d,duration=%v,every=%v", v.spike.Rps, v.spike.Duration, v.spike.Every)
+}
+
+func (v *spikeValue) Set(s string) error {
+	spike, err := ParseSpike(s)
+	if err != nil {
+		return err
+	}
+	*v.spike = spike
+	return nil
+}
+
+// extractorsValue is a repeatable flag.Value for response extractors
+type extractorsValue struct {
+	extractors *[]Extractor
+}
+
+func (v *extractorsValue) String() string {
+	if v.extractors == nil {
+		return ""
+	}
+	parts := make([]string, len(*v.extractors))
+	for i, e := range *v.extractors {
+		parts[i] = e.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (v *extractorsValue) Set(s string) error {
+	e, err := ParseExtractor(s)
+	if err != nil {
+		return err
+	}
+	*v.extractors = append(*v.extractors, e)
+	return nil
+}
+
+// sizesValue is a flag.Value for a comma-separated list of sizes
+type sizesValue struct {
+	sizes *[]int64
+}
+
+func (v *sizesValue) String() string {
+	if v.sizes == nil {
+		return ""
+	}
+	var parts []string
+	for _, n := range *v.sizes {
+		parts = append(parts, formatBytes(n))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v *sizesValue) Set(s string) error {
+	sizes, err := ParseSizes(s)
+	if err != nil {
+		return err
+	}
+	*v.sizes = sizes
+	return nil
+}
+
+// sweepValuesValue is a flag.Value for the values of a load sweep, setting
+// the swept parameter too
+type sweepValuesValue struct {
+	sweep *LoadSweepConf
+	param LoadSweepParam
+}
+
+func (v *sweepValuesValue) String() string {
+	if v.sweep == nil || v.sweep.Param != v.param {
+		return ""
+	}
+	parts := make([]string, len(v.sweep.Values))
+	for i, n := range v.sweep.Values {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v *sweepValuesValue) Set(s string) error {
+	values, err := ParseSweepValues(s)
+	if err != nil {
+		return err
+	}
+	if len(v.sweep.Values) > 0 && v.sweep.Param != v.param {
+		return fmt.Errorf("-sweep-streams and -sweep-rps cannot be used together")
+	}
+	v.sweep.Param, v.sweep.Values = v.param, values
+	return nil
+}
+
+// formFieldValue is a repeatable flag.Value for name=value and name=@file
+// form fields
+type formFieldValue struct {
+	fields *[]FormField
+}
+
+func (v *formFieldValue) String() string {
+	if v.fields == nil {
+		return ""
+	}
+	var parts []string
+	for _, f := range *v.fields {
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (v *formFieldValue) Set(s string) error {
+	f, err := ParseFormField(s)
+	if err != nil {
+		return err
+	}
+	*v.fields = append(*v.fields, f)
+	return nil
+}
+
+// priorityClassValue is a repeatable flag.Value for
+// name=weight[,parent=class][,exclusive][,url=URL] priority classes
+type priorityClassValue struct {
+	classes *[]PriorityClass
+}
+
+func (v *priorityClassValue) String() string {
+	if v.classes == nil {
+		return ""
+	}
+	var parts []string
+	for _, p := range *v.classes {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (v *priorityClassValue) Set(s string) error {
+	p, err := ParsePriorityClass(s)
+	if err != nil {
+		return err
+	}
+	*v.classes = append(*v.classes, p)
+	return nil
+}
+
+// byteSizeValue is a flag.Value for sizes such as "512MB", "2GiB" or "1048576".
+// Decimal (KB, MB, GB) and binary (KiB, MiB, GiB) units are accepted.
+type byteSizeValue struct {
+	bytes *int64
+}
+
+var byteSizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+func (b *byteSizeValue) String() string {
+	if b.bytes == nil || *b.bytes == 0 {
+		return "0"
+	}
+	return formatBytes(*b.bytes)
+}
+
+func (b *byteSizeValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s, factor = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b.bytes = int64(v * float64(factor))
+	return nil
+}
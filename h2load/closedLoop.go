@@ -0,0 +1,62 @@
+package h2load
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Load models a run's report names, by how it decides when to send
+const (
+	LoadModelOpen   = "open-loop"   // Requests are sent at an RPS rate, whether or not earlier ones completed
+	LoadModelClosed = "closed-loop" // A fixed number of workers send their next request once their last one completed
+)
+
+// ClosedLoopConf runs a closed loop: exactly ConcurrentStreams workers per
+// client, each sending its next request as soon as its previous one
+// completed, after ThinkTime. The server's latency sets the rate, unlike in
+// the open loop of Rps and TotalRps, where it only sets the requests in
+// flight.
+type ClosedLoopConf struct {
+	Enabled   bool
+	ThinkTime time.Duration // Pause of a worker between a response and its next request
+}
+
+func (c *ClosedLoopConf) Validate() error {
+	if c.ThinkTime < 0 {
+		return fmt.Errorf("think time must be greater than 0")
+	}
+	if c.ThinkTime > 0 && !c.Enabled {
+		return fmt.Errorf("think time requires the closed loop")
+	}
+	return nil
+}
+
+// loadModel returns the load model of a run with h, closed unless requests
+// are sent at a rate. Without one every stream sends back-to-back, a closed
+// loop without think time.
+func (h *H2loadConf) loadModel() string {
+	if h.Rps > 0 || h.TotalRps > 0 {
+		return LoadModelOpen
+	}
+	return LoadModelClosed
+}
+
+// think pauses a closed-loop worker for its think time, holding its stream
+// slot, unless the client is done or has sent all its requests. It returns
+// early when the client is stopped.
+func (h *H2Client) think() {
+	think := h.Conf.ClosedLoop.ThinkTime
+	if think <= 0 {
+		return
+	}
+	if h.Conf.Requests > 0 && atomic.LoadInt64(&h.sentRequests) >= int64(h.Conf.Requests) {
+		return
+	}
+	timer := time.NewTimer(think)
+	defer timer.Stop()
+	select {
+	case <-h.ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,146 @@
+package h2load
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MetricDelta is the change of one metric between two runs
+type MetricDelta struct {
+	Name      string
+	Baseline  float64
+	Current   float64
+	Unit      string
+	Regressed bool // Set by CheckRegressions
+}
+
+// Change returns the relative change from the baseline, e.g. 0.1 for +10%,
+// or 0 when the baseline is 0
+func (d MetricDelta) Change() float64 {
+	if d.Baseline == 0 {
+		return 0
+	}
+	return (d.Current - d.Baseline) / d.Baseline
+}
+
+// Comparison is the difference between a baseline and a current run
+type Comparison struct {
+	Baseline string // Names of the compared runs
+	Current  string
+	Metrics  []MetricDelta
+}
+
+// CompareReports compares the totals of two runs
+func CompareReports(baselineName string, baseline Report, currentName string, current Report) Comparison {
+	b, c := baseline.Total, current.Total
+	return Comparison{
+		Baseline: baselineName,
+		Current:  currentName,
+		Metrics: []MetricDelta{
+			{Name: "Requests", Baseline: float64(b.Requests), Current: float64(c.Requests), Unit: ""},
+			{Name: "RPS", Baseline: b.Rps, Current: c.Rps, Unit: ""},
+			{Name: "Error rate", Baseline: b.ErrorRate * 100, Current: c.ErrorRate * 100, Unit: "%"},
+			{Name: "Avg latency", Baseline: b.AvgMs, Current: c.AvgMs, Unit: "ms"},
+			{Name: "P50 latency", Baseline: b.P50Ms, Current: c.P50Ms, Unit: "ms"},
+			{Name: "P90 latency", Baseline: b.P90Ms, Current: c.P90Ms, Unit: "ms"},
+			{Name: "P99 latency", Baseline: b.P99Ms, Current: c.P99Ms, Unit: "ms"},
+			{Name: "Max latency", Baseline: b.MaxMs, Current: c.MaxMs, Unit: "ms"},
+		},
+	}
+}
+
+// String formats the Comparison as a table
+func (c Comparison) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Comparison (%s -> %s):\n", c.Baseline, c.Current)
+	fmt.Fprintf(&b, "  %-12s %14s %14s %9s\n", "Metric", "Baseline", "Current", "Change")
+	for _, m := range c.Metrics {
+		change := "-"
+		if m.Baseline != 0 {
+			change = fmt.Sprintf("%+.1f%%", m.Change()*100)
+		}
+		mark := ""
+		if m.Regressed {
+			mark = "  REGRESSION"
+		}
+		fmt.Fprintf(&b, "  %-12s %12.2f%-2s %12.2f%-2s %9s%s\n", m.Name, m.Baseline, m.Unit, m.Current, m.Unit, change, mark)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// RegressionThresholds are the largest acceptable changes from a baseline.
+// Zero disables a check.
+type RegressionThresholds struct {
+	MaxRpsDrop           float64 // Relative RPS drop, e.g. 0.1 for 10%
+	MaxP50Increase       float64 // Relative p50 latency increase
+	MaxP99Increase       float64 // Relative p99 latency increase
+	MaxErrorRateIncrease float64 // Error rate increase in absolute terms, e.g. 0.01 for 1 point
+}
+
+// CheckRegressions checks each enabled threshold of t, marking the metrics
+// that changed beyond it
+func (c *Comparison) CheckRegressions(t RegressionThresholds) []CheckResult {
+	checks := []struct {
+		metric string
+		limit  float64
+		name   string
+		failed func(m MetricDelta) string
+	}{
+		{"RPS", t.MaxRpsDrop, fmt.Sprintf("RPS drop <= %.1f%%", t.MaxRpsDrop*100), func(m MetricDelta) string {
+			if -m.Change() > t.MaxRpsDrop {
+				return fmt.Sprintf("RPS dropped %.1f%%", -m.Change()*100)
+			}
+			return ""
+		}},
+		{"P50 latency", t.MaxP50Increase, fmt.Sprintf("p50 latency increase <= %.1f%%", t.MaxP50Increase*100), func(m MetricDelta) string {
+			if m.Change() > t.MaxP50Increase {
+				return fmt.Sprintf("p50 latency rose %.1f%%", m.Change()*100)
+			}
+			return ""
+		}},
+		{"P99 latency", t.MaxP99Increase, fmt.Sprintf("p99 latency increase <= %.1f%%", t.MaxP99Increase*100), func(m MetricDelta) string {
+			if m.Change() > t.MaxP99Increase {
+				return fmt.Sprintf("p99 latency rose %.1f%%", m.Change()*100)
+			}
+			return ""
+		}},
+		{"Error rate", t.MaxErrorRateIncrease, fmt.Sprintf("error rate increase <= %.2f points", t.MaxErrorRateIncrease*100), func(m MetricDelta) string {
+			if m.Current-m.Baseline > t.MaxErrorRateIncrease*100 {
+				return fmt.Sprintf("error rate rose %.2f points", m.Current-m.Baseline)
+			}
+			return ""
+		}},
+	}
+
+	var results []CheckResult
+	for _, check := range checks {
+		if check.limit <= 0 {
+			continue
+		}
+		for i := range c.Metrics {
+			m := &c.Metrics[i]
+			if m.Name != check.metric {
+				continue
+			}
+			result := CheckResult{Name: check.name, Failure: check.failed(*m)}
+			m.Regressed = m.Regressed || !result.Passed()
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// LoadReport reads a summary written with -o json
+func LoadReport(path string) (Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Report{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
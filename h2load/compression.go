@@ -0,0 +1,192 @@
+package h2load
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// compressionEncodings are the content codings that can be requested, and
+// whether they can be decompressed
+var compressionEncodings = map[string]bool{"gzip": true, "deflate": true, "br": false}
+
+// CompressionConf asks for compressed responses. Without it no
+// Accept-Encoding is sent and bodies are measured as the server sends them.
+type CompressionConf struct {
+	Encodings  []string // Content codings sent as Accept-Encoding, e.g. gzip, br (empty = identity)
+	Decompress bool     // Decompress gzip and deflate bodies, timing it; br bodies are only counted
+}
+
+// ParseEncodings parses a comma-separated list of gzip, deflate and br
+func ParseEncodings(s string) ([]string, error) {
+	var encodings []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if _, ok := compressionEncodings[e]; !ok {
+			return nil, fmt.Errorf("invalid encoding %q, expected gzip, deflate or br", e)
+		}
+		encodings = append(encodings, e)
+	}
+	return encodings, nil
+}
+
+func (c *CompressionConf) Validate() error {
+	for _, e := range c.Encodings {
+		if _, ok := compressionEncodings[e]; !ok {
+			return fmt.Errorf("invalid encoding %q, expected gzip, deflate or br", e)
+		}
+	}
+	return nil
+}
+
+func (c *CompressionConf) acceptEncoding() string {
+	return strings.Join(c.Encodings, ", ")
+}
+
+// setAcceptEncoding returns req asking for c's encodings, as a copy, or req
+// itself when it has an Accept-Encoding of its own or c has no encodings
+func (c *CompressionConf) setAcceptEncoding(req *http.Request) *http.Request {
+	ae := c.acceptEncoding()
+	if ae == "" || req.Header.Get("Accept-Encoding") != "" {
+		return req
+	}
+	// Factories may hand out the same request every time
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", ae)
+	return req
+}
+
+// CompressionStats counts the responses received with a content coding
+type CompressionStats struct {
+	Responses         int64         // Responses with a Content-Encoding
+	BytesCompressed   int64         // Their body bytes as received
+	BytesDecompressed int64         // Body bytes after decompression, of the decompressed responses
+	Decompressed      int64         // Responses decompressed
+	DecompressErrors  int64         // Bodies that failed to decompress
+	DecompressTime    time.Duration // Time spent decompressing
+}
+
+func (s *CompressionStats) merge(other CompressionStats) {
+	s.Responses += other.Responses
+	s.BytesCompressed += other.BytesCompressed
+	s.BytesDecompressed += other.BytesDecompressed
+	s.Decompressed += other.Decompressed
+	s.DecompressErrors += other.DecompressErrors
+	s.DecompressTime += other.DecompressTime
+}
+
+// String formats the counts, e.g. "120 (1.2MB received, 8.4MB decompressed
+// in avg 95µs)"
+func (s CompressionStats) String() string {
+	summary := fmt.Sprintf("%d (%s received", s.Responses, formatBytes(s.BytesCompressed))
+	if s.Decompressed > 0 {
+		summary += fmt.Sprintf(", %s decompressed in avg %v", formatBytes(s.BytesDecompressed),
+			s.DecompressTime/time.Duration(s.Decompressed))
+	}
+	if s.DecompressErrors > 0 {
+		summary += fmt.Sprintf(", %d failed to decompress", s.DecompressErrors)
+	}
+	return summary + ")"
+}
+
+// compressionCounter collects the CompressionStats of a client
+type compressionCounter struct {
+	responses, compressed, decompressed, decoded, errors, nanos int64 // Atomic counters
+}
+
+// decodedBody is the outcome of decompressing a body
+type decodedBody struct {
+	n       int64
+	elapsed time.Duration
+	err     error
+}
+
+// record counts a response whose body of n bytes had a content coding,
+// decoded if it was decompressed
+func (c *compressionCounter) record(n int64, decoded *decodedBody) {
+	atomic.AddInt64(&c.responses, 1)
+	atomic.AddInt64(&c.compressed, n)
+	switch {
+	case decoded == nil:
+	case decoded.err != nil:
+		atomic.AddInt64(&c.errors, 1)
+	default:
+		atomic.AddInt64(&c.decoded, 1)
+		atomic.AddInt64(&c.decompressed, decoded.n)
+		atomic.AddInt64(&c.nanos, int64(decoded.elapsed))
+	}
+}
+
+func (c *compressionCounter) stats() CompressionStats {
+	return CompressionStats{
+		Responses:         atomic.LoadInt64(&c.responses),
+		BytesCompressed:   atomic.LoadInt64(&c.compressed),
+		BytesDecompressed: atomic.LoadInt64(&c.decompressed),
+		Decompressed:      atomic.LoadInt64(&c.decoded),
+		DecompressErrors:  atomic.LoadInt64(&c.errors),
+		DecompressTime:    time.Duration(atomic.LoadInt64(&c.nanos)),
+	}
+}
+
+func (c *compressionCounter) reset() {
+	for _, n := range []*int64{&c.responses, &c.compressed, &c.decompressed, &c.decoded, &c.errors, &c.nanos} {
+		atomic.StoreInt64(n, 0)
+	}
+}
+
+// decompressBody reads resp's whole body, so network time isn't counted, then
+// times its decompression and replaces it with the decompressed bytes. It
+// returns nil for codings that can't be decompressed.
+func decompressBody(resp *http.Response, encoding string) *decodedBody {
+	if !compressionEncodings[encoding] {
+		return nil
+	}
+	body := resp.Body
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return &decodedBody{err: err}
+	}
+	start := time.Now()
+	var r io.ReadCloser
+	if encoding == "gzip" {
+		r, err = gzip.NewReader(bytes.NewReader(raw))
+	} else {
+		r, err = zlib.NewReader(bytes.NewReader(raw))
+	}
+	var plain []byte
+	if err == nil {
+		plain, err = io.ReadAll(r)
+	}
+	elapsed := time.Since(start)
+	if err != nil {
+		resp.Body = readCloser{bytes.NewReader(raw), body}
+		return &decodedBody{err: err}
+	}
+	resp.Body = readCloser{bytes.NewReader(plain), body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(plain))
+	resp.Uncompressed = true
+	return &decodedBody{n: int64(len(plain)), elapsed: elapsed}
+}
+
+// readCloser reads a body buffered from the one it closes
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// contentEncoding returns the content coding of resp, "" for identity
+func contentEncoding(resp *http.Response) string {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		return ""
+	}
+	return encoding
+}
@@ -0,0 +1,108 @@
+package h2load
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// concurrencySampleInterval is how often the requests in flight are counted
+const concurrencySampleInterval = 10 * time.Millisecond
+
+// ConcurrencyStats are the requests actually in flight during a run, sampled
+// every concurrencySampleInterval, against the configured cap. An RPS limit
+// or the server's MAX_CONCURRENT_STREAMS can keep a run well below the
+// concurrency it was configured with.
+type ConcurrencyStats struct {
+	Cap     int64   // Clients × ConcurrentStreams at the start of the run
+	Avg     float64 // Average requests in flight over all clients
+	Max     int64
+	AtCap   float64 // Share of samples with Cap requests in flight
+	Samples int64
+}
+
+// String formats the ConcurrencyStats as a readable string
+func (s ConcurrencyStats) String() string {
+	summary := fmt.Sprintf("Concurrency: avg %.1f, max %d of %d streams in flight (at the cap %.0f%% of the time)",
+		s.Avg, s.Max, s.Cap, s.AtCap*100)
+	if s.Samples > 0 && s.Max < s.Cap {
+		summary += "\nWarning: the run never reached its configured concurrency, requests were held back by the RPS limit, think time, the server's MAX_CONCURRENT_STREAMS or the generator"
+	}
+	return summary
+}
+
+// concurrencyGauge samples the requests in flight over a run's clients
+type concurrencyGauge struct {
+	clients []*H2Client
+	cap     int64
+
+	mu          sync.Mutex
+	stats       ConcurrencyStats
+	sum         int64 // Of all samples
+	atCap       int64
+	windowSum   int64 // Of the samples since the last takeWindow
+	windowCount int64
+	windowMax   int64
+}
+
+func newConcurrencyGauge(clients []*H2Client, streams int) *concurrencyGauge {
+	return &concurrencyGauge{clients: clients, cap: int64(len(clients) * max(streams, 1))}
+}
+
+// run samples until done is closed
+func (g *concurrencyGauge) run(done <-chan struct{}) {
+	ticker := time.NewTicker(concurrencySampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			g.sample()
+		}
+	}
+}
+
+func (g *concurrencyGauge) sample() {
+	var n int64
+	for _, c := range g.clients {
+		n += atomic.LoadInt64(&c.inFlight)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.Samples++
+	g.stats.Max = max(g.stats.Max, n)
+	g.sum += n
+	if g.cap > 0 && n >= g.cap {
+		g.atCap++
+	}
+	g.windowSum += n
+	g.windowCount++
+	g.windowMax = max(g.windowMax, n)
+}
+
+// takeWindow returns the average and max requests in flight since it was
+// last called
+func (g *concurrencyGauge) takeWindow() (avg float64, peak int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.windowCount > 0 {
+		avg = float64(g.windowSum) / float64(g.windowCount)
+	}
+	peak = g.windowMax
+	g.windowSum, g.windowCount, g.windowMax = 0, 0, 0
+	return avg, peak
+}
+
+func (g *concurrencyGauge) result() ConcurrencyStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.stats
+	s.Cap = g.cap
+	if s.Samples > 0 {
+		s.Avg = float64(g.sum) / float64(s.Samples)
+		s.AtCap = float64(g.atCap) / float64(s.Samples)
+	}
+	return s
+}
@@ -0,0 +1,346 @@
+package h2load
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// nextConnID numbers the connections dialed by all clients
+var nextConnID uint64
+
+// metaConn is a net.Conn that follows the HTTP/2 frames written on it, so the
+// stream ID of a request can be read from the WroteHeaders trace callback.
+// The transport holds its write lock from writing the HEADERS frame until
+// that callback returns, so the last HEADERS frame written is the request's.
+// Frames read are counted into traffic, and frames both ways followed by
+// flow and logged to frames, if set. The server's first SETTINGS are passed
+// to onSettings.
+type metaConn struct {
+	net.Conn
+	id uint64
+
+	mu  sync.Mutex   // Guards out
+	out frameScanner // Frames written, after the client preface
+	in  frameScanner // Frames read, only touched by the transport's read loop
+
+	lastHeaders uint32 // Stream ID of the last HEADERS frame written (atomic)
+
+	traffic    *trafficCounter
+	flow       *flowTracker
+	onSettings func(ServerSettings) // Cleared once called, owned by the read loop
+	frames     *connFrames
+	dialStart  time.Time
+	readAny    bool // Whether a byte was read yet, owned by the read loop
+
+	goAway     chan struct{} // Closed once the server sends GOAWAY
+	goAwayOnce sync.Once
+	closeWait  int64 // Nanos Close waits for the server's GOAWAY, set by a graceful close (atomic)
+
+	counters connCounters // For ConnectionStats
+}
+
+// frameScanner follows the frame boundaries of one direction of an HTTP/2
+// connection
+type frameScanner struct {
+	skip        int     // Bytes still to skip before the first frame
+	header      [9]byte // Header of the current frame
+	headerLen   int     // Bytes of header seen so far
+	payloadLeft int     // Payload bytes of the current frame still to skip
+	fh          http2.FrameHeader
+	payload     []byte // Payload of the current frame, if kept
+	keeping     bool
+}
+
+// scanFrames advances over p, calling onFrame with the header of each frame
+// once it is complete, or once its payload is too for the frame types keep
+// reports, which get their payload
+func (s *frameScanner) scanFrames(p []byte, keep func(http2.FrameType) bool, onFrame func(fh http2.FrameHeader, payload []byte)) {
+	for len(p) > 0 {
+		switch {
+		case s.skip > 0:
+			n := min(s.skip, len(p))
+			s.skip -= n
+			p = p[n:]
+		case s.payloadLeft > 0:
+			n := min(s.payloadLeft, len(p))
+			s.payloadLeft -= n
+			if s.keeping {
+				s.payload = append(s.payload, p[:n]...)
+				if s.payloadLeft == 0 {
+					s.keeping = false
+					onFrame(s.fh, s.payload)
+				}
+			}
+			p = p[n:]
+		default:
+			n := copy(s.header[s.headerLen:], p)
+			s.headerLen += n
+			p = p[n:]
+			if s.headerLen < len(s.header) {
+				return
+			}
+			s.headerLen = 0
+			s.payloadLeft = int(s.header[0])<<16 | int(s.header[1])<<8 | int(s.header[2])
+			s.fh = http2.FrameHeader{
+				Type:     http2.FrameType(s.header[3]),
+				Flags:    http2.Flags(s.header[4]),
+				Length:   uint32(s.payloadLeft),
+				StreamID: binary.BigEndian.Uint32(s.header[5:]) & (1<<31 - 1),
+			}
+			if s.payloadLeft > 0 && keep != nil && keep(s.fh.Type) {
+				s.keeping = true
+				s.payload = s.payload[:0]
+			} else {
+				onFrame(s.fh, nil)
+			}
+		}
+	}
+}
+
+// tlsMetaConn is a metaConn over a TLS connection, keeping ConnectionState
+// visible to the transport
+type tlsMetaConn struct {
+	*metaConn
+	tls *tls.Conn
+}
+
+func (c *tlsMetaConn) ConnectionState() tls.ConnectionState {
+	return c.tls.ConnectionState()
+}
+
+// connTracking is what wrapped connections follow of their frames
+type connTracking struct {
+	traffic    *trafficCounter      // Counts what the connections read, if set
+	trackFlow  bool                 // Follow the connections' flow control windows
+	onSettings func(ServerSettings) // Gets the first SETTINGS of every connection, if set
+	frames     *frameLogger         // Logs the frames of some streams, if set
+}
+
+// wrapConn returns conn numbered and wrapped to follow its HTTP/2 frames as
+// set by tracking. dialStart is when dialing conn began.
+func wrapConn(conn net.Conn, tracking connTracking, dialStart time.Time) net.Conn {
+	mc := &metaConn{
+		Conn:       conn,
+		id:         atomic.AddUint64(&nextConnID, 1),
+		out:        frameScanner{skip: len(http2.ClientPreface)},
+		traffic:    tracking.traffic,
+		onSettings: tracking.onSettings,
+		dialStart:  dialStart,
+		goAway:     make(chan struct{}),
+	}
+	if tracking.trackFlow {
+		mc.flow = newFlowTracker()
+	}
+	if tracking.frames != nil {
+		mc.frames = tracking.frames.conn(mc.id)
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		return &tlsMetaConn{metaConn: mc, tls: tc}
+	}
+	return mc
+}
+
+// connMeta returns the metaConn behind a connection from GotConnInfo
+func connMeta(conn net.Conn) *metaConn {
+	switch c := conn.(type) {
+	case *metaConn:
+		return c
+	case *tlsMetaConn:
+		return c.metaConn
+	}
+	return nil
+}
+
+func (c *metaConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.counters.bytesOut, int64(n))
+	c.mu.Lock()
+	c.out.scanFrames(p[:n], c.keepWritten, c.wroteFrame)
+	c.mu.Unlock()
+	return n, err
+}
+
+// keepWritten reports the frame types written whose payload is needed
+func (c *metaConn) keepWritten(typ http2.FrameType) bool {
+	return c.flow != nil && flowPayload(typ) || c.frames != nil && debugPayload(typ)
+}
+
+// wroteFrame follows a frame written by the transport
+func (c *metaConn) wroteFrame(fh http2.FrameHeader, payload []byte) {
+	if fh.Type == http2.FrameHeaders {
+		atomic.StoreUint32(&c.lastHeaders, fh.StreamID)
+	}
+	c.counters.wrote(fh)
+	if c.flow != nil {
+		c.flow.wrote(fh, payload)
+	}
+	if c.frames != nil {
+		c.frames.frame("send", fh, payload)
+	}
+}
+
+func (c *metaConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	atomic.AddInt64(&c.counters.bytesIn, int64(n))
+	if c.traffic != nil {
+		if !c.readAny {
+			c.readAny = true
+			c.traffic.firstByte(time.Since(c.dialStart))
+		}
+		c.traffic.readBytes(n)
+	}
+	c.in.scanFrames(p[:n], c.keepPayload, c.readFrame)
+	return n, err
+}
+
+// keepPayload reports the frame types read whose payload is needed
+func (c *metaConn) keepPayload(typ http2.FrameType) bool {
+	return c.flow != nil && flowPayload(typ) ||
+		c.frames != nil && debugPayload(typ) ||
+		typ == http2.FrameSettings && c.onSettings != nil
+}
+
+// readFrame follows a frame read from the server
+func (c *metaConn) readFrame(fh http2.FrameHeader, payload []byte) {
+	if c.traffic != nil {
+		c.traffic.frame(fh.Type, int(fh.Length))
+	}
+	if fh.Type == http2.FrameSettings && !fh.Flags.Has(http2.FlagSettingsAck) && c.onSettings != nil {
+		c.onSettings(parseSettings(payload))
+		c.onSettings = nil
+	}
+	if fh.Type == http2.FrameGoAway {
+		c.goAwayOnce.Do(func() { close(c.goAway) })
+	}
+	c.counters.read(fh)
+	if c.flow != nil {
+		c.flow.read(fh, payload)
+	}
+	if c.frames != nil {
+		c.frames.frame("recv", fh, payload)
+	}
+}
+
+// sentGoAway reports whether the server sent GOAWAY
+func (c *metaConn) sentGoAway() bool {
+	select {
+	case <-c.goAway:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the connection, first waiting for the server's GOAWAY if a
+// graceful close asked to. The transport's read loop keeps reading
+// meanwhile.
+func (c *metaConn) Close() error {
+	if wait := time.Duration(atomic.SwapInt64(&c.closeWait, 0)); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-c.goAway:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	c.counters.close()
+	return c.Conn.Close()
+}
+
+// entryTracer collects the connection, stream and time to first byte of a
+// logged request from httptrace callbacks
+type entryTracer struct {
+	mu        sync.Mutex
+	conn      *metaConn
+	connID    uint64
+	streamID  uint32
+	wrote     time.Time // When the HEADERS were written
+	firstByte time.Time
+}
+
+// attach returns a copy of req that reports its events to the tracer
+func (t *entryTracer) attach(req *http.Request) *http.Request {
+	var conn *metaConn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			conn = connMeta(info.Conn)
+			t.conn = conn
+			if conn != nil {
+				t.connID = conn.id
+			}
+			t.mu.Unlock()
+		},
+		WroteHeaders: func() {
+			t.mu.Lock()
+			if conn != nil {
+				t.streamID = atomic.LoadUint32(&conn.lastHeaders)
+			}
+			t.wrote = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.mu.Unlock()
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// fill copies the collected metadata into entry
+func (t *entryTracer) fill(entry *LogEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry.ConnID = t.connID
+	entry.StreamID = t.streamID
+	if !t.firstByte.IsZero() {
+		entry.TTFB = t.firstByte.Sub(entry.Start)
+	}
+}
+
+// queue returns the time from eligible to the request's HEADERS being
+// written, 0 if they never were
+func (t *entryTracer) queue(eligible time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.wrote.IsZero() {
+		return 0
+	}
+	return t.wrote.Sub(eligible)
+}
+
+// stalled returns the time the request's stream was stalled on flow
+// control, 0 if its connection doesn't follow flow control
+func (t *entryTracer) stalled() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil || t.conn.flow == nil || t.streamID == 0 {
+		return 0
+	}
+	return t.conn.flow.take(t.streamID)
+}
+
+// countingBody counts the response body bytes read through it
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,170 @@
+package h2load
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// recycleShutdownTimeout bounds how long a recycled connection may keep
+// serving its in-flight streams before it is closed
+const recycleShutdownTimeout = 10 * time.Second
+
+// connPool is an http2.ClientConnPool that keeps a single connection per
+// client. A new connection is dialed when the current one can no longer take
+// requests (e.g. after a GOAWAY) or when it is recycled. Unlike the
+// transport's own pool, no second connection is opened once the server's
+// MAX_CONCURRENT_STREAMS is reached: the streams above it queue on the one
+// connection, so -c sets how many connections the server sees.
+type connPool struct {
+	transport *http2.Transport
+	dial      func(ctx context.Context) (net.Conn, error)
+	tracking  connTracking // What the connections follow of their frames
+
+	mu           sync.Mutex
+	cc           *http2.ClientConn
+	meta         *metaConn     // Connection cc runs on
+	dialing      chan struct{} // Closed when the dial in progress is done, nil when none is
+	streamErrors int           // Stream errors seen on cc
+	conns        []*metaConn   // Every connection dialed, for ConnectionStats
+
+	recycled int64 // Connections recycled by the error budget policy
+}
+
+func newConnPool(transport *http2.Transport, dial func(ctx context.Context) (net.Conn, error), tracking connTracking) *connPool {
+	return &connPool{transport: transport, dial: dial, tracking: tracking}
+}
+
+// GetClientConn implements http2.ClientConnPool. The connection is dialed
+// without holding the lock, so stats and recycles aren't held up by a slow
+// dial; requests arriving meanwhile wait for it rather than dial their own.
+func (p *connPool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	for {
+		p.mu.Lock()
+		if p.cc != nil && p.cc.CanTakeNewRequest() {
+			cc := p.cc
+			p.mu.Unlock()
+			return cc, nil
+		}
+		if dialing := p.dialing; dialing != nil {
+			p.mu.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+		dialing := make(chan struct{})
+		p.dialing = dialing
+		p.mu.Unlock()
+
+		cc, meta, err := p.newConn(req.Context())
+		p.mu.Lock()
+		if err == nil {
+			p.cc, p.meta = cc, meta
+			p.conns = append(p.conns, meta)
+			p.streamErrors = 0
+		}
+		p.dialing = nil
+		p.mu.Unlock()
+		close(dialing)
+		return cc, err
+	}
+}
+
+// newConn dials a connection and starts HTTP/2 on it
+func (p *connPool) newConn(ctx context.Context) (*http2.ClientConn, *metaConn, error) {
+	dialStart := time.Now()
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.tracking.traffic != nil {
+		p.tracking.traffic.connected(time.Since(dialStart))
+	}
+	wrapped := wrapConn(conn, p.tracking, dialStart)
+	cc, err := p.transport.NewClientConn(wrapped)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return cc, connMeta(wrapped), nil
+}
+
+// MarkDead implements http2.ClientConnPool
+func (p *connPool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cc == cc {
+		p.cc, p.meta = nil, nil
+	}
+}
+
+// streamError records a stream error on the current connection and reports
+// whether the connection has now used up its budget of maxErrors
+func (p *connPool) streamError(maxErrors int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cc == nil {
+		return false
+	}
+	p.streamErrors++
+	return p.streamErrors >= maxErrors
+}
+
+// recycle retires the current connection, letting its in-flight streams
+// finish, so the next request dials a fresh one
+func (p *connPool) recycle() {
+	p.mu.Lock()
+	cc := p.cc
+	p.cc, p.meta = nil, nil
+	p.mu.Unlock()
+	if cc == nil {
+		return
+	}
+
+	atomic.AddInt64(&p.recycled, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), recycleShutdownTimeout)
+		defer cancel()
+		if err := cc.Shutdown(ctx); err != nil {
+			cc.Close()
+		}
+	}()
+}
+
+// close closes the current connection
+func (p *connPool) close() {
+	p.mu.Lock()
+	cc := p.cc
+	p.cc, p.meta = nil, nil
+	p.mu.Unlock()
+	if cc != nil {
+		cc.Close()
+	}
+}
+
+// state returns the state of the current connection, if any
+func (p *connPool) state() (http2.ClientConnState, bool) {
+	p.mu.Lock()
+	cc := p.cc
+	p.mu.Unlock()
+	if cc == nil {
+		return http2.ClientConnState{}, false
+	}
+	return cc.State(), true
+}
+
+func (p *connPool) recycledCount() int64 {
+	return atomic.LoadInt64(&p.recycled)
+}
+
+func (p *connPool) resetRecycled() {
+	atomic.StoreInt64(&p.recycled, 0)
+}
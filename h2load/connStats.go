@@ -0,0 +1,233 @@
+package h2load
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// ConnectionStats are the stats of one connection, followed from its frames.
+// When a client's connections land on different backends, e.g. behind an L4
+// load balancer, the totals hide an imbalance these show.
+type ConnectionStats struct {
+	ID             uint64 // Connection number, as logged with requests
+	ClientID       int
+	RemoteAddr     string
+	Family         string    // FamilyIPv4 or FamilyIPv6, of RemoteAddr
+	Opened         time.Time // When dialing began
+	Closed         time.Time // Zero while open
+	Streams        int64     // Streams the client opened
+	Completed      int64     // Streams the server ended
+	ResetsReceived int64     // RST_STREAMs received
+	ResetsSent     int64     // RST_STREAMs sent
+	BytesIn        int64
+	BytesOut       int64
+	TotalLatency   time.Duration // Time from sending a stream's HEADERS to the server ending it, over Completed
+	MaxLatency     time.Duration
+}
+
+// Lifetime returns how long the connection was open, so far if it still is
+func (s ConnectionStats) Lifetime() time.Duration {
+	if s.Closed.IsZero() {
+		return time.Since(s.Opened)
+	}
+	return s.Closed.Sub(s.Opened)
+}
+
+// AvgLatency returns the average latency of the completed streams
+func (s ConnectionStats) AvgLatency() time.Duration {
+	if s.Completed == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Completed)
+}
+
+// connCounters are what a metaConn counts of its frames for ConnectionStats
+type connCounters struct {
+	streams    int64 // Atomic counters
+	completed  int64
+	resetsIn   int64
+	resetsOut  int64
+	bytesIn    int64
+	bytesOut   int64
+	lastOpened uint32 // Highest stream opened, guarded by the metaConn's mu
+
+	mu           sync.Mutex // Guards the rest
+	open         map[uint32]time.Time
+	totalLatency time.Duration
+	maxLatency   time.Duration
+	closed       time.Time
+}
+
+// wrote counts a frame the client wrote, with the metaConn's mu held
+func (c *connCounters) wrote(fh http2.FrameHeader) {
+	switch fh.Type {
+	case http2.FrameHeaders:
+		// Trailers are a second HEADERS on the stream
+		if fh.StreamID <= c.lastOpened {
+			return
+		}
+		c.lastOpened = fh.StreamID
+		atomic.AddInt64(&c.streams, 1)
+		c.mu.Lock()
+		if c.open == nil {
+			c.open = make(map[uint32]time.Time)
+		}
+		c.open[fh.StreamID] = time.Now()
+		c.mu.Unlock()
+	case http2.FrameRSTStream:
+		atomic.AddInt64(&c.resetsOut, 1)
+		c.mu.Lock()
+		delete(c.open, fh.StreamID)
+		c.mu.Unlock()
+	}
+}
+
+// read counts a frame the server sent
+func (c *connCounters) read(fh http2.FrameHeader) {
+	switch fh.Type {
+	case http2.FrameHeaders, http2.FrameData:
+		if !fh.Flags.Has(http2.FlagDataEndStream) {
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		start, ok := c.open[fh.StreamID]
+		if !ok {
+			return
+		}
+		delete(c.open, fh.StreamID)
+		latency := time.Since(start)
+		c.totalLatency += latency
+		c.maxLatency = max(c.maxLatency, latency)
+		atomic.AddInt64(&c.completed, 1)
+	case http2.FrameRSTStream:
+		atomic.AddInt64(&c.resetsIn, 1)
+		c.mu.Lock()
+		delete(c.open, fh.StreamID)
+		c.mu.Unlock()
+	}
+}
+
+// close records when the connection closed, the first time
+func (c *connCounters) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed.IsZero() {
+		c.closed = time.Now()
+	}
+}
+
+// reset zeroes the counters, keeping the streams still open
+func (c *connCounters) reset() {
+	for _, n := range []*int64{&c.streams, &c.completed, &c.resetsIn, &c.resetsOut, &c.bytesIn, &c.bytesOut} {
+		atomic.StoreInt64(n, 0)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totalLatency, c.maxLatency = 0, 0
+}
+
+// connStats returns the stats of conn
+func (c *metaConn) connStats() ConnectionStats {
+	cc := &c.counters
+	s := ConnectionStats{
+		ID:             c.id,
+		RemoteAddr:     c.RemoteAddr().String(),
+		Family:         addrFamily(c.RemoteAddr().String()),
+		Opened:         c.dialStart,
+		Streams:        atomic.LoadInt64(&cc.streams),
+		Completed:      atomic.LoadInt64(&cc.completed),
+		ResetsReceived: atomic.LoadInt64(&cc.resetsIn),
+		ResetsSent:     atomic.LoadInt64(&cc.resetsOut),
+		BytesIn:        atomic.LoadInt64(&cc.bytesIn),
+		BytesOut:       atomic.LoadInt64(&cc.bytesOut),
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	s.Closed = cc.closed
+	s.TotalLatency, s.MaxLatency = cc.totalLatency, cc.maxLatency
+	return s
+}
+
+// connStats returns the stats of every connection the pool dialed, oldest
+// first
+func (p *connPool) connStats() []ConnectionStats {
+	p.mu.Lock()
+	conns := p.conns
+	p.mu.Unlock()
+	stats := make([]ConnectionStats, 0, len(conns))
+	for _, c := range conns {
+		stats = append(stats, c.connStats())
+	}
+	return stats
+}
+
+// resetConnStats forgets the closed connections and zeroes the counters of
+// the open one
+func (p *connPool) resetConnStats() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var open []*metaConn
+	for _, c := range p.conns {
+		c.counters.mu.Lock()
+		closed := !c.counters.closed.IsZero()
+		c.counters.mu.Unlock()
+		if !closed {
+			c.counters.reset()
+			open = append(open, c)
+		}
+	}
+	p.conns = open
+}
+
+// GetConnectionStats returns the stats of every connection the client
+// dialed, oldest first
+func (h *H2Client) GetConnectionStats() []ConnectionStats {
+	if h.pool == nil {
+		return nil
+	}
+	stats := h.pool.connStats()
+	for i := range stats {
+		stats[i].ClientID = h.ID
+	}
+	return stats
+}
+
+// GetConnectionStats returns the stats of every connection dialed by all
+// clients, by client and oldest first
+func (h *H2loadClient) GetConnectionStats() []ConnectionStats {
+	var stats []ConnectionStats
+	for _, c := range h.Clients {
+		stats = append(stats, c.GetConnectionStats()...)
+	}
+	return stats
+}
+
+// FormatConnectionStats formats per-connection stats as a table
+func FormatConnectionStats(conns []ConnectionStats) string {
+	width := len("Remote")
+	for _, c := range conns {
+		width = max(width, len(c.RemoteAddr))
+	}
+	var b strings.Builder
+	b.WriteString("Per-Connection Statistics:\n")
+	fmt.Fprintf(&b, "%6s %6s %-*s %9s %9s %9s %10s %10s %12s %12s %12s\n",
+		"Conn", "Client", width, "Remote", "Streams", "Completed", "Resets", "Bytes In", "Bytes Out", "Avg", "Max", "Lifetime")
+	for _, c := range conns {
+		lifetime := c.Lifetime().Round(time.Millisecond).String()
+		if c.Closed.IsZero() {
+			lifetime += "+"
+		}
+		fmt.Fprintf(&b, "%6d %6d %-*s %9d %9d %9s %10s %10s %12v %12v %12s\n",
+			c.ID, c.ClientID, width, c.RemoteAddr, c.Streams, c.Completed,
+			fmt.Sprintf("%d/%d", c.ResetsReceived, c.ResetsSent),
+			formatBytes(c.BytesIn), formatBytes(c.BytesOut), c.AvgLatency(), c.MaxLatency, lifetime)
+	}
+	b.WriteString("Resets are received/sent; a + marks connections still open")
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package h2load
+
+import (
+	"fmt"
+	"net"
+	urlpkg "net/url"
+	"strings"
+)
+
+// ConnectTo dials another address for a host and port of the URL, like
+// curl's --connect-to. The URL, and so the :authority and TLS server name,
+// stay unchanged. Empty From fields match any host or port, empty To fields
+// keep the original one.
+type ConnectTo struct {
+	FromHost string
+	FromPort string
+	ToHost   string
+	ToPort   string
+}
+
+// ParseConnectTo parses HOST1:PORT1:HOST2:PORT2, with IPv6 hosts in brackets
+func ParseConnectTo(s string) (ConnectTo, error) {
+	parts := splitHostFields(s)
+	if len(parts) != 4 {
+		return ConnectTo{}, fmt.Errorf("invalid connect-to %q, expected HOST1:PORT1:HOST2:PORT2", s)
+	}
+	for i := range parts {
+		parts[i] = unbracketHost(parts[i])
+	}
+	return ConnectTo{FromHost: parts[0], FromPort: parts[1], ToHost: parts[2], ToPort: parts[3]}, nil
+}
+
+func (c ConnectTo) String() string {
+	return strings.Join([]string{bracketHost(c.FromHost), c.FromPort, bracketHost(c.ToHost), c.ToPort}, ":")
+}
+
+// splitHostFields splits s at the colons outside of brackets, so IPv6
+// addresses can be given as [::1]
+func splitHostFields(s string) []string {
+	var parts []string
+	start, inBrackets := 0, false
+	for i, r := range s {
+		switch r {
+		case '[':
+			inBrackets = true
+		case ']':
+			inBrackets = false
+		case ':':
+			if !inBrackets {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unbracketHost(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+func bracketHost(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// matches reports whether the mapping applies to host and port
+func (c ConnectTo) matches(host, port string) bool {
+	return (c.FromHost == "" || strings.EqualFold(c.FromHost, host)) &&
+		(c.FromPort == "" || c.FromPort == port)
+}
+
+// dialAddress returns the address to dial for rawURL: serverAddress if set,
+// otherwise the URL's host and port, with the port defaulting from the
+// scheme, mapped by the first matching ConnectTo
+func dialAddress(rawURL, serverAddress string, connectTo []ConnectTo) (string, error) {
+	if serverAddress != "" {
+		return serverAddress, nil
+	}
+	u, err := urlpkg.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	for _, c := range connectTo {
+		if c.matches(host, port) {
+			if c.ToHost != "" {
+				host = c.ToHost
+			}
+			if c.ToPort != "" {
+				port = c.ToPort
+			}
+			break
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
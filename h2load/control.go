@@ -0,0 +1,176 @@
+package h2load
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// liveControl applies operator commands to a running H2loadClient. It backs
+// the CLI control socket and the RPS step signals.
+type liveControl struct {
+	client *H2loadClient
+	step   float64 // Fraction the rate is changed by per step
+
+	mu sync.Mutex // Serializes commands
+}
+
+func newLiveControl(client *H2loadClient, step float64) *liveControl {
+	return &liveControl{client: client, step: step}
+}
+
+// stepRps raises or lowers the active RPS limit by the step fraction
+func (c *liveControl) stepRps(up bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stepRpsLocked(up)
+}
+
+func (c *liveControl) stepRpsLocked(up bool) (string, error) {
+	factor := 1 - c.step
+	if up {
+		factor = 1 + c.step
+	}
+	// Always move by at least one request per second
+	next := func(rps int) int {
+		n := int(math.Round(float64(rps) * factor))
+		if n == rps {
+			if up {
+				n++
+			} else {
+				n--
+			}
+		}
+		return max(n, 1)
+	}
+
+	conf := &c.client.ClientsConf
+	switch {
+	case conf.TotalRps > 0:
+		if err := c.client.SetTotalRps(next(conf.TotalRps)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("total rps %d", conf.TotalRps), nil
+	case conf.Rps > 0:
+		if err := c.client.SetRps(next(conf.Rps)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rps %d", conf.Rps), nil
+	}
+	return "", errors.New("rps is unlimited, set a rate first")
+}
+
+// execute runs one command line and returns its reply
+func (c *liveControl) execute(line string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", errors.New("empty command")
+	}
+	arg := func() (int, error) {
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("%s expects one number", fields[0])
+		}
+		return strconv.Atoi(fields[1])
+	}
+
+	switch fields[0] {
+	case "rps", "total-rps", "streams":
+		n, err := arg()
+		if err != nil {
+			return "", err
+		}
+		switch fields[0] {
+		case "rps":
+			err = c.client.SetRps(n)
+		case "total-rps":
+			err = c.client.SetTotalRps(n)
+		default:
+			err = c.client.SetConcurrentStreams(n)
+		}
+		if err != nil {
+			return "", err
+		}
+	case "up", "down":
+		return c.stepRpsLocked(fields[0] == "up")
+	case "pause":
+		c.client.Pause()
+	case "resume":
+		c.client.Resume()
+	case "status":
+		conf := c.client.ClientsConf
+		return fmt.Sprintf("rps %d, total rps %d, streams %d, paused %t, sent %d",
+			conf.Rps, conf.TotalRps, conf.ConcurrentStreams, c.client.IsPaused(), c.client.GetSentRequests()), nil
+	case "stats":
+		return c.client.GetTotalStats().String(), nil
+	case "help":
+		return "commands: rps <n>, total-rps <n>, streams <n>, up, down, pause, resume, status, stats", nil
+	default:
+		return "", fmt.Errorf("unknown command %q", fields[0])
+	}
+	return "ok", nil
+}
+
+// controlSocket serves liveControl commands, one per line, on a unix socket
+type controlSocket struct {
+	path     string
+	listener net.Listener
+	control  *liveControl
+	wg       sync.WaitGroup
+}
+
+// listenControlSocket removes a stale socket file at path and starts serving
+func listenControlSocket(path string, control *liveControl) (*controlSocket, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	s := &controlSocket{path: path, listener: l, control: control}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *controlSocket) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *controlSocket) handle(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		reply, err := s.control.execute(scanner.Text())
+		if err != nil {
+			reply = "error: " + err.Error()
+		}
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops accepting commands and removes the socket file
+func (s *controlSocket) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	os.Remove(s.path)
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package h2load
+
+// watchRpsSignals is a no-op on platforms without SIGUSR1/SIGUSR2
+func watchRpsSignals(control *liveControl) (stop func()) {
+	return func() {}
+}
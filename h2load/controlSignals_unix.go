@@ -0,0 +1,37 @@
+//go:build unix
+
+package h2load
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchRpsSignals steps the RPS limit up on SIGUSR1 and down on SIGUSR2 until
+// the returned function is called
+func watchRpsSignals(control *liveControl) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				reply, err := control.stepRps(sig == syscall.SIGUSR1)
+				if err != nil {
+					log.Printf("Ignoring %v: %v", sig, err)
+					continue
+				}
+				log.Printf("%v: %s", sig, reply)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,174 @@
+package h2load
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	baselineProbes  = 10 // Probes sent before the load to measure the baseline
+	recoveredProbes = 3  // Consecutive probes within tolerance that count as recovered
+)
+
+// CooldownConf configures the probe that measures how long the server takes
+// to recover once the load stops
+type CooldownConf struct {
+	Duration  time.Duration // How long to probe after the load (0 = disabled)
+	Rate      int           // Probes per second (default: 5)
+	Tolerance float64       // Allowed slowdown over the baseline, e.g. 0.5 for +50% (default: 0.5)
+}
+
+func (c *CooldownConf) Validate() error {
+	if c.Duration < 0 {
+		return fmt.Errorf("cooldown duration must be greater than 0")
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("cooldown rate must be greater than 0")
+	}
+	if c.Tolerance < 0 {
+		return fmt.Errorf("cooldown tolerance must be greater than 0")
+	}
+	return nil
+}
+
+// CooldownSample is a single probe sent after the load
+type CooldownSample struct {
+	Offset  time.Duration // Time since the load stopped
+	Latency time.Duration
+	Status  int // 0 if the request failed
+}
+
+// CooldownResult describes how the server recovered after the load
+type CooldownResult struct {
+	Baseline       time.Duration // Median probe latency before the load
+	Threshold      time.Duration // Latency a probe must stay under to count as recovered
+	Samples        []CooldownSample
+	Recovered      bool
+	RecoveredAfter time.Duration // Time since the load stopped until recovery
+}
+
+// String formats the CooldownResult as a readable string
+func (r CooldownResult) String() string {
+	var b strings.Builder
+	b.WriteString("Cooldown:\n")
+	fmt.Fprintf(&b, "Baseline Latency: %v (recovered below %v)\n", r.Baseline, r.Threshold)
+	fmt.Fprintf(&b, "Probes: %d\n", len(r.Samples))
+	if r.Recovered {
+		fmt.Fprintf(&b, "Recovered After: %v", r.RecoveredAfter)
+	} else {
+		b.WriteString("Recovered After: not recovered while probing")
+	}
+	return b.String()
+}
+
+// CooldownProbe sends low-rate probes on its own connection, so they neither
+// wait behind the load nor count in its stats
+type CooldownProbe struct {
+	conf     CooldownConf
+	client   *H2Client
+	baseline time.Duration
+}
+
+// NewCooldownProbe connects a probe client for the target of conf
+func NewCooldownProbe(conf H2loadConf, cooldown CooldownConf) (*CooldownProbe, error) {
+	if err := cooldown.Validate(); err != nil {
+		return nil, err
+	}
+	if cooldown.Rate == 0 {
+		cooldown.Rate = 5
+	}
+	if cooldown.Tolerance == 0 {
+		cooldown.Tolerance = 0.5
+	}
+	conf.Requests, conf.Rps, conf.TotalRps = 0, 0, 0
+	conf.ConcurrentStreams, conf.Clients = 1, 1
+	client := NewH2Client(conf)
+	probe := &CooldownProbe{conf: cooldown, client: client}
+	if err := client.Connect(); err != nil {
+		probe.Close()
+		return nil, fmt.Errorf("cooldown probe failed to connect: %w", err)
+	}
+	return probe, nil
+}
+
+func (p *CooldownProbe) probe() CooldownSample {
+	req, _ := http.NewRequest("GET", p.client.Conf.URL, nil)
+	for k, v := range p.client.Conf.Headers {
+		req.Header[k] = v
+	}
+	start := time.Now()
+	resp, err := p.client.DoRequest(req)
+	sample := CooldownSample{Latency: time.Since(start)}
+	if err == nil {
+		sample.Status = resp.StatusCode
+	}
+	return sample
+}
+
+// MeasureBaseline probes the idle server before the load to learn its
+// normal latency
+func (p *CooldownProbe) MeasureBaseline() error {
+	interval := time.Second / time.Duration(p.conf.Rate)
+	var latencies []time.Duration
+	for i := 0; i < baselineProbes; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		if s := p.probe(); s.Status >= 200 && s.Status < 400 {
+			latencies = append(latencies, s.Latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return fmt.Errorf("cooldown baseline probes all failed")
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p.baseline = latencies[len(latencies)/2]
+	return nil
+}
+
+// Run probes for the configured duration, right after the load stopped, and
+// reports when latency returned to within tolerance of the baseline
+func (p *CooldownProbe) Run() CooldownResult {
+	result := CooldownResult{
+		Baseline:  p.baseline,
+		Threshold: time.Duration(float64(p.baseline) * (1 + p.conf.Tolerance)),
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(p.conf.Rate))
+	defer ticker.Stop()
+
+	start := time.Now()
+	streak := 0
+	for {
+		offset := time.Since(start)
+		sample := p.probe()
+		sample.Offset = offset
+		result.Samples = append(result.Samples, sample)
+
+		if sample.Status >= 200 && sample.Status < 400 && sample.Latency <= result.Threshold {
+			streak++
+			if streak == recoveredProbes {
+				// Recovery counts from the first probe of the streak
+				result.Recovered = true
+				result.RecoveredAfter = result.Samples[len(result.Samples)-recoveredProbes].Offset
+				return result
+			}
+		} else {
+			streak = 0
+		}
+
+		if time.Since(start) >= p.conf.Duration {
+			return result
+		}
+		<-ticker.C
+	}
+}
+
+// Close closes the probe connection
+func (p *CooldownProbe) Close() {
+	// The probe client never runs DoRequestsFactory, which normally closes them
+	p.client.closeChannels()
+	p.client.Close()
+}
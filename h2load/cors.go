@@ -0,0 +1,278 @@
+package h2load
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	urlpkg "net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// CORSConf sends a CORS preflight, an OPTIONS request as a browser sends
+// it before a cross-origin request that isn't simple, ahead of a fraction of
+// the requests. Preflights are timed apart from the requests they precede,
+// and every request carries Origin, as cross-origin browser requests do.
+type CORSConf struct {
+	Fraction       float64  // Fraction of the requests preceded by a preflight, 0 to 1
+	Origin         string   // Origin of the simulated page, e.g. https://app.example.com
+	RequestMethod  string   // Access-Control-Request-Method (default: the request's method)
+	RequestHeaders []string // Access-Control-Request-Headers (default: the request's non-safelisted headers)
+}
+
+func (c *CORSConf) enabled() bool {
+	return c.Fraction > 0
+}
+
+func (c *CORSConf) Validate() error {
+	if c.Fraction < 0 || c.Fraction > 1 {
+		return fmt.Errorf("CORS preflight fraction must be between 0 and 1")
+	}
+	if !c.enabled() {
+		if c.Origin != "" || c.RequestMethod != "" || len(c.RequestHeaders) > 0 {
+			return fmt.Errorf("CORS origin, method and headers need a preflight fraction")
+		}
+		return nil
+	}
+	origin, err := urlpkg.Parse(c.Origin)
+	if err != nil || origin.Scheme == "" || origin.Host == "" || (origin.Path != "" && origin.Path != "/") {
+		return fmt.Errorf("CORS preflight needs an origin such as https://app.example.com, got %q", c.Origin)
+	}
+	if c.RequestMethod != "" && !validToken(c.RequestMethod) {
+		return fmt.Errorf("invalid CORS preflight method %q", c.RequestMethod)
+	}
+	for _, name := range c.RequestHeaders {
+		if !validToken(name) {
+			return fmt.Errorf("invalid CORS preflight header %q", name)
+		}
+	}
+	return nil
+}
+
+// origin returns Origin without a trailing slash, as browsers send it
+func (c *CORSConf) origin() string {
+	return strings.TrimSuffix(c.Origin, "/")
+}
+
+// unlistedHeaders are the request headers browsers never list in
+// Access-Control-Request-Headers: CORS-safelisted ones and the ones the
+// browser sets itself
+var unlistedHeaders = map[string]bool{
+	"accept": true, "accept-language": true, "content-language": true,
+	"accept-encoding": true, "cookie": true, "expect": true, "origin": true,
+	"referer": true, "te": true, "trailer": true, "user-agent": true,
+}
+
+// simpleContentTypes are the Content-Types a request can have without a
+// preflight
+var simpleContentTypes = []string{"application/x-www-form-urlencoded", "multipart/form-data", "text/plain"}
+
+// requestHeaders returns the Access-Control-Request-Headers of req: the
+// configured ones, or else its non-safelisted headers, lowercased and sorted
+func (c *CORSConf) requestHeaders(req *http.Request) []string {
+	if len(c.RequestHeaders) > 0 {
+		return c.RequestHeaders
+	}
+	var names []string
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if unlistedHeaders[lower] {
+			continue
+		}
+		if lower == "content-type" {
+			mediaType, _, _ := strings.Cut(req.Header.Get(name), ";")
+			if slices.Contains(simpleContentTypes, strings.ToLower(strings.TrimSpace(mediaType))) {
+				continue
+			}
+		}
+		names = append(names, lower)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// allowed reports whether resp allows the cross-origin request the
+// preflight asked for
+func (c *CORSConf) allowed(resp *http.Response, method string, headers []string) bool {
+	if resp.StatusCode/100 != 2 {
+		return false
+	}
+	if o := resp.Header.Get("Access-Control-Allow-Origin"); o != "*" && o != c.origin() {
+		return false
+	}
+	list := func(name string) []string {
+		var values []string
+		for _, v := range resp.Header.Values(name) {
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					values = append(values, item)
+				}
+			}
+		}
+		return values
+	}
+	// GET, HEAD and POST are always allowed
+	methods := list("Access-Control-Allow-Methods")
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+	default:
+		if !slices.Contains(methods, method) && !slices.Contains(methods, "*") {
+			return false
+		}
+	}
+	allowedHeaders := list("Access-Control-Allow-Headers")
+	if slices.Contains(allowedHeaders, "*") {
+		return true
+	}
+	for _, name := range headers {
+		if !slices.ContainsFunc(allowedHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// CORSStats are the outcomes and latencies of the CORS preflights of a
+// run
+type CORSStats struct {
+	Preflights int64 // Preflights sent
+	Allowed    int64 // Answered 2xx allowing the origin, method and headers
+	Denied     int64 // Answered without allowing them, a browser wouldn't send the request
+	Failed     int64 // Failed without a response
+
+	AvgLatency time.Duration
+	P50Latency time.Duration
+	P99Latency time.Duration
+	MaxLatency time.Duration
+}
+
+// String formats the CORSStats as a readable string
+func (s CORSStats) String() string {
+	return fmt.Sprintf(`CORS Preflight:
+Preflights: %d
+Allowed: %d
+Denied: %d
+Failed: %d
+Preflight Latency: avg %v, p50 %v, p99 %v, max %v`,
+		s.Preflights, s.Allowed, s.Denied, s.Failed,
+		s.AvgLatency, s.P50Latency, s.P99Latency, s.MaxLatency)
+}
+
+// corsRecorder collects the CORSStats of a client
+type corsRecorder struct {
+	mu    sync.Mutex
+	stats CORSStats
+	total time.Duration // Sum of the latencies of the answered preflights
+	hist  *hdrhistogram.Histogram
+}
+
+func newCORSRecorder() *corsRecorder {
+	return &corsRecorder{hist: newLatencyHistogram()}
+}
+
+// record counts a preflight answered after latency, allowed or not, or
+// failed with err
+func (r *corsRecorder) record(latency time.Duration, allowed bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Preflights++
+	switch {
+	case err != nil:
+		r.stats.Failed++
+		return
+	case allowed:
+		r.stats.Allowed++
+	default:
+		r.stats.Denied++
+	}
+	r.total += latency
+	r.stats.MaxLatency = max(r.stats.MaxLatency, latency)
+	recordLatency(r.hist, latency)
+}
+
+func (r *corsRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = CORSStats{}
+	r.total = 0
+	r.hist.Reset()
+}
+
+// corsPreflight sends the CORS preflight of req if it is picked for one, and
+// returns req carrying Origin, and whether a preflight was sent. The request
+// is sent even when denied, so the load stays as configured.
+func (h *H2Client) corsPreflight(req *http.Request) (*http.Request, bool) {
+	conf := &h.Conf.CORS
+	// Factories may hand out the same request every time
+	req = req.Clone(req.Context())
+	if req.Header.Get("Origin") == "" {
+		req.Header.Set("Origin", conf.origin())
+	}
+	if h.corsRand.Float64() >= conf.Fraction {
+		return req, false
+	}
+
+	method := conf.RequestMethod
+	if method == "" {
+		method = req.Method
+	}
+	headers := conf.requestHeaders(req)
+	options, err := http.NewRequestWithContext(req.Context(), http.MethodOptions, req.URL.String(), nil)
+	if err != nil {
+		h.cors.record(0, false, err)
+		return req, true
+	}
+	options.Host = req.Host
+	options.Header.Set("Origin", conf.origin())
+	options.Header.Set("Access-Control-Request-Method", method)
+	if len(headers) > 0 {
+		options.Header.Set("Access-Control-Request-Headers", strings.Join(headers, ","))
+	}
+
+	start := time.Now()
+	resp, err := h.do(options)
+	if err != nil {
+		if !abandoned(options) {
+			h.cors.record(time.Since(start), false, err)
+		}
+		return req, true
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	h.cors.record(time.Since(start), conf.allowed(resp, method, headers), nil)
+	return req, true
+}
+
+// GetCORSStats returns the CORS preflights of all clients, and false
+// without CORS
+func (h *H2loadClient) GetCORSStats() (CORSStats, bool) {
+	if !h.ClientsConf.CORS.enabled() {
+		return CORSStats{}, false
+	}
+	var stats CORSStats
+	var total time.Duration
+	hist := newLatencyHistogram()
+	for _, c := range h.Clients {
+		r := c.cors
+		r.mu.Lock()
+		stats.Preflights += r.stats.Preflights
+		stats.Allowed += r.stats.Allowed
+		stats.Denied += r.stats.Denied
+		stats.Failed += r.stats.Failed
+		stats.MaxLatency = max(stats.MaxLatency, r.stats.MaxLatency)
+		total += r.total
+		hist.Merge(r.hist)
+		r.mu.Unlock()
+	}
+	if answered := stats.Allowed + stats.Denied; answered > 0 {
+		stats.AvgLatency = total / time.Duration(answered)
+		stats.P50Latency = latencyPercentile(hist, 50)
+		stats.P99Latency = latencyPercentile(hist, 99)
+	}
+	return stats, true
+}
@@ -0,0 +1,263 @@
+package h2load
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// maxCrudResponseBody bounds how much of a create response is read for its ID
+const maxCrudResponseBody = 1 << 20
+
+// CrudConf configures a stateful REST workload: resources are created at
+// CreateRps, and the IDs they return feed reads at ReadRps and deletes at
+// DeleteRps
+type CrudConf struct {
+	CreateURL   string // Collection URL resources are POSTed to
+	CreateBody  []byte // Body of create requests
+	ContentType string // Content-Type of create requests (default: application/json)
+	ResourceURL string // Resource URL with an {id} placeholder (default: CreateURL/{id})
+	IDField     string // JSON field of the create response holding the ID (default: id)
+	CreateRps   int
+	ReadRps     int
+	DeleteRps   int
+	Seed        uint64 // Seed of the IDs picked for reads and deletes (0 = random)
+}
+
+func (c *CrudConf) Validate() error {
+	if c.CreateURL == "" {
+		return fmt.Errorf("crud create URL is required")
+	}
+	if c.CreateRps < 0 || c.ReadRps < 0 || c.DeleteRps < 0 {
+		return fmt.Errorf("crud rates must be greater than 0")
+	}
+	if c.CreateRps == 0 {
+		return fmt.Errorf("crud create rps must be greater than 0, reads and deletes need created IDs")
+	}
+	if c.ResourceURL != "" && !strings.Contains(c.ResourceURL, "{id}") {
+		return fmt.Errorf("crud resource URL must contain an {id} placeholder")
+	}
+	return nil
+}
+
+// TotalRps returns the combined rate of all operations
+func (c *CrudConf) TotalRps() int {
+	return c.CreateRps + c.ReadRps + c.DeleteRps
+}
+
+// CrudOp is an operation of the CRUD workload
+type CrudOp int
+
+const (
+	CrudCreate CrudOp = iota
+	CrudRead
+	CrudDelete
+)
+
+func (o CrudOp) String() string {
+	return [...]string{"create", "read", "delete"}[o]
+}
+
+// CrudStats counts the operations of a CRUD workload
+type CrudStats struct {
+	Created     int64 // Create responses an ID was extracted from
+	Reads       int64
+	Deletes     int64
+	NoID        int64 // Create responses without a usable ID
+	NotFound    int64 // Reads and deletes answered with 404
+	Substituted int64 // Reads/deletes sent as creates because no ID was available
+	PoolSize    int   // Live IDs at the end of the run
+}
+
+// String formats the CrudStats as a readable string
+func (s CrudStats) String() string {
+	return fmt.Sprintf(`CRUD Workload:
+Created: %d
+Reads: %d
+Deletes: %d
+Creates Without ID: %d
+Not Found (404): %d
+Sent As Create (empty ID pool): %d
+Live IDs: %d`,
+		s.Created, s.Reads, s.Deletes, s.NoID, s.NotFound, s.Substituted, s.PoolSize)
+}
+
+type crudOpKey struct{}
+
+// CrudWorkload generates the requests of a CRUD workload and tracks the pool
+// of created resource IDs
+type CrudWorkload struct {
+	conf CrudConf
+
+	mu      sync.Mutex
+	ids     []string
+	weights [3]int // Current smooth weighted round-robin weights per op
+	stats   CrudStats
+	rand    *rand.Rand
+}
+
+func NewCrudWorkload(conf CrudConf) (*CrudWorkload, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	if conf.ContentType == "" {
+		conf.ContentType = "application/json"
+	}
+	if conf.ResourceURL == "" {
+		conf.ResourceURL = strings.TrimSuffix(conf.CreateURL, "/") + "/{id}"
+	}
+	if conf.IDField == "" {
+		conf.IDField = "id"
+	}
+	return &CrudWorkload{conf: conf, rand: newRand(conf.Seed, randCrud)}, nil
+}
+
+// nextOp picks the next operation by smooth weighted round-robin, so the
+// operations interleave evenly in proportion to their rates
+func (w *CrudWorkload) nextOp() CrudOp {
+	rates := [3]int{w.conf.CreateRps, w.conf.ReadRps, w.conf.DeleteRps}
+	total := w.conf.TotalRps()
+	best := CrudCreate
+	for op := range rates {
+		w.weights[op] += rates[op]
+		if w.weights[op] > w.weights[best] {
+			best = CrudOp(op)
+		}
+	}
+	w.weights[best] -= total
+	return best
+}
+
+// nextRequest is the request factory of the workload
+func (w *CrudWorkload) nextRequest() *http.Request {
+	w.mu.Lock()
+	op := w.nextOp()
+	var id string
+	if op != CrudCreate {
+		if len(w.ids) == 0 {
+			op = CrudCreate
+			w.stats.Substituted++
+		} else if op == CrudRead {
+			id = w.ids[w.rand.IntN(len(w.ids))]
+		} else {
+			// Deleted IDs leave the pool right away so they aren't read or deleted again
+			i := w.rand.IntN(len(w.ids))
+			id = w.ids[i]
+			w.ids[i] = w.ids[len(w.ids)-1]
+			w.ids = w.ids[:len(w.ids)-1]
+		}
+	}
+	w.mu.Unlock()
+
+	var req *http.Request
+	switch op {
+	case CrudCreate:
+		req, _ = http.NewRequest(http.MethodPost, w.conf.CreateURL, bytes.NewReader(w.conf.CreateBody))
+		req.Header.Set("Content-Type", w.conf.ContentType)
+	case CrudRead:
+		req, _ = http.NewRequest(http.MethodGet, w.resourceURL(id), nil)
+		req = WithRoute(req, "GET "+w.resourceRoute())
+	case CrudDelete:
+		req, _ = http.NewRequest(http.MethodDelete, w.resourceURL(id), nil)
+		req = WithRoute(req, "DELETE "+w.resourceRoute())
+	}
+	return req.WithContext(context.WithValue(req.Context(), crudOpKey{}, op))
+}
+
+func (w *CrudWorkload) resourceURL(id string) string {
+	return strings.ReplaceAll(w.conf.ResourceURL, "{id}", id)
+}
+
+// resourceRoute returns the path of ResourceURL with its {id} placeholder,
+// so all resources are counted under one route
+func (w *CrudWorkload) resourceRoute() string {
+	if u, err := url.Parse(w.conf.ResourceURL); err == nil {
+		if p, err := url.PathUnescape(u.EscapedPath()); err == nil {
+			return p
+		}
+	}
+	return w.conf.ResourceURL
+}
+
+// handleResponse feeds created IDs into the pool and counts outcomes
+func (w *CrudWorkload) handleResponse(req *http.Request, resp *http.Response) {
+	op, ok := req.Context().Value(crudOpKey{}).(CrudOp)
+	if !ok {
+		return
+	}
+	var id string
+	if op == CrudCreate && resp.StatusCode < 300 {
+		id = w.extractID(resp)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case op != CrudCreate && resp.StatusCode == http.StatusNotFound:
+		w.stats.NotFound++
+	case op == CrudRead:
+		w.stats.Reads++
+	case op == CrudDelete:
+		w.stats.Deletes++
+	case id == "":
+		w.stats.NoID++
+	default:
+		w.stats.Created++
+		w.ids = append(w.ids, id)
+	}
+}
+
+// extractID reads the ID from the IDField of a JSON body, falling back to the
+// last path segment of the Location header
+func (w *CrudWorkload) extractID(resp *http.Response) string {
+	var body map[string]any
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, maxCrudResponseBody))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err == nil {
+		switch v := body[w.conf.IDField].(type) {
+		case string:
+			return v
+		case json.Number:
+			return v.String()
+		}
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		return path.Base(location)
+	}
+	return ""
+}
+
+// Stats returns the operation counts of the workload
+func (w *CrudWorkload) Stats() CrudStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.PoolSize = len(w.ids)
+	return stats
+}
+
+// RunCrud runs the CRUD workload on all clients. The rates of the workload
+// replace the RPS limits of the client configuration.
+func (h *H2loadClient) RunCrud(w *CrudWorkload) error {
+	h.ClientsConf.Rps = 0
+	h.ClientsConf.TotalRps = w.conf.TotalRps()
+	for _, c := range h.Clients {
+		c.Conf.Rps = 0
+		c.responseFunc = w.handleResponse
+	}
+	return h.RunRequestsFactory(func() *http.Request {
+		req := w.nextRequest()
+		for k, v := range h.ClientsConf.Headers {
+			req.Header[k] = v
+		}
+		return req
+	})
+}
@@ -0,0 +1,95 @@
+package h2load
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DryRunResult is what a dry run learned about the server, without sending
+// a request
+type DryRunResult struct {
+	DialAddress string        // Host and port dialed, after ServerAddress and ConnectTo
+	Resolved    []string      // Addresses DialAddress resolved to, after Resolve and DNSServer
+	Connected   string        // Address connected to
+	Protocol    string        // "h2" negotiated with ALPN, or "h2c" over cleartext
+	TLSVersion  string        // Empty over cleartext
+	Handshake   time.Duration // From dialing to the server's SETTINGS
+	Settings    ServerSettings
+}
+
+// String formats the DryRunResult as a readable string
+func (r DryRunResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Dry run:\n")
+	fmt.Fprintf(&b, "  Resolved: %s -> %s\n", r.DialAddress, strings.Join(r.Resolved, ", "))
+	fmt.Fprintf(&b, "  Connected: %s (%s) in %v\n", r.Connected, addrFamily(r.Connected), r.Handshake)
+	if r.TLSVersion != "" {
+		fmt.Fprintf(&b, "  Protocol: %s (ALPN, %s)\n", r.Protocol, r.TLSVersion)
+	} else {
+		fmt.Fprintf(&b, "  Protocol: %s (cleartext)\n", r.Protocol)
+	}
+	fmt.Fprintf(&b, "  Server SETTINGS: %s", r.Settings)
+	return b.String()
+}
+
+// DryRun resolves the host conf dials, and completes the TCP, TLS and HTTP/2
+// handshakes of a single connection, which it closes without sending a
+// request
+func DryRun(conf H2loadConf) (DryRunResult, error) {
+	if err := conf.Validate(); err != nil {
+		return DryRunResult{}, err
+	}
+	var result DryRunResult
+	var err error
+	if len(conf.Targets) > 0 {
+		// The first target stands for the others
+		conf.ServerAddress = conf.Targets[0]
+	}
+	if result.DialAddress, err = dialAddress(conf.URL, conf.ServerAddress, conf.ConnectTo); err != nil {
+		return result, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	addrs := resolveAddrs(result.DialAddress, conf.Resolve)
+	if result.Resolved, err = lookupAddrs(ctx, newResolver(conf.DNSServer), addrs, &trafficCounter{}); err != nil {
+		return result, fmt.Errorf("resolving %s: %w", result.DialAddress, err)
+	}
+
+	conf.Clients = 1
+	client := NewH2Client(conf)
+	defer func() {
+		// The client never runs DoRequestsFactory, which normally closes them
+		client.closeChannels()
+		client.Close()
+	}()
+	dial, _, err := client.dialer()
+	if err != nil {
+		return result, err
+	}
+	start := time.Now()
+	conn, err := dial(ctx)
+	if err != nil {
+		return result, fmt.Errorf("connecting to %s: %w", result.DialAddress, err)
+	}
+	defer conn.Close()
+	result.Connected = conn.RemoteAddr().String()
+	result.Protocol = "h2c"
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		result.TLSVersion = tls.VersionName(state.Version)
+		if state.NegotiatedProtocol != "h2" {
+			return result, fmt.Errorf("server negotiated %q instead of h2", state.NegotiatedProtocol)
+		}
+		result.Protocol = "h2"
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if result.Settings, err = prefaceExchange(conn); err != nil {
+		return result, err
+	}
+	result.Handshake = time.Since(start)
+	return result, nil
+}
@@ -0,0 +1,181 @@
+package h2load
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// ExpectContinueConf sends Expect: 100-continue on requests with a body,
+// which hold the body back until the server answers 100 Continue, or
+// Timeout passes. The time to the 100 is measured, and the requests the
+// server answered before their body was sent are counted.
+type ExpectContinueConf struct {
+	Enabled bool
+	Timeout time.Duration // How long to wait for 100 Continue before sending the body, required when Enabled (CLI default: 1s, as curl waits)
+}
+
+func (c *ExpectContinueConf) Validate() error {
+	if c.Timeout < 0 {
+		return fmt.Errorf("expect continue timeout must not be negative")
+	}
+	// A zero timeout would send every body without waiting, which is no
+	// expect continue at all
+	if c.Enabled && c.Timeout == 0 {
+		return fmt.Errorf("expect continue timeout must be greater than 0")
+	}
+	return nil
+}
+
+// hasBody reports whether req sends a body
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0
+}
+
+// continueTracer times a request's wait for 100 Continue, from the transport's
+// goroutines
+type continueTracer struct {
+	mu       sync.Mutex
+	waiting  time.Time     // When the headers were sent and the body held back
+	got100   time.Duration // Time to the 100 Continue
+	got      bool
+	answered time.Duration // Time to the first response byte
+}
+
+// attach returns a copy of req sending Expect: 100-continue and reporting
+// its 100 Continue to the tracer
+func (t *continueTracer) attach(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		Wait100Continue: func() {
+			t.mu.Lock()
+			t.waiting = time.Now()
+			t.mu.Unlock()
+		},
+		Got100Continue: func() {
+			t.mu.Lock()
+			t.got100, t.got = time.Since(t.waiting), true
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.answered = time.Since(t.waiting)
+			t.mu.Unlock()
+		},
+	}
+	// Factories may hand out the same request every time
+	req = req.Clone(httptrace.WithClientTrace(req.Context(), trace))
+	req.Header.Set("Expect", "100-continue")
+	return req
+}
+
+// ExpectContinueStats are the outcomes of the requests sent with Expect:
+// 100-continue
+type ExpectContinueStats struct {
+	Requests   int64 // Sent with Expect: 100-continue
+	Continued  int64 // Answered 100 Continue, then their body was sent
+	Rejected   int64 // Answered with a final 3xx-5xx response before their body was sent
+	NoContinue int64 // Not answered 100 Continue in time, their body was sent after the timeout
+	Failed     int64 // Failed without a response
+
+	// Time from the headers being sent to the 100 Continue
+	AvgContinue time.Duration
+	P50Continue time.Duration
+	P99Continue time.Duration
+	MaxContinue time.Duration
+}
+
+// String formats the ExpectContinueStats as a readable string
+func (s ExpectContinueStats) String() string {
+	return fmt.Sprintf(`Expect 100-continue:
+Requests: %d
+Continued (100): %d
+Rejected Before Body: %d
+No 100, Body Sent After Timeout: %d
+Failed: %d
+Time to 100: avg %v, p50 %v, p99 %v, max %v`,
+		s.Requests, s.Continued, s.Rejected, s.NoContinue, s.Failed,
+		s.AvgContinue, s.P50Continue, s.P99Continue, s.MaxContinue)
+}
+
+// continueRecorder collects the ExpectContinueStats of a client
+type continueRecorder struct {
+	mu    sync.Mutex
+	stats ExpectContinueStats
+	total time.Duration // Sum of the times to 100
+	hist  *hdrhistogram.Histogram
+}
+
+func newContinueRecorder() *continueRecorder {
+	return &continueRecorder{hist: newLatencyHistogram()}
+}
+
+// record counts the outcome of a request traced by t, answered with status
+// or failed with err, and reports whether it was rejected before its body
+// was sent. Answers after timeout came once the body was being sent anyway.
+func (r *continueRecorder) record(t *continueTracer, timeout time.Duration, status int, err error) (rejected bool) {
+	t.mu.Lock()
+	got, got100, answered := t.got && t.got100 < timeout, t.got100, t.answered
+	t.mu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Requests++
+	switch {
+	case got:
+		r.stats.Continued++
+		r.total += got100
+		r.stats.MaxContinue = max(r.stats.MaxContinue, got100)
+		recordLatency(r.hist, got100)
+	case err != nil:
+		r.stats.Failed++
+	case status >= 300 && answered < timeout:
+		// The transport stops sending the body of requests answered
+		// with a 3xx-5xx
+		r.stats.Rejected++
+		return true
+	default:
+		r.stats.NoContinue++
+	}
+	return false
+}
+
+func (r *continueRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = ExpectContinueStats{}
+	r.total = 0
+	r.hist.Reset()
+}
+
+// GetExpectContinueStats returns the outcomes of the requests of all
+// clients sent with Expect: 100-continue, and false without ExpectContinue
+func (h *H2loadClient) GetExpectContinueStats() (ExpectContinueStats, bool) {
+	if !h.ClientsConf.ExpectContinue.Enabled {
+		return ExpectContinueStats{}, false
+	}
+	var stats ExpectContinueStats
+	var total time.Duration
+	hist := newLatencyHistogram()
+	for _, c := range h.Clients {
+		r := c.continues
+		r.mu.Lock()
+		stats.Requests += r.stats.Requests
+		stats.Continued += r.stats.Continued
+		stats.Rejected += r.stats.Rejected
+		stats.NoContinue += r.stats.NoContinue
+		stats.Failed += r.stats.Failed
+		stats.MaxContinue = max(stats.MaxContinue, r.stats.MaxContinue)
+		total += r.total
+		hist.Merge(r.hist)
+		r.mu.Unlock()
+	}
+	if stats.Continued > 0 {
+		stats.AvgContinue = total / time.Duration(stats.Continued)
+		stats.P50Continue = latencyPercentile(hist, 50)
+		stats.P99Continue = latencyPercentile(hist, 99)
+	}
+	return stats, true
+}
@@ -0,0 +1,278 @@
+package h2load
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// maxExtractBody bounds how much of a response body extractors read
+const maxExtractBody = 1 << 20
+
+// ExtractKind is where an Extractor looks for its value
+type ExtractKind int
+
+const (
+	ExtractJSON   ExtractKind = iota // A JSON path into the body, e.g. $.data.token
+	ExtractRegex                     // A regular expression over the body, its first group if it has one
+	ExtractHeader                    // A response header
+)
+
+func (k ExtractKind) String() string {
+	return [...]string{"json", "regex", "header"}[k]
+}
+
+// Extractor pulls a value out of every response into a variable of the
+// virtual user that sent the request. A {name} placeholder in the URL or a
+// header of the user's next requests is replaced by the variable, so one
+// request can use what the last response returned, e.g. a login token or a
+// pagination cursor. A response without the value leaves the variable as is.
+type Extractor struct {
+	Name string
+	Kind ExtractKind
+	Expr string // JSON path, regular expression or header name
+
+	path []jsonPathStep
+	re   *regexp.Regexp
+}
+
+// jsonPathStep is a field name, or an index with field empty
+type jsonPathStep struct {
+	field string
+	index int
+}
+
+// ParseExtractor parses NAME=json:PATH, NAME=regex:PATTERN or
+// NAME=header:HEADER, e.g. token=json:$.data.token
+func ParseExtractor(s string) (Extractor, error) {
+	name, spec, ok := strings.Cut(s, "=")
+	kind, expr, ok2 := strings.Cut(spec, ":")
+	if !ok || !ok2 || strings.TrimSpace(name) == "" || expr == "" {
+		return Extractor{}, fmt.Errorf("invalid extractor %q, expected NAME=json:PATH, NAME=regex:PATTERN or NAME=header:HEADER", s)
+	}
+	e := Extractor{Name: strings.TrimSpace(name), Expr: expr}
+	switch strings.ToLower(kind) {
+	case "json":
+		e.Kind = ExtractJSON
+	case "regex":
+		e.Kind = ExtractRegex
+	case "header":
+		e.Kind = ExtractHeader
+	default:
+		return Extractor{}, fmt.Errorf("unknown extractor kind %q, expected json, regex or header", kind)
+	}
+	return e, e.compile()
+}
+
+// String formats the extractor as ParseExtractor parses it
+func (e Extractor) String() string {
+	return fmt.Sprintf("%s=%s:%s", e.Name, e.Kind, e.Expr)
+}
+
+// compile parses the JSON path or regular expression of e
+func (e *Extractor) compile() error {
+	if strings.ContainsAny(e.Name, "{}") {
+		return fmt.Errorf("extractor name %q can't contain braces", e.Name)
+	}
+	var err error
+	switch e.Kind {
+	case ExtractJSON:
+		e.path, err = parseJSONPath(e.Expr)
+	case ExtractRegex:
+		if e.re, err = regexp.Compile(e.Expr); err != nil {
+			err = fmt.Errorf("extractor %s: %w", e.Name, err)
+		}
+	case ExtractHeader:
+		if strings.TrimSpace(e.Expr) == "" {
+			err = fmt.Errorf("extractor %s needs a header name", e.Name)
+		}
+	}
+	return err
+}
+
+// parseJSONPath parses a path of fields and indexes such as
+// $.items[0].id, the leading $ being optional
+func parseJSONPath(p string) ([]jsonPathStep, error) {
+	invalid := fmt.Errorf("invalid JSON path %q, expected fields and indexes such as $.items[0].id", p)
+	rest := strings.TrimPrefix(strings.TrimSpace(p), "$")
+	var steps []jsonPathStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			field := rest[1 : end+1]
+			if field == "" {
+				return nil, invalid
+			}
+			steps = append(steps, jsonPathStep{field: field})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, invalid
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, invalid
+			}
+			steps = append(steps, jsonPathStep{index: i})
+			rest = rest[end+1:]
+		default:
+			return nil, invalid
+		}
+	}
+	if len(steps) == 0 {
+		return nil, invalid
+	}
+	return steps, nil
+}
+
+// extract returns the value of e in resp, whose body is body
+func (e *Extractor) extract(resp *http.Response, body []byte) (string, bool) {
+	switch e.Kind {
+	case ExtractHeader:
+		v := resp.Header.Get(e.Expr)
+		return v, v != ""
+	case ExtractRegex:
+		m := e.re.FindSubmatch(body)
+		if m == nil {
+			return "", false
+		}
+		if len(m) > 1 {
+			return string(m[1]), true
+		}
+		return string(m[0]), true
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return "", false
+	}
+	for _, step := range e.path {
+		switch node := v.(type) {
+		case map[string]any:
+			if v = node[step.field]; step.field == "" || v == nil {
+				return "", false
+			}
+		case []any:
+			if step.field != "" || step.index >= len(node) {
+				return "", false
+			}
+			v = node[step.index]
+		default:
+			return "", false
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "", false
+	}
+	// Objects and arrays are kept as JSON
+	raw, err := json.Marshal(v)
+	return string(raw), err == nil
+}
+
+// ExtractorStats counts the responses an Extractor found its value in
+type ExtractorStats struct {
+	Extractor string
+	Hits      int64
+	Misses    int64
+}
+
+// extractorSet applies the run's extractors, shared by its user pools
+type extractorSet struct {
+	extractors []Extractor
+	readsBody  bool    // Some extractors need the body
+	hits       []int64 // Per extractor
+	misses     []int64
+}
+
+// newExtractorSet compiles copies of extractors, checked by Validate
+func newExtractorSet(extractors []Extractor) *extractorSet {
+	s := &extractorSet{
+		extractors: append([]Extractor(nil), extractors...),
+		hits:       make([]int64, len(extractors)),
+		misses:     make([]int64, len(extractors)),
+	}
+	for i := range s.extractors {
+		_ = s.extractors[i].compile()
+		s.readsBody = s.readsBody || s.extractors[i].Kind != ExtractHeader
+	}
+	return s
+}
+
+// apply extracts the values of resp into u's variables. The body read is put
+// back in front of the rest, so it is still drained and counted.
+func (s *extractorSet) apply(u *VirtualUser, resp *http.Response) {
+	var body []byte
+	if s.readsBody {
+		body, _ = io.ReadAll(io.LimitReader(resp.Body, maxExtractBody))
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	}
+	for i := range s.extractors {
+		if v, ok := s.extractors[i].extract(resp, body); ok {
+			u.Set(s.extractors[i].Name, v)
+			atomic.AddInt64(&s.hits[i], 1)
+		} else {
+			atomic.AddInt64(&s.misses[i], 1)
+		}
+	}
+}
+
+// substitute replaces the {name} placeholders of the extractors' variables
+// in the URL and headers of req, a copy u owns. Substituted paths are
+// counted under their route with the placeholders.
+func (s *extractorSet) substitute(u *VirtualUser, req *http.Request) *http.Request {
+	replace := func(v string, escape func(string) string) string {
+		if !strings.Contains(v, "{") {
+			return v
+		}
+		for _, e := range s.extractors {
+			v = strings.ReplaceAll(v, "{"+e.Name+"}", escape(u.Get(e.Name)))
+		}
+		return v
+	}
+	keep := func(v string) string { return v }
+
+	if path := replace(req.URL.Path, url.PathEscape); path != req.URL.Path {
+		req = WithRoute(req, requestRoute(req))
+		req.URL.Path, req.URL.RawPath = path, ""
+	}
+	req.URL.RawQuery = replace(req.URL.RawQuery, url.QueryEscape)
+	for name, values := range req.Header {
+		for i, v := range values {
+			values[i] = replace(v, keep)
+		}
+		req.Header[name] = values
+	}
+	return req
+}
+
+// stats returns the hits and misses of every extractor
+func (s *extractorSet) stats() []ExtractorStats {
+	stats := make([]ExtractorStats, len(s.extractors))
+	for i, e := range s.extractors {
+		stats[i] = ExtractorStats{Extractor: e.String(), Hits: atomic.LoadInt64(&s.hits[i]), Misses: atomic.LoadInt64(&s.misses[i])}
+	}
+	return stats
+}
@@ -0,0 +1,219 @@
+package h2load
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// initialWindow is the flow control window of connections and streams
+// before any SETTINGS or WINDOW_UPDATE
+const initialWindow = 65535
+
+// maxEndedStreams is how many stall times of ended streams are kept for
+// pickup before those of old streams no request asked for, e.g. earlier
+// attempts of a retried request, are dropped
+const maxEndedStreams = 1024
+
+// flowTracker follows the flow control windows of a connection from the
+// frames read and written on it, timing how long each stream is stalled: it
+// has a body left to send and its send window or the connection's is used
+// up, or it has a response left to receive and the window the client gave
+// the server for it is used up.
+type flowTracker struct {
+	mu          sync.Mutex
+	sendConn    int64 // Connection window for DATA sent
+	recvConn    int64 // Connection window for DATA received
+	sendInitial int64 // Initial stream windows, from the server's SETTINGS
+	recvInitial int64 // and the transport's
+	streams     map[uint32]*flowStream
+	ended       map[uint32]time.Duration // Stall time of ended streams, until taken
+}
+
+// flowStream is the flow control state of an open stream
+type flowStream struct {
+	send, recv   int64
+	sending      bool // The request body isn't fully sent
+	receiving    bool // The response isn't fully received
+	stalledSince time.Time
+	stalled      time.Duration
+}
+
+func newFlowTracker() *flowTracker {
+	return &flowTracker{
+		sendConn:    initialWindow,
+		recvConn:    initialWindow,
+		sendInitial: initialWindow,
+		recvInitial: initialWindow,
+		streams:     make(map[uint32]*flowStream),
+		ended:       make(map[uint32]time.Duration),
+	}
+}
+
+// flowPayload reports the frame types whose payload the tracker needs
+func flowPayload(typ http2.FrameType) bool {
+	return typ == http2.FrameSettings || typ == http2.FrameWindowUpdate
+}
+
+// wrote follows a frame written by the client
+func (t *flowTracker) wrote(fh http2.FrameHeader, payload []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	switch fh.Type {
+	case http2.FrameHeaders:
+		s := t.streams[fh.StreamID]
+		if s == nil {
+			s = &flowStream{send: t.sendInitial, recv: t.recvInitial, sending: true, receiving: true}
+			t.streams[fh.StreamID] = s
+		}
+		if fh.Flags.Has(http2.FlagHeadersEndStream) {
+			s.sending = false
+		}
+		t.update(fh.StreamID, s, now)
+	case http2.FrameData:
+		t.sendConn -= int64(fh.Length)
+		if s := t.streams[fh.StreamID]; s != nil {
+			s.send -= int64(fh.Length)
+			if fh.Flags.Has(http2.FlagDataEndStream) {
+				s.sending = false
+			}
+		}
+		t.updateAll(now)
+	case http2.FrameWindowUpdate:
+		t.windowUpdate(fh.StreamID, payload, &t.recvConn, func(s *flowStream) *int64 { return &s.recv }, now)
+	case http2.FrameSettings:
+		t.settings(fh, payload, &t.recvInitial, func(s *flowStream) *int64 { return &s.recv }, now)
+	case http2.FrameRSTStream:
+		t.end(fh.StreamID, now)
+	}
+}
+
+// read follows a frame read from the server
+func (t *flowTracker) read(fh http2.FrameHeader, payload []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	switch fh.Type {
+	case http2.FrameHeaders:
+		if s := t.streams[fh.StreamID]; s != nil && fh.Flags.Has(http2.FlagHeadersEndStream) {
+			s.receiving = false
+			t.update(fh.StreamID, s, now)
+		}
+	case http2.FrameData:
+		t.recvConn -= int64(fh.Length)
+		if s := t.streams[fh.StreamID]; s != nil {
+			s.recv -= int64(fh.Length)
+			if fh.Flags.Has(http2.FlagDataEndStream) {
+				s.receiving = false
+			}
+		}
+		t.updateAll(now)
+	case http2.FrameWindowUpdate:
+		t.windowUpdate(fh.StreamID, payload, &t.sendConn, func(s *flowStream) *int64 { return &s.send }, now)
+	case http2.FrameSettings:
+		t.settings(fh, payload, &t.sendInitial, func(s *flowStream) *int64 { return &s.send }, now)
+	case http2.FrameRSTStream:
+		t.end(fh.StreamID, now)
+	}
+}
+
+// windowUpdate credits conn, or the window of the stream picked by window
+func (t *flowTracker) windowUpdate(streamID uint32, payload []byte, conn *int64, window func(*flowStream) *int64, now time.Time) {
+	if len(payload) != 4 {
+		return
+	}
+	inc := int64(binary.BigEndian.Uint32(payload) & (1<<31 - 1))
+	if streamID == 0 {
+		*conn += inc
+		t.updateAll(now)
+		return
+	}
+	if s := t.streams[streamID]; s != nil {
+		*window(s) += inc
+		t.update(streamID, s, now)
+	}
+}
+
+// settings applies a change of the initial stream window to the open
+// streams' windows picked by window
+func (t *flowTracker) settings(fh http2.FrameHeader, payload []byte, initial *int64, window func(*flowStream) *int64, now time.Time) {
+	if fh.Flags.Has(http2.FlagSettingsAck) {
+		return
+	}
+	for ; len(payload) >= 6; payload = payload[6:] {
+		if http2.SettingID(binary.BigEndian.Uint16(payload)) != http2.SettingInitialWindowSize {
+			continue
+		}
+		val := int64(binary.BigEndian.Uint32(payload[2:]))
+		for _, s := range t.streams {
+			*window(s) += val - *initial
+		}
+		*initial = val
+	}
+	t.updateAll(now)
+}
+
+func (t *flowTracker) updateAll(now time.Time) {
+	for id, s := range t.streams {
+		t.update(id, s, now)
+	}
+}
+
+// update starts or stops the stall timer of a stream, and retires it once
+// both directions ended
+func (t *flowTracker) update(id uint32, s *flowStream, now time.Time) {
+	stalled := s.sending && (s.send <= 0 || t.sendConn <= 0) ||
+		s.receiving && (s.recv <= 0 || t.recvConn <= 0)
+	switch {
+	case stalled && s.stalledSince.IsZero():
+		s.stalledSince = now
+	case !stalled && !s.stalledSince.IsZero():
+		s.stalled += now.Sub(s.stalledSince)
+		s.stalledSince = time.Time{}
+	}
+	if !s.sending && !s.receiving {
+		t.end(id, now)
+	}
+}
+
+// end retires a stream, keeping its stall time until taken
+func (t *flowTracker) end(id uint32, now time.Time) {
+	s := t.streams[id]
+	if s == nil {
+		return
+	}
+	delete(t.streams, id)
+	if !s.stalledSince.IsZero() {
+		s.stalled += now.Sub(s.stalledSince)
+	}
+	if len(t.ended) >= maxEndedStreams {
+		for old := range t.ended {
+			if old+2*maxEndedStreams < id {
+				delete(t.ended, old)
+			}
+		}
+	}
+	t.ended[id] = s.stalled
+}
+
+// take returns the time a stream was stalled so far, forgetting it if it
+// ended
+func (t *flowTracker) take(id uint32) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if d, ok := t.ended[id]; ok {
+		delete(t.ended, id)
+		return d
+	}
+	s := t.streams[id]
+	if s == nil {
+		return 0
+	}
+	if !s.stalledSince.IsZero() {
+		return s.stalled + time.Since(s.stalledSince)
+	}
+	return s.stalled
+}
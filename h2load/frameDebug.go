@@ -0,0 +1,190 @@
+package h2load
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// FrameDebugConf logs the HTTP/2 frames sent and received on some of the
+// run's streams, one line per frame, for debugging the server or a proxy
+// in the path. Connection frames (stream 0) are logged on the connections
+// of logged streams, and on every connection until the first Streams
+// streams are logged.
+type FrameDebugConf struct {
+	Streams int       // Log the frames of the run's first Streams streams
+	Sample  float64   // Log the frames of this fraction of the other streams
+	Out     io.Writer // Where the frames are logged (default: os.Stderr)
+}
+
+func (c *FrameDebugConf) Validate() error {
+	if c.Streams < 0 {
+		return fmt.Errorf("debug frames streams must be greater than 0")
+	}
+	if c.Sample < 0 || c.Sample > 1 {
+		return fmt.Errorf("debug frames sample must be between 0 and 1")
+	}
+	return nil
+}
+
+func (c *FrameDebugConf) enabled() bool {
+	return c.Streams > 0 || c.Sample > 0
+}
+
+// frameLogger writes the frames of the picked streams, shared by all
+// clients of a run
+type frameLogger struct {
+	conf   FrameDebugConf
+	mu     sync.Mutex // Serializes lines
+	out    io.Writer
+	picked int64 // Streams offered to the first Streams so far (atomic)
+	sample *lockedRand
+}
+
+// newFrameLogger returns the frame logger of conf, sampling streams with
+// seed, nil if disabled
+func newFrameLogger(conf FrameDebugConf, seed uint64) *frameLogger {
+	if !conf.enabled() {
+		return nil
+	}
+	out := conf.Out
+	if out == nil {
+		out = os.Stderr
+	}
+	return &frameLogger{conf: conf, out: out, sample: newLockedRand(seed, randFrames)}
+}
+
+// pick decides whether a new stream is logged
+func (l *frameLogger) pick() bool {
+	if atomic.LoadInt64(&l.picked) < int64(l.conf.Streams) && atomic.AddInt64(&l.picked, 1) <= int64(l.conf.Streams) {
+		return true
+	}
+	return l.conf.Sample > 0 && l.sample.Float64() < l.conf.Sample
+}
+
+// firstLeft reports whether some of the first Streams streams aren't picked
+func (l *frameLogger) firstLeft() bool {
+	return atomic.LoadInt64(&l.picked) < int64(l.conf.Streams)
+}
+
+// conn returns the frame logging of a new connection
+func (l *frameLogger) conn(id uint64) *connFrames {
+	return &connFrames{log: l, connID: id, picked: make(map[uint32]bool)}
+}
+
+// write logs a frame of a connection, dir being send or recv
+func (l *frameLogger) write(connID uint64, dir string, fh http2.FrameHeader, payload []byte) {
+	line := fmt.Sprintf("time=%s conn=%d dir=%s stream=%d type=%v flags=%s len=%d%s\n",
+		time.Now().Format("15:04:05.000000"), connID, dir, fh.StreamID, fh.Type, frameFlags(fh), fh.Length, frameDetail(fh, payload))
+	l.mu.Lock()
+	io.WriteString(l.out, line)
+	l.mu.Unlock()
+}
+
+// connFrames picks the streams of a connection whose frames are logged.
+// Streams are picked on their first frame, each side's IDs only grow.
+type connFrames struct {
+	log       *frameLogger
+	connID    uint64
+	mu        sync.Mutex
+	picked    map[uint32]bool // Streams logged
+	last      [2]uint32       // Highest stream ID seen, of the client's and the server's streams
+	anyPicked bool
+}
+
+// frame logs a frame of the connection if its stream is logged
+func (c *connFrames) frame(dir string, fh http2.FrameHeader, payload []byte) {
+	if c.logged(fh.StreamID) {
+		c.log.write(c.connID, dir, fh, payload)
+	}
+}
+
+func (c *connFrames) logged(id uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id == 0 {
+		return c.anyPicked || c.log.firstLeft()
+	}
+	if side := id % 2; id > c.last[side] {
+		c.last[side] = id
+		if c.log.pick() {
+			c.picked[id] = true
+			c.anyPicked = true
+		}
+	}
+	return c.picked[id]
+}
+
+// debugPayload reports the frame types whose payload is logged
+func debugPayload(typ http2.FrameType) bool {
+	switch typ {
+	case http2.FrameRSTStream, http2.FrameGoAway, http2.FrameWindowUpdate, http2.FrameSettings:
+		return true
+	}
+	return false
+}
+
+// flagName names a flag of a frame type
+type flagName struct {
+	flag http2.Flags
+	name string
+}
+
+// frameFlagNames are the flags defined for each frame type
+var frameFlagNames = map[http2.FrameType][]flagName{
+	http2.FrameData:         {{http2.FlagDataEndStream, "END_STREAM"}, {http2.FlagDataPadded, "PADDED"}},
+	http2.FrameHeaders:      {{http2.FlagHeadersEndStream, "END_STREAM"}, {http2.FlagHeadersEndHeaders, "END_HEADERS"}, {http2.FlagHeadersPadded, "PADDED"}, {http2.FlagHeadersPriority, "PRIORITY"}},
+	http2.FrameSettings:     {{http2.FlagSettingsAck, "ACK"}},
+	http2.FramePing:         {{http2.FlagPingAck, "ACK"}},
+	http2.FramePushPromise:  {{http2.FlagPushPromiseEndHeaders, "END_HEADERS"}, {http2.FlagPushPromisePadded, "PADDED"}},
+	http2.FrameContinuation: {{http2.FlagContinuationEndHeaders, "END_HEADERS"}},
+}
+
+// frameFlags names the flags set on a frame, "-" if none
+func frameFlags(fh http2.FrameHeader) string {
+	var names []string
+	for _, f := range frameFlagNames[fh.Type] {
+		if fh.Flags.Has(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, "|")
+}
+
+// frameDetail formats the fields of the payloads debugPayload keeps
+func frameDetail(fh http2.FrameHeader, payload []byte) string {
+	switch fh.Type {
+	case http2.FrameRSTStream:
+		if len(payload) == 4 {
+			return fmt.Sprintf(" code=%v", http2.ErrCode(binary.BigEndian.Uint32(payload)))
+		}
+	case http2.FrameGoAway:
+		if len(payload) >= 8 {
+			return fmt.Sprintf(" last_stream=%d code=%v",
+				binary.BigEndian.Uint32(payload)&(1<<31-1), http2.ErrCode(binary.BigEndian.Uint32(payload[4:])))
+		}
+	case http2.FrameWindowUpdate:
+		if len(payload) == 4 {
+			return fmt.Sprintf(" increment=%d", binary.BigEndian.Uint32(payload)&(1<<31-1))
+		}
+	case http2.FrameSettings:
+		var settings []string
+		for _, s := range parseSettings(payload) {
+			settings = append(settings, fmt.Sprintf("%v:%d", s.ID, s.Val))
+		}
+		if len(settings) > 0 {
+			return " settings=" + strings.Join(settings, ",")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package h2load
+
+import "time"
+
+// processCPUTime is not available on platforms without getrusage
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,136 @@
+package h2load
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	generatorSampleInterval = 500 * time.Millisecond
+	generatorSaturatedCPU   = 0.9  // Share of GOMAXPROCS above which a sample counts as saturated
+	generatorMaxGCShare     = 0.02 // Share of the run paused for GC above which the run is warned about
+)
+
+// GeneratorStats are the load generator's own resource use during a run.
+// When the generator saturates its CPUs, latency and throughput measure the
+// client machine as much as the server.
+type GeneratorStats struct {
+	Procs          int           // GOMAXPROCS, the CPUs the generator can use
+	CPUKnown       bool          // Whether the platform reports the process CPU time
+	CPUTime        time.Duration // CPU time used by the process
+	AvgCPU         float64       // CPU used over the run, as a share of Procs
+	PeakCPU        float64       // Highest CPU used over a sample interval, as a share of Procs
+	SaturatedTime  time.Duration // Time spent above generatorSaturatedCPU
+	PeakMemory     int64         // Highest memory obtained from the OS and not returned
+	PeakGoroutines int
+	GCCycles       uint32
+	GCPauseTotal   time.Duration
+	Duration       time.Duration
+}
+
+// Saturated reports whether the generator was busy enough to skew the
+// results: its CPUs were saturated, or it was paused for GC too long
+func (s GeneratorStats) Saturated() bool {
+	return s.SaturatedTime > 0 || s.gcShare() > generatorMaxGCShare
+}
+
+func (s GeneratorStats) gcShare() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return s.GCPauseTotal.Seconds() / s.Duration.Seconds()
+}
+
+// String formats the GeneratorStats as a readable string
+func (s GeneratorStats) String() string {
+	summary := "Generator Overhead:\n"
+	if s.CPUKnown {
+		summary += fmt.Sprintf("CPU: %.0f%% avg, %.0f%% peak of %d CPUs (%v CPU time)\n",
+			s.AvgCPU*100, s.PeakCPU*100, s.Procs, s.CPUTime.Round(time.Millisecond))
+	}
+	summary += fmt.Sprintf("Memory: peak %s, %d goroutines peak\n", formatBytes(s.PeakMemory), s.PeakGoroutines)
+	summary += fmt.Sprintf("GC: %d cycles, %v paused (%.2f%% of the run)", s.GCCycles, s.GCPauseTotal, s.gcShare()*100)
+	if s.SaturatedTime > 0 {
+		summary += fmt.Sprintf("\nWarning: the generator's CPUs were over %.0f%% busy for %v of %v, the results may measure this machine rather than the server; spread the load over more machines or lower it",
+			generatorSaturatedCPU*100, s.SaturatedTime.Round(time.Millisecond), s.Duration.Round(time.Millisecond))
+	}
+	if s.gcShare() > generatorMaxGCShare {
+		summary += fmt.Sprintf("\nWarning: the generator was paused for GC %.1f%% of the run, latencies include these pauses",
+			s.gcShare()*100)
+	}
+	return summary
+}
+
+// generatorMonitor samples the generator's resource use during a run
+type generatorMonitor struct {
+	mu    sync.Mutex
+	stats GeneratorStats
+	start time.Time
+
+	startCPU time.Duration
+	lastCPU  time.Duration
+	lastAt   time.Time
+	startGC  runtime.MemStats
+}
+
+func newGeneratorMonitor() *generatorMonitor {
+	m := &generatorMonitor{start: time.Now(), stats: GeneratorStats{Procs: runtime.GOMAXPROCS(0)}}
+	m.startCPU, m.stats.CPUKnown = processCPUTime()
+	m.lastCPU, m.lastAt = m.startCPU, m.start
+	runtime.ReadMemStats(&m.startGC)
+	return m
+}
+
+// run samples until done is closed. The run's last sample is taken by its
+// caller, once the clients are done.
+func (m *generatorMonitor) run(done <-chan struct{}) {
+	ticker := time.NewTicker(generatorSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			m.sample()
+		}
+	}
+}
+
+func (m *generatorMonitor) sample() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	cpu, _ := processCPUTime()
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &m.stats
+	s.PeakMemory = max(s.PeakMemory, int64(mem.Sys-mem.HeapReleased))
+	s.PeakGoroutines = max(s.PeakGoroutines, runtime.NumGoroutine())
+	s.GCCycles = mem.NumGC - m.startGC.NumGC
+	s.GCPauseTotal = time.Duration(mem.PauseTotalNs - m.startGC.PauseTotalNs)
+	s.Duration = now.Sub(m.start)
+	if !s.CPUKnown {
+		return
+	}
+	s.CPUTime = cpu - m.startCPU
+	s.AvgCPU = s.CPUTime.Seconds() / s.Duration.Seconds() / float64(s.Procs)
+	s.PeakCPU = max(s.PeakCPU, s.AvgCPU)
+	// A short last interval would make a noisy peak
+	if elapsed := now.Sub(m.lastAt); elapsed >= generatorSampleInterval/2 {
+		used := (cpu - m.lastCPU).Seconds() / elapsed.Seconds() / float64(s.Procs)
+		s.PeakCPU = max(s.PeakCPU, used)
+		if used >= generatorSaturatedCPU {
+			s.SaturatedTime += elapsed
+		}
+	}
+	m.lastCPU, m.lastAt = cpu, now
+}
+
+func (m *generatorMonitor) result() GeneratorStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
@@ -0,0 +1,109 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// gracefulCloseTimeout bounds how long a connection closed gracefully may
+// keep serving its in-flight streams before it is closed abruptly
+const gracefulCloseTimeout = 10 * time.Second
+
+// CloseConf closes the clients' connections gracefully once a run is done,
+// instead of leaving them open until the process exits and resets them.
+// Some load balancers penalize backends whose clients go away abruptly.
+type CloseConf struct {
+	Graceful   bool          // Send GOAWAY and close each connection once its streams finish
+	WaitGoAway time.Duration // Then wait up to this long for the server's GOAWAY before closing (0 = don't wait)
+}
+
+func (c *CloseConf) Validate() error {
+	if c.WaitGoAway < 0 {
+		return fmt.Errorf("GOAWAY wait must be greater than 0")
+	}
+	if c.WaitGoAway > 0 && !c.Graceful {
+		return fmt.Errorf("GOAWAY wait requires a graceful close")
+	}
+	return nil
+}
+
+// CloseStats are how the connections were closed at the end of a run with
+// Close.Graceful set
+type CloseStats struct {
+	Connections  int64 // Connections closed
+	Clean        int64 // Closed once their streams finished, within gracefulCloseTimeout
+	GoAways      int64 // Closed after the server's GOAWAY, with Close.WaitGoAway set
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// AvgLatency returns the average time from sending GOAWAY to closing
+func (s CloseStats) AvgLatency() time.Duration {
+	if s.Connections == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Connections)
+}
+
+func (s *CloseStats) merge(o CloseStats) {
+	s.Connections += o.Connections
+	s.Clean += o.Clean
+	s.GoAways += o.GoAways
+	s.TotalLatency += o.TotalLatency
+	s.MaxLatency = max(s.MaxLatency, o.MaxLatency)
+}
+
+// String formats the CloseStats as a readable string
+func (s CloseStats) String() string {
+	summary := fmt.Sprintf("Connection Close: %d closed gracefully, %d clean, %d after the server's GOAWAY\n",
+		s.Connections, s.Clean, s.GoAways)
+	summary += fmt.Sprintf("Close Latency: avg %v, max %v", s.AvgLatency(), s.MaxLatency)
+	if unclean := s.Connections - s.Clean; unclean > 0 {
+		summary += fmt.Sprintf("\nWarning: %d connections still had streams after %v and were closed abruptly", unclean, gracefulCloseTimeout)
+	}
+	return summary
+}
+
+// shutdown closes the current connection gracefully: it sends GOAWAY, lets
+// the streams in flight finish and, with waitGoAway set, waits for the
+// server's GOAWAY before closing. The stats of the close are returned, empty
+// if there was no connection.
+func (p *connPool) shutdown(waitGoAway time.Duration) CloseStats {
+	p.mu.Lock()
+	cc, meta := p.cc, p.meta
+	p.cc, p.meta = nil, nil
+	p.mu.Unlock()
+	if cc == nil {
+		return CloseStats{}
+	}
+
+	if meta != nil && waitGoAway > 0 {
+		atomic.StoreInt64(&meta.closeWait, int64(waitGoAway))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gracefulCloseTimeout+waitGoAway)
+	defer cancel()
+	start := time.Now()
+	err := cc.Shutdown(ctx)
+	latency := time.Since(start)
+	if err != nil {
+		cc.Close()
+	}
+	s := CloseStats{Connections: 1, TotalLatency: latency, MaxLatency: latency}
+	if err == nil {
+		s.Clean = 1
+	}
+	if meta != nil && meta.sentGoAway() {
+		s.GoAways = 1
+	}
+	return s
+}
+
+// closeGracefully closes the client's connection as Conf.Close sets
+func (h *H2Client) closeGracefully() CloseStats {
+	if h.pool == nil {
+		return CloseStats{}
+	}
+	return h.pool.shutdown(h.Conf.Close.WaitGoAway)
+}
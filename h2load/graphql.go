@@ -0,0 +1,97 @@
+package h2load
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxGraphQLResponse bounds how much of a response is parsed for errors,
+// larger responses aren't checked
+const maxGraphQLResponse = 4 << 20
+
+// GraphQLConf sends a GraphQL operation as the JSON body of every request.
+// GraphQL servers report failures in an errors array of 200 responses, so
+// 2xx responses with errors are counted as failed.
+type GraphQLConf struct {
+	Query         string          // Query or mutation document
+	Variables     json.RawMessage // JSON object of the operation's variables, if any
+	OperationName string          // Operation run when Query holds several
+}
+
+func (c *GraphQLConf) Validate() error {
+	if c.Query == "" {
+		if len(c.Variables) > 0 || c.OperationName != "" {
+			return fmt.Errorf("graphql variables and operation name require a query")
+		}
+		return nil
+	}
+	if len(c.Variables) > 0 {
+		var vars map[string]any
+		if err := json.Unmarshal(c.Variables, &vars); err != nil {
+			return fmt.Errorf("graphql variables must be a JSON object: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *GraphQLConf) enabled() bool {
+	return c.Query != ""
+}
+
+// body returns the JSON request body of the operation
+func (c *GraphQLConf) body() ([]byte, error) {
+	return json.Marshal(struct {
+		Query         string          `json:"query"`
+		OperationName string          `json:"operationName,omitempty"`
+		Variables     json.RawMessage `json:"variables,omitempty"`
+	}{c.Query, c.OperationName, c.Variables})
+}
+
+// GraphQLRequestFactory returns a request factory for RunRequestsFactory
+// sending method requests to url with the operation of conf as their body
+func GraphQLRequestFactory(method, url string, header http.Header, conf GraphQLConf) (func() *http.Request, error) {
+	body, err := conf.body()
+	if err != nil {
+		return nil, fmt.Errorf("graphql: %w", err)
+	}
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	if header.Get("Accept") == "" {
+		header.Set("Accept", "application/graphql-response+json, application/json")
+	}
+	return StreamingRequestFactory(method, url, header, func() io.Reader {
+		return bytes.NewReader(body)
+	}, int64(len(body)))
+}
+
+// graphQLError returns an error holding the first message of the errors
+// array of a GraphQL response, or nil if there is none
+func graphQLError(body io.Reader) error {
+	limited := &io.LimitedReader{R: body, N: maxGraphQLResponse}
+	var resp struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(limited).Decode(&resp); err != nil {
+		if limited.N <= 0 {
+			return nil
+		}
+		return fmt.Errorf("graphql: invalid response: %w", err)
+	}
+	switch len(resp.Errors) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("graphql: %s", resp.Errors[0].Message)
+	}
+	return fmt.Errorf("graphql: %s (%d more)", resp.Errors[0].Message, len(resp.Errors)-1)
+}
@@ -0,0 +1,482 @@
+package h2load
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	// grpcMessageHeader is the compressed flag and length prefixing every
+	// message on the wire
+	grpcMessageHeader = 5
+	// grpcMaxMessage bounds the messages read from the server
+	grpcMaxMessage = 16 << 20
+	// grpcCloseTimeout bounds the wait for a call's trailers once the run
+	// is over and the call has stopped sending
+	grpcCloseTimeout = 2 * time.Second
+)
+
+// GRPCStreamType is the kind of streaming call made
+type GRPCStreamType int
+
+const (
+	GRPCBidi         GRPCStreamType = iota // Messages answered one for one, in order
+	GRPCServerStream                       // One request message, a stream of responses
+	GRPCClientStream                       // A stream of request messages, one response
+)
+
+// ParseGRPCStreamType parses bidi, server or client
+func ParseGRPCStreamType(s string) (GRPCStreamType, error) {
+	switch strings.ToLower(s) {
+	case "bidi":
+		return GRPCBidi, nil
+	case "server":
+		return GRPCServerStream, nil
+	case "client":
+		return GRPCClientStream, nil
+	}
+	return 0, fmt.Errorf("invalid gRPC stream type %q, expected bidi, server or client", s)
+}
+
+func (t GRPCStreamType) String() string {
+	return [...]string{"bidi", "server", "client"}[t]
+}
+
+// grpcStatusNames are the names of the gRPC status codes
+var grpcStatusNames = [...]string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+func grpcStatusName(code string) string {
+	if n, err := strconv.Atoi(code); err == nil && n >= 0 && n < len(grpcStatusNames) {
+		return grpcStatusNames[n]
+	}
+	return "status " + code
+}
+
+// GRPCStreamConf makes long-lived gRPC streaming calls to the method at the
+// URL, e.g. https://example.com/chat.Chat/Talk. Messages are sent as given,
+// already serialized, so no .proto is needed.
+type GRPCStreamConf struct {
+	Type     GRPCStreamType
+	Message  []byte        // Serialized request message (default: empty, as google.protobuf.Empty)
+	Messages int           // Messages sent per bidi or client streaming call (0 = until Duration)
+	Rate     int           // Messages per second per call (0 = as fast as the stream allows)
+	Duration time.Duration // How long calls are made for (0 = the conf's Requests calls per client)
+}
+
+func (c *GRPCStreamConf) Validate(conf H2loadConf) error {
+	if c.Rate < 0 {
+		return fmt.Errorf("gRPC message rate must be greater than 0")
+	}
+	if c.Messages < 0 {
+		return fmt.Errorf("gRPC messages per call must be greater than 0")
+	}
+	if c.Duration < 0 {
+		return fmt.Errorf("gRPC streaming duration must be greater than 0")
+	}
+	if len(c.Message) > grpcMaxMessage {
+		return fmt.Errorf("gRPC message must be at most %s", formatBytes(grpcMaxMessage))
+	}
+	if c.Messages == 0 && c.Duration == 0 && c.Type != GRPCServerStream {
+		return fmt.Errorf("gRPC %s streaming needs a number of messages per call or a duration", c.Type)
+	}
+	if c.Duration == 0 && conf.Requests == 0 {
+		return fmt.Errorf("gRPC streaming needs a duration or a number of calls")
+	}
+	return nil
+}
+
+// GRPCStreamStats are the outcome of a gRPC streaming run
+type GRPCStreamStats struct {
+	Type             GRPCStreamType
+	Calls            int64            // Calls finished
+	FailedCalls      int64            // Calls without an OK grpc-status
+	StatusCodes      map[string]int64 // Calls by grpc-status name; calls that got none are counted as "no status"
+	MessagesSent     int64
+	MessagesReceived int64
+	BytesSent        int64 // Bytes of the messages sent, without framing
+	BytesReceived    int64
+	Duration         time.Duration
+
+	// Message latencies: the round trip of bidi messages, matched in order,
+	// and the gap since the previous message of server streams
+	P50Latency time.Duration
+	P90Latency time.Duration
+	P99Latency time.Duration
+	MaxLatency time.Duration
+
+	// Call lifetimes, from the request to the trailers
+	AvgLifetime time.Duration
+	P50Lifetime time.Duration
+	P99Lifetime time.Duration
+	MaxLifetime time.Duration
+
+	LastError string // Last transport error or non-OK status
+}
+
+// String formats the GRPCStreamStats as a readable string
+func (s GRPCStreamStats) String() string {
+	rate := func(n int64) float64 {
+		if s.Duration <= 0 {
+			return 0
+		}
+		return float64(n) / s.Duration.Seconds()
+	}
+	codes := make([]string, 0, len(s.StatusCodes))
+	for code := range s.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return s.StatusCodes[codes[i]] > s.StatusCodes[codes[j]] })
+	for i, code := range codes {
+		codes[i] = fmt.Sprintf("%s: %d", code, s.StatusCodes[code])
+	}
+
+	summary := fmt.Sprintf(`gRPC Streaming Statistics (%s):
+Calls: %d (%d failed)
+Status Codes: %s
+Messages Sent: %d (%.2f/s, %s)
+Messages Received: %d (%.2f/s, %s)`,
+		s.Type, s.Calls, s.FailedCalls, strings.Join(codes, ", "),
+		s.MessagesSent, rate(s.MessagesSent), formatBytes(s.BytesSent),
+		s.MessagesReceived, rate(s.MessagesReceived), formatBytes(s.BytesReceived))
+	if s.Type != GRPCClientStream {
+		summary += fmt.Sprintf("\nMessage Latency: p50 %v, p90 %v, p99 %v, max %v",
+			s.P50Latency, s.P90Latency, s.P99Latency, s.MaxLatency)
+	}
+	summary += fmt.Sprintf("\nStream Lifetime: avg %v, p50 %v, p99 %v, max %v\nTotal Duration: %v",
+		s.AvgLifetime, s.P50Lifetime, s.P99Lifetime, s.MaxLifetime, s.Duration)
+	if s.LastError != "" {
+		summary += fmt.Sprintf("\nLast Error: %s", s.LastError)
+	}
+	return summary
+}
+
+// grpcCollector gathers the stats of all calls of a run
+type grpcCollector struct {
+	sent, received           int64
+	bytesSent, bytesReceived int64
+
+	mu            sync.Mutex
+	calls, failed int64
+	codes         map[string]int64
+	messages      *hdrhistogram.Histogram
+	lifetimes     *hdrhistogram.Histogram
+	lifetimeTotal time.Duration
+	lastError     string
+}
+
+func newGRPCCollector() *grpcCollector {
+	return &grpcCollector{
+		codes:     make(map[string]int64),
+		messages:  newLatencyHistogram(),
+		lifetimes: newLatencyHistogram(),
+	}
+}
+
+func (c *grpcCollector) recordMessage(d time.Duration) {
+	c.mu.Lock()
+	recordLatency(c.messages, d)
+	c.mu.Unlock()
+}
+
+// finish records a call that ended with status, "" if it got none, or err
+func (c *grpcCollector) finish(lifetime time.Duration, status, message string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	recordLatency(c.lifetimes, lifetime)
+	c.lifetimeTotal += lifetime
+	switch {
+	case err != nil:
+		c.failed++
+		c.codes["no status"]++
+		c.lastError = err.Error()
+	case status == "":
+		c.failed++
+		c.codes["no status"]++
+		c.lastError = "no grpc-status received"
+	default:
+		name := grpcStatusName(status)
+		c.codes[name]++
+		if status != "0" {
+			c.failed++
+			c.lastError = name
+			if message != "" {
+				c.lastError += ": " + message
+			}
+		}
+	}
+}
+
+func (c *grpcCollector) stats(t GRPCStreamType, duration time.Duration) GRPCStreamStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := GRPCStreamStats{
+		Type:             t,
+		Calls:            c.calls,
+		FailedCalls:      c.failed,
+		StatusCodes:      make(map[string]int64, len(c.codes)),
+		MessagesSent:     atomic.LoadInt64(&c.sent),
+		MessagesReceived: atomic.LoadInt64(&c.received),
+		BytesSent:        atomic.LoadInt64(&c.bytesSent),
+		BytesReceived:    atomic.LoadInt64(&c.bytesReceived),
+		Duration:         duration,
+		P50Latency:       latencyPercentile(c.messages, 50),
+		P90Latency:       latencyPercentile(c.messages, 90),
+		P99Latency:       latencyPercentile(c.messages, 99),
+		MaxLatency:       latencyPercentile(c.messages, 100),
+		P50Lifetime:      latencyPercentile(c.lifetimes, 50),
+		P99Lifetime:      latencyPercentile(c.lifetimes, 99),
+		MaxLifetime:      latencyPercentile(c.lifetimes, 100),
+		LastError:        c.lastError,
+	}
+	for code, n := range c.codes {
+		stats.StatusCodes[code] = n
+	}
+	if c.calls > 0 {
+		stats.AvgLifetime = c.lifetimeTotal / time.Duration(c.calls)
+	}
+	return stats
+}
+
+// RunGRPCStream makes streaming calls with conf's clients: every client runs
+// ConcurrentStreams calls at a time, back to back, until g.Duration is over
+// or it has made conf.Requests calls
+func RunGRPCStream(conf H2loadConf, g GRPCStreamConf) (GRPCStreamStats, error) {
+	if err := g.Validate(conf); err != nil {
+		return GRPCStreamStats{}, err
+	}
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return GRPCStreamStats{}, err
+	}
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		return GRPCStreamStats{}, fmt.Errorf("connect failed: %w", err)
+	}
+
+	ctx := context.Background()
+	if g.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Duration)
+		defer cancel()
+	}
+	c := newGRPCCollector()
+	start := time.Now()
+	RunConcurrent(client.Clients, func(h *H2Client) error {
+		h.runGRPCStreams(ctx, g, c)
+		return nil
+	})
+	return c.stats(g.Type, time.Since(start)), nil
+}
+
+// runGRPCStreams runs ConcurrentStreams calls at a time on the client's
+// connection, until ctx ends or Conf.Requests calls were made
+func (h *H2Client) runGRPCStreams(ctx context.Context, g GRPCStreamConf, c *grpcCollector) {
+	// Nothing is sent through the request path, its collectors can stop
+	defer h.closeChannels()
+	var claimed int64
+	claim := func() bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		return g.Duration > 0 || atomic.AddInt64(&claimed, 1) <= int64(h.Conf.Requests)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < max(h.Conf.ConcurrentStreams, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for claim() {
+				h.grpcCall(ctx, g, c)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// grpcCall makes one streaming call. Sending stops at g.Messages or when ctx
+// ends, after which the server gets grpcCloseTimeout to finish the call.
+func (h *H2Client) grpcCall(ctx context.Context, g GRPCStreamConf, c *grpcCollector) {
+	callCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { time.AfterFunc(grpcCloseTimeout, cancel) })
+	defer stop()
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(callCtx, http.MethodPost, h.Conf.URL, pr)
+	if err != nil {
+		c.finish(0, "", "", err)
+		return
+	}
+	for k, v := range h.Conf.Headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	// Sent concurrently, as servers may only answer once the client is done
+	start := time.Now()
+	var sends sendTimes
+	sendDone := make(chan struct{})
+	go func() {
+		defer close(sendDone)
+		pw.CloseWithError(h.grpcSend(ctx, g, pw, &sends, c))
+	}()
+	defer func() {
+		pr.Close()
+		<-sendDone
+	}()
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		c.finish(time.Since(start), "", "", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.finish(time.Since(start), "", "", fmt.Errorf("HTTP %s", resp.Status))
+		return
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/grpc") {
+		c.finish(time.Since(start), "", "", fmt.Errorf("not a gRPC response, content type %q", ct))
+		return
+	}
+
+	r := bufio.NewReader(resp.Body)
+	last := start
+	for {
+		n, err := readGRPCMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.finish(time.Since(start), "", "", err)
+			return
+		}
+		now := time.Now()
+		atomic.AddInt64(&c.received, 1)
+		atomic.AddInt64(&c.bytesReceived, n)
+		switch g.Type {
+		case GRPCBidi:
+			if sent, ok := sends.pop(); ok {
+				c.recordMessage(now.Sub(sent))
+			}
+		case GRPCServerStream:
+			c.recordMessage(now.Sub(last))
+		}
+		last = now
+	}
+	status, message := grpcStatus(resp)
+	c.finish(time.Since(start), status, message, nil)
+}
+
+// grpcSend writes the request messages of a call to w
+func (h *H2Client) grpcSend(ctx context.Context, g GRPCStreamConf, w io.Writer, sends *sendTimes, c *grpcCollector) error {
+	frame := make([]byte, grpcMessageHeader, grpcMessageHeader+len(g.Message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(g.Message)))
+	frame = append(frame, g.Message...)
+
+	messages := g.Messages
+	var tick <-chan time.Time
+	if g.Type == GRPCServerStream {
+		messages = 1
+	} else if g.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(g.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for sent := 0; messages == 0 || sent < messages; sent++ {
+		if tick == nil {
+			if ctx.Err() != nil && sent > 0 {
+				break
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-tick:
+			}
+		}
+		if g.Type == GRPCBidi {
+			sends.push(time.Now())
+		}
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+		atomic.AddInt64(&c.sent, 1)
+		atomic.AddInt64(&c.bytesSent, int64(len(g.Message)))
+	}
+	return nil
+}
+
+// readGRPCMessage reads one length-prefixed message and returns its size
+func readGRPCMessage(r *bufio.Reader) (int64, error) {
+	var header [grpcMessageHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("truncated gRPC message header")
+		}
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > grpcMaxMessage {
+		return 0, fmt.Errorf("gRPC message of %d bytes exceeds the %s limit", n, formatBytes(grpcMaxMessage))
+	}
+	if _, err := r.Discard(int(n)); err != nil {
+		return 0, fmt.Errorf("truncated gRPC message: %w", err)
+	}
+	return int64(n), nil
+}
+
+// grpcStatus returns the grpc-status and grpc-message of a finished call,
+// from its trailers or, for Trailers-Only responses, its headers
+func grpcStatus(resp *http.Response) (string, string) {
+	for _, h := range []http.Header{resp.Trailer, resp.Header} {
+		if status := h.Get("Grpc-Status"); status != "" {
+			message, _ := url.PathUnescape(h.Get("Grpc-Message"))
+			return status, message
+		}
+	}
+	return "", ""
+}
+
+// sendTimes queues the send times of bidi messages until they're answered
+type sendTimes struct {
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func (s *sendTimes) push(t time.Time) {
+	s.mu.Lock()
+	s.times = append(s.times, t)
+	s.mu.Unlock()
+}
+
+func (s *sendTimes) pop() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.times) == 0 {
+		return time.Time{}, false
+	}
+	t := s.times[0]
+	s.times = s.times[1:]
+	return t, true
+}
@@ -3,9 +3,11 @@ package h2load
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	urlpkg "net/url"
@@ -13,6 +15,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
 	"golang.org/x/net/http2"
 )
 
@@ -20,24 +23,83 @@ import (
 // and that H2loadConf in h2load.go includes the logger, logChan, and logWg fields.
 
 type H2Client struct {
+	ID           int // Index of the client within its H2loadClient
 	Conf         H2loadConf
 	LogAsJSON    bool
-	LogLineFunc  func(start time.Time, status int, latency time.Duration) string
+	LogLineFunc  func(entry LogEntry) string
+	IsSuccess    func(status int, err error) bool             // Decides which requests count as successful
+	LogFilter    func(status int, latency time.Duration) bool // Decides which requests are logged, nil logs all
 	client       *http.Client
+	pool         *connPool
 	ctx          context.Context
 	cancel       context.CancelFunc
 	sentRequests int64
 
-	logger    *log.Logger    // Logger instance for this client
-	logChan   chan string    // Channel for asynchronous logging
-	loggingWg sync.WaitGroup // WaitGroup for logging operations
-	reqWg     sync.WaitGroup // WaitGroup for requests
-	stats     RequestStats   // Statistics for this client
-	statsChan chan LogEntry  // Channel for asynchronous stats collection
-	statsWg   sync.WaitGroup // WaitGroup for stats collection
+	logger    Logger                  // Logger instance for this client
+	logChan   chan LogEntry           // Channel for asynchronous logging
+	loggingWg sync.WaitGroup          // WaitGroup for logging operations
+	reqWg     sync.WaitGroup          // WaitGroup for requests
+	statsMu   sync.Mutex              // Guards stats and hist
+	stats     RequestStats            // Statistics for this client
+	hist      *hdrhistogram.Histogram // Latency histogram for this client
+	statsChan chan LogEntry           // Channel for asynchronous stats collection
+	statsWg   sync.WaitGroup          // WaitGroup for stats collection
+
+	statsStart int64 // Unix nanos the stats are measured from
+	statsEnd   int64 // Unix nanos DoRequestsFactory finished, 0 while running
+
+	observers   atomic.Value // []statsObserver, copied on write
+	observersMu sync.Mutex   // Serializes observer registration
+
+	responseFunc func(req *http.Request, resp *http.Response) // Inspects responses before their body is drained
+	capturer     *bodyCapturer                                // Captures failed responses, nil if disabled
+	traceFunc    func(RequestTrace)                           // Receives sampled request traces
+	traceSampler *traceSampler                                // Bounds how many requests are traced
+	profiler     *latencyProfiler                             // Attributes the latency of sampled requests
+	frameLog     *frameLogger                                 // Logs the frames of some streams, nil if disabled
+
+	sharedLimiter *rpsLimiter                // Run-wide RPS limiter, overrides Conf.Rps when set
+	limiter       atomic.Pointer[rpsLimiter] // Limiter of the running DoRequestsFactory, if any
+	streams       *streamSemaphore           // Stream slots of the running DoRequestsFactory, if any
+	pacing        []*pacingRecorder          // Pacing of the per-client limiters used, nil in burst mode
+	schedules     []*scheduleRecorder        // Slips of the per-client limiters used, with StrictSchedule
+	tuneMu        sync.Mutex                 // Guards Conf.Rps, Conf.ConcurrentStreams, limiter swaps and streams
+	gate          pauseGate                  // Holds back new requests while paused
+	rpsPhase      time.Duration              // Delay before this client's first RPS refill
+	intervalRand  *lockedRand                // Skews RpsModeJitter intervals, shared by the run's clients
+	spiking       *atomic.Bool               // Set during a spike of the run, nil without Spike
+	delayRand     *lockedRand                // Draws SendDelay delays, shared by the run's clients
+	users         *userPool                  // Virtual users of this client, nil without Users
+
+	traffic        trafficCounter                 // Bytes read and connection times
+	serverSettings atomic.Pointer[ServerSettings] // SETTINGS the server sent last connection
+	compression    compressionCounter             // Responses received with a content coding
+	validators     *validatorCache                // Validators of the responses, nil without Revalidate
+	continues      *continueRecorder              // Outcomes of the Expect: 100-continue requests, nil without ExpectContinue
+	cors           *corsRecorder                  // CORS preflights sent, nil without CORS
+	corsRand       *lockedRand                    // Picks the requests preflighted, shared by the run's clients
+
+	calmEvents int64 // ENHANCE_YOUR_CALM errors received
+	redirects  int64 // Redirects followed
+
+	bytesOut       int64 // Request body bytes sent
+	retries        int64 // Retries sent
+	retrySuccesses int64 // Requests that succeeded after a retry
+	trailerErrors  int64 // Responses without the expected trailers
+	graphQLErrors  int64 // 2xx responses with GraphQL errors
+	stalled        int64 // Requests blocked on flow control windows
+	stalledNanos   int64 // Time requests were blocked on flow control windows
+	backoffUntil   int64 // Unix nanos until which no new requests are sent
+	abandoned      int64 // Requests cancelled when the ramp-down ran out
+	inFlight       int64 // Requests sent and not done yet
+
+	shedLevel    int32 // Memory pressure, only 1 in 2^shedLevel log lines is kept
+	logCounter   int64 // Log lines produced while shedding
+	shedLogLines int64 // Log lines dropped by shedding
 }
 
 func NewH2Client(conf H2loadConf) *H2Client {
+	conf = conf.withMeshDefaults()
 	ctx, cancel := context.WithCancel(context.Background())
 	// Validate URL early
 	if _, err := urlpkg.Parse(conf.URL); err != nil {
@@ -49,13 +111,30 @@ func NewH2Client(conf H2loadConf) *H2Client {
 		ctx:         ctx,
 		cancel:      cancel,
 		logger:      nil,
-		logChan:     make(chan string, 10000),
+		logChan:     make(chan LogEntry, 10000),
 		loggingWg:   sync.WaitGroup{},
 		reqWg:       sync.WaitGroup{},
 		LogLineFunc: LogResultAsJSON,
+		IsSuccess:   DefaultIsSuccess,
 		stats:       RequestStats{},
+		hist:        newLatencyHistogram(),
 		statsChan:   make(chan LogEntry, 10000),
 		statsWg:     sync.WaitGroup{},
+
+		traceSampler: newTraceSampler(conf.TraceSamplesPerMinute),
+		profiler:     newLatencyProfiler(conf.LatencyProfileRate),
+		capturer:     newBodyCapturer(conf.Capture),
+		delayRand:    newLockedRand(conf.Seed, randDelays),
+	}
+	if conf.Revalidate {
+		h.validators = newValidatorCache()
+	}
+	if conf.ExpectContinue.Enabled {
+		h.continues = newContinueRecorder()
+	}
+	if conf.CORS.enabled() {
+		h.cors = newCORSRecorder()
+		h.corsRand = newLockedRand(conf.Seed, randCORS)
 	}
 
 	// Start the stats collector goroutine
@@ -70,32 +149,94 @@ func NewH2Client(conf H2loadConf) *H2Client {
 
 func (h *H2Client) statsCollector() {
 	for entry := range h.statsChan {
-		h.stats.TotalRequests++
-		if entry.Status >= 200 && entry.Status < 400 {
-			h.stats.SuccessRequests++
-		} else {
-			h.stats.FailedRequests++
+		success := h.IsSuccess(entry.Status, entry.Err)
+		h.statsMu.Lock()
+		h.stats.record(entry, success)
+		recordLatency(h.hist, entry.Latency)
+		h.statsMu.Unlock()
+		for _, observe := range h.getObservers() {
+			observe(entry, success)
 		}
+	}
+}
 
-		if h.stats.TotalRequests == 1 {
-			h.stats.MinLatency = entry.Latency
-			h.stats.MaxLatency = entry.Latency
-		} else {
-			if entry.Latency < h.stats.MinLatency {
-				h.stats.MinLatency = entry.Latency
-			}
-			if entry.Latency > h.stats.MaxLatency {
-				h.stats.MaxLatency = entry.Latency
-			}
-		}
-		h.stats.TotalLatency += entry.Latency
+// DefaultIsSuccess counts responses with a 2xx or 3xx status as successful
+func DefaultIsSuccess(status int, err error) bool {
+	return err == nil && status >= 200 && status < 400
+}
+
+// SetIsSuccess sets the predicate deciding which requests count as successful,
+// e.g. to accept an expected 404. err is non-nil when no response was received
+// or it failed the GraphQL or trailer checks of Conf.
+func (h *H2Client) SetIsSuccess(isSuccess func(status int, err error) bool) {
+	h.IsSuccess = isSuccess
+}
+
+// setShedLevel sets how much optional work is shed under memory pressure
+func (h *H2Client) setShedLevel(level int) {
+	atomic.StoreInt32(&h.shedLevel, int32(level))
+}
+
+// ResetStats zeroes the counters and latency histogram, e.g. after a warm-up,
+// so the stats that follow measure a fresh window. Safe to call mid-run.
+func (h *H2Client) ResetStats() {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+	h.stats = RequestStats{}
+	h.hist.Reset()
+	atomic.StoreInt64(&h.calmEvents, 0)
+	atomic.StoreInt64(&h.redirects, 0)
+	atomic.StoreInt64(&h.bytesOut, 0)
+	atomic.StoreInt64(&h.retries, 0)
+	atomic.StoreInt64(&h.retrySuccesses, 0)
+	atomic.StoreInt64(&h.trailerErrors, 0)
+	atomic.StoreInt64(&h.stalled, 0)
+	atomic.StoreInt64(&h.stalledNanos, 0)
+	atomic.StoreInt64(&h.graphQLErrors, 0)
+	atomic.StoreInt64(&h.shedLogLines, 0)
+	atomic.StoreInt64(&h.abandoned, 0)
+	h.traffic.reset()
+	h.compression.reset()
+	if h.validators != nil {
+		h.validators.reset()
+	}
+	if h.continues != nil {
+		h.continues.reset()
+	}
+	if h.cors != nil {
+		h.cors.reset()
+	}
+	if h.pool != nil {
+		h.pool.resetRecycled()
+		h.pool.resetConnStats()
+	}
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&h.statsStart, now)
+	if atomic.LoadInt64(&h.statsEnd) != 0 {
+		atomic.StoreInt64(&h.statsEnd, now)
 	}
 }
 
+// statsObserver receives every entry the stats collector records
+type statsObserver func(entry LogEntry, success bool)
+
+// addStatsObserver registers an observer called by the stats collector goroutine
+func (h *H2Client) addStatsObserver(observer statsObserver) {
+	h.observersMu.Lock()
+	defer h.observersMu.Unlock()
+	observers := append([]statsObserver{}, h.getObservers()...)
+	h.observers.Store(append(observers, observer))
+}
+
+func (h *H2Client) getObservers() []statsObserver {
+	observers, _ := h.observers.Load().([]statsObserver)
+	return observers
+}
+
 // logStats sends stats to the stats collector goroutine
-func (h *H2Client) logStats(status int, latency time.Duration) {
+func (h *H2Client) logStats(entry LogEntry) {
 	select {
-	case h.statsChan <- LogEntry{Status: status, Latency: latency, Timestamp: ""}:
+	case h.statsChan <- LogEntry{Status: entry.Status, Latency: entry.Latency, Route: entry.Route, Err: entry.Err, BytesOut: entry.BytesOut, Queue: entry.Queue, Spike: entry.Spike}:
 		// sent successfully
 	default:
 		// drop silently if the channel is full
@@ -107,6 +248,115 @@ func (h *H2Client) closeChannels() {
 	close(h.logChan)
 }
 
+// Pause suspends sending new requests and RPS token issuance. Connections,
+// in-flight requests and stats are left untouched.
+func (h *H2Client) Pause() {
+	h.gate.pause()
+	h.tuneMu.Lock()
+	defer h.tuneMu.Unlock()
+	if l := h.limiter.Load(); l != nil && l != h.sharedLimiter {
+		l.setPaused(true)
+	}
+}
+
+// Resume continues sending requests after Pause
+func (h *H2Client) Resume() {
+	h.tuneMu.Lock()
+	if l := h.limiter.Load(); l != nil && l != h.sharedLimiter {
+		l.setPaused(false)
+	}
+	h.tuneMu.Unlock()
+	h.gate.resume()
+}
+
+// IsPaused reports whether the client is paused
+func (h *H2Client) IsPaused() bool {
+	return h.gate.isPaused()
+}
+
+// SetRps changes the per-client RPS limit, taking effect immediately if
+// DoRequestsFactory is running. 0 removes the limit.
+func (h *H2Client) SetRps(rps int) error {
+	if rps < 0 {
+		return fmt.Errorf("rps must be greater than 0")
+	}
+	if h.sharedLimiter != nil {
+		return fmt.Errorf("rps is set by the total rps of the run")
+	}
+	h.tuneMu.Lock()
+	defer h.tuneMu.Unlock()
+	h.Conf.Rps = rps
+	if h.streams == nil {
+		// Not running, the new rate is used by the next DoRequestsFactory
+		return nil
+	}
+
+	l := h.limiter.Load()
+	switch {
+	case rps == 0 && l != nil:
+		h.limiter.Store(nil)
+		l.close()
+	case rps > 0 && l == nil:
+		l = h.newLimiter(rps, 0)
+		l.setPaused(h.gate.isPaused())
+		h.limiter.Store(l)
+	case rps > 0:
+		l.setRate(rps)
+	}
+	return nil
+}
+
+// SetConcurrentStreams changes the number of concurrent streams, taking
+// effect immediately if DoRequestsFactory is running. Lowering it lets the
+// streams in flight finish.
+func (h *H2Client) SetConcurrentStreams(streams int) error {
+	if streams < 1 {
+		return fmt.Errorf("concurrent streams must be greater than 0")
+	}
+	h.tuneMu.Lock()
+	defer h.tuneMu.Unlock()
+	h.Conf.ConcurrentStreams = streams
+	if h.streams != nil {
+		h.streams.setLimit(streams)
+	}
+	return nil
+}
+
+// ServerSettings returns the SETTINGS the server sent on the client's last
+// connection, nil before any connection
+func (h *H2Client) ServerSettings() ServerSettings {
+	if s := h.serverSettings.Load(); s != nil {
+		return *s
+	}
+	return nil
+}
+
+// serverSettingsReceived keeps the SETTINGS of a new connection and, with
+// Conf.RespectMaxStreams, caps the concurrent streams to the server's limit
+func (h *H2Client) serverSettingsReceived(s ServerSettings) {
+	h.serverSettings.Store(&s)
+	if !h.Conf.RespectMaxStreams {
+		return
+	}
+	// A limit of 0 blocks every stream, it's not one to run with
+	if limit, ok := s.MaxConcurrentStreams(); ok && limit > 0 {
+		h.capConcurrentStreams(int(min(limit, math.MaxInt32)))
+	}
+}
+
+// capConcurrentStreams lowers the concurrent streams to limit if above it
+func (h *H2Client) capConcurrentStreams(limit int) {
+	h.tuneMu.Lock()
+	defer h.tuneMu.Unlock()
+	if h.Conf.ConcurrentStreams <= limit {
+		return
+	}
+	h.Conf.ConcurrentStreams = limit
+	if h.streams != nil {
+		h.streams.setLimit(limit)
+	}
+}
+
 func (h *H2Client) Stop() {
 	h.cancel()
 	h.Wait()
@@ -120,51 +370,117 @@ func (h *H2Client) Wait() {
 
 // Connect sets up the HTTP/2 client
 func (h *H2Client) Connect() error {
-	dialAddr := h.Conf.ServerAddress
-	if dialAddr == "" {
-		parsed, err := urlpkg.Parse(h.Conf.URL)
-		if err != nil {
-			return fmt.Errorf("invalid URL: %w", err)
+	dial, tlsConfig, err := h.dialer()
+	if err != nil {
+		return err
+	}
+
+	// A single connection per client, requests queue on it once the
+	// server's MAX_CONCURRENT_STREAMS is reached
+	// Compression is only requested with Conf.Compression, so bodies are
+	// measured as sent unless asked otherwise
+	transport := &http2.Transport{}
+	if h.Conf.ExpectContinue.Enabled {
+		// The HTTP/2 transport only waits for 100 Continue with the timeout
+		// of an HTTP/1 transport it is configured from
+		if transport, err = http2.ConfigureTransports(&http.Transport{ExpectContinueTimeout: h.Conf.ExpectContinue.Timeout}); err != nil {
+			return err
 		}
-		dialAddr = parsed.Host
 	}
+	transport.StrictMaxConcurrentStreams = true
+	transport.DisableCompression = true
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	} else {
+		transport.AllowHTTP = true
+	}
+	h.pool = newConnPool(transport, dial, connTracking{
+		traffic:    &h.traffic,
+		trackFlow:  h.Conf.FlowStalls,
+		onSettings: h.serverSettingsReceived,
+		frames:     h.frameLog,
+	})
+	transport.ConnPool = h.pool
+	h.client = &http.Client{Transport: transport, CheckRedirect: checkRedirect(h.Conf.FollowRedirects)}
+	return nil
+}
 
-	parsed, err := urlpkg.Parse(h.Conf.URL)
+// dialer returns the func dialing the client's connections to the server,
+// honoring ServerAddress, ConnectTo, Resolve, DNSServer, IPVersion, Socket
+// and Throttle, and the TLS config it negotiates h2 with, nil for h2c
+func (h *H2Client) dialer() (func(ctx context.Context) (net.Conn, error), *tls.Config, error) {
+	dialAddr, err := dialAddress(h.Conf.URL, h.Conf.ServerAddress, h.Conf.ConnectTo)
 	if err != nil {
-		return fmt.Errorf("invalid URL: %w", err)
+		return nil, nil, err
 	}
-	useTLS := parsed.Scheme == "https"
-
-	if useTLS {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: true,
-			ServerName:         getHostname(h.Conf.URL),
-			NextProtos:         []string{"h2"},
+	dialAddrs := resolveAddrs(dialAddr, h.Conf.Resolve)
+	resolver := newResolver(h.Conf.DNSServer)
+	dialTCP := func(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+		addrs, err := lookupAddrs(ctx, resolver, dialAddrs, &h.traffic)
+		if err != nil {
+			return nil, err
 		}
-		transport := &http2.Transport{
-			TLSClientConfig: tlsConfig,
-			DialTLS: func(network, _ string, cfg *tls.Config) (net.Conn, error) {
-				return tls.Dial(network, dialAddr, cfg)
-			},
+		if addrs = familyAddrs(addrs, h.Conf.IPVersion); len(addrs) == 0 {
+			return nil, fmt.Errorf("%s has no IPv%d address", dialAddr, h.Conf.IPVersion)
 		}
-		h.client = &http.Client{Transport: transport}
-	} else {
-		transport := &http2.Transport{
-			AllowHTTP: true,
-			DialTLS: func(network, _ string, _ *tls.Config) (net.Conn, error) {
-				return net.Dial(network, dialAddr)
-			},
+		if h.Conf.IPVersion != 0 {
+			return dialFirst(ctx, addrs, dialNetwork(h.Conf.IPVersion), dial)
 		}
-		h.client = &http.Client{Transport: transport}
+		return dialDualStack(ctx, addrs, dial)
 	}
-	return nil
+
+	parsed, err := urlpkg.Parse(h.Conf.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	dialNet := h.Conf.Socket.dialer()
+	if h.Conf.Throttle.enabled() {
+		dialNet = throttleDialer(h.Conf.Throttle, dialNet)
+	}
+	if parsed.Scheme != "https" {
+		return func(ctx context.Context) (net.Conn, error) {
+			return dialTCP(ctx, dialNet)
+		}, nil, nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         getHostname(h.Conf.URL),
+		NextProtos:         []string{"h2"},
+		KeyLogWriter:       h.Conf.KeyLog,
+	}
+	// TLS over the dialed connection, so it has the socket options and its
+	// records are throttled as sent
+	return func(ctx context.Context) (net.Conn, error) {
+		return dialTCP(ctx, func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialNet(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		})
+	}, tlsConfig, nil
 }
 
-// SetLogger sets the logger to be used and starts the logger goroutine
+// SetLogger sets the logger to be used and starts the logger goroutine.
+// Lines are formatted with LogLineFunc.
 func (h *H2Client) SetLogger(logger *log.Logger) error {
 	if logger == nil {
 		return nil
 	}
+	return h.SetRequestLogger(&lineLogger{client: h, out: logger})
+}
+
+// SetRequestLogger sets a Logger receiving each request as a LogEntry, e.g. a
+// SlogLogger, and starts the logger goroutine
+func (h *H2Client) SetRequestLogger(logger Logger) error {
+	if logger == nil {
+		return nil
+	}
 
 	// If we already have a logger, unset it first
 	if h.logChan != nil {
@@ -172,34 +488,60 @@ func (h *H2Client) SetLogger(logger *log.Logger) error {
 	}
 
 	h.logger = logger
-	h.logChan = make(chan string, 10000)
+	h.logChan = make(chan LogEntry, 10000)
 
 	// Start the new logger goroutine
 	h.loggingWg.Add(1)
 	go func() {
 		defer h.loggingWg.Done()
-		for line := range h.logChan {
-			if h.logger != nil {
-				h.logger.Print(line)
-			}
+		for entry := range h.logChan {
+			logger.LogRequest(entry)
 		}
 	}()
 	return nil
 }
 
-func (h *H2Client) SetLogLineFunc(logLineFunc func(start time.Time, status int, latency time.Duration) string) {
+// SetLogFilter sets which requests are written to the log, e.g. only slow
+// ones. Stats still include every request.
+func (h *H2Client) SetLogFilter(logFilter func(status int, latency time.Duration) bool) {
+	h.LogFilter = logFilter
+}
+
+func (h *H2Client) SetLogLineFunc(logLineFunc func(entry LogEntry) string) {
 	h.LogLineFunc = logLineFunc
 }
 
-func (h *H2Client) logResult(start time.Time, status int, latency time.Duration) {
-	h.logStats(status, latency)
+// SetTraceFunc sets a func that receives full timing traces for a sampled
+// subset of requests, bounded by Conf.TraceSamplesPerMinute.
+// It is called from the request goroutine and should not block.
+func (h *H2Client) SetTraceFunc(traceFunc func(RequestTrace)) {
+	h.traceFunc = traceFunc
+}
+
+// logResult records entry in the stats and sends it to the logger goroutine,
+// adding the metadata collected by trace
+func (h *H2Client) logResult(entry LogEntry, trace *entryTracer) {
+	h.logStats(entry)
 	if h.logChan == nil || h.logger == nil {
 		return // No logger channel is set up
 	}
-	logLine := h.LogLineFunc(start, status, latency)
-	// Send the formatted line to the channel
+	if h.LogFilter != nil && !h.LogFilter(entry.Status, entry.Latency) {
+		return
+	}
+	if level := atomic.LoadInt32(&h.shedLevel); level > 0 &&
+		atomic.AddInt64(&h.logCounter, 1)&(1<<level-1) != 0 {
+		atomic.AddInt64(&h.shedLogLines, 1)
+		return
+	}
+	if trace != nil {
+		trace.fill(&entry)
+	}
+	if entry.Err != nil {
+		entry.Error = entry.Err.Error()
+	}
+	// Lines are formatted by the logger goroutine, off the request path
 	select {
-	case h.logChan <- logLine:
+	case h.logChan <- entry:
 		// sent successfully
 	default:
 		// drop silently if the channel is full
@@ -207,22 +549,240 @@ func (h *H2Client) logResult(start time.Time, status int, latency time.Duration)
 }
 
 func (h *H2Client) DoRequest(req *http.Request) (*http.Response, error) {
+	return h.doRequest(req, time.Time{})
+}
+
+// doRequest sends req; eligible is when the request got its RPS token and
+// stream slot, or zero when unknown
+func (h *H2Client) doRequest(req *http.Request, eligible time.Time) (*http.Response, error) {
+	atomic.AddInt64(&h.inFlight, 1)
+	defer atomic.AddInt64(&h.inFlight, -1)
+	var tracer *requestTracer
+	shedding := atomic.LoadInt32(&h.shedLevel) > 0
+	traced := h.traceFunc != nil && !shedding && h.traceSampler.allow()
+	profiled := !shedding && h.profiler.sample()
+	if h.validators != nil {
+		req = h.validators.setValidators(req)
+	}
+	req = h.Conf.Compression.setAcceptEncoding(req)
+	req = h.Conf.Trailers.setTrailers(req)
+	req, requestID, traceID := h.Conf.RequestID.set(req)
 	start := time.Now()
-	resp, err := h.client.Do(req)
+	if traced || profiled {
+		tracer = &requestTracer{}
+		req = tracer.attach(req, start)
+	}
+	var continues *continueTracer
+	if h.continues != nil && hasBody(req) {
+		continues = &continueTracer{}
+		req = continues.attach(req)
+	}
+	// Always traced, for the queue delay
+	entryTrace := &entryTracer{}
+	req = entryTrace.attach(req)
+	resp, retries, err := h.doWithRetries(req)
 	latency := time.Since(start)
+	if eligible.IsZero() {
+		eligible = start
+	}
+	entry := LogEntry{
+		Latency:   latency,
+		Start:     start,
+		Route:     requestRoute(req),
+		ClientID:  h.ID,
+		BytesOut:  max(req.ContentLength, 0),
+		Retries:   retries,
+		RequestID: requestID,
+		TraceID:   traceID,
+		Queue:     entryTrace.queue(eligible),
+		Spike:     inSpike(req),
+	}
+	if continues != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		if h.continues.record(continues, h.Conf.ExpectContinue.Timeout, status, err) {
+			entry.BytesOut = 0
+		}
+	}
+	atomic.AddInt64(&h.retries, int64(retries))
 
+	if err != nil && abandoned(req) {
+		atomic.AddInt64(&h.abandoned, 1)
+		return nil, fmt.Errorf("request abandoned: %w", errRampDownExpired)
+	}
 	if err != nil {
-		h.logResult(start, 0, latency)
+		h.recordStall(&entry, entryTrace)
+		if h.capturer != nil && !h.IsSuccess(0, err) {
+			if n, ok := h.capturer.claim(shedding); ok {
+				h.capturer.captureError(n, req, err)
+			}
+		}
+		entry.Err = err
+		h.logResult(entry, entryTrace)
+		if isEnhanceYourCalm(err) {
+			h.enhanceYourCalm()
+		}
+		h.handleStreamError(err)
+		if tracer != nil {
+			rt := tracer.finish(0, err, latency)
+			if traced {
+				h.traceFunc(rt)
+			}
+			if profiled {
+				h.profiler.record(eligible, rt, time.Now())
+			}
+		}
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 
-	io.Copy(io.Discard, resp.Body)
+	if h.capturer != nil && !h.IsSuccess(resp.StatusCode, nil) {
+		if n, ok := h.capturer.claim(shedding); ok {
+			h.capturer.captureResponse(n, req, resp)
+		}
+	}
+	h.traffic.response(resp)
+	body := &countingBody{ReadCloser: resp.Body}
+	resp.Body = body
+	// Body-less responses may still carry the Content-Encoding of the
+	// body they stand for
+	var encoding string
+	if hasResponseBody(req, resp) {
+		encoding = contentEncoding(resp)
+	}
+	var decoded *decodedBody
+	if encoding != "" && h.Conf.Compression.Decompress {
+		decoded = decompressBody(resp, encoding)
+	}
+	if h.responseFunc != nil {
+		h.responseFunc(req, resp)
+	}
+	if h.users != nil {
+		h.users.observe(req, resp)
+	}
+	if h.Conf.GraphQL.enabled() && resp.StatusCode/100 == 2 && hasResponseBody(req, resp) {
+		if entry.Err = graphQLError(resp.Body); entry.Err != nil {
+			atomic.AddInt64(&h.graphQLErrors, 1)
+		}
+	}
+	_, copyErr := io.Copy(io.Discard, resp.Body)
 	resp.Body.Close()
+	if copyErr != nil && abandoned(req) {
+		atomic.AddInt64(&h.abandoned, 1)
+		return nil, fmt.Errorf("request abandoned: %w", errRampDownExpired)
+	}
+	if encoding != "" {
+		h.compression.record(body.n, decoded)
+	}
 
-	h.logResult(start, resp.StatusCode, latency)
+	entry.Status = resp.StatusCode
+	entry.BytesIn = body.n
+	if h.validators != nil {
+		h.validators.record(req, resp.StatusCode, resp.Header, body.n)
+	}
+	entry.Trailers = responseTrailers(resp)
+	h.recordStall(&entry, entryTrace)
+	if len(h.Conf.Trailers.Expect) > 0 {
+		if err := h.Conf.Trailers.check(entry.Trailers); err != nil {
+			atomic.AddInt64(&h.trailerErrors, 1)
+			if entry.Err == nil {
+				entry.Err = err
+			}
+		}
+	}
+	if retries > 0 && h.IsSuccess(resp.StatusCode, entry.Err) {
+		atomic.AddInt64(&h.retrySuccesses, 1)
+	}
+	if entry.Redirects = redirectChain(resp); len(entry.Redirects) > 0 {
+		atomic.AddInt64(&h.redirects, int64(len(entry.Redirects)))
+	}
+	h.logResult(entry, entryTrace)
+	if tracer != nil {
+		done := time.Now()
+		rt := tracer.finish(resp.StatusCode, nil, done.Sub(start))
+		if traced {
+			h.traceFunc(rt)
+		}
+		if profiled {
+			h.profiler.record(eligible, rt, done)
+		}
+	}
 	return resp, nil
 }
 
+// recordStall sets the time entry's request was blocked on flow control,
+// with FlowStalls set
+func (h *H2Client) recordStall(entry *LogEntry, trace *entryTracer) {
+	if !h.Conf.FlowStalls || trace == nil {
+		return
+	}
+	if entry.Stalled = trace.stalled(); entry.Stalled > 0 {
+		atomic.AddInt64(&h.stalled, 1)
+		atomic.AddInt64(&h.stalledNanos, int64(entry.Stalled))
+	}
+}
+
+// isEnhanceYourCalm reports whether err is a GOAWAY or RST_STREAM with
+// ENHANCE_YOUR_CALM, which servers send when rate limiting or under
+// rapid-reset protection
+func isEnhanceYourCalm(err error) bool {
+	var goAway http2.GoAwayError
+	if errors.As(err, &goAway) {
+		return goAway.ErrCode == http2.ErrCodeEnhanceYourCalm
+	}
+	var streamErr http2.StreamError
+	if errors.As(err, &streamErr) {
+		return streamErr.Code == http2.ErrCodeEnhanceYourCalm
+	}
+	return false
+}
+
+// enhanceYourCalm counts an ENHANCE_YOUR_CALM and backs off the whole
+// connection for Conf.CalmBackoff
+func (h *H2Client) enhanceYourCalm() {
+	atomic.AddInt64(&h.calmEvents, 1)
+	if h.Conf.CalmBackoff > 0 {
+		until := time.Now().Add(h.Conf.CalmBackoff).UnixNano()
+		if until > atomic.LoadInt64(&h.backoffUntil) {
+			atomic.StoreInt64(&h.backoffUntil, until)
+		}
+	}
+}
+
+// waitBackoff blocks while the client is backing off, returning false if
+// the client is stopped first
+func (h *H2Client) waitBackoff() bool {
+	wait := time.Until(time.Unix(0, atomic.LoadInt64(&h.backoffUntil)))
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-h.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// handleStreamError applies the connection error budget: the connection is
+// recycled after Conf.MaxStreamErrors stream errors or a GOAWAY with an error
+func (h *H2Client) handleStreamError(err error) {
+	if h.Conf.MaxStreamErrors <= 0 || h.pool == nil || h.ctx.Err() != nil {
+		return
+	}
+	var goAway http2.GoAwayError
+	if errors.As(err, &goAway) && goAway.ErrCode != http2.ErrCodeNo {
+		h.pool.recycle()
+		return
+	}
+	if h.pool.streamError(h.Conf.MaxStreamErrors) {
+		h.pool.recycle()
+	}
+}
+
 // DoRequests sends as many requests as possible, never exceeding maxStreams in flight
 func (h *H2Client) DoRequests(req *http.Request) {
 	//req.Host = getHostname(h.Conf.URL) // override Host header
@@ -252,62 +812,34 @@ func (h *H2Client) DoRequestsFactoryAsync(factory func() *http.Request) error {
 }
 func (h *H2Client) DoRequestsFactory(factory func() *http.Request) error {
 	defer h.closeChannels()
-	streams := make(chan struct{}, h.Conf.ConcurrentStreams)
-	defer close(streams)
 	var streamsWg sync.WaitGroup
 	var firstErr atomic.Value
+	// Cancels the requests in flight when the ramp-down runs out
+	drain, abandon := context.WithCancelCause(context.Background())
+	defer abandon(nil)
 
-	// RPS limiter setup
-	var rpsTokens chan struct{}
-	var rpsResetTicker *time.Ticker
-
-	if h.Conf.Rps > 0 {
-		rpsTokens = make(chan struct{}, h.Conf.Rps)
-		defer close(rpsTokens)
-		rpsResetTicker = time.NewTicker(time.Second)
-		defer rpsResetTicker.Stop()
-
-		// For even mode, we'll need a separate ticker
-		var evenTicker *time.Ticker
-		if h.Conf.RpsMode == RpsModeEven {
-			interval := time.Second / time.Duration(h.Conf.Rps)
-			evenTicker = time.NewTicker(interval)
-			defer evenTicker.Stop()
-
-			// Start a goroutine to continuously fill tokens at even intervals
-			go func() {
-				for range evenTicker.C {
-					select {
-					case <-h.ctx.Done():
-						return
-					case rpsTokens <- struct{}{}:
-					default:
-						// If channel is full, skip this token
-					}
-				}
-			}()
-		}
-
-		// For burst mode or to reset even mode's counter
-		go func() {
-			for range rpsResetTicker.C {
-				if h.Conf.RpsMode == RpsModeBurst {
-					// Fill the channel all at once for burst mode
-					for i := 0; i < h.Conf.Rps; i++ {
-						select {
-						case <-h.ctx.Done():
-							return
-						case rpsTokens <- struct{}{}:
-						default:
-							// If channel is full, skip this token
-						}
-					}
-				}
-			}
-		}()
+	// RPS limiter and stream slots setup, a limiter shared by the whole run
+	// takes precedence. Both can be retuned with SetRps and SetConcurrentStreams.
+	h.tuneMu.Lock()
+	streams := newStreamSemaphore(h.Conf.ConcurrentStreams)
+	h.streams = streams
+	limiter := h.sharedLimiter
+	if limiter == nil && h.Conf.Rps > 0 {
+		limiter = h.newLimiter(h.Conf.Rps, h.rpsPhase)
+		limiter.setPaused(h.gate.isPaused())
 	}
+	h.limiter.Store(limiter)
+	h.tuneMu.Unlock()
+	defer func() {
+		h.tuneMu.Lock()
+		defer h.tuneMu.Unlock()
+		h.streams = nil
+		if l := h.limiter.Swap(nil); l != nil && l != h.sharedLimiter {
+			l.close()
+		}
+	}()
 
-	startTime := time.Now()
+	atomic.StoreInt64(&h.statsStart, time.Now().UnixNano())
 loop:
 	for {
 		select {
@@ -320,40 +852,92 @@ loop:
 				break loop
 			}
 
+			// Hold off while paused or while the server asked us to calm down
+			if !h.gate.wait(h.ctx) || !h.waitBackoff() {
+				break loop
+			}
+
 			// Wait for RPS token if rate limiting is enabled
-			if h.Conf.Rps > 0 {
-				select {
-				case <-h.ctx.Done():
+			limiter := h.limiter.Load()
+			var scheduled time.Time
+			if limiter != nil {
+				var ok bool
+				if scheduled, ok = limiter.waitScheduled(h.ctx); !ok {
 					break loop
-				case <-rpsTokens:
-					// Got RPS token, continue
 				}
 			}
 
-			select {
-			case <-h.ctx.Done():
+			// Block for a free stream so an acquired token is never discarded
+			if !streams.acquire(h.ctx) {
 				break loop
-			case streams <- struct{}{}:
-				atomic.AddInt64(&h.sentRequests, 1)
-				streamsWg.Add(1)
-				go func() {
-					defer func() {
-						<-streams
-						streamsWg.Done()
-					}()
-					req := factory()
-					_, err := h.DoRequest(req)
-					if err != nil && firstErr.Load() == nil {
-						firstErr.Store(err)
+			}
+			// A user sends one request at a time, wait for a free one
+			var user *VirtualUser
+			if h.users != nil {
+				if user = h.users.acquire(h.ctx); user == nil {
+					streams.release()
+					break loop
+				}
+			}
+			eligible := time.Now()
+			if limiter != nil {
+				limiter.sent(scheduled, eligible)
+			}
+			spike := h.spiking != nil && h.spiking.Load()
+			atomic.AddInt64(&h.sentRequests, 1)
+			streamsWg.Add(1)
+			go func() {
+				defer func() {
+					if user != nil {
+						h.users.release(user)
 					}
+					streams.release()
+					streamsWg.Done()
 				}()
-			default:
-				time.Sleep(time.Microsecond)
-			}
+				if !h.delaySend() {
+					// Stopped while held back, the request is never sent
+					atomic.AddInt64(&h.sentRequests, -1)
+					return
+				}
+				if h.Conf.SendDelay != nil {
+					// The queue delay starts once the request is let go
+					eligible = time.Now()
+				}
+				var req *http.Request
+				if user != nil {
+					req = h.users.request(user, factory)
+				} else {
+					req = factory()
+				}
+				if spike {
+					req = withSpike(req)
+				}
+				// Before the preflight, which an expired ramp-down abandons too
+				if h.Conf.RampDown > 0 {
+					var release func()
+					req, release = abandonable(req, drain)
+					defer release()
+				}
+				if h.cors != nil {
+					var preflighted bool
+					if req, preflighted = h.corsPreflight(req); preflighted {
+						// The request waited on its preflight, not the generator
+						eligible = time.Now()
+					}
+				}
+				_, err := h.doRequest(req, eligible)
+				if err != nil && !errors.Is(err, errRampDownExpired) && firstErr.Load() == nil {
+					firstErr.Store(err)
+				}
+				h.think()
+			}()
 		}
 	}
+	if h.Conf.RampDown > 0 && !waitTimeout(streamsWg.Wait, h.Conf.RampDown) {
+		abandon(errRampDownExpired)
+	}
 	streamsWg.Wait()
-	h.stats.Duration = time.Since(startTime)
+	atomic.StoreInt64(&h.statsEnd, time.Now().UnixNano())
 	if errVal := firstErr.Load(); errVal != nil {
 		return errVal.(error)
 	}
@@ -363,16 +947,102 @@ loop:
 // Close stops the client and signals the shared logger goroutine to finish.
 func (h *H2Client) Close() {
 	h.Stop()
-	h.client.CloseIdleConnections()
+	if h.pool != nil {
+		h.pool.close()
+	}
 }
 
 func (h *H2Client) GetSentRequests() int64 {
 	return atomic.LoadInt64(&h.sentRequests)
 }
 
+// StatsSnapshot is a consistent point-in-time copy of collected stats
+type StatsSnapshot struct {
+	Time      time.Time
+	Stats     RequestStats
+	Histogram *hdrhistogram.Histogram // Latencies in microseconds, owned by the caller
+}
+
+// Snapshot returns a consistent copy of the stats and latency histogram. It
+// is safe to call from any goroutine while requests are running.
+func (h *H2Client) Snapshot() StatsSnapshot {
+	hist := newLatencyHistogram()
+	h.statsMu.Lock()
+	stats := h.stats
+	hist.Merge(h.hist)
+	h.statsMu.Unlock()
+
+	now := time.Now()
+	stats.setPercentiles(hist)
+	if start := atomic.LoadInt64(&h.statsStart); start != 0 {
+		end := atomic.LoadInt64(&h.statsEnd)
+		if end == 0 {
+			end = now.UnixNano()
+		}
+		stats.Duration = time.Duration(end - start)
+	}
+	if h.pool != nil {
+		stats.RecycledConnections = h.pool.recycledCount()
+	}
+	stats.EnhanceYourCalm = atomic.LoadInt64(&h.calmEvents)
+	stats.RedirectsFollowed = atomic.LoadInt64(&h.redirects)
+	stats.BytesOut = atomic.LoadInt64(&h.bytesOut)
+	stats.Retries = atomic.LoadInt64(&h.retries)
+	stats.SucceededAfterRetry = atomic.LoadInt64(&h.retrySuccesses)
+	stats.TrailerMismatches = atomic.LoadInt64(&h.trailerErrors)
+	stats.GraphQLErrors = atomic.LoadInt64(&h.graphQLErrors)
+	stats.StalledRequests = atomic.LoadInt64(&h.stalled)
+	stats.StalledTime = time.Duration(atomic.LoadInt64(&h.stalledNanos))
+	stats.Compression = h.compression.stats()
+	stats.ShedLogLines = atomic.LoadInt64(&h.shedLogLines)
+	stats.Abandoned = atomic.LoadInt64(&h.abandoned)
+	return StatsSnapshot{Time: now, Stats: stats, Histogram: hist}
+}
+
 // GetStats returns a copy of the current statistics
 func (h *H2Client) GetStats() RequestStats {
-	return h.stats
+	return h.Snapshot().Stats
+}
+
+// GetTrafficStats returns the bytes received and the connection times of
+// this client
+func (h *H2Client) GetTrafficStats() TrafficStats {
+	return h.traffic.stats()
+}
+
+// newLimiter returns a per-client limiter at rps, recording its pacing and
+// slips. It must be called with tuneMu held.
+func (h *H2Client) newLimiter(rps int, phase time.Duration) *rpsLimiter {
+	if !h.Conf.StrictSchedule {
+		l := newJitteredRpsLimiter(rps, h.Conf.RpsMode, phase, h.Conf.IntervalJitter, h.intervalRand)
+		h.pacing = append(h.pacing, l.pacing)
+		return l
+	}
+	l := newScheduledLimiter(rps, h.Conf.RpsMode, phase, h.Conf.IntervalJitter, h.intervalRand)
+	h.pacing = append(h.pacing, l.pacing)
+	h.schedules = append(h.schedules, l.schedule.recorder)
+	return l
+}
+
+// GetScheduleStats returns the slips of this client's strict schedule. It is
+// empty unless the client ran with Rps and StrictSchedule.
+func (h *H2Client) GetScheduleStats() ScheduleStats {
+	h.tuneMu.Lock()
+	defer h.tuneMu.Unlock()
+	return mergeSchedules(h.schedules...)
+}
+
+// GetPacingReport returns the even-mode pacing accuracy of this client's RPS
+// limiter. It is empty unless the client ran with Rps in RpsModeEven.
+func (h *H2Client) GetPacingReport() PacingReport {
+	h.tuneMu.Lock()
+	defer h.tuneMu.Unlock()
+	return mergePacing(h.pacing...)
+}
+
+// GetLatencyProfile returns the latency attribution of the sampled requests
+func (h *H2Client) GetLatencyProfile() LatencyProfile {
+	return h.profiler.profile()
 }
 
 // GetStatsSummary returns a formatted string with statistics
@@ -0,0 +1,505 @@
+package h2load
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	urlpkg "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// h2RequestTimeout bounds the wait for a response and the pushes promised
+// with it
+const h2RequestTimeout = 30 * time.Second
+
+// h2Window is the flow control window granted to the connection and every
+// stream, replenished as DATA is read
+const h2Window = 1 << 24
+
+// h2MaxFrame is the largest DATA payload sent, the smallest frame size a
+// server may allow
+const h2MaxFrame = 16384
+
+var (
+	errH2ConnClosed   = errors.New("connection closed")
+	errH2StreamClosed = errors.New("stream closed")
+)
+
+// h2Conn is a client connection speaking HTTP/2 frames directly, for what
+// the x/net transport doesn't do: receiving server push, sending stream
+// priorities, holding idle connections, resetting streams as they open and
+// sending bodies at a set pace
+type h2Conn struct {
+	conn   net.Conn
+	fr     *http2.Framer
+	push   *pushCollector // Push stats, push is disabled when nil
+	accept bool           // Read pushed streams, or reset them when promised
+
+	wmu    sync.Mutex // Guards writes, enc and nextID
+	enc    *hpack.Encoder
+	encBuf bytes.Buffer
+	nextID uint32
+
+	mu       sync.Mutex
+	streams  map[uint32]*h2Stream
+	pings    map[uint64]chan struct{} // PINGs sent, by their data, until acked
+	nextPing uint64
+	resets   map[http2.ErrCode]int64 // RST_STREAMs received, by error code
+	sendConn int64                   // Connection window for DATA sent
+	sendInit int64                   // Initial stream window for DATA sent, from the server's SETTINGS
+	err      error                   // Set once the connection is closed
+	closed   chan struct{}           // Closed with the connection
+}
+
+// h2Stream is an open stream, of a request or pushed with one
+type h2Stream struct {
+	req      *h2Request
+	pushed   bool
+	promised time.Time
+	status   int
+	send     int64 // Window for DATA sent
+}
+
+// h2Request tracks a request until its response and every push it was
+// promised with are done
+type h2Request struct {
+	open   int // Streams still open: the request's and its accepted pushes
+	status int
+	bytes  int64 // DATA bytes of the response
+	err    error
+	done   chan struct{}
+}
+
+// dialH2Conn opens a connection to the client's URL, with push enabled if
+// push is set
+func (h *H2Client) dialH2Conn(ctx context.Context, push *pushCollector, accept bool) (*h2Conn, error) {
+	dial, _, err := h.dialer()
+	if err != nil {
+		return nil, err
+	}
+	nc, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := newH2Conn(nc, push, accept)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+func newH2Conn(conn net.Conn, push *pushCollector, accept bool) (*h2Conn, error) {
+	pc := &h2Conn{
+		conn:     conn,
+		fr:       http2.NewFramer(conn, conn),
+		push:     push,
+		accept:   accept,
+		nextID:   1,
+		streams:  make(map[uint32]*h2Stream),
+		pings:    make(map[uint64]chan struct{}),
+		resets:   make(map[http2.ErrCode]int64),
+		sendConn: initialWindow,
+		sendInit: initialWindow,
+		closed:   make(chan struct{}),
+	}
+	pc.fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	pc.enc = hpack.NewEncoder(&pc.encBuf)
+	if _, err := conn.Write([]byte(http2.ClientPreface)); err != nil {
+		return nil, err
+	}
+	var enablePush uint32
+	if push != nil {
+		enablePush = 1
+	}
+	err := pc.fr.WriteSettings(
+		http2.Setting{ID: http2.SettingEnablePush, Val: enablePush},
+		http2.Setting{ID: http2.SettingInitialWindowSize, Val: h2Window},
+	)
+	if err == nil {
+		err = pc.fr.WriteWindowUpdate(0, h2Window)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go pc.readLoop()
+	return pc, nil
+}
+
+func (pc *h2Conn) close() {
+	pc.conn.Close()
+	<-pc.closed
+}
+
+// closeErr returns why the connection closed, once closed
+func (pc *h2Conn) closeErr() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.err
+}
+
+// ping sends a PING and waits for its ack, returning the round trip
+func (pc *h2Conn) ping(ctx context.Context) (time.Duration, error) {
+	pc.mu.Lock()
+	if pc.err != nil {
+		pc.mu.Unlock()
+		return 0, pc.err
+	}
+	pc.nextPing++
+	id := pc.nextPing
+	acked := make(chan struct{})
+	pc.pings[id] = acked
+	pc.mu.Unlock()
+	defer func() {
+		pc.mu.Lock()
+		delete(pc.pings, id)
+		pc.mu.Unlock()
+	}()
+
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], id)
+	start := time.Now()
+	pc.write(func() error { return pc.fr.WritePing(false, data) })
+	select {
+	case <-acked:
+		return time.Since(start), nil
+	case <-pc.closed:
+		return 0, pc.closeErr()
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// get sends a GET for u and waits for its response and pushes, returning
+// the size of the response body. priority, if set, is called with the
+// stream's ID to get the priority sent with its HEADERS.
+func (pc *h2Conn) get(u *urlpkg.URL, header http.Header, priority func(id uint32) http2.PriorityParam) (int64, error) {
+	_, req, err := pc.start(http.MethodGet, u, header, false, priority)
+	if err != nil {
+		return 0, err
+	}
+	timer := time.NewTimer(h2RequestTimeout)
+	defer timer.Stop()
+	select {
+	case <-req.done:
+	case <-timer.C:
+		return 0, fmt.Errorf("no response within %v", h2RequestTimeout)
+	}
+	if req.err != nil {
+		return req.bytes, req.err
+	}
+	if req.status/100 != 2 {
+		return req.bytes, fmt.Errorf("HTTP %d", req.status)
+	}
+	return req.bytes, nil
+}
+
+// start sends the HEADERS of a request for u, ending its stream unless
+// body is set, and returns the stream's ID and the request to wait for
+func (pc *h2Conn) start(method string, u *urlpkg.URL, header http.Header, body bool, priority func(id uint32) http2.PriorityParam) (uint32, *h2Request, error) {
+	req := &h2Request{open: 1, done: make(chan struct{})}
+
+	pc.wmu.Lock()
+	id := pc.nextID
+	pc.nextID += 2
+	pc.mu.Lock()
+	if pc.err != nil {
+		pc.mu.Unlock()
+		pc.wmu.Unlock()
+		return 0, nil, pc.err
+	}
+	pc.streams[id] = &h2Stream{req: req, send: pc.sendInit}
+	pc.mu.Unlock()
+	param := http2.HeadersFrameParam{
+		StreamID:      id,
+		BlockFragment: pc.encodeRequest(method, u, header),
+		EndStream:     !body,
+		EndHeaders:    true,
+	}
+	if priority != nil {
+		param.Priority = priority(id)
+	}
+	err := pc.fr.WriteHeaders(param)
+	pc.wmu.Unlock()
+	if err != nil {
+		pc.shutdown(err)
+		return 0, nil, errH2ConnClosed
+	}
+	return id, req, nil
+}
+
+// sendData sends as much of p on stream id as the flow control windows
+// allow, ending the stream with it if end is set and all of p is sent. It
+// returns the bytes sent, and errH2StreamClosed once the server ended the
+// stream.
+func (pc *h2Conn) sendData(id uint32, p []byte, end bool) (int, error) {
+	pc.wmu.Lock()
+	defer pc.wmu.Unlock()
+	pc.mu.Lock()
+	if pc.err != nil {
+		pc.mu.Unlock()
+		return 0, pc.err
+	}
+	s := pc.streams[id]
+	if s == nil {
+		pc.mu.Unlock()
+		return 0, errH2StreamClosed
+	}
+	n := max(min(int64(len(p)), h2MaxFrame, s.send, pc.sendConn), 0)
+	s.send -= n
+	pc.sendConn -= n
+	pc.mu.Unlock()
+	if n == 0 && len(p) > 0 {
+		return 0, nil
+	}
+	if err := pc.fr.WriteData(id, end && int(n) == len(p), p[:n]); err != nil {
+		pc.shutdown(err)
+		return 0, errH2ConnClosed
+	}
+	return int(n), nil
+}
+
+// openAndReset sends a GET for u and resets its stream with CANCEL right
+// after, without waiting for the server
+func (pc *h2Conn) openAndReset(u *urlpkg.URL, header http.Header) error {
+	pc.wmu.Lock()
+	if err := pc.closeErr(); err != nil {
+		pc.wmu.Unlock()
+		return err
+	}
+	id := pc.nextID
+	pc.nextID += 2
+	err := pc.fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      id,
+		BlockFragment: pc.encodeRequest(http.MethodGet, u, header),
+		EndStream:     true,
+		EndHeaders:    true,
+	})
+	if err == nil {
+		err = pc.fr.WriteRSTStream(id, http2.ErrCodeCancel)
+	}
+	pc.wmu.Unlock()
+	if err != nil {
+		pc.shutdown(err)
+		return errH2ConnClosed
+	}
+	return nil
+}
+
+// resetsReceived returns the RST_STREAMs received so far, by error code
+func (pc *h2Conn) resetsReceived() map[http2.ErrCode]int64 {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return maps.Clone(pc.resets)
+}
+
+// encodeRequest encodes the header block of a request for u, with wmu held
+func (pc *h2Conn) encodeRequest(method string, u *urlpkg.URL, header http.Header) []byte {
+	pc.encBuf.Reset()
+	pc.enc.WriteField(hpack.HeaderField{Name: ":method", Value: method})
+	pc.enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: u.Scheme})
+	pc.enc.WriteField(hpack.HeaderField{Name: ":authority", Value: u.Host})
+	pc.enc.WriteField(hpack.HeaderField{Name: ":path", Value: u.RequestURI()})
+	for k, vs := range header {
+		for _, v := range vs {
+			pc.enc.WriteField(hpack.HeaderField{Name: strings.ToLower(k), Value: v})
+		}
+	}
+	return pc.encBuf.Bytes()
+}
+
+// write serializes a frame write with the requests
+func (pc *h2Conn) write(fn func() error) {
+	pc.wmu.Lock()
+	err := fn()
+	pc.wmu.Unlock()
+	if err != nil {
+		pc.shutdown(err)
+	}
+}
+
+func (pc *h2Conn) readLoop() {
+	defer close(pc.closed)
+	for {
+		f, err := pc.fr.ReadFrame()
+		if err != nil {
+			pc.shutdown(err)
+			return
+		}
+		switch f := f.(type) {
+		case *http2.MetaHeadersFrame:
+			pc.mu.Lock()
+			if s := pc.streams[f.StreamID]; s != nil {
+				s.status, _ = strconv.Atoi(f.PseudoValue("status"))
+				if !s.pushed {
+					s.req.status = s.status
+				}
+			}
+			pc.mu.Unlock()
+			if f.StreamEnded() {
+				pc.endStream(f.StreamID, nil)
+			}
+		case *http2.DataFrame:
+			pc.data(f)
+		case *http2.PushPromiseFrame:
+			pc.promise(f)
+		case *http2.RSTStreamFrame:
+			pc.mu.Lock()
+			pc.resets[f.ErrCode]++
+			pc.mu.Unlock()
+			pc.endStream(f.StreamID, http2.StreamError{StreamID: f.StreamID, Code: f.ErrCode})
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				pc.settings(f)
+				pc.write(pc.fr.WriteSettingsAck)
+			}
+		case *http2.WindowUpdateFrame:
+			pc.mu.Lock()
+			if f.StreamID == 0 {
+				pc.sendConn += int64(f.Increment)
+			} else if s := pc.streams[f.StreamID]; s != nil {
+				s.send += int64(f.Increment)
+			}
+			pc.mu.Unlock()
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				pc.write(func() error { return pc.fr.WritePing(true, f.Data) })
+				break
+			}
+			pc.mu.Lock()
+			if acked := pc.pings[binary.BigEndian.Uint64(f.Data[:])]; acked != nil {
+				close(acked)
+				delete(pc.pings, binary.BigEndian.Uint64(f.Data[:]))
+			}
+			pc.mu.Unlock()
+		case *http2.GoAwayFrame:
+			pc.shutdown(http2.GoAwayError{LastStreamID: f.LastStreamID, ErrCode: f.ErrCode, DebugData: string(f.DebugData())})
+			return
+		}
+	}
+}
+
+// settings applies a change of the server's initial stream window to the
+// open streams
+func (pc *h2Conn) settings(f *http2.SettingsFrame) {
+	val, ok := f.Value(http2.SettingInitialWindowSize)
+	if !ok {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, s := range pc.streams {
+		s.send += int64(val) - pc.sendInit
+	}
+	pc.sendInit = int64(val)
+}
+
+func (pc *h2Conn) data(f *http2.DataFrame) {
+	n := int64(len(f.Data()))
+	pc.mu.Lock()
+	if s := pc.streams[f.StreamID]; s != nil && s.pushed {
+		atomic.AddInt64(&pc.push.pushBytes, n)
+	} else if s != nil {
+		s.req.bytes += n
+	}
+	pc.mu.Unlock()
+	if length := f.Length; length > 0 {
+		// Padding counts against flow control too
+		pc.write(func() error {
+			if !f.StreamEnded() {
+				if err := pc.fr.WriteWindowUpdate(f.StreamID, length); err != nil {
+					return err
+				}
+			}
+			return pc.fr.WriteWindowUpdate(0, length)
+		})
+	}
+	if f.StreamEnded() {
+		pc.endStream(f.StreamID, nil)
+	}
+}
+
+// promise accepts or rejects a pushed stream
+func (pc *h2Conn) promise(f *http2.PushPromiseFrame) {
+	if pc.push == nil {
+		pc.shutdown(fmt.Errorf("PUSH_PROMISE on stream %d with push disabled", f.StreamID))
+		return
+	}
+	atomic.AddInt64(&pc.push.promised, 1)
+	// The header block must be decoded to keep the HPACK state in sync
+	if _, err := pc.fr.ReadMetaHeaders.DecodeFull(f.HeaderBlockFragment()); err != nil || !f.HeadersEnded() {
+		pc.shutdown(fmt.Errorf("invalid PUSH_PROMISE on stream %d", f.StreamID))
+		return
+	}
+	pc.mu.Lock()
+	parent := pc.streams[f.StreamID]
+	accept := pc.accept && parent != nil && !parent.pushed
+	if accept {
+		parent.req.open++
+		pc.streams[f.PromiseID] = &h2Stream{req: parent.req, pushed: true, promised: time.Now()}
+	}
+	pc.mu.Unlock()
+	if !accept {
+		atomic.AddInt64(&pc.push.rejected, 1)
+		pc.write(func() error { return pc.fr.WriteRSTStream(f.PromiseID, http2.ErrCodeCancel) })
+	}
+}
+
+// endStream records the end of a stream, completing its request once all of
+// the request's streams ended
+func (pc *h2Conn) endStream(id uint32, err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	s := pc.streams[id]
+	if s == nil {
+		return
+	}
+	delete(pc.streams, id)
+	if s.pushed {
+		if err != nil || s.status/100 != 2 {
+			atomic.AddInt64(&pc.push.pushErrors, 1)
+		} else {
+			pc.push.pushed(time.Since(s.promised))
+		}
+	} else if err != nil {
+		s.req.err = err
+	}
+	if s.req.open--; s.req.open == 0 {
+		close(s.req.done)
+	}
+}
+
+// shutdown closes the connection, failing every open request
+func (pc *h2Conn) shutdown(err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.err != nil {
+		return
+	}
+	pc.err = errH2ConnClosed
+	if !errors.Is(err, net.ErrClosed) {
+		pc.err = fmt.Errorf("%w: %w", errH2ConnClosed, err)
+	}
+	pc.conn.Close()
+	for id, s := range pc.streams {
+		delete(pc.streams, id)
+		if s.req.err == nil {
+			s.req.err = pc.err
+		}
+		if s.req.open--; s.req.open == 0 {
+			close(s.req.done)
+		}
+	}
+}
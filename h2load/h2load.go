@@ -2,16 +2,25 @@ package h2load
 
 import (
 	"fmt"
+	"io"
+	"net/http"
 	"time"
 )
 
 type RpsMode int
 
 const (
-	RpsModeBurst RpsMode = iota // fire as fast as allowed up to the RPS limit per second
-	RpsModeEven                 // spread requests evenly within the second
+	RpsModeBurst  RpsMode = iota // fire as fast as allowed up to the RPS limit per second
+	RpsModeEven                  // spread requests evenly within the second
+	RpsModeJitter                // spread requests within the second, every interval skewed by a random ±IntervalJitter
 )
 
+// spaced reports whether m spaces requests out within the second rather
+// than sending them in a burst
+func (m RpsMode) spaced() bool {
+	return m != RpsModeBurst
+}
+
 // the fields that matter are
 // requests
 // rps
@@ -20,14 +29,175 @@ const (
 type H2loadConf struct {
 	Protocol          string
 	ServerAddress     string
+	ConnectTo         []ConnectTo // Addresses dialed for the URL's host and port, unless ServerAddress is set
+	Targets           []string    // Server addresses (host:port) the clients are sharded over, client i dialing Targets[i % len(Targets)]
+	Resolve           []Resolve   // Pinned addresses of the host dialed
+	DNSServer         string      // DNS server resolving the host dialed, e.g. 10.0.0.53:53 (default: the system resolver)
+	IPVersion         int         // 4 or 6 to dial only IPv4 or IPv6 addresses (0 = both, with Happy Eyeballs)
 	Requests          int
 	Rate              int
 	RatePeriod        int
 	Rps               int
+	TotalRps          int
 	RpsMode           RpsMode
+	RpsJitter         float64 // Random per-client skew of Rps, as a fraction (0.1 = ±10%)
+	StrictSchedule    bool    // Schedule every send of Rps or TotalRps ahead, sending late rather than skipping when behind
+	IntervalJitter    float64 // Random skew of every interval between sends in RpsModeJitter, as a fraction (0.1 = ±10%)
 	ConcurrentStreams int
 	Clients           int
 	URL               string
+	Headers           http.Header // Headers added to requests built from URL
+	Method            string      // Method of requests built from URL (default: GET, POST with a form body)
+	Body              BodyConf    // Body of requests built from URL, streamed as they are sent
+
+	// URLList sends these requests in turn instead of requests built from
+	// URL, each with its own method, relative URLs resolved against URL.
+	// POST, PUT and PATCH requests send Body.
+	URLList []ListedRequest
+
+	// ClosedLoop sends requests back-to-back from a fixed number of workers,
+	// with an optional think time between them, instead of at a rate
+	ClosedLoop ClosedLoopConf
+
+	// Spike injects periodic bursts of load on top of Rps or TotalRps
+	Spike SpikeConf
+
+	// Users simulates this many virtual users, each with its own cookies,
+	// variables and counters and sending one request at a time, dealt out
+	// to the clients (0 = requests carry no user state)
+	Users int
+
+	// Extract pulls values out of responses into the variables of Users,
+	// replacing their {name} placeholders in the URL and headers of later
+	// requests (default: none)
+	Extract []Extractor
+
+	// SendDelay holds every request back for a random delay before it is
+	// sent, keeping its stream slot, to emulate clients on a WAN (default:
+	// sent at once)
+	SendDelay *DelayDist
+
+	// Socket sets options on the socket of every connection, e.g. Nagle's
+	// algorithm, buffer sizes and DSCP marking (default: Go's)
+	Socket SocketConf
+
+	// Throttle caps the bandwidth of every connection, to emulate
+	// constrained clients such as phones on 3G (default: unlimited)
+	Throttle ThrottleConf
+
+	// Mesh sends traffic through a local service mesh sidecar
+	Mesh MeshConf
+
+	// LatencyTarget adjusts TotalRps during the run to hold a latency
+	// percentile at a target, starting from TotalRps
+	LatencyTarget LatencyTargetConf
+
+	// Abort stops the run early when the error rate or p99 blows up
+	Abort AbortConf
+
+	// MaxStreamErrors recycles a client's connection after this many stream
+	// errors or a GOAWAY with an error (0 = never recycle)
+	MaxStreamErrors int
+
+	// FollowRedirects follows up to this many redirects per request, on the
+	// client's connection (0 = don't follow, 3xx responses are recorded as
+	// they are)
+	FollowRedirects int
+
+	// Retry retries failed requests (default: no retries)
+	Retry RetryConf
+
+	// Compression requests compressed responses and times their
+	// decompression (default: no Accept-Encoding is sent)
+	Compression CompressionConf
+
+	// Revalidate keeps the ETag and Last-Modified of every client's GET and
+	// HEAD responses by URL and sends them back as If-None-Match and
+	// If-Modified-Since, counting the 304s and the bandwidth they saved
+	Revalidate bool
+
+	// ExpectContinue sends Expect: 100-continue on requests with a body,
+	// timing the 100 Continue and counting the requests rejected before
+	// their body was sent
+	ExpectContinue ExpectContinueConf
+
+	// CORS sends a CORS preflight ahead of a fraction of the requests,
+	// timed apart from them, as browsers do for cross-origin API calls
+	CORS CORSConf
+
+	// GraphQL sends a GraphQL operation as the body of every request,
+	// failing responses with GraphQL errors
+	GraphQL GraphQLConf
+
+	// RequestID adds a generated request ID header and/or a W3C traceparent
+	// to every request, recorded in its log entry (default: none)
+	RequestID RequestIDConf
+
+	// Trailers sends request trailers and checks response trailers, failing
+	// requests whose trailers don't match
+	Trailers TrailerConf
+
+	// FlowStalls times how long each request is blocked on HTTP/2 flow
+	// control windows, the connection's or its stream's
+	FlowStalls bool
+
+	// RespectMaxStreams caps ConcurrentStreams to the MAX_CONCURRENT_STREAMS
+	// the server sends, instead of queueing the streams above it
+	RespectMaxStreams bool
+
+	// DebugFrames logs the HTTP/2 frames of some streams (default: none)
+	DebugFrames FrameDebugConf
+
+	// KeyLog receives the TLS secrets of every connection in NSS key log
+	// format, for decrypting captured traffic with e.g. Wireshark. It is
+	// written by concurrent connections (default: no key log).
+	KeyLog io.Writer
+
+	// RampDown bounds the wait for the requests in flight once a client
+	// stops sending new ones, at the end of the test or when stopped. The
+	// requests still in flight after it are cancelled and counted as
+	// abandoned rather than failed (0 = wait for all of them).
+	RampDown time.Duration
+
+	// Close closes the connections gracefully once the run is done (default:
+	// they are left open until Close)
+	Close CloseConf
+
+	// CalmBackoff pauses a client's new requests after the server sends
+	// ENHANCE_YOUR_CALM (0 = don't back off)
+	CalmBackoff time.Duration
+
+	// Capture writes the responses of failed requests to a debug directory
+	Capture CaptureConf
+
+	// MaxMemory caps the generator's memory in bytes. Close to the cap log
+	// lines are sampled ever harder and traces are dropped (0 = no cap).
+	MaxMemory int64
+
+	// LatencyProfileRate attributes the latency of one in every this many
+	// requests to generator-side and network/server phases (0 = disabled)
+	LatencyProfileRate int
+
+	// Seed makes the run's random choices reproducible: the RPS jitter,
+	// random body sizes and the streams sampled for frame debugging. They
+	// come out the same, in the order concurrent clients draw them. 0 draws
+	// a seed, kept in the H2loadClient's ClientsConf.
+	Seed uint64
+
+	// TraceSamplesPerMinute bounds how many requests per minute are traced
+	// when a trace func is set (0 = trace every request)
+	TraceSamplesPerMinute int
+}
+
+// method returns the method of requests built from URL
+func (h *H2loadConf) method() string {
+	if h.Method != "" {
+		return h.Method
+	}
+	if len(h.Body.Form) > 0 || h.GraphQL.enabled() {
+		return http.MethodPost
+	}
+	return http.MethodGet
 }
 
 func (h *H2loadConf) Validate() error {
@@ -37,6 +207,22 @@ func (h *H2loadConf) Validate() error {
 	if h.Requests < 0 {
 		return fmt.Errorf("requests must be greater than 0")
 	}
+	if h.Method != "" && !validToken(h.Method) {
+		return fmt.Errorf("invalid method %q", h.Method)
+	}
+	if len(h.URLList) > 0 {
+		if h.GraphQL.enabled() {
+			return fmt.Errorf("URL list can't be used with GraphQL")
+		}
+		for _, r := range h.URLList {
+			if r.Method != "" && !validToken(r.Method) {
+				return fmt.Errorf("URL list: invalid method %q", r.Method)
+			}
+			if _, err := resolveOnHost(h.URL, r.URL); err != nil {
+				return fmt.Errorf("URL list: %w", err)
+			}
+		}
+	}
 	if h.Rate < 0 {
 		return fmt.Errorf("rate must be greater than 0")
 	}
@@ -46,12 +232,150 @@ func (h *H2loadConf) Validate() error {
 	if h.Rps < 0 {
 		return fmt.Errorf("rps must be greater than 0")
 	}
+	if h.TotalRps < 0 {
+		return fmt.Errorf("total rps must be greater than 0")
+	}
+	if h.Rps > 0 && h.TotalRps > 0 {
+		return fmt.Errorf("rps and total rps are mutually exclusive")
+	}
+	if h.StrictSchedule && h.Rps == 0 && h.TotalRps == 0 {
+		return fmt.Errorf("strict schedule requires rps or total rps")
+	}
+	if h.RpsJitter < 0 || h.RpsJitter >= 1 {
+		return fmt.Errorf("rps jitter must be between 0 and 1")
+	}
+	if h.IntervalJitter < 0 || h.IntervalJitter >= 1 {
+		return fmt.Errorf("interval jitter must be between 0 and 1")
+	}
+	if err := h.ClosedLoop.Validate(); err != nil {
+		return err
+	}
+	if h.ClosedLoop.Enabled && (h.Rps > 0 || h.TotalRps > 0) {
+		return fmt.Errorf("closed loop and rps are mutually exclusive")
+	}
+	if err := h.Spike.Validate(); err != nil {
+		return err
+	}
+	if h.Spike.enabled() && h.Rps == 0 && h.TotalRps == 0 {
+		return fmt.Errorf("spike requires rps or total rps as the baseline")
+	}
 	if h.ConcurrentStreams < 0 {
 		return fmt.Errorf("concurrent streams must be greater than 0")
 	}
 	if h.Clients < 0 {
 		return fmt.Errorf("clients must be greater than 0")
 	}
+	if err := h.LatencyTarget.Validate(); err != nil {
+		return err
+	}
+	if h.LatencyTarget.Target > 0 && h.TotalRps == 0 {
+		return fmt.Errorf("latency target requires total rps as the starting rate")
+	}
+	if h.LatencyTarget.Target > 0 && h.Spike.enabled() {
+		return fmt.Errorf("latency target and spike are mutually exclusive, both set the rate")
+	}
+	if h.ServerAddress != "" && len(h.ConnectTo) > 0 {
+		return fmt.Errorf("server address and connect-to are mutually exclusive")
+	}
+	if h.Users < 0 {
+		return fmt.Errorf("users must be greater than 0")
+	}
+	if h.Users > 0 && h.Users < h.Clients {
+		return fmt.Errorf("%d clients need at least as many users, got %d", h.Clients, h.Users)
+	}
+	if len(h.Extract) > 0 && h.Users == 0 {
+		return fmt.Errorf("extractors set variables of virtual users, they require users")
+	}
+	names := make(map[string]bool)
+	for _, e := range h.Extract {
+		if names[e.Name] {
+			return fmt.Errorf("extractor %s is set twice", e.Name)
+		}
+		names[e.Name] = true
+		if err := e.compile(); err != nil {
+			return err
+		}
+	}
+	if err := h.validateTargets(); err != nil {
+		return err
+	}
+	if err := h.Mesh.Validate(); err != nil {
+		return err
+	}
+	if err := h.Abort.Validate(); err != nil {
+		return err
+	}
+	if h.RampDown < 0 {
+		return fmt.Errorf("ramp down must be greater than 0")
+	}
+	if err := h.Close.Validate(); err != nil {
+		return err
+	}
+	if h.MaxStreamErrors < 0 {
+		return fmt.Errorf("max stream errors must be greater than 0")
+	}
+	if h.FollowRedirects < 0 {
+		return fmt.Errorf("follow redirects must be greater than 0")
+	}
+	if h.SendDelay != nil {
+		if err := h.SendDelay.Validate(); err != nil {
+			return err
+		}
+	}
+	if h.IPVersion != 0 && h.IPVersion != 4 && h.IPVersion != 6 {
+		return fmt.Errorf("IP version must be 4, 6 or 0 for both")
+	}
+	if err := h.Socket.Validate(); err != nil {
+		return err
+	}
+	if err := h.Throttle.Validate(); err != nil {
+		return err
+	}
+	if err := h.Body.Validate(); err != nil {
+		return err
+	}
+	if err := h.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := h.Compression.Validate(); err != nil {
+		return err
+	}
+	if err := h.ExpectContinue.Validate(); err != nil {
+		return err
+	}
+	if err := h.CORS.Validate(); err != nil {
+		return err
+	}
+	if err := h.GraphQL.Validate(); err != nil {
+		return err
+	}
+	if h.GraphQL.enabled() && h.Body.enabled() {
+		return fmt.Errorf("graphql query and request body are mutually exclusive")
+	}
+	if err := h.RequestID.Validate(); err != nil {
+		return err
+	}
+	if err := h.Trailers.Validate(); err != nil {
+		return err
+	}
+	if h.CalmBackoff < 0 {
+		return fmt.Errorf("calm backoff must be greater than 0")
+	}
+	if err := h.Capture.Validate(); err != nil {
+		return err
+	}
+	if err := h.DebugFrames.Validate(); err != nil {
+		return err
+	}
+	if h.MaxMemory < 0 {
+		return fmt.Errorf("max memory must be greater than 0")
+	}
+	if h.LatencyProfileRate < 0 {
+		return fmt.Errorf("latency profile rate must be greater than 0")
+	}
+	if h.TraceSamplesPerMinute < 0 {
+		return fmt.Errorf("trace samples per minute must be greater than 0")
+	}
 	return nil
 }
 
@@ -59,4 +383,24 @@ type LogEntry struct {
 	Status    int
 	Latency   time.Duration
 	Timestamp string
+	Start     time.Time     // When the request was sent, set on logged entries
+	Route     string        // Method and path, or the name set with WithRoute
+	Err       error         // Set when no response was received, or it failed a GraphQL or trailer check
+	Queue     time.Duration // Time from the request becoming eligible to its HEADERS being written, 0 if they never were
+	Spike     bool          // Sent during a spike of Spike
+
+	// Set on logged entries only
+	ClientID  int           // Index of the client that sent the request
+	ConnID    uint64        // Connection the request was sent on, numbered from 1 across clients
+	StreamID  uint32        // HTTP/2 stream ID of the request, 0 if it was never sent
+	BytesOut  int64         // Request body bytes
+	BytesIn   int64         // Response body bytes read
+	TTFB      time.Duration // Time to the first response byte, 0 without a response
+	Stalled   time.Duration // Time blocked on flow control windows, with FlowStalls set
+	Error     string        // Err as a string
+	Redirects []string      // URLs redirected to, in order, when following redirects
+	Retries   int           // Retries sent before the outcome recorded
+	Trailers  http.Header   // Trailers of the response
+	RequestID string        // Request ID header sent, with RequestID.Header set
+	TraceID   string        // Trace ID of the traceparent sent, with RequestID.TraceParent set
 }
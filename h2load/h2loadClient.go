@@ -1,15 +1,54 @@
 package h2load
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 )
 
 type H2loadClient struct {
 	Clients     []*H2Client
 	ClientsConf H2loadConf
+
+	latencyController *latencyController
+	latencyTargetFunc func(LatencyTargetSample)
+
+	abortMu     sync.Mutex
+	abortReason string
+
+	sharedLimiter atomic.Pointer[rpsLimiter] // TotalRps limiter of the running test, if any
+	sharedPacing  *pacingRecorder            // Pacing of the last TotalRps limiter, nil in burst mode
+	sharedSlips   *scheduleRecorder          // Slips of the last TotalRps limiter, with StrictSchedule
+	pauseMu       sync.Mutex                 // Serializes Pause and Resume
+	paused        bool
+
+	histStore    HistogramStore // Receives raw latency histograms, if set
+	histInterval time.Duration
+
+	memoryGuard *memoryGuard      // Enforces MaxMemory during the last run
+	generator   *generatorMonitor // Resource use of the generator during the last run
+	concurrency *concurrencyGauge // Requests in flight during the last run
+
+	routes *routeCollector // Stats per route across all clients
+	sizes  *sizeCollector  // Stats per request body size across all clients
+
+	closes *CloseStats   // Graceful close of the last run's connections, with Close.Graceful set
+	phases []PhaseResult // Stats of every phase of the last RunPhases
+	spiker *spiker       // Spikes of the last run, with Spike set
+
+	abortMonitor *abortMonitor // Abort thresholds of the last run, with Abort set
+
+	seriesInterval time.Duration // Time series sampling interval, 0 = disabled
+	series         *timeSeries   // Time series of the last run
 }
 
 /*
@@ -53,14 +92,65 @@ func NewH2loadClient(conf H2loadConf) (*H2loadClient, error) {
 	if err := conf.Validate(); err != nil {
 		return nil, err
 	}
+	conf = conf.withMeshDefaults()
+	if conf.Seed == 0 {
+		// Kept in ClientsConf, so an unseeded run can be reproduced
+		conf.Seed = newSeed()
+	}
 	clients := make([]*H2Client, 0, conf.Clients)
+	sampler := newTraceSampler(conf.TraceSamplesPerMinute)
+	profiler := newLatencyProfiler(conf.LatencyProfileRate)
+	capturer := newBodyCapturer(conf.Capture)
+	frameLog := newFrameLogger(conf.DebugFrames, conf.Seed)
+	jitter := newRand(conf.Seed, randRpsJitter)
+	intervals := newLockedRand(conf.Seed, randIntervals)
+	delays := newLockedRand(conf.Seed, randDelays)
+	cors := newLockedRand(conf.Seed, randCORS)
+	var users []*userPool
+	if conf.Users > 0 {
+		users = newUserPools(conf.Users, conf.Clients, conf.Extract)
+	}
 	for i := 0; i < conf.Clients; i++ {
-		client := NewH2Client(conf)
+		clientConf := conf
+		var phase time.Duration
+		if conf.RpsJitter > 0 && conf.Rps > 0 {
+			clientConf.Rps, phase = jitterRps(conf.Rps, conf.RpsMode, conf.RpsJitter, jitter)
+		}
+		if target := conf.clientTarget(i); target != "" {
+			clientConf.ServerAddress = target
+		}
+		client := NewH2Client(clientConf)
+		client.ID = i
+		client.traceSampler = sampler // the trace budget is shared by the whole run
+		client.profiler = profiler
+		client.capturer = capturer
+		client.frameLog = frameLog
+		client.rpsPhase = phase
+		client.intervalRand = intervals
+		client.delayRand = delays
+		if client.corsRand != nil {
+			client.corsRand = cors
+		}
+		if users != nil {
+			client.users = users[i]
+		}
 		clients = append(clients, client)
 	}
 	return &H2loadClient{Clients: clients, ClientsConf: conf}, nil
 }
 
+// jitterRps skews rps by a random amount within ±jitter and picks a random
+// refill phase, drawn from r, so identical clients don't refill their tokens
+// in lockstep
+func jitterRps(rps int, mode RpsMode, jitter float64, r *rand.Rand) (int, time.Duration) {
+	skewed := int(math.Round(float64(rps) * (1 + (r.Float64()*2-1)*jitter)))
+	if skewed < 1 {
+		skewed = 1
+	}
+	phase := time.Duration(r.Int64N(int64(refillPeriod(skewed, mode))))
+	return skewed, phase
+}
+
 func (h *H2loadClient) Connect() error {
 	errs := RunConcurrent(h.Clients, func(c *H2Client) error {
 		return c.Connect()
@@ -75,22 +165,332 @@ func (h *H2loadClient) RunRequests(req *http.Request) error {
 }
 
 func (h *H2loadClient) Run() error {
-	req, _ := http.NewRequest("GET", h.ClientsConf.URL, nil)
-	return h.RunRequests(req)
+	factory, err := h.requestFactory(h.ClientsConf)
+	if err != nil {
+		return err
+	}
+	return h.RunRequestsFactory(factory)
+}
+
+// requestFactory returns the factory of the requests built from conf's URL
+// list, or URL, headers, body or GraphQL operation
+func (h *H2loadClient) requestFactory(conf H2loadConf) (func() *http.Request, error) {
+	method := conf.method()
+	if len(conf.URLList) > 0 {
+		return urlListFactory(conf)
+	}
+	if conf.GraphQL.enabled() {
+		return GraphQLRequestFactory(method, conf.URL, conf.Headers, conf.GraphQL)
+	}
+	if conf.Body.enabled() {
+		return conf.Body.requestFactory(method, conf.URL, conf.Headers, conf.Seed)
+	}
+	req, err := http.NewRequest(method, conf.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range conf.Headers {
+		req.Header[k] = v
+	}
+	return func() *http.Request {
+		return req
+	}, nil
 }
 
 func (h *H2loadClient) RunRequestsFactory(factory func() *http.Request) error {
+	// Background monitors run until all clients are done
+	done := make(chan struct{})
+	defer close(done)
+
+	if h.ClientsConf.TotalRps > 0 {
+		// One token bucket for all clients, so the total rate holds even
+		// when some clients finish early
+		var limiter *rpsLimiter
+		conf := h.ClientsConf
+		intervals := newLockedRand(conf.Seed, randIntervals)
+		if conf.StrictSchedule {
+			limiter = newScheduledLimiter(conf.TotalRps, conf.RpsMode, 0, conf.IntervalJitter, intervals)
+			h.sharedSlips = limiter.schedule.recorder
+		} else {
+			limiter = newJitteredRpsLimiter(conf.TotalRps, conf.RpsMode, 0, conf.IntervalJitter, intervals)
+		}
+		defer limiter.close()
+		h.sharedPacing = limiter.pacing
+		for _, c := range h.Clients {
+			c.sharedLimiter = limiter
+		}
+		h.pauseMu.Lock()
+		limiter.setPaused(h.paused)
+		h.sharedLimiter.Store(limiter)
+		h.pauseMu.Unlock()
+		defer h.sharedLimiter.Store(nil)
+
+		if h.ClientsConf.LatencyTarget.Target > 0 {
+			controller := newLatencyController(h.ClientsConf.LatencyTarget, limiter, h.ClientsConf.TotalRps)
+			controller.onSample = h.latencyTargetFunc
+			for _, c := range h.Clients {
+				c.addStatsObserver(controller.window.record)
+			}
+			go controller.run(done)
+			h.latencyController = controller
+		}
+	}
+
+	h.spiker = nil
+	if h.ClientsConf.Spike.enabled() {
+		h.spiker = newSpiker(h.ClientsConf.Spike)
+		for _, c := range h.Clients {
+			c.spiking = &h.spiker.active
+			c.addStatsObserver(h.spiker.record)
+		}
+		go h.spiker.run(done, h.spikeRaiser(h.sharedLimiter.Load()))
+	}
+
+	h.abortMonitor = nil
+	if h.ClientsConf.Abort.enabled() {
+		h.abortMonitor = newAbortMonitor(h.ClientsConf.Abort)
+		for _, c := range h.Clients {
+			c.addStatsObserver(h.abortMonitor.window.record)
+		}
+		go h.abortMonitor.run(done, h.abort)
+	}
+
+	h.routes = newRouteCollector()
+	h.sizes = newSizeCollector()
+	for _, c := range h.Clients {
+		c.addStatsObserver(h.routes.record)
+		c.addStatsObserver(h.sizes.record)
+	}
+
+	if h.ClientsConf.MaxMemory > 0 {
+		h.memoryGuard = newMemoryGuard(h.ClientsConf.MaxMemory, h.Clients)
+		go h.memoryGuard.run(done)
+	}
+	h.generator = newGeneratorMonitor()
+	go h.generator.run(done)
+	h.concurrency = newConcurrencyGauge(h.Clients, h.ClientsConf.ConcurrentStreams)
+	go h.concurrency.run(done)
+
+	var persister *histogramPersister
+	if h.histStore != nil {
+		persister = newHistogramPersister(h.histStore, h.histInterval)
+		for _, c := range h.Clients {
+			c.addStatsObserver(persister.window.record)
+		}
+		go persister.run()
+	}
+	if h.seriesInterval > 0 {
+		h.series = newTimeSeries(h.seriesInterval)
+		h.series.gauge = h.concurrency
+		for _, c := range h.Clients {
+			c.addStatsObserver(h.series.window.record)
+		}
+		go h.series.run()
+	}
+	start := time.Now()
+
 	errs := RunConcurrent(h.Clients, func(c *H2Client) error {
 		return c.DoRequestsFactory(factory)
 	})
-	return JoinIndexedErrors(errs)
+	err := JoinIndexedErrors(errs)
+	h.generator.sample()
+	if h.ClientsConf.Close.Graceful {
+		h.closeGracefully()
+	}
+	if persister != nil || h.series != nil {
+		// Let the stats collectors drain before the final samples are taken
+		for _, c := range h.Clients {
+			c.statsWg.Wait()
+		}
+	}
+	if h.series != nil {
+		h.series.finish()
+	}
+	if persister != nil {
+		if storeErr := persister.finish(start, h.Snapshot().Histogram); storeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to store histograms: %w", storeErr))
+		}
+	}
+	return err
+}
+
+// closeGracefully closes the connections of all clients as Close sets,
+// recording how
+func (h *H2loadClient) closeGracefully() {
+	var mu sync.Mutex
+	var total CloseStats
+	_ = RunConcurrent(h.Clients, func(c *H2Client) error {
+		s := c.closeGracefully()
+		mu.Lock()
+		defer mu.Unlock()
+		total.merge(s)
+		return nil
+	})
+	h.closes = &total
+}
+
+// GetCloseStats returns how the last run's connections were closed. It is
+// empty unless Close.Graceful is set.
+func (h *H2loadClient) GetCloseStats() CloseStats {
+	if h.closes == nil {
+		return CloseStats{}
+	}
+	return *h.closes
+}
+
+// SetHistogramStore makes the next run persist the raw latency histogram of
+// every interval, tagged HistogramTagInterval, and of the whole run, tagged
+// HistogramTagTotal
+func (h *H2loadClient) SetHistogramStore(store HistogramStore, interval time.Duration) {
+	h.histStore = store
+	h.histInterval = interval
 }
 
-func (h *H2loadClient) Start() error {
-	if err := h.Connect(); err != nil {
-		return fmt.Errorf("connect failed: %w", err)
+// SetTimeSeries makes the next run sample the stats of every interval, see
+// GetTimeSeries
+func (h *H2loadClient) SetTimeSeries(interval time.Duration) {
+	h.seriesInterval = interval
+}
+
+// GetTimeSeries returns the stats of each interval of the last run, when
+// enabled with SetTimeSeries
+func (h *H2loadClient) GetTimeSeries() []TimeSeriesPoint {
+	if h.series == nil {
+		return nil
+	}
+	return h.series.getPoints()
+}
+
+// abort stops all clients early, recording why
+func (h *H2loadClient) abort(reason string) {
+	h.abortMu.Lock()
+	if h.abortReason == "" {
+		h.abortReason = reason
+	}
+	h.abortMu.Unlock()
+	for _, c := range h.Clients {
+		c.cancel()
 	}
-	return h.Run()
+}
+
+// AbortReason returns why the run was aborted early, or "" if it wasn't
+func (h *H2loadClient) AbortReason() string {
+	h.abortMu.Lock()
+	defer h.abortMu.Unlock()
+	return h.abortReason
+}
+
+// runFor runs the test with run until it completes or d elapses, whichever
+// comes first. Once stopped, the clients get wait to finish (0 = no bound),
+// failing with a *WaitTimeoutError after it.
+func (h *H2loadClient) runFor(d, wait time.Duration, run func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- run()
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+	}
+	if wait <= 0 {
+		h.Stop()
+		return <-errCh
+	}
+	for _, c := range h.Clients {
+		c.cancel()
+	}
+	if err := h.WaitTimeout(wait); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
+// Pause suspends sending new requests on all clients without closing
+// connections or resetting stats
+func (h *H2loadClient) Pause() {
+	h.pauseMu.Lock()
+	defer h.pauseMu.Unlock()
+	h.paused = true
+	h.pauseSends(true)
+}
+
+// Resume continues sending requests on all clients after Pause
+func (h *H2loadClient) Resume() {
+	h.pauseMu.Lock()
+	defer h.pauseMu.Unlock()
+	h.paused = false
+	h.pauseSends(false)
+}
+
+// pauseSends suspends or continues sending on all clients, with pauseMu held
+func (h *H2loadClient) pauseSends(paused bool) {
+	if l := h.sharedLimiter.Load(); l != nil {
+		l.setPaused(paused)
+	}
+	for _, c := range h.Clients {
+		if paused {
+			c.Pause()
+		} else {
+			c.Resume()
+		}
+	}
+}
+
+// IsPaused reports whether the test is paused
+func (h *H2loadClient) IsPaused() bool {
+	h.pauseMu.Lock()
+	defer h.pauseMu.Unlock()
+	return h.paused
+}
+
+// SetRps changes the per-client RPS limit of all clients while the test is
+// running. 0 removes the limit. Not available when the test uses TotalRps.
+func (h *H2loadClient) SetRps(rps int) error {
+	if h.ClientsConf.TotalRps > 0 {
+		return fmt.Errorf("test is limited by total rps, use SetTotalRps")
+	}
+	for _, c := range h.Clients {
+		if err := c.SetRps(rps); err != nil {
+			return err
+		}
+	}
+	h.ClientsConf.Rps = rps
+	return nil
+}
+
+// SetTotalRps changes the total RPS limit shared by all clients while the
+// test is running. Only available when the test uses TotalRps.
+func (h *H2loadClient) SetTotalRps(rps int) error {
+	if h.ClientsConf.TotalRps == 0 {
+		return fmt.Errorf("test is not limited by total rps, use SetRps")
+	}
+	if rps <= 0 {
+		return fmt.Errorf("total rps must be greater than 0")
+	}
+	if h.latencyController != nil {
+		return fmt.Errorf("total rps is set by the latency target controller")
+	}
+	h.ClientsConf.TotalRps = rps
+	if l := h.sharedLimiter.Load(); l != nil {
+		l.setRate(rps)
+	}
+	return nil
+}
+
+// SetConcurrentStreams changes the number of concurrent streams per client
+// of all clients while the test is running
+func (h *H2loadClient) SetConcurrentStreams(streams int) error {
+	for _, c := range h.Clients {
+		if err := c.SetConcurrentStreams(streams); err != nil {
+			return err
+		}
+	}
+	h.ClientsConf.ConcurrentStreams = streams
+	return nil
 }
 
 func (h *H2loadClient) Stop() {
@@ -111,7 +511,7 @@ func (h *H2loadClient) SetLoggerForClient(clientIndex int, logger *log.Logger) {
 	h.Clients[clientIndex].SetLogger(logger)
 }
 
-func (h *H2loadClient) SetLogLineFuncForClient(clientIndex int, logLineFunc func(start time.Time, status int, latency time.Duration) string) {
+func (h *H2loadClient) SetLogLineFuncForClient(clientIndex int, logLineFunc func(entry LogEntry) string) {
 	h.Clients[clientIndex].SetLogLineFunc(logLineFunc)
 }
 
@@ -121,12 +521,81 @@ func (h *H2loadClient) SetGlobalLogger(logger *log.Logger) {
 	}
 }
 
-func (h *H2loadClient) SetGlobalLogLineFunc(logLineFunc func(start time.Time, status int, latency time.Duration) string) {
+// SetGlobalRequestLogger sets the Logger receiving the requests of all clients
+func (h *H2loadClient) SetGlobalRequestLogger(logger Logger) {
+	for _, c := range h.Clients {
+		c.SetRequestLogger(logger)
+	}
+}
+
+// SetGlobalSlogLogger logs the requests of all clients as structured records
+func (h *H2loadClient) SetGlobalSlogLogger(logger *slog.Logger) {
+	h.SetGlobalRequestLogger(NewSlogLogger(logger))
+}
+
+// SetGlobalBufferedLogger logs all clients to out through a ShardedLogWriter
+// with one shard per client, so clients don't contend on a shared logger.
+// The returned writer must be closed after Wait to flush the remaining lines.
+func (h *H2loadClient) SetGlobalBufferedLogger(out io.Writer, flushInterval time.Duration) *ShardedLogWriter {
+	w := NewShardedLogWriter(out, len(h.Clients), flushInterval)
+	for i, c := range h.Clients {
+		c.SetLogger(log.New(w.Shard(i), "", 0))
+	}
+	return w
+}
+
+// SetGlobalMultiFileLogger splits the log across outs, client i writing to
+// outs[i%len(outs)]. Each output is drained by its own ShardedLogWriter, so
+// high rates aren't limited by a single flusher. The returned writers must be
+// closed after Wait to flush the remaining lines.
+func (h *H2loadClient) SetGlobalMultiFileLogger(outs []io.Writer, flushInterval time.Duration) []*ShardedLogWriter {
+	writers := make([]*ShardedLogWriter, len(outs))
+	for j, out := range outs {
+		// Clients j, j+n, j+2n, ... share this output
+		shards := (len(h.Clients) - j + len(outs) - 1) / len(outs)
+		writers[j] = NewShardedLogWriter(out, shards, flushInterval)
+	}
+	for i, c := range h.Clients {
+		c.SetLogger(log.New(writers[i%len(outs)].Shard(i/len(outs)), "", 0))
+	}
+	return writers
+}
+
+func (h *H2loadClient) SetGlobalLogLineFunc(logLineFunc func(entry LogEntry) string) {
 	for _, c := range h.Clients {
 		c.SetLogLineFunc(logLineFunc)
 	}
 }
 
+// SetGlobalLogTemplate formats log lines on all clients with tmpl, see
+// ParseLogTemplate and LogTemplateData
+func (h *H2loadClient) SetGlobalLogTemplate(tmpl *template.Template) {
+	h.SetGlobalLogLineFunc(LogResultAsTemplate(tmpl))
+}
+
+// SetGlobalLogFilter sets which requests are written to the log on all clients
+func (h *H2loadClient) SetGlobalLogFilter(logFilter func(status int, latency time.Duration) bool) {
+	for _, c := range h.Clients {
+		c.SetLogFilter(logFilter)
+	}
+}
+
+// SetGlobalIsSuccess sets the predicate deciding which requests count as
+// successful on all clients
+func (h *H2loadClient) SetGlobalIsSuccess(isSuccess func(status int, err error) bool) {
+	for _, c := range h.Clients {
+		c.SetIsSuccess(isSuccess)
+	}
+}
+
+// SetGlobalTraceFunc sets the trace func on all clients. The
+// TraceSamplesPerMinute budget applies to all clients together.
+func (h *H2loadClient) SetGlobalTraceFunc(traceFunc func(RequestTrace)) {
+	for _, c := range h.Clients {
+		c.SetTraceFunc(traceFunc)
+	}
+}
+
 func (h *H2loadClient) Close() {
 	_ = RunConcurrent(h.Clients, func(c *H2Client) error {
 		c.Close()
@@ -142,16 +611,106 @@ func (h *H2loadClient) GetSentRequests() int64 {
 	return total
 }
 
+// SetLatencyTargetFunc sets a func called with every adjustment the latency
+// target controller makes. It must be set before the run starts.
+func (h *H2loadClient) SetLatencyTargetFunc(fn func(LatencyTargetSample)) {
+	h.latencyTargetFunc = fn
+}
+
+// GetLatencyTargetHistory returns the adjustments made by the latency target
+// controller, or nil if the controller didn't run
+func (h *H2loadClient) GetLatencyTargetHistory() []LatencyTargetSample {
+	if h.latencyController == nil {
+		return nil
+	}
+	return h.latencyController.getHistory()
+}
+
+// GetPacingReport returns how closely even-mode sends followed the ideal
+// interval. With TotalRps it covers the shared limiter, otherwise the
+// per-client limiters of all clients combined.
+func (h *H2loadClient) GetPacingReport() PacingReport {
+	if h.ClientsConf.TotalRps > 0 {
+		return mergePacing(h.sharedPacing)
+	}
+	var recorders []*pacingRecorder
+	for _, c := range h.Clients {
+		c.tuneMu.Lock()
+		recorders = append(recorders, c.pacing...)
+		c.tuneMu.Unlock()
+	}
+	return mergePacing(recorders...)
+}
+
+// GetScheduleStats returns how far the sends of a strict schedule slipped
+// behind it. With TotalRps it covers the shared limiter, otherwise the
+// per-client limiters of all clients combined.
+func (h *H2loadClient) GetScheduleStats() ScheduleStats {
+	if h.ClientsConf.TotalRps > 0 {
+		return mergeSchedules(h.sharedSlips)
+	}
+	var recorders []*scheduleRecorder
+	for _, c := range h.Clients {
+		c.tuneMu.Lock()
+		recorders = append(recorders, c.schedules...)
+		c.tuneMu.Unlock()
+	}
+	return mergeSchedules(recorders...)
+}
+
+// GetLatencyProfile returns the latency attribution of the sampled requests
+// of all clients
+func (h *H2loadClient) GetLatencyProfile() LatencyProfile {
+	if len(h.Clients) == 0 {
+		return LatencyProfile{}
+	}
+	return h.Clients[0].profiler.profile()
+}
+
 // GetTotalStats returns aggregated total statistics from all clients
 func (h *H2loadClient) GetTotalStats() RequestStats {
+	return h.Snapshot().Stats
+}
+
+// Snapshot returns the stats and latency histogram of all clients combined.
+// It is safe to call from any goroutine while requests are running.
+func (h *H2loadClient) Snapshot() StatsSnapshot {
+	return snapshotClients(h.Clients)
+}
+
+// snapshotClients returns the stats and latency histogram of clients
+// combined
+func snapshotClients(clients []*H2Client) StatsSnapshot {
 	var totalStats RequestStats
+	hist := newLatencyHistogram()
 
-	for _, client := range h.Clients {
-		stats := client.GetStats()
+	for _, client := range clients {
+		snapshot := client.Snapshot()
+		stats := snapshot.Stats
+		hist.Merge(snapshot.Histogram)
 		totalStats.TotalRequests += stats.TotalRequests
 		totalStats.SuccessRequests += stats.SuccessRequests
 		totalStats.FailedRequests += stats.FailedRequests
 		totalStats.TotalLatency += stats.TotalLatency
+		totalStats.RecycledConnections += stats.RecycledConnections
+		totalStats.EnhanceYourCalm += stats.EnhanceYourCalm
+		totalStats.RedirectsFollowed += stats.RedirectsFollowed
+		totalStats.Retries += stats.Retries
+		totalStats.SucceededAfterRetry += stats.SucceededAfterRetry
+		totalStats.TrailerMismatches += stats.TrailerMismatches
+		totalStats.GraphQLErrors += stats.GraphQLErrors
+		totalStats.StalledRequests += stats.StalledRequests
+		totalStats.StalledTime += stats.StalledTime
+		totalStats.QueuedRequests += stats.QueuedRequests
+		totalStats.QueueDelay += stats.QueueDelay
+		totalStats.MaxQueueDelay = max(totalStats.MaxQueueDelay, stats.MaxQueueDelay)
+		totalStats.Compression.merge(stats.Compression)
+		totalStats.BytesOut += stats.BytesOut
+		totalStats.ShedLogLines += stats.ShedLogLines
+		totalStats.Abandoned += stats.Abandoned
+		for i, n := range stats.StatusClasses {
+			totalStats.StatusClasses[i] += n
+		}
 
 		// For min latency, take the minimum across all clients (ignore zero values)
 		if totalStats.MinLatency == 0 || (stats.MinLatency > 0 && stats.MinLatency < totalStats.MinLatency) {
@@ -166,8 +725,116 @@ func (h *H2loadClient) GetTotalStats() RequestStats {
 			totalStats.Duration = stats.Duration
 		}
 	}
+	// Percentiles come from the merged histograms, not from per-client values
+	totalStats.setPercentiles(hist)
+
+	return StatsSnapshot{Time: time.Now(), Stats: totalStats, Histogram: hist}
+}
+
+// GetTrafficStats returns the bytes received and the connection times of all
+// clients combined
+func (h *H2loadClient) GetTrafficStats() TrafficStats {
+	var total TrafficStats
+	for _, client := range h.Clients {
+		total.merge(client.GetTrafficStats())
+	}
+	return total
+}
+
+// GetServerSettings returns the SETTINGS the server sent, as seen by the
+// first client that connected, nil if none did
+func (h *H2loadClient) GetServerSettings() ServerSettings {
+	for _, client := range h.Clients {
+		if s := client.ServerSettings(); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// GetCaptureStats returns how many failed responses were captured. It is
+// empty unless Capture.Dir is set.
+func (h *H2loadClient) GetCaptureStats() CaptureStats {
+	if len(h.Clients) == 0 || h.Clients[0].capturer == nil {
+		return CaptureStats{}
+	}
+	return h.Clients[0].capturer.stats()
+}
+
+// GetMemoryReport returns the memory use and load shedding of the last run.
+// It is empty unless MaxMemory is set.
+func (h *H2loadClient) GetMemoryReport() MemoryReport {
+	if h.memoryGuard == nil {
+		return MemoryReport{}
+	}
+	return h.memoryGuard.getReport()
+}
 
-	return totalStats
+// GetConcurrencyStats returns the requests in flight during the last run
+// against the configured concurrency
+func (h *H2loadClient) GetConcurrencyStats() ConcurrencyStats {
+	if h.concurrency == nil {
+		return ConcurrencyStats{}
+	}
+	return h.concurrency.result()
+}
+
+// GetGeneratorStats returns the generator's own resource use during the
+// last run
+func (h *H2loadClient) GetGeneratorStats() GeneratorStats {
+	if h.generator == nil {
+		return GeneratorStats{}
+	}
+	return h.generator.result()
+}
+
+// ResetStats zeroes the stats of all clients, e.g. after a warm-up or a
+// configuration change, so the stats that follow measure a fresh window. The
+// windows shared by the clients, like the abort window, start over with them.
+func (h *H2loadClient) ResetStats() {
+	// Nothing is sent while the clients and the shared recorders are zeroed
+	// one after another, so they all start the new window together
+	h.pauseMu.Lock()
+	defer h.pauseMu.Unlock()
+	if !h.paused {
+		h.pauseSends(true)
+		defer h.pauseSends(false)
+	}
+
+	for _, c := range h.Clients {
+		c.ResetStats()
+	}
+	if h.routes != nil {
+		h.routes.reset()
+		h.sizes.reset()
+	}
+	if h.spiker != nil {
+		h.spiker.reset()
+	}
+	if h.abortMonitor != nil {
+		h.abortMonitor.window.reset()
+	}
+	if h.series != nil {
+		h.series.reset()
+	}
+}
+
+// GetRouteStats returns the stats of every route (method and path, or the
+// name set with WithRoute) across all clients, sorted by route
+func (h *H2loadClient) GetRouteStats() []RouteStats {
+	if h.routes == nil {
+		return nil
+	}
+	return h.routes.snapshot(h.GetTotalStats().Duration)
+}
+
+// GetSizeBucketStats returns the stats of the requests by body size, in
+// buckets growing by powers of 4 from 1KiB, sorted by size
+func (h *H2loadClient) GetSizeBucketStats() []SizeBucketStats {
+	if h.sizes == nil {
+		return nil
+	}
+	return h.sizes.snapshot(h.GetTotalStats().Duration)
 }
 
 // GetAvgClientStats returns average statistics per client as RequestStats
@@ -182,8 +849,25 @@ func (h *H2loadClient) GetAvgClientStats() RequestStats {
 		FailedRequests:  int64(float64(totalStats.FailedRequests) / float64(clientCount)),
 		MinLatency:      totalStats.MinLatency, // Keep min/max as-is (not averages)
 		MaxLatency:      totalStats.MaxLatency,
+		P50Latency:      totalStats.P50Latency,
+		P90Latency:      totalStats.P90Latency,
+		P99Latency:      totalStats.P99Latency,
 		TotalLatency:    time.Duration(int64(totalStats.TotalLatency) / int64(clientCount)),
 		Duration:        totalStats.Duration, // Duration is per test, not per client
+
+		RecycledConnections: int64(float64(totalStats.RecycledConnections) / float64(clientCount)),
+		EnhanceYourCalm:     int64(float64(totalStats.EnhanceYourCalm) / float64(clientCount)),
+		RedirectsFollowed:   int64(float64(totalStats.RedirectsFollowed) / float64(clientCount)),
+		Retries:             int64(float64(totalStats.Retries) / float64(clientCount)),
+		SucceededAfterRetry: int64(float64(totalStats.SucceededAfterRetry) / float64(clientCount)),
+		TrailerMismatches:   int64(float64(totalStats.TrailerMismatches) / float64(clientCount)),
+		GraphQLErrors:       int64(float64(totalStats.GraphQLErrors) / float64(clientCount)),
+		StalledRequests:     int64(float64(totalStats.StalledRequests) / float64(clientCount)),
+		StalledTime:         time.Duration(int64(totalStats.StalledTime) / int64(clientCount)),
+		QueuedRequests:      int64(float64(totalStats.QueuedRequests) / float64(clientCount)),
+		QueueDelay:          time.Duration(int64(totalStats.QueueDelay) / int64(clientCount)),
+		MaxQueueDelay:       totalStats.MaxQueueDelay,
+		Abandoned:           int64(float64(totalStats.Abandoned) / float64(clientCount)),
 	}
 }
 
@@ -200,6 +884,26 @@ func (h *H2loadClient) GetClientStats(clientIndex int) string {
 	return h.Clients[clientIndex].GetStatsSummary()
 }
 
+// GetClientStatsStruct returns the statistics of a specific client. An
+// index out of range returns empty stats, see GetAllClientStats to walk
+// every client.
+func (h *H2loadClient) GetClientStatsStruct(clientIndex int) RequestStats {
+	if clientIndex < 0 || clientIndex >= len(h.Clients) {
+		return RequestStats{}
+	}
+	return h.Clients[clientIndex].GetStats()
+}
+
+// GetAllClientStats returns the statistics of every client, indexed like
+// Clients, e.g. to detect a client stuck on a slow connection
+func (h *H2loadClient) GetAllClientStats() []RequestStats {
+	stats := make([]RequestStats, len(h.Clients))
+	for i, c := range h.Clients {
+		stats[i] = c.GetStats()
+	}
+	return stats
+}
+
 func (h *H2loadClient) GetAllClientsStatsSummary() string {
 	stats := ""
 	for i, c := range h.Clients {
@@ -0,0 +1,175 @@
+package h2load
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// h2loadStat is a row of the min/max/mean/sd table of nghttp2's h2load
+type h2loadStat struct {
+	min, max, mean, sd float64
+	withinSd           float64 // Percentage of samples within one sd of the mean
+}
+
+// newH2loadStat computes the table row of values
+func newH2loadStat(values []float64) h2loadStat {
+	if len(values) == 0 {
+		return h2loadStat{}
+	}
+	s := h2loadStat{min: values[0], max: values[0]}
+	var sum float64
+	for _, v := range values {
+		s.min, s.max = min(s.min, v), max(s.max, v)
+		sum += v
+	}
+	s.mean = sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - s.mean) * (v - s.mean)
+	}
+	s.sd = math.Sqrt(variance / float64(len(values)))
+	var within int
+	for _, v := range values {
+		if math.Abs(v-s.mean) <= s.sd {
+			within++
+		}
+	}
+	s.withinSd = float64(within) / float64(len(values)) * 100
+	return s
+}
+
+// histogramH2loadStat computes the table row of a latency histogram, in
+// seconds
+func histogramH2loadStat(hist *hdrhistogram.Histogram) h2loadStat {
+	if hist.TotalCount() == 0 {
+		return h2loadStat{}
+	}
+	s := h2loadStat{
+		min:  float64(hist.Min()) / 1e6,
+		max:  float64(hist.Max()) / 1e6,
+		mean: hist.Mean() / 1e6,
+		sd:   hist.StdDev() / 1e6,
+	}
+	var within int64
+	for _, bar := range hist.Distribution() {
+		v := float64(bar.From+bar.To) / 2 / 1e6
+		if math.Abs(v-s.mean) <= s.sd {
+			within += bar.Count
+		}
+	}
+	s.withinSd = float64(within) / float64(hist.TotalCount()) * 100
+	return s
+}
+
+func durationSeconds(durations []time.Duration) []float64 {
+	values := make([]float64, len(durations))
+	for i, d := range durations {
+		values[i] = d.Seconds()
+	}
+	return values
+}
+
+// FormatH2loadHeader formats the lines h2load prints when a run starts, for
+// totalRequests requests or, when it is 0, for duration
+func FormatH2loadHeader(clients, totalRequests int, duration time.Duration) string {
+	var b strings.Builder
+	b.WriteString("starting benchmark...\n")
+	if totalRequests > 0 {
+		fmt.Fprintf(&b, "spawning thread #0: %d total client(s). %d total requests\n", clients, totalRequests)
+	} else {
+		fmt.Fprintf(&b, "spawning thread #0: %d total client(s). Timing-based test with 0s of warm-up time and %s of main duration for measurements.\n",
+			clients, h2loadDurationStr(duration))
+	}
+	b.WriteString("Application protocol: h2")
+	return b.String()
+}
+
+// FormatH2loadSummary formats the results of the last run in the stdout
+// layout of nghttp2's h2load, for parsers and dashboards built around it
+func FormatH2loadSummary(h *H2loadClient, duration time.Duration) string {
+	snapshot := h.Snapshot()
+	stats := snapshot.Stats
+	traffic := h.GetTrafficStats()
+	clients := len(h.Clients)
+	sent := h.GetSentRequests()
+	total := sent
+	if h.ClientsConf.Requests > 0 {
+		total = max(int64(h.ClientsConf.Requests*clients), sent)
+	}
+	secs := duration.Seconds()
+	if secs <= 0 {
+		secs = 1
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "finished in %s, %.2f req/s, %sB/s\n",
+		h2loadFormatDuration(duration.Seconds()), float64(stats.SuccessRequests)/secs,
+		h2loadFuint(int64(float64(traffic.BytesTotal)/secs)))
+	fmt.Fprintf(&b, "requests: %d total, %d started, %d done, %d succeeded, %d failed, %d errored, %d timeout\n",
+		total, sent, stats.TotalRequests, stats.SuccessRequests, total-stats.SuccessRequests, stats.StatusClasses[0], 0)
+	c := stats.StatusClasses
+	fmt.Fprintf(&b, "status codes: %d 2xx, %d 3xx, %d 4xx, %d 5xx\n", c[2], c[3], c[4], c[5])
+	fmt.Fprintf(&b, "traffic: %sB (%d) total, %sB (%d) headers (space savings %.2f%%), %sB (%d) data\n",
+		h2loadFuint(traffic.BytesTotal), traffic.BytesTotal,
+		h2loadFuint(traffic.BytesHeaders), traffic.BytesHeaders, traffic.HeaderSpaceSavings()*100,
+		h2loadFuint(traffic.BytesData), traffic.BytesData)
+
+	rps := make([]float64, 0, clients)
+	for _, client := range h.Clients {
+		rps = append(rps, float64(client.GetStats().SuccessRequests)/secs)
+	}
+	b.WriteString("                     min         max         mean         sd        +/- sd\n")
+	writeTimeRow := func(name string, s h2loadStat) {
+		fmt.Fprintf(&b, "%s: %10s  %10s  %10s  %10s%9.2f%%\n", name,
+			h2loadFormatDuration(s.min), h2loadFormatDuration(s.max),
+			h2loadFormatDuration(s.mean), h2loadFormatDuration(s.sd), s.withinSd)
+	}
+	writeTimeRow("time for request", histogramH2loadStat(snapshot.Histogram))
+	writeTimeRow("time for connect", newH2loadStat(durationSeconds(traffic.ConnectTimes)))
+	writeTimeRow("time to 1st byte", newH2loadStat(durationSeconds(traffic.FirstByteTimes)))
+	r := newH2loadStat(rps)
+	fmt.Fprintf(&b, "req/s           : %10.2f  %10.2f  %10.2f  %10.2f%9.2f%%", r.min, r.max, r.mean, r.sd, r.withinSd)
+	return b.String()
+}
+
+// h2loadFormatDuration formats seconds the way h2load does: whole
+// microseconds below 1ms, otherwise 2 decimals of ms or s
+func h2loadFormatDuration(t float64) string {
+	switch {
+	case t >= 1:
+		return fmt.Sprintf("%.2fs", t)
+	case t >= 0.001:
+		return fmt.Sprintf("%.2fms", t*1000)
+	}
+	return fmt.Sprintf("%dus", int64(math.Round(t*1e6)))
+}
+
+// h2loadFuint formats a byte count with a binary K, M or G unit
+func h2loadFuint(n int64) string {
+	units := []string{"G", "M", "K"}
+	for i, shift := range []uint{30, 20, 10} {
+		if n >= 1<<shift {
+			return fmt.Sprintf("%.2f%s", float64(n)/float64(int64(1)<<shift), units[i])
+		}
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// h2loadDurationStr formats a test duration in its largest whole unit
+func h2loadDurationStr(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
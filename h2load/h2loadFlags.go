@@ -0,0 +1,137 @@
+package h2load
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// h2loadNumericOptions are the single-letter nghttp2 h2load options taking a
+// number, which h2load also accepts attached, e.g. -n10000
+const h2loadNumericOptions = "nctmD"
+
+// h2loadArgs translates nghttp2 h2load options into run options, so scripts
+// written for h2load keep working. -n is the total number of requests, split
+// across the clients, and -m the streams per client.
+func h2loadArgs(args []string, stderr io.Writer) ([]string, error) {
+	fs := flag.NewFlagSet("h2load", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		requests, clients, streams, rps int
+		duration                        string
+		h1                              bool
+		headers                         http.Header
+	)
+	fs.IntVar(&requests, "n", 1, "Total number of requests")
+	fs.IntVar(&requests, "requests", 1, "Total number of requests")
+	fs.IntVar(&clients, "c", 1, "Number of concurrent clients")
+	fs.IntVar(&clients, "clients", 1, "Number of concurrent clients")
+	fs.Int("t", 1, "Number of native threads (ignored)")
+	fs.Int("threads", 1, "Number of native threads (ignored)")
+	fs.IntVar(&streams, "m", 1, "Max concurrent streams per client")
+	fs.IntVar(&streams, "max-concurrent-streams", 1, "Max concurrent streams per client")
+	fs.Var(&headerValue{&headers}, "H", "Header added to every request (repeatable)")
+	fs.Var(&headerValue{&headers}, "header", "Header added to every request (repeatable)")
+	fs.StringVar(&duration, "D", "", "Duration of the main measurement, in seconds or with a unit (e.g. 10s)")
+	fs.StringVar(&duration, "duration", "", "Duration of the main measurement, in seconds or with a unit")
+	fs.IntVar(&rps, "rps", 0, "Requests per second of each client")
+	fs.BoolVar(&h1, "h1", false, "Use HTTP/1.1 (not supported)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s h2load [options] <URI>\n\n", os.Args[0])
+		fmt.Fprintf(stderr, "Runs a load test with nghttp2 h2load's options and output. Also used when\n")
+		fmt.Fprintf(stderr, "the binary is invoked as 'h2load', e.g. through a symlink.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(splitShortOptions(args)); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case h1:
+		return nil, fmt.Errorf("--h1: HTTP/1.1 is not supported")
+	case fs.NArg() == 0:
+		return nil, fmt.Errorf("no URI given")
+	case fs.NArg() > 1:
+		return nil, fmt.Errorf("only one URI is supported, got %d", fs.NArg())
+	case clients < 1:
+		return nil, fmt.Errorf("-c: the number of clients must be greater than 0")
+	case requests < clients && duration == "":
+		return nil, fmt.Errorf("-n: the number of requests must be greater than or equal to the clients")
+	}
+
+	out := []string{
+		"-url", fs.Arg(0),
+		"-c", strconv.Itoa(clients),
+		"-s", strconv.Itoa(streams),
+		"-output-compat", "h2load",
+		"-stats=false",
+		"-charts=false",
+	}
+	if duration != "" {
+		d, err := parseH2loadDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("-D: %w", err)
+		}
+		out = append(out, "-duration", d.String())
+	} else {
+		if requests%clients != 0 {
+			fmt.Fprintf(stderr, "Warning: -n %d is not a multiple of -c %d, sending %d requests\n",
+				requests, clients, requests/clients*clients)
+		}
+		out = append(out, "-n", strconv.Itoa(requests/clients))
+	}
+	if rps > 0 {
+		out = append(out, "-rps", strconv.Itoa(rps))
+	}
+	for name, values := range headers {
+		for _, v := range values {
+			out = append(out, "-header", name+": "+v)
+		}
+	}
+	return out, nil
+}
+
+// splitShortOptions splits attached values of single-letter options, so
+// -n10000 becomes -n 10000 and -Hname:value becomes -H name:value. Long
+// options given with a single dash, e.g. -threads, are left as they are.
+func splitShortOptions(args []string) []string {
+	var out []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		if len(arg) > 2 && arg[0] == '-' && arg[2] != '=' &&
+			(arg[1] == 'H' || strings.IndexByte(h2loadNumericOptions, arg[1]) >= 0 && arg[2] >= '0' && arg[2] <= '9') {
+			out = append(out, arg[:2], arg[2:])
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// parseH2loadDuration parses a duration in seconds, or with a unit
+func parseH2loadDuration(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// h2loadMain runs a load test given nghttp2 h2load options
+func h2loadMain(args []string) {
+	runArgs, err := h2loadArgs(args, os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	runMain(runArgs)
+}
@@ -0,0 +1,274 @@
+package h2load
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"golang.org/x/net/http2"
+)
+
+// handshakeTimeout bounds a whole handshake, from dialing to the server's
+// SETTINGS
+const handshakeTimeout = 10 * time.Second
+
+// HandshakeConf repeatedly opens connections, completes the TCP, TLS and
+// HTTP/2 handshakes and closes them without sending a request, to measure
+// connection setup apart from request throughput. TLS sessions aren't
+// resumed, so every handshake is a full one.
+type HandshakeConf struct {
+	Rate     int           // Handshakes per second started by the whole run (0 = as fast as they complete)
+	Duration time.Duration // How long handshakes are made (0 = the conf's Requests per client)
+}
+
+func (c *HandshakeConf) Validate(conf H2loadConf) error {
+	if c.Rate < 0 {
+		return fmt.Errorf("handshake rate must be greater than 0")
+	}
+	if c.Duration < 0 {
+		return fmt.Errorf("handshake duration must be greater than 0")
+	}
+	if c.Duration == 0 && conf.Requests == 0 {
+		return fmt.Errorf("handshakes need a duration or a number of requests")
+	}
+	return nil
+}
+
+// HandshakeStats are the outcome of a handshake run
+type HandshakeStats struct {
+	Handshakes      int64 // Handshakes completed
+	ConnectFailures int64 // Failed to resolve, connect or complete TLS
+	H2Failures      int64 // Connected, but the HTTP/2 preface exchange failed
+	Duration        time.Duration
+
+	// Time to connect, including the TLS handshake
+	P50Connect time.Duration
+	P90Connect time.Duration
+	P99Connect time.Duration
+	MaxConnect time.Duration
+
+	// Time to the server's SETTINGS, the whole handshake
+	P50Handshake time.Duration
+	P90Handshake time.Duration
+	P99Handshake time.Duration
+	MaxHandshake time.Duration
+
+	LastError string
+}
+
+// Rate returns the completed handshakes per second
+func (s HandshakeStats) Rate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Handshakes) / s.Duration.Seconds()
+}
+
+// String formats the HandshakeStats as a readable string
+func (s HandshakeStats) String() string {
+	summary := fmt.Sprintf(`Handshake Statistics:
+Handshakes: %d (%.2f/s)
+Failures: %d (%d connect, %d HTTP/2)
+Connect (TCP+TLS): p50 %v, p90 %v, p99 %v, max %v
+Handshake (to server SETTINGS): p50 %v, p90 %v, p99 %v, max %v
+Total Duration: %v`,
+		s.Handshakes, s.Rate(),
+		s.ConnectFailures+s.H2Failures, s.ConnectFailures, s.H2Failures,
+		s.P50Connect, s.P90Connect, s.P99Connect, s.MaxConnect,
+		s.P50Handshake, s.P90Handshake, s.P99Handshake, s.MaxHandshake,
+		s.Duration)
+	if s.LastError != "" {
+		summary += fmt.Sprintf("\nLast Error: %s", s.LastError)
+	}
+	return summary
+}
+
+// handshakeCollector gathers the stats of all handshakes of a run
+type handshakeCollector struct {
+	mu                 sync.Mutex
+	handshakes         int64
+	connectFailures    int64
+	h2Failures         int64
+	connects, complete *hdrhistogram.Histogram
+	lastError          string
+}
+
+func newHandshakeCollector() *handshakeCollector {
+	return &handshakeCollector{connects: newLatencyHistogram(), complete: newLatencyHistogram()}
+}
+
+func (c *handshakeCollector) connectFailed(err error) {
+	c.mu.Lock()
+	c.connectFailures++
+	c.lastError = err.Error()
+	c.mu.Unlock()
+}
+
+func (c *handshakeCollector) h2Failed(err error) {
+	c.mu.Lock()
+	c.h2Failures++
+	c.lastError = err.Error()
+	c.mu.Unlock()
+}
+
+func (c *handshakeCollector) done(connect, handshake time.Duration) {
+	c.mu.Lock()
+	c.handshakes++
+	recordLatency(c.connects, connect)
+	recordLatency(c.complete, handshake)
+	c.mu.Unlock()
+}
+
+func (c *handshakeCollector) stats(duration time.Duration) HandshakeStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return HandshakeStats{
+		Handshakes:      c.handshakes,
+		ConnectFailures: c.connectFailures,
+		H2Failures:      c.h2Failures,
+		Duration:        duration,
+		P50Connect:      latencyPercentile(c.connects, 50),
+		P90Connect:      latencyPercentile(c.connects, 90),
+		P99Connect:      latencyPercentile(c.connects, 99),
+		MaxConnect:      latencyPercentile(c.connects, 100),
+		P50Handshake:    latencyPercentile(c.complete, 50),
+		P90Handshake:    latencyPercentile(c.complete, 90),
+		P99Handshake:    latencyPercentile(c.complete, 99),
+		MaxHandshake:    latencyPercentile(c.complete, 100),
+		LastError:       c.lastError,
+	}
+}
+
+// RunHandshakes makes handshakes with conf's clients: every client makes
+// ConcurrentStreams at a time until hs.Duration is over or it has made
+// conf.Requests, all of them sharing hs.Rate
+func RunHandshakes(conf H2loadConf, hs HandshakeConf) (HandshakeStats, error) {
+	if err := hs.Validate(conf); err != nil {
+		return HandshakeStats{}, err
+	}
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return HandshakeStats{}, err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	if hs.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hs.Duration)
+		defer cancel()
+	}
+	var limiter *rpsLimiter
+	if hs.Rate > 0 {
+		limiter = newRpsLimiter(hs.Rate, RpsModeEven, 0)
+		defer limiter.close()
+	}
+	c := newHandshakeCollector()
+	start := time.Now()
+	errs := RunConcurrent(client.Clients, func(h *H2Client) error {
+		return h.runHandshakes(ctx, hs, limiter, c)
+	})
+	if err := JoinIndexedErrors(errs); err != nil {
+		return c.stats(time.Since(start)), err
+	}
+	return c.stats(time.Since(start)), nil
+}
+
+// runHandshakes runs ConcurrentStreams handshakes at a time, until ctx ends
+// or Conf.Requests handshakes were made
+func (h *H2Client) runHandshakes(ctx context.Context, hs HandshakeConf, limiter *rpsLimiter, c *handshakeCollector) error {
+	// Nothing is sent through the request path, its collectors can stop
+	defer h.closeChannels()
+	dial, _, err := h.dialer()
+	if err != nil {
+		return err
+	}
+	var claimed int64
+	claim := func() bool {
+		if limiter != nil && !limiter.wait(ctx) {
+			return false
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		return hs.Duration > 0 || atomic.AddInt64(&claimed, 1) <= int64(h.Conf.Requests)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < max(h.Conf.ConcurrentStreams, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for claim() {
+				handshake(ctx, dial, c)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// handshake dials a connection, exchanges the HTTP/2 prefaces and closes it.
+// Failures caused by the end of the run aren't counted.
+func handshake(run context.Context, dial func(ctx context.Context) (net.Conn, error), c *handshakeCollector) {
+	ctx, cancel := context.WithTimeout(run, handshakeTimeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := dial(ctx)
+	if err != nil {
+		if run.Err() == nil {
+			c.connectFailed(err)
+		}
+		return
+	}
+	defer conn.Close()
+	connected := time.Since(start)
+	if tc, ok := conn.(*tls.Conn); ok && tc.ConnectionState().NegotiatedProtocol != "h2" {
+		c.h2Failed(fmt.Errorf("server negotiated %q instead of h2", tc.ConnectionState().NegotiatedProtocol))
+		return
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if _, err := prefaceExchange(conn); err != nil {
+		if run.Err() == nil {
+			c.h2Failed(err)
+		}
+		return
+	}
+	c.done(connected, time.Since(start))
+}
+
+// prefaceExchange sends the client preface and SETTINGS, and reads frames
+// until the server's SETTINGS, which it acknowledges and returns
+func prefaceExchange(conn net.Conn) (ServerSettings, error) {
+	if _, err := conn.Write([]byte(http2.ClientPreface)); err != nil {
+		return nil, err
+	}
+	fr := http2.NewFramer(conn, conn)
+	if err := fr.WriteSettings(); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return nil, fmt.Errorf("reading server SETTINGS: %w", err)
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				settings := ServerSettings{}
+				f.ForeachSetting(func(s http2.Setting) error {
+					settings = append(settings, s)
+					return nil
+				})
+				return settings, fr.WriteSettingsAck()
+			}
+		case *http2.GoAwayFrame:
+			return nil, fmt.Errorf("GOAWAY %v before SETTINGS", f.ErrCode)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package h2load
+
+import (
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Latency histograms record microseconds from 1µs to 1h with 3 significant digits
+const (
+	histMinValue = 1
+	histMaxValue = int64(time.Hour / time.Microsecond)
+	histSigFigs  = 3
+)
+
+func newLatencyHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(histMinValue, histMaxValue, histSigFigs)
+}
+
+// recordLatency records d, clamping it to the histogram's trackable range
+func recordLatency(hist *hdrhistogram.Histogram, d time.Duration) {
+	v := d.Microseconds()
+	if v < histMinValue {
+		v = histMinValue
+	} else if v > histMaxValue {
+		v = histMaxValue
+	}
+	hist.RecordValue(v)
+}
+
+// latencyPercentile returns the latency at percentile p (0-100) of hist
+func latencyPercentile(hist *hdrhistogram.Histogram, p float64) time.Duration {
+	if hist.TotalCount() == 0 {
+		return 0
+	}
+	return time.Duration(hist.ValueAtQuantile(p)) * time.Microsecond
+}
+
+// setPercentiles fills the percentile fields of r from hist
+func (r *RequestStats) setPercentiles(hist *hdrhistogram.Histogram) {
+	r.P50Latency = latencyPercentile(hist, 50)
+	r.P90Latency = latencyPercentile(hist, 90)
+	r.P99Latency = latencyPercentile(hist, 99)
+}
@@ -0,0 +1,157 @@
+package h2load
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Tags of the histograms persisted during a run
+const (
+	HistogramTagInterval = "interval" // Requests completed during one interval
+	HistogramTagTotal    = "total"    // All requests of the run
+)
+
+// HistogramStore persists raw latency histograms, with values in microseconds,
+// so percentiles can be recomputed at any granularity after the run
+type HistogramStore interface {
+	StoreHistogram(tag string, start, end time.Time, hist *hdrhistogram.Histogram) error
+}
+
+// HistogramLogStore is a HistogramStore writing the standard HdrHistogram
+// interval log format, readable by HistogramLogAnalyzer, hdr-plot and
+// ReadHistogramLog. Timestamps are relative to the first stored histogram.
+type HistogramLogStore struct {
+	mu    sync.Mutex
+	out   io.Writer
+	start time.Time // Zero until the header is written
+}
+
+func NewHistogramLogStore(out io.Writer) *HistogramLogStore {
+	return &HistogramLogStore{out: out}
+}
+
+// StoreHistogram implements HistogramStore
+func (s *HistogramLogStore) StoreHistogram(tag string, start, end time.Time, hist *hdrhistogram.Histogram) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.start.IsZero() {
+		if err := s.writeHeader(start); err != nil {
+			return err
+		}
+		s.start = start
+	}
+
+	payload, err := hist.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	if err != nil {
+		return fmt.Errorf("failed to encode histogram: %w", err)
+	}
+	// Interval max is logged in milliseconds, as for the nanosecond histograms
+	// of the reference implementation
+	_, err = fmt.Fprintf(s.out, "Tag=%s,%.3f,%.3f,%.3f,%s\n",
+		tag,
+		start.Sub(s.start).Seconds(),
+		end.Sub(start).Seconds(),
+		float64(hist.Max())/1000,
+		payload)
+	return err
+}
+
+func (s *HistogramLogStore) writeHeader(start time.Time) error {
+	w := hdrhistogram.NewHistogramLogWriter(s.out)
+	if err := w.OutputLogFormatVersion(); err != nil {
+		return err
+	}
+	if err := w.OutputComment("Latency values in microseconds, written by h2loadGo"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.out, "#[StartTime: %.3f (seconds since epoch), %s]\n",
+		float64(start.UnixMilli())/1000, start.UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return w.OutputLegend()
+}
+
+// ReadHistogramLog reads the histograms with the given tag from an HdrHistogram
+// interval log, or all histograms if tag is empty
+func ReadHistogramLog(r io.Reader, tag string) ([]*hdrhistogram.Histogram, error) {
+	reader := hdrhistogram.NewHistogramLogReader(r)
+	var hists []*hdrhistogram.Histogram
+	for {
+		hist, err := reader.NextIntervalHistogram()
+		if err != nil {
+			return hists, fmt.Errorf("failed to read histogram log: %w", err)
+		}
+		if hist == nil {
+			return hists, nil
+		}
+		if tag == "" || hist.Tag() == tag {
+			hists = append(hists, hist)
+		}
+	}
+}
+
+// histogramPersister stores the latency histogram of every interval of a run,
+// and the total at the end
+type histogramPersister struct {
+	store    HistogramStore
+	interval time.Duration
+	window   *statsWindow
+	stop     chan struct{}
+	stopped  chan struct{}
+	err      error // First store error, owned by run until stopped is closed
+}
+
+func newHistogramPersister(store HistogramStore, interval time.Duration) *histogramPersister {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &histogramPersister{
+		store:    store,
+		interval: interval,
+		window:   newStatsWindow(),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// run stores a histogram every interval until finish is called
+func (p *histogramPersister) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.storeInterval(); err != nil && p.err == nil {
+				p.err = err
+			}
+		}
+	}
+}
+
+// storeInterval stores the histogram of the requests since the last call
+func (p *histogramPersister) storeInterval() error {
+	stats, hist := p.window.take()
+	end := time.Now()
+	return p.store.StoreHistogram(HistogramTagInterval, end.Add(-stats.Duration), end, hist)
+}
+
+// finish stops run, then stores the last partial interval and the total
+// histogram of the run. It returns the first error of the whole run.
+func (p *histogramPersister) finish(start time.Time, total *hdrhistogram.Histogram) error {
+	close(p.stop)
+	<-p.stopped
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.storeInterval(); err != nil {
+		return err
+	}
+	return p.store.StoreHistogram(HistogramTagTotal, start, time.Now(), total)
+}
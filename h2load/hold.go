@@ -0,0 +1,233 @@
+package h2load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"golang.org/x/net/http2"
+)
+
+// HoldConf opens connections and holds them idle without sending requests,
+// to test the server's connection limits and idle timeouts. The connections
+// are spread over the conf's clients.
+type HoldConf struct {
+	Connections int           // Connections opened by the whole run
+	Rate        int           // Connections opened per second (0 = all at once)
+	Ping        time.Duration // Interval of the PINGs sent on every connection (0 = none)
+	Duration    time.Duration // How long the connections are held
+}
+
+func (c *HoldConf) Validate() error {
+	if c.Connections <= 0 {
+		return fmt.Errorf("hold connections must be greater than 0")
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("hold rate must be greater than 0")
+	}
+	if c.Ping < 0 {
+		return fmt.Errorf("hold ping interval must be greater than 0")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("holding connections needs a duration")
+	}
+	return nil
+}
+
+// HoldStats are the outcome of a hold run
+type HoldStats struct {
+	Opened       int64 // Connections opened
+	OpenFailures int64 // Connections that couldn't be opened
+	Held         int64 // Connections still open at the end of the run
+	GoAways      int64 // Connections closed by the server with a GOAWAY
+	Dropped      int64 // Connections closed by the server without a GOAWAY
+	Duration     time.Duration
+
+	// How long the connections closed by the server were held
+	MinClosedAfter time.Duration
+	P50ClosedAfter time.Duration
+	MaxClosedAfter time.Duration
+
+	PingsSent  int64
+	PingsAcked int64
+	P50Ping    time.Duration
+	P99Ping    time.Duration
+	MaxPing    time.Duration
+
+	LastError string
+}
+
+// String formats the HoldStats as a readable string
+func (s HoldStats) String() string {
+	summary := fmt.Sprintf(`Connection Hold Statistics:
+Opened: %d (%d failed)
+Held to the End: %d
+Closed by Server: %d (%d GOAWAY, %d dropped)`,
+		s.Opened, s.OpenFailures, s.Held, s.GoAways+s.Dropped, s.GoAways, s.Dropped)
+	if s.GoAways+s.Dropped > 0 {
+		summary += fmt.Sprintf("\nClosed After: min %v, p50 %v, max %v", s.MinClosedAfter, s.P50ClosedAfter, s.MaxClosedAfter)
+	}
+	if s.PingsSent > 0 {
+		summary += fmt.Sprintf("\nPINGs: %d sent, %d acked\nPING RTT: p50 %v, p99 %v, max %v",
+			s.PingsSent, s.PingsAcked, s.P50Ping, s.P99Ping, s.MaxPing)
+	}
+	summary += fmt.Sprintf("\nTotal Duration: %v", s.Duration)
+	if s.LastError != "" {
+		summary += fmt.Sprintf("\nLast Error: %s", s.LastError)
+	}
+	return summary
+}
+
+// holdCollector gathers the stats of all connections of a run
+type holdCollector struct {
+	mu                     sync.Mutex
+	stats                  HoldStats
+	closedAfter, pingTimes *hdrhistogram.Histogram
+}
+
+func newHoldCollector() *holdCollector {
+	return &holdCollector{closedAfter: newLatencyHistogram(), pingTimes: newLatencyHistogram()}
+}
+
+func (c *holdCollector) update(fn func(s *HoldStats)) {
+	c.mu.Lock()
+	fn(&c.stats)
+	c.mu.Unlock()
+}
+
+// closed records a connection the server closed after held, with err
+func (c *holdCollector) closed(held time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if errors.As(err, new(http2.GoAwayError)) {
+		c.stats.GoAways++
+	} else {
+		c.stats.Dropped++
+	}
+	c.stats.LastError = err.Error()
+	recordLatency(c.closedAfter, held)
+}
+
+func (c *holdCollector) ping(rtt time.Duration) {
+	c.mu.Lock()
+	c.stats.PingsAcked++
+	recordLatency(c.pingTimes, rtt)
+	c.mu.Unlock()
+}
+
+func (c *holdCollector) result(duration time.Duration) HoldStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Duration = duration
+	if c.closedAfter.TotalCount() > 0 {
+		s.MinClosedAfter = time.Duration(c.closedAfter.Min()) * time.Microsecond
+	}
+	s.P50ClosedAfter = latencyPercentile(c.closedAfter, 50)
+	s.MaxClosedAfter = latencyPercentile(c.closedAfter, 100)
+	s.P50Ping = latencyPercentile(c.pingTimes, 50)
+	s.P99Ping = latencyPercentile(c.pingTimes, 99)
+	s.MaxPing = latencyPercentile(c.pingTimes, 100)
+	return s
+}
+
+// RunHold opens hold.Connections connections with conf's clients and holds
+// them for hold.Duration
+func RunHold(conf H2loadConf, hold HoldConf) (HoldStats, error) {
+	if err := hold.Validate(); err != nil {
+		return HoldStats{}, err
+	}
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return HoldStats{}, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), hold.Duration)
+	defer cancel()
+	var limiter *rpsLimiter
+	if hold.Rate > 0 {
+		limiter = newRpsLimiter(hold.Rate, RpsModeEven, 0)
+		defer limiter.close()
+	}
+	c := newHoldCollector()
+	start := time.Now()
+	clients := len(client.Clients)
+	errs := RunConcurrent(client.Clients, func(h *H2Client) error {
+		n := hold.Connections / clients
+		if h.ID < hold.Connections%clients {
+			n++
+		}
+		return h.runHold(ctx, n, hold, limiter, c)
+	})
+	return c.result(time.Since(start)), JoinIndexedErrors(errs)
+}
+
+// runHold opens n connections and holds them until ctx ends
+func (h *H2Client) runHold(ctx context.Context, n int, hold HoldConf, limiter *rpsLimiter, c *holdCollector) error {
+	// Nothing is sent through the request path, its collectors can stop
+	defer h.closeChannels()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		if limiter != nil && !limiter.wait(ctx) {
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.holdConn(ctx, hold, c)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// holdConn opens a connection and holds it, pinging it every hold.Ping,
+// until ctx ends or the server closes it
+func (h *H2Client) holdConn(ctx context.Context, hold HoldConf, c *holdCollector) {
+	pc, err := h.dialH2Conn(ctx, nil, false)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.update(func(s *HoldStats) {
+				s.OpenFailures++
+				s.LastError = err.Error()
+			})
+		}
+		return
+	}
+	defer pc.close()
+	opened := time.Now()
+	c.update(func(s *HoldStats) { s.Opened++ })
+
+	var pings <-chan time.Time
+	if hold.Ping > 0 {
+		ticker := time.NewTicker(hold.Ping)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			c.update(func(s *HoldStats) { s.Held++ })
+			return
+		case <-pc.closed:
+			c.closed(time.Since(opened), pc.closeErr())
+			return
+		case <-pings:
+			c.update(func(s *HoldStats) { s.PingsSent++ })
+			// A lost ack shows as a missing one, the connection is watched
+			// for the server closing it
+			pingCtx, cancel := context.WithTimeout(ctx, hold.Ping)
+			if rtt, err := pc.ping(pingCtx); err == nil {
+				c.ping(rtt)
+			}
+			cancel()
+		}
+	}
+}
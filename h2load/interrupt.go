@@ -0,0 +1,35 @@
+package h2load
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// abortOnInterrupt aborts the run of h on the first SIGINT or SIGTERM, so an
+// interrupted run still finishes its logs, report and notification. Another
+// signal after it kills the process as usual. The returned function stops
+// watching.
+func abortOnInterrupt(h *H2loadClient) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case sig := <-signals:
+			signal.Stop(signals)
+			name := "SIGINT"
+			if sig == syscall.SIGTERM {
+				name = "SIGTERM"
+			}
+			log.Printf("%s: stopping the run, send it again to quit at once", name)
+			h.abort("interrupted by " + name)
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
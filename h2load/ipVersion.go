@@ -0,0 +1,161 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Address families of ConnectionStats.Family
+const (
+	FamilyIPv4 = "IPv4"
+	FamilyIPv6 = "IPv6"
+)
+
+// happyEyeballsDelay is how long a dual-stack dial gives the preferred
+// family before racing the other one, as in RFC 8305 and Go's net.Dialer
+const happyEyeballsDelay = 300 * time.Millisecond
+
+// addrFamily returns the address family of addr, a host and port or an IP
+func addrFamily(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return FamilyIPv6
+	}
+	return FamilyIPv4
+}
+
+// familyAddrs keeps the addresses of addrs that IP version version dials,
+// all of them for version 0
+func familyAddrs(addrs []string, version int) []string {
+	if version == 0 {
+		return addrs
+	}
+	want := FamilyIPv4
+	if version == 6 {
+		want = FamilyIPv6
+	}
+	var kept []string
+	for _, addr := range addrs {
+		if addrFamily(addr) == want {
+			kept = append(kept, addr)
+		}
+	}
+	return kept
+}
+
+// dialNetwork returns the network IP version version dials
+func dialNetwork(version int) string {
+	switch version {
+	case 4:
+		return "tcp4"
+	case 6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// dialDualStack dials addrs with Happy Eyeballs: the addresses of the
+// family of the first one in order, then after happyEyeballsDelay, or as soon
+// as they all failed, the others in parallel. The first connection made is
+// returned and the other closed.
+func dialDualStack(ctx context.Context, addrs []string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	var primary, fallback []string
+	for _, addr := range addrs {
+		if addrFamily(addr) == addrFamily(addrs[0]) {
+			primary = append(primary, addr)
+		} else {
+			fallback = append(fallback, addr)
+		}
+	}
+	if len(fallback) == 0 {
+		return dialFirst(ctx, primary, "tcp", dial)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	race := func(addrs []string) {
+		go func() {
+			conn, err := dialFirst(ctx, addrs, "tcp", dial)
+			results <- dialResult{conn, err}
+		}()
+	}
+	race(primary)
+	timer := time.NewTimer(happyEyeballsDelay)
+	defer timer.Stop()
+	racing, fellBack := 1, false
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fellBack {
+				fellBack = true
+				racing++
+				race(fallback)
+			}
+		case r := <-results:
+			racing--
+			if r.err == nil {
+				if racing > 0 {
+					// The other family lost, drop its connection if it makes one
+					go func() {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !fellBack {
+				fellBack = true
+				racing++
+				race(fallback)
+			} else if racing == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// FormatAddressFamilies formats how many connections used each address
+// family, e.g. "Address families: 8 connections over IPv6, 2 over IPv4"
+func FormatAddressFamilies(conns []ConnectionStats) string {
+	counts := make(map[string]int)
+	for _, c := range conns {
+		counts[c.Family]++
+	}
+	families := make([]string, 0, len(counts))
+	for family := range counts {
+		families = append(families, family)
+	}
+	// Most used first
+	sort.Slice(families, func(i, j int) bool {
+		if counts[families[i]] != counts[families[j]] {
+			return counts[families[i]] > counts[families[j]]
+		}
+		return families[i] < families[j]
+	})
+	parts := make([]string, len(families))
+	for i, family := range families {
+		if i == 0 {
+			parts[i] = fmt.Sprintf("%d connections over %s", counts[family], family)
+		} else {
+			parts[i] = fmt.Sprintf("%d over %s", counts[family], family)
+		}
+	}
+	return "Address families: " + strings.Join(parts, ", ")
+}
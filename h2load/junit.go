@@ -0,0 +1,83 @@
+package h2load
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// CheckResult is the outcome of one threshold or assertion of a run
+type CheckResult struct {
+	Name    string
+	Failure string // Why the check failed, "" if it passed
+}
+
+// Passed reports whether the check passed
+func (c CheckResult) Passed() bool {
+	return c.Failure == ""
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes checks as a JUnit XML test suite named suite, one test
+// case per check, so CI servers can show them as tests
+func WriteJUnit(w io.Writer, suite string, start time.Time, duration time.Duration, checks []CheckResult) error {
+	s := junitTestSuite{
+		Name:      suite,
+		Tests:     len(checks),
+		Time:      fmt.Sprintf("%.3f", duration.Seconds()),
+		Timestamp: start.UTC().Format("2006-01-02T15:04:05"),
+	}
+	for _, c := range checks {
+		tc := junitTestCase{Name: c.Name, ClassName: suite}
+		if !c.Passed() {
+			s.Failures++
+			tc.Failure = &junitFailure{Message: c.Failure, Text: c.Failure}
+		}
+		s.Cases = append(s.Cases, tc)
+	}
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{s}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return err
+}
+
+// WriteJUnitFile writes checks to a JUnit XML file at path
+func WriteJUnitFile(path, suite string, start time.Time, duration time.Duration, checks []CheckResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteJUnit(f, suite, start, duration, checks); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,121 @@
+package h2load
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// generatorBoundShare is the generator-side share of latency above which the
+// load generator itself is likely the bottleneck
+const generatorBoundShare = 0.2
+
+// LatencyProfile attributes the latency of sampled requests to where it was
+// spent. All durations are averages over the sampled requests.
+type LatencyProfile struct {
+	Samples    int64
+	Scheduling time.Duration // Request eligible (token and stream acquired) -> request started
+	ConnWait   time.Duration // Request started -> connection acquired
+	StreamWait time.Duration // Connection acquired -> headers written (stream slot and header write)
+	BodyWrite  time.Duration // Headers written -> request fully written
+	ServerTime time.Duration // Request written -> first response byte (network and server)
+	BodyRead   time.Duration // First response byte -> response body read
+}
+
+// GeneratorTime returns the average time spent inside the load generator
+func (p LatencyProfile) GeneratorTime() time.Duration {
+	return p.Scheduling + p.ConnWait + p.StreamWait + p.BodyWrite
+}
+
+// GeneratorShare returns the fraction of the request time spent inside the
+// load generator rather than on the network or server
+func (p LatencyProfile) GeneratorShare() float64 {
+	total := p.GeneratorTime() + p.ServerTime + p.BodyRead
+	if total <= 0 {
+		return 0
+	}
+	return float64(p.GeneratorTime()) / float64(total)
+}
+
+// String formats the LatencyProfile as a readable string
+func (p LatencyProfile) String() string {
+	summary := fmt.Sprintf(`Latency Profile (%d sampled requests, averages):
+Scheduling Delay: %v
+Connection Wait: %v
+Stream Wait / Header Write: %v
+Body Write: %v
+Network + Server: %v
+Body Read: %v
+Generator-side Share: %.1f%%`,
+		p.Samples,
+		p.Scheduling,
+		p.ConnWait,
+		p.StreamWait,
+		p.BodyWrite,
+		p.ServerTime,
+		p.BodyRead,
+		p.GeneratorShare()*100)
+	if p.Samples > 0 && p.GeneratorShare() > generatorBoundShare {
+		summary += "\nThe generator accounts for a large share of latency, consider more generator machines or fewer streams per client"
+	}
+	return summary
+}
+
+// latencyProfiler samples one in every rate requests and sums their phases
+type latencyProfiler struct {
+	rate    int64
+	counter int64
+
+	mu    sync.Mutex
+	total LatencyProfile // Sums, divided by Samples on read
+}
+
+func newLatencyProfiler(rate int) *latencyProfiler {
+	return &latencyProfiler{rate: int64(rate)}
+}
+
+// sample reports whether the current request should be profiled
+func (p *latencyProfiler) sample() bool {
+	return p.rate > 0 && atomic.AddInt64(&p.counter, 1)%p.rate == 0
+}
+
+// record adds a profiled request. eligible is when the request could have
+// started, rt its trace and done when its body was fully read.
+func (p *latencyProfiler) record(eligible time.Time, rt RequestTrace, done time.Time) {
+	// A phase is only attributed if both of its events happened
+	phase := func(from, to time.Duration) time.Duration {
+		if from < 0 || to <= 0 || to < from {
+			return 0
+		}
+		return to - from
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total.Samples++
+	if !eligible.IsZero() {
+		p.total.Scheduling += rt.Start.Sub(eligible)
+	}
+	p.total.ConnWait += phase(0, rt.GotConn)
+	p.total.StreamWait += phase(rt.GotConn, rt.WroteHeaders)
+	p.total.BodyWrite += phase(rt.WroteHeaders, rt.WroteRequest)
+	p.total.ServerTime += phase(rt.WroteRequest, rt.FirstByte)
+	p.total.BodyRead += phase(rt.FirstByte, done.Sub(rt.Start))
+}
+
+// profile returns the averaged profile
+func (p *latencyProfiler) profile() LatencyProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	avg := p.total
+	if n := time.Duration(avg.Samples); n > 0 {
+		avg.Scheduling /= n
+		avg.ConnWait /= n
+		avg.StreamWait /= n
+		avg.BodyWrite /= n
+		avg.ServerTime /= n
+		avg.BodyRead /= n
+	}
+	return avg
+}
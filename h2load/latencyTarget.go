@@ -0,0 +1,137 @@
+package h2load
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LatencyTargetConf configures a closed-loop controller that adjusts the total
+// RPS limit during the run to hold a latency percentile at a target value
+type LatencyTargetConf struct {
+	Target     time.Duration // Latency the percentile is held at (0 = disabled)
+	Percentile float64       // Percentile to control, e.g. 99 (default: 99)
+	Interval   time.Duration // How often the RPS limit is adjusted (default: 1s)
+	MinRps     int           // Lowest RPS limit the controller may set (default: 1)
+	MaxRps     int           // Highest RPS limit the controller may set (0 = unbounded)
+}
+
+func (c *LatencyTargetConf) Validate() error {
+	if c.Target < 0 {
+		return fmt.Errorf("latency target must be greater than 0")
+	}
+	if c.Percentile < 0 || c.Percentile > 100 {
+		return fmt.Errorf("latency target percentile must be between 0 and 100")
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("latency target interval must be greater than 0")
+	}
+	if c.MinRps < 0 || c.MaxRps < 0 {
+		return fmt.Errorf("latency target rps bounds must be greater than 0")
+	}
+	if c.MaxRps > 0 && c.MinRps > c.MaxRps {
+		return fmt.Errorf("latency target min rps must not exceed max rps")
+	}
+	return nil
+}
+
+// LatencyTargetSample records one adjustment made by the latency controller
+type LatencyTargetSample struct {
+	Time     time.Time
+	Latency  time.Duration // Observed percentile latency over the interval
+	Achieved float64       // Achieved RPS over the interval
+	Rps      int           // RPS limit during the interval
+	NextRps  int           // RPS limit set for the next interval
+}
+
+// String formats the LatencyTargetSample as a readable string
+func (s LatencyTargetSample) String() string {
+	return fmt.Sprintf("%s latency %v, achieved %.2f rps, limit %d -> %d rps",
+		s.Time.Format("15:04:05"), s.Latency, s.Achieved, s.Rps, s.NextRps)
+}
+
+// latencyController adjusts a limiter's rate from windowed latency stats
+type latencyController struct {
+	conf    LatencyTargetConf
+	limiter *rpsLimiter
+	window  *statsWindow
+	rps     int
+
+	mu       sync.Mutex
+	history  []LatencyTargetSample
+	onSample func(LatencyTargetSample)
+}
+
+func newLatencyController(conf LatencyTargetConf, limiter *rpsLimiter, rps int) *latencyController {
+	if conf.Percentile == 0 {
+		conf.Percentile = 99
+	}
+	if conf.Interval == 0 {
+		conf.Interval = time.Second
+	}
+	if conf.MinRps == 0 {
+		conf.MinRps = 1
+	}
+	return &latencyController{conf: conf, limiter: limiter, window: newStatsWindow(), rps: rps}
+}
+
+// run adjusts the rate every interval until done is closed
+func (c *latencyController) run(done <-chan struct{}) {
+	ticker := time.NewTicker(c.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.adjust()
+		}
+	}
+}
+
+func (c *latencyController) adjust() {
+	stats, hist := c.window.take()
+	if stats.TotalRequests == 0 {
+		return
+	}
+	latency := latencyPercentile(hist, c.conf.Percentile)
+
+	// Scale the limit by target/observed, at most halving or adding half per step
+	ratio := 1.5
+	if latency > 0 {
+		ratio = min(max(float64(c.conf.Target)/float64(latency), 0.5), 1.5)
+	}
+	next := int(float64(c.rps) * ratio)
+	if ratio > 1 {
+		// Don't wind up the limit far beyond what is actually being achieved
+		next = min(next, int(stats.Rps()*2)+1)
+	}
+	next = max(next, c.conf.MinRps)
+	if c.conf.MaxRps > 0 {
+		next = min(next, c.conf.MaxRps)
+	}
+	c.limiter.setRate(next)
+
+	sample := LatencyTargetSample{
+		Time:     time.Now(),
+		Latency:  latency,
+		Achieved: stats.Rps(),
+		Rps:      c.rps,
+		NextRps:  next,
+	}
+	c.rps = next
+
+	c.mu.Lock()
+	c.history = append(c.history, sample)
+	onSample := c.onSample
+	c.mu.Unlock()
+	if onSample != nil {
+		onSample(sample)
+	}
+}
+
+func (c *latencyController) getHistory() []LatencyTargetSample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]LatencyTargetSample(nil), c.history...)
+}
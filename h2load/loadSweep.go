@@ -0,0 +1,180 @@
+package h2load
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadSweepParam is the setting a load sweep steps through
+type LoadSweepParam int
+
+const (
+	SweepStreams LoadSweepParam = iota // ConcurrentStreams per client
+	SweepRps                           // TotalRps across clients
+)
+
+func (p LoadSweepParam) String() string {
+	if p == SweepRps {
+		return "rps"
+	}
+	return "streams"
+}
+
+func (p LoadSweepParam) column() string {
+	if p == SweepRps {
+		return "RPS"
+	}
+	return "Streams"
+}
+
+// LoadSweepConf re-runs the same test at increasing concurrency or RPS, for
+// a throughput-latency curve from one invocation
+type LoadSweepConf struct {
+	Param        LoadSweepParam
+	Values       []int         // Values of Param tried, in order
+	StepDuration time.Duration // How long each value is run (0 = the conf's Requests per client)
+}
+
+func (c *LoadSweepConf) Validate(conf H2loadConf) error {
+	if len(c.Values) == 0 {
+		return fmt.Errorf("load sweep needs at least one value")
+	}
+	for _, v := range c.Values {
+		if v <= 0 {
+			return fmt.Errorf("load sweep values must be greater than 0")
+		}
+	}
+	if c.StepDuration < 0 {
+		return fmt.Errorf("load sweep step duration must be greater than 0")
+	}
+	if c.StepDuration == 0 && conf.Requests == 0 {
+		return fmt.Errorf("load sweep needs a step duration or a number of requests")
+	}
+	return nil
+}
+
+// ParseSweepValues parses a comma-separated list of values, or a range
+// START-END:STEP, e.g. 1,2,4,8 or 100-1000:100
+func ParseSweepValues(s string) ([]int, error) {
+	invalid := fmt.Errorf("invalid sweep values %q, expected a list such as 1,2,4,8 or a range such as 100-1000:100", s)
+	if bounds, step, ok := strings.Cut(s, ":"); ok {
+		start, end, ok := strings.Cut(bounds, "-")
+		if !ok {
+			return nil, invalid
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(start))
+		to, err2 := strconv.Atoi(strings.TrimSpace(end))
+		by, err3 := strconv.Atoi(strings.TrimSpace(step))
+		if err1 != nil || err2 != nil || err3 != nil || by <= 0 || to < from {
+			return nil, invalid
+		}
+		var values []int
+		for v := from; v <= to; v += by {
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	var values []int
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, invalid
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// LoadSweepStep is the outcome of running one value
+type LoadSweepStep struct {
+	Value int
+	Stats RequestStats
+}
+
+// LoadSweepResult is the outcome of a load sweep
+type LoadSweepResult struct {
+	Param LoadSweepParam
+	Steps []LoadSweepStep
+}
+
+// String formats the steps as a table of achieved RPS and latency per value
+func (r LoadSweepResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Load Sweep (%s):\n", r.Param)
+	fmt.Fprintf(&b, "%10s %10s %12s %8s %12s %12s %12s\n",
+		r.Param.column(), "Requests", "Achieved RPS", "Errors", "P50", "P90", "P99")
+	for _, s := range r.Steps {
+		fmt.Fprintf(&b, "%10d %10d %12.2f %7.2f%% %12v %12v %12v\n", s.Value, s.Stats.TotalRequests,
+			s.Stats.Rps(), s.Stats.ErrorRate()*100, s.Stats.P50Latency, s.Stats.P90Latency, s.Stats.P99Latency)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// WriteCSV writes the steps as CSV, with latencies in milliseconds
+func (r LoadSweepResult) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{r.Param.String(), "requests", "achieved_rps", "error_rate", "p50_ms", "p90_ms", "p99_ms"})
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+	for _, s := range r.Steps {
+		cw.Write([]string{
+			strconv.Itoa(s.Value),
+			strconv.FormatInt(s.Stats.TotalRequests, 10),
+			strconv.FormatFloat(s.Stats.Rps(), 'f', 2, 64),
+			strconv.FormatFloat(s.Stats.ErrorRate(), 'f', 4, 64),
+			ms(s.Stats.P50Latency), ms(s.Stats.P90Latency), ms(s.Stats.P99Latency),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// SweepLoad runs conf once for every value of sweep, with a fresh
+// H2loadClient each time and ConcurrentStreams or TotalRps set to the value
+func SweepLoad(conf H2loadConf, sweep LoadSweepConf) (LoadSweepResult, error) {
+	result := LoadSweepResult{Param: sweep.Param}
+	if err := sweep.Validate(conf); err != nil {
+		return result, err
+	}
+	for _, v := range sweep.Values {
+		step, err := runLoadSweepStep(conf, sweep, v)
+		if err != nil {
+			return result, err
+		}
+		result.Steps = append(result.Steps, step)
+	}
+	return result, nil
+}
+
+func runLoadSweepStep(conf H2loadConf, sweep LoadSweepConf, value int) (LoadSweepStep, error) {
+	switch sweep.Param {
+	case SweepRps:
+		conf.Rps = 0
+		conf.TotalRps = value
+	default:
+		conf.ConcurrentStreams = value
+	}
+	if sweep.StepDuration > 0 {
+		conf.Requests = 0
+	}
+
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return LoadSweepStep{}, err
+	}
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		return LoadSweepStep{}, fmt.Errorf("connect failed at %d %s: %w", value, sweep.Param, err)
+	}
+	if sweep.StepDuration > 0 {
+		client.runFor(sweep.StepDuration, 0, client.Run)
+	} else {
+		client.Run()
+	}
+	return LoadSweepStep{Value: value, Stats: client.GetTotalStats()}, nil
+}
@@ -0,0 +1,227 @@
+package h2load
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// logFileSet is the set of files the per-request log is split across, each
+// drained by its own ShardedLogWriter
+type logFileSet struct {
+	files   []*os.File
+	gzips   []*gzip.Writer
+	outs    []io.Writer
+	writers []*ShardedLogWriter
+}
+
+// createLogFiles creates n log files named after path, or path itself when n
+// is 1, optionally gzip-compressed
+func createLogFiles(path string, n int, gz bool) (*logFileSet, error) {
+	s := &logFileSet{}
+	for _, name := range logFilePaths(path, n) {
+		f, err := os.Create(name)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("failed to create log file %s: %w", name, err)
+		}
+		s.files = append(s.files, f)
+		if gz {
+			zw := gzip.NewWriter(f)
+			s.gzips = append(s.gzips, zw)
+			s.outs = append(s.outs, zw)
+		} else {
+			s.outs = append(s.outs, f)
+		}
+	}
+	return s, nil
+}
+
+// logFilePaths returns the names of the n files the log at path is split
+// across, path itself when n is 1
+func logFilePaths(path string, n int) []string {
+	if n <= 1 {
+		return []string{path}
+	}
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = shardLogPath(path, i)
+	}
+	return paths
+}
+
+// shardLogPath returns the name of shard i of the log at path, with the
+// index before the extension, e.g. results.log.gz -> results.2.log.gz
+func shardLogPath(path string, i int) string {
+	base, gz := strings.CutSuffix(path, ".gz")
+	ext := filepath.Ext(base)
+	name := fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), i, ext)
+	if gz {
+		name += ".gz"
+	}
+	return name
+}
+
+// close flushes the writers, finishes the gzip streams and closes the files.
+// It is safe to call more than once.
+func (s *logFileSet) close() error {
+	var errs []error
+	for _, w := range s.writers {
+		errs = append(errs, w.Close())
+	}
+	for _, zw := range s.gzips {
+		errs = append(errs, zw.Close())
+	}
+	for _, f := range s.files {
+		errs = append(errs, f.Close())
+	}
+	s.writers, s.gzips, s.files = nil, nil, nil
+	return errors.Join(errs...)
+}
+
+// MergeLogFiles merges log files written with several shards into the file
+// at outPath, see MergeLogs. Inputs and output ending in .gz are compressed.
+func MergeLogFiles(outPath string, paths []string) error {
+	var inputs []io.Reader
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if strings.HasSuffix(path, ".gz") {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			r = zr
+		}
+		inputs = append(inputs, r)
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	var w io.Writer = out
+	var zw *gzip.Writer
+	if strings.HasSuffix(outPath, ".gz") {
+		zw = gzip.NewWriter(out)
+		w = zw
+	}
+	bw := bufio.NewWriter(w)
+	if err := MergeLogs(bw, inputs...); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// MergeLogs interleaves the lines of several logs by request start time: the
+// first field of text lines, or the timestamp of JSON lines. Each log is
+// read once, so the output is as well ordered as the inputs are.
+func MergeLogs(out io.Writer, inputs ...io.Reader) error {
+	h := &logMergeHeap{}
+	for _, r := range inputs {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1<<20)
+		src := &logMergeSource{sc: sc}
+		if src.next() {
+			heap.Push(h, src)
+		} else if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+	for h.Len() > 0 {
+		src := (*h)[0]
+		if _, err := out.Write(append(src.line, '\n')); err != nil {
+			return err
+		}
+		if src.next() {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+			if err := src.sc.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type logMergeSource struct {
+	sc   *bufio.Scanner
+	line []byte
+	key  string
+}
+
+// next reads the next non-empty line, returning false at the end
+func (s *logMergeSource) next() bool {
+	for s.sc.Scan() {
+		line := s.sc.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		s.line = append(s.line[:0], line...)
+		s.key = logLineKey(s.line)
+		return true
+	}
+	return false
+}
+
+// logLineKey returns the sort key of a log line
+func logLineKey(line []byte) string {
+	if line[0] == '{' {
+		var entry struct {
+			Timestamp string `json:"timestamp"`
+		}
+		if json.Unmarshal(line, &entry) == nil {
+			return entry.Timestamp
+		}
+	}
+	if i := bytes.IndexByte(line, ' '); i >= 0 {
+		line = line[:i]
+	}
+	return string(line)
+}
+
+// lessLogKey orders keys as numbers when both are, otherwise as strings
+func lessLogKey(a, b string) bool {
+	if x, err := strconv.ParseInt(a, 10, 64); err == nil {
+		if y, err := strconv.ParseInt(b, 10, 64); err == nil {
+			return x < y
+		}
+	}
+	return a < b
+}
+
+type logMergeHeap []*logMergeSource
+
+func (h logMergeHeap) Len() int           { return len(h) }
+func (h logMergeHeap) Less(i, j int) bool { return lessLogKey(h[i].key, h[j].key) }
+func (h logMergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *logMergeHeap) Push(x any)        { *h = append(*h, x.(*logMergeSource)) }
+func (h *logMergeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,57 @@
+package h2load
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// LogTemplateData is the data a log format template is executed with, e.g.
+// '{{.Timestamp}} {{.Status}} {{.LatencyMs}} {{.ClientID}}'. All LogEntry
+// fields are available along with these preformatted ones.
+type LogTemplateData struct {
+	LogEntry
+	Timestamp   string // Start formatted as 15:04:05.000000000
+	EpochMicros int64  // Start as microseconds since the epoch
+	LatencyMs   string // Latency in milliseconds, e.g. 12.345
+	LatencyUs   int64  // Latency in microseconds
+	TTFBMs      string // TTFB in milliseconds
+	QueueMs     string // Queue in milliseconds
+}
+
+// ParseLogTemplate parses a log format template, adding a trailing newline
+// if it has none
+func ParseLogTemplate(format string) (*template.Template, error) {
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	tmpl, err := template.New("log").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log format: %w", err)
+	}
+	// Catch unknown fields now rather than on every request
+	if err := tmpl.Execute(&strings.Builder{}, LogTemplateData{}); err != nil {
+		return nil, fmt.Errorf("invalid log format: %w", err)
+	}
+	return tmpl, nil
+}
+
+// LogResultAsTemplate returns a log line func formatting lines with tmpl
+func LogResultAsTemplate(tmpl *template.Template) func(entry LogEntry) string {
+	return func(entry LogEntry) string {
+		var b strings.Builder
+		err := tmpl.Execute(&b, LogTemplateData{
+			LogEntry:    entry,
+			Timestamp:   entry.Start.Format("15:04:05.000000000"),
+			EpochMicros: entry.Start.UnixMicro(),
+			LatencyMs:   fmt.Sprintf("%.3f", float64(entry.Latency.Nanoseconds())/1000000),
+			LatencyUs:   entry.Latency.Microseconds(),
+			TTFBMs:      fmt.Sprintf("%.3f", float64(entry.TTFB.Nanoseconds())/1000000),
+			QueueMs:     fmt.Sprintf("%.3f", float64(entry.Queue.Nanoseconds())/1000000),
+		})
+		if err != nil {
+			return "" // The template was checked when parsed
+		}
+		return b.String()
+	}
+}
@@ -3,24 +3,131 @@ package h2load
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// logResult sends a log line to the logger goroutine
-func LogResultAsJSON(start time.Time, status int, latency time.Duration) string {
-	entry := map[string]interface{}{
-		"timestamp": start.Format("15:04:05.000000000"),
-		"status":    status,
-		"latency":   fmt.Sprintf("%.3fms", float64(latency.Nanoseconds())/1000000),
-	}
-	jsonBytes, err := json.Marshal(entry)
+// LogResultAsJSON formats entry as a JSON log line
+func LogResultAsJSON(entry LogEntry) string {
+	fields := map[string]interface{}{
+		"timestamp": entry.Start.Format("15:04:05.000000000"),
+		"status":    entry.Status,
+		"latency":   fmt.Sprintf("%.3fms", float64(entry.Latency.Nanoseconds())/1000000),
+		"ttfb":      fmt.Sprintf("%.3fms", float64(entry.TTFB.Nanoseconds())/1000000),
+		"client":    entry.ClientID,
+		"conn":      entry.ConnID,
+		"stream":    entry.StreamID,
+		"bytes_in":  entry.BytesIn,
+		"bytes_out": entry.BytesOut,
+		"queue":     fmt.Sprintf("%.3fms", float64(entry.Queue.Nanoseconds())/1000000),
+	}
+	if entry.Error != "" {
+		fields["error"] = entry.Error
+	}
+	if entry.Retries > 0 {
+		fields["retries"] = entry.Retries
+	}
+	if entry.Spike {
+		fields["spike"] = true
+	}
+	if len(entry.Redirects) > 0 {
+		fields["redirects"] = entry.Redirects
+	}
+	if len(entry.Trailers) > 0 {
+		fields["trailers"] = entry.Trailers
+	}
+	if entry.Stalled > 0 {
+		fields["stalled"] = fmt.Sprintf("%.3fms", float64(entry.Stalled.Nanoseconds())/1000000)
+	}
+	if entry.RequestID != "" {
+		fields["request_id"] = entry.RequestID
+	}
+	if entry.TraceID != "" {
+		fields["trace_id"] = entry.TraceID
+	}
+	jsonBytes, err := json.Marshal(fields)
 	if err != nil {
 		return "" // optionally handle or report JSON marshal error
 	}
 	return string(jsonBytes) + "\n"
 }
 
-func LogResultAsText(start time.Time, status int, latency time.Duration) string {
-	epochMicros := start.UnixNano() / int64(time.Microsecond)
-	return fmt.Sprintf("%d %d %d\n", epochMicros, status, latency.Microseconds())
+// LogResultAsText formats entry as "<start epoch us> <status> <latency us>",
+// followed by the request ID and trace ID sent, if any
+func LogResultAsText(entry LogEntry) string {
+	epochMicros := entry.Start.UnixNano() / int64(time.Microsecond)
+	line := fmt.Sprintf("%d %d %d", epochMicros, entry.Status, entry.Latency.Microseconds())
+	if entry.RequestID != "" {
+		line += " " + entry.RequestID
+	}
+	if entry.TraceID != "" {
+		line += " " + entry.TraceID
+	}
+	return line + "\n"
+}
+
+// LogSlowerThan returns a log filter keeping only requests slower than threshold
+func LogSlowerThan(threshold time.Duration) func(status int, latency time.Duration) bool {
+	return func(status int, latency time.Duration) bool {
+		return latency > threshold
+	}
+}
+
+// LogStatus returns a log filter keeping only the listed statuses. The spec is
+// a comma separated list of codes and classes, e.g. "5xx,429,0" where 0 stands
+// for requests that failed without a response.
+func LogStatus(spec string) (func(status int, latency time.Duration) bool, error) {
+	var codes []int
+	var classes []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if len(part) == 3 && strings.HasSuffix(part, "xx") && part[0] >= '1' && part[0] <= '5' {
+			classes = append(classes, int(part[0]-'0'))
+			continue
+		}
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 0 || code > 999 {
+			return nil, fmt.Errorf("invalid status %q, expected a code like 404 or a class like 5xx", part)
+		}
+		codes = append(codes, code)
+	}
+	return func(status int, latency time.Duration) bool {
+		for _, code := range codes {
+			if status == code {
+				return true
+			}
+		}
+		for _, class := range classes {
+			if status/100 == class {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// AllLogFilters combines log filters, keeping requests that pass every one.
+// Nil filters are ignored.
+func AllLogFilters(filters ...func(status int, latency time.Duration) bool) func(status int, latency time.Duration) bool {
+	var active []func(status int, latency time.Duration) bool
+	for _, f := range filters {
+		if f != nil {
+			active = append(active, f)
+		}
+	}
+	switch len(active) {
+	case 0:
+		return nil
+	case 1:
+		return active[0]
+	}
+	return func(status int, latency time.Duration) bool {
+		for _, f := range active {
+			if !f(status, latency) {
+				return false
+			}
+		}
+		return true
+	}
 }
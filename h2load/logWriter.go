@@ -0,0 +1,121 @@
+package h2load
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// maxShardBuffer is the buffered size at which a shard asks for an early flush
+const maxShardBuffer = 4 << 20
+
+// ShardedLogWriter buffers log output in independent shards, one per writer,
+// and copies them to the underlying writer from a single flusher goroutine.
+// Writers only contend with the flusher on their own shard, never with each other.
+type ShardedLogWriter struct {
+	out      io.Writer
+	shards   []*logShard
+	interval time.Duration
+	flushMu  sync.Mutex
+	kick     chan struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+	wg       sync.WaitGroup
+	err      error
+}
+
+type logShard struct {
+	mu    sync.Mutex
+	buf   []byte
+	spare []byte
+	kick  chan struct{}
+}
+
+// NewShardedLogWriter creates a writer with the given number of shards that
+// flushes to out every flushInterval, and starts its flusher goroutine
+func NewShardedLogWriter(out io.Writer, shards int, flushInterval time.Duration) *ShardedLogWriter {
+	if shards < 1 {
+		shards = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = 100 * time.Millisecond
+	}
+	w := &ShardedLogWriter{
+		out:      out,
+		shards:   make([]*logShard, shards),
+		interval: flushInterval,
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	for i := range w.shards {
+		w.shards[i] = &logShard{kick: w.kick}
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.flusher()
+	}()
+	return w
+}
+
+// Shard returns the writer for shard i (modulo the number of shards)
+func (w *ShardedLogWriter) Shard(i int) io.Writer {
+	return w.shards[i%len(w.shards)]
+}
+
+func (w *ShardedLogWriter) flusher() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		case <-w.kick:
+		}
+		w.Flush()
+	}
+}
+
+// Flush copies all buffered shard data to the underlying writer
+func (w *ShardedLogWriter) Flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	for _, s := range w.shards {
+		s.mu.Lock()
+		buf := s.buf
+		s.buf = s.spare[:0]
+		s.mu.Unlock()
+
+		if len(buf) > 0 {
+			if _, err := w.out.Write(buf); err != nil && w.err == nil {
+				w.err = err
+			}
+		}
+		s.spare = buf
+	}
+	return w.err
+}
+
+// Close stops the flusher and flushes any remaining data
+func (w *ShardedLogWriter) Close() error {
+	w.doneOnce.Do(func() { close(w.done) })
+	w.wg.Wait()
+	return w.Flush()
+}
+
+func (s *logShard) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.buf = append(s.buf, p...)
+	full := len(s.buf) >= maxShardBuffer
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
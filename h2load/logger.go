@@ -0,0 +1,90 @@
+package h2load
+
+import (
+	"context"
+	"log"
+	"log/slog"
+)
+
+// Logger receives an entry for every logged request. Each client calls its
+// logger from a single goroutine, off the request path.
+type Logger interface {
+	LogRequest(entry LogEntry)
+}
+
+// lineLogger writes entries formatted with the client's LogLineFunc to a
+// *log.Logger
+type lineLogger struct {
+	client *H2Client
+	out    *log.Logger
+}
+
+func (l *lineLogger) LogRequest(entry LogEntry) {
+	l.out.Print(l.client.LogLineFunc(entry))
+}
+
+// SlogLogger logs requests as structured slog records with client, conn,
+// stream, start, status, latency, ttfb, bytes and route attributes. Failed
+// requests, the ones without a response and the ones whose response failed
+// a check such as GraphQL errors or expected trailers, get an error
+// attribute and are logged at ErrorLevel.
+type SlogLogger struct {
+	Logger     *slog.Logger
+	Message    string     // Record message (default: "request")
+	Level      slog.Level // Level of successful requests (default: Info)
+	ErrorLevel slog.Level // Level of failed requests, without a response or failing a response check (default: Warn)
+}
+
+// NewSlogLogger returns a SlogLogger writing to logger with the defaults
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	return &SlogLogger{
+		Logger:     logger,
+		Message:    "request",
+		Level:      slog.LevelInfo,
+		ErrorLevel: slog.LevelWarn,
+	}
+}
+
+func (s *SlogLogger) LogRequest(entry LogEntry) {
+	level := s.Level
+	attrs := make([]slog.Attr, 0, 12)
+	attrs = append(attrs,
+		slog.Int("client", entry.ClientID),
+		slog.Uint64("conn", entry.ConnID),
+		slog.Uint64("stream", uint64(entry.StreamID)),
+		slog.Time("start", entry.Start),
+		slog.Int("status", entry.Status),
+		slog.Duration("latency", entry.Latency),
+		slog.Duration("ttfb", entry.TTFB),
+		slog.Duration("queue", entry.Queue),
+		slog.Int64("bytes_in", entry.BytesIn),
+		slog.Int64("bytes_out", entry.BytesOut),
+		slog.String("route", entry.Route),
+	)
+	if entry.Error != "" {
+		level = s.ErrorLevel
+		attrs = append(attrs, slog.String("error", entry.Error))
+	}
+	if entry.Retries > 0 {
+		attrs = append(attrs, slog.Int("retries", entry.Retries))
+	}
+	if entry.Spike {
+		attrs = append(attrs, slog.Bool("spike", true))
+	}
+	if len(entry.Redirects) > 0 {
+		attrs = append(attrs, slog.Any("redirects", entry.Redirects))
+	}
+	if len(entry.Trailers) > 0 {
+		attrs = append(attrs, slog.Any("trailers", entry.Trailers))
+	}
+	if entry.Stalled > 0 {
+		attrs = append(attrs, slog.Duration("stalled", entry.Stalled))
+	}
+	if entry.RequestID != "" {
+		attrs = append(attrs, slog.String("request_id", entry.RequestID))
+	}
+	if entry.TraceID != "" {
+		attrs = append(attrs, slog.String("trace_id", entry.TraceID))
+	}
+	s.Logger.LogAttrs(context.Background(), level, s.Message, attrs...)
+}
@@ -0,0 +1,115 @@
+package h2load
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	memoryCheckInterval = 500 * time.Millisecond
+	memoryShedAbove     = 0.8 // Fraction of MaxMemory above which shedding increases
+	memoryRelaxBelow    = 0.6 // Fraction of MaxMemory below which shedding decreases
+	maxShedLevel        = 10  // At most 1 in 1024 log lines is kept
+)
+
+// MemoryReport describes the generator's memory use under MaxMemory
+type MemoryReport struct {
+	Limit        int64 // MaxMemory in bytes
+	Peak         int64 // Highest memory in use observed
+	MaxShedLevel int   // Highest shedding level reached, 0 if nothing was shed
+}
+
+// String formats the MemoryReport as a readable string
+func (m MemoryReport) String() string {
+	summary := fmt.Sprintf("Memory: peak %s of %s limit", formatBytes(m.Peak), formatBytes(m.Limit))
+	if m.MaxShedLevel > 0 {
+		summary += fmt.Sprintf("\nLoad shedding was active: up to 1 in %d log lines kept, traces, latency profiles and response captures dropped",
+			1<<m.MaxShedLevel)
+	}
+	return summary
+}
+
+// memoryGuard keeps the generator under a memory cap by shedding optional
+// work (log lines, traces, latency profiles, response captures) as the cap
+// gets close, instead of being OOM-killed mid-run. Requests themselves are
+// never shed.
+type memoryGuard struct {
+	limit   int64
+	clients []*H2Client
+
+	mu     sync.Mutex
+	level  int
+	report MemoryReport
+}
+
+func newMemoryGuard(limit int64, clients []*H2Client) *memoryGuard {
+	return &memoryGuard{limit: limit, clients: clients, report: MemoryReport{Limit: limit}}
+}
+
+// run checks memory use until done is closed. The Go runtime's soft memory
+// limit is set to the cap for the duration so the GC works harder near it.
+func (g *memoryGuard) run(done <-chan struct{}) {
+	previous := debug.SetMemoryLimit(g.limit)
+	defer debug.SetMemoryLimit(previous)
+
+	g.check(memoryInUse())
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			g.check(memoryInUse())
+			return
+		case <-ticker.C:
+			g.check(memoryInUse())
+		}
+	}
+}
+
+func (g *memoryGuard) check(used int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.report.Peak = max(g.report.Peak, used)
+
+	level := g.level
+	switch {
+	case float64(used) > float64(g.limit)*memoryShedAbove:
+		level = min(level+1, maxShedLevel)
+	case float64(used) < float64(g.limit)*memoryRelaxBelow:
+		level = max(level-1, 0)
+	}
+	if level == g.level {
+		return
+	}
+	g.level = level
+	g.report.MaxShedLevel = max(g.report.MaxShedLevel, level)
+	for _, c := range g.clients {
+		c.setShedLevel(level)
+	}
+}
+
+func (g *memoryGuard) getReport() MemoryReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.report
+}
+
+// memoryInUse returns the memory obtained from the OS and not yet returned
+func memoryInUse() int64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.Sys - m.HeapReleased)
+}
+
+// formatBytes formats n with a binary unit suffix, e.g. "1.5GiB"
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	exp := min(int(math.Log(float64(n))/math.Log(1024)), 4)
+	return fmt.Sprintf("%.1f%ciB", float64(n)/math.Pow(1024, float64(exp)), "KMGT"[exp-1])
+}
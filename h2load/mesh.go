@@ -0,0 +1,59 @@
+package h2load
+
+import (
+	"fmt"
+	"net/http"
+	urlpkg "net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultMeshSidecarAddress is the outbound listener of an Istio/Envoy sidecar
+const DefaultMeshSidecarAddress = "127.0.0.1:15001"
+
+// MeshConf sends traffic through a local service mesh sidecar. Requests use
+// h2c with prior knowledge to the sidecar while keeping the virtual service
+// from the URL as the Host (:authority), since the sidecar handles mTLS.
+type MeshConf struct {
+	Enabled         bool
+	SidecarAddress  string        // Sidecar to dial (default: DefaultMeshSidecarAddress)
+	UpstreamTimeout time.Duration // Sent as x-envoy-upstream-rq-timeout-ms (0 = not sent)
+	Headers         http.Header   // Extra mesh headers added to every request
+}
+
+func (m *MeshConf) Validate() error {
+	if m.UpstreamTimeout < 0 {
+		return fmt.Errorf("mesh upstream timeout must be greater than 0")
+	}
+	return nil
+}
+
+// withMeshDefaults returns the conf with the mesh defaults applied, when enabled
+func (h H2loadConf) withMeshDefaults() H2loadConf {
+	if !h.Mesh.Enabled {
+		return h
+	}
+	if u, err := urlpkg.Parse(h.URL); err == nil && u.Scheme == "https" {
+		u.Scheme = "http"
+		h.URL = u.String()
+	}
+	if h.ServerAddress == "" {
+		h.ServerAddress = h.Mesh.SidecarAddress
+		if h.ServerAddress == "" {
+			h.ServerAddress = DefaultMeshSidecarAddress
+		}
+	}
+
+	headers := h.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	for k, v := range h.Mesh.Headers {
+		headers[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+	}
+	if h.Mesh.UpstreamTimeout > 0 {
+		headers.Set("x-envoy-upstream-rq-timeout-ms", strconv.FormatInt(h.Mesh.UpstreamTimeout.Milliseconds(), 10))
+	}
+	h.Headers = headers
+	return h
+}
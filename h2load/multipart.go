@@ -0,0 +1,143 @@
+package h2load
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FormField is a field of a multipart/form-data body, a value or a file
+type FormField struct {
+	Name        string
+	Value       string // Value of a plain field
+	File        string // Path of a file field, streamed as the field's content
+	ContentType string // Content type of a file field (default: from its extension)
+}
+
+// ParseFormField parses a field given the way curl's -F takes it:
+// name=value, name=@path or name=@path;type=mime/type
+func ParseFormField(s string) (FormField, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return FormField{}, fmt.Errorf("invalid form field %q, expected name=value or name=@file", s)
+	}
+	path, isFile := strings.CutPrefix(value, "@")
+	if !isFile {
+		return FormField{Name: name, Value: value}, nil
+	}
+	f := FormField{Name: name, File: path}
+	if p, typ, ok := strings.Cut(path, ";type="); ok {
+		f.File, f.ContentType = p, typ
+	}
+	if f.File == "" {
+		return FormField{}, fmt.Errorf("invalid form field %q: no file given", s)
+	}
+	return f, nil
+}
+
+func (f FormField) String() string {
+	if f.File == "" {
+		return f.Name + "=" + f.Value
+	}
+	s := f.Name + "=@" + f.File
+	if f.ContentType != "" {
+		s += ";type=" + f.ContentType
+	}
+	return s
+}
+
+func (f FormField) contentType() string {
+	if f.ContentType != "" {
+		return f.ContentType
+	}
+	if typ := mime.TypeByExtension(filepath.Ext(f.File)); typ != "" {
+		return typ
+	}
+	return "application/octet-stream"
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// writeForm writes fields as a multipart/form-data body with boundary
+func writeForm(w io.Writer, boundary string, fields []FormField) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.File == "" {
+			if err := mw.WriteField(f.Name, f.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(f.Name), quoteEscaper.Replace(filepath.Base(f.File))))
+		h.Set("Content-Type", f.contentType())
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(f.File)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// MultipartBody returns bodies streaming fields as multipart/form-data, with
+// files read as the body is sent, along with the Content-Type and length of
+// every body
+func MultipartBody(fields []FormField) (BodyFactory, string, int64, error) {
+	boundary := multipart.NewWriter(nil).Boundary()
+	var size countingWriter
+	if err := writeForm(&size, boundary, fields); err != nil {
+		return nil, "", 0, fmt.Errorf("form: %w", err)
+	}
+	body := func() io.Reader {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeForm(pw, boundary, fields))
+		}()
+		return pr
+	}
+	return body, "multipart/form-data; boundary=" + boundary, size.n, nil
+}
+
+// MultipartRequestFactory returns a request factory for RunRequestsFactory
+// sending method requests to url with fields as a multipart/form-data body
+func MultipartRequestFactory(method, url string, header http.Header, fields []FormField) (func() *http.Request, error) {
+	body, contentType, size, err := MultipartBody(fields)
+	if err != nil {
+		return nil, err
+	}
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", contentType)
+	return StreamingRequestFactory(method, url, header, body, size)
+}
@@ -0,0 +1,55 @@
+package h2load
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// notifyTimeout bounds a webhook call, so a dead endpoint can't hold up the
+// end of a run
+const notifyTimeout = 10 * time.Second
+
+// SlackMessage formats r as the text of a chat message
+func SlackMessage(r Report) string {
+	t := r.Total
+	status := ":white_check_mark: completed"
+	if r.AbortReason != "" {
+		status = ":x: aborted (" + r.AbortReason + ")"
+	}
+	return fmt.Sprintf("h2load run against %s %s\n%d requests, %.2f rps, p50 %.2fms, p99 %.2fms, %.2f%% errors",
+		r.URL, status, t.Requests, t.Rps, t.P50Ms, t.P99Ms, t.ErrorRate*100)
+}
+
+// NotifyWebhook POSTs r to url. The body is the JSON summary, or with format
+// "slack" a Slack-compatible {"text": ...} message.
+func NotifyWebhook(url, format string, r Report) error {
+	var body []byte
+	var err error
+	switch format {
+	case "", "json":
+		body, err = json.Marshal(r)
+	case "slack":
+		body, err = json.Marshal(map[string]string{"text": SlackMessage(r)})
+	default:
+		return fmt.Errorf("unknown notify format %q, expected json or slack", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package h2load
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	otlpQueueSize     = 4096 // Spans waiting to be sent, more are dropped
+	otlpBatchSize     = 512  // Most spans per export request
+	otlpFlushInterval = 5 * time.Second
+	otlpTimeout       = 10 * time.Second // Bound of each export request
+	otlpScope         = "github.com/galbarnahum/h2loadGo/h2load"
+)
+
+// OTLPExporter sends request traces as client spans to an OTLP/HTTP
+// collector, JSON encoded, e.g. to http://localhost:4318/v1/traces. Spans
+// are batched and sent from a goroutine of its own; when the collector falls
+// behind they are dropped, so the run isn't slowed down. A request sent with
+// a traceparent gets its span under that trace ID, with the parent ID as its
+// span ID, so the server's spans nest under it.
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+	spans    chan RequestTrace
+	done     chan struct{}
+
+	exported, dropped, failed int64 // Atomic counters
+}
+
+// OTLPStats counts the spans an OTLPExporter handled
+type OTLPStats struct {
+	Endpoint string
+	Exported int64 // Accepted by the collector
+	Dropped  int64 // Dropped because the queue was full
+	Failed   int64 // In export requests that failed
+}
+
+func (s OTLPStats) String() string {
+	return fmt.Sprintf("OTLP Traces (%s):\n  Exported: %d spans, dropped: %d, failed: %d",
+		s.Endpoint, s.Exported, s.Dropped, s.Failed)
+}
+
+// NewOTLPExporter starts an exporter sending spans to endpoint, the full
+// URL of the collector's traces path
+func NewOTLPExporter(endpoint string) (*OTLPExporter, error) {
+	if err := validateOTLPEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: otlpTimeout},
+		spans:    make(chan RequestTrace, otlpQueueSize),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+func validateOTLPEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("otlp endpoint must be an http or https URL, e.g. http://localhost:4318/v1/traces")
+	}
+	return nil
+}
+
+// Export queues rt to be sent, it never blocks. It is a trace func for
+// SetGlobalTraceFunc, and must not be called after Close.
+func (e *OTLPExporter) Export(rt RequestTrace) {
+	select {
+	case e.spans <- rt:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+// Close sends the queued spans and stops the exporter
+func (e *OTLPExporter) Close() OTLPStats {
+	close(e.spans)
+	<-e.done
+	return e.Stats()
+}
+
+func (e *OTLPExporter) Stats() OTLPStats {
+	return OTLPStats{
+		Endpoint: e.endpoint,
+		Exported: atomic.LoadInt64(&e.exported),
+		Dropped:  atomic.LoadInt64(&e.dropped),
+		Failed:   atomic.LoadInt64(&e.failed),
+	}
+}
+
+// run batches the queued spans, sending a batch once full or every
+// otlpFlushInterval
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	batch := make([]RequestTrace, 0, otlpBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			atomic.AddInt64(&e.failed, int64(len(batch)))
+		} else {
+			atomic.AddInt64(&e.exported, int64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case rt, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, rt); len(batch) == otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// send posts one export request holding batch
+func (e *OTLPExporter) send(batch []RequestTrace) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, rt := range batch {
+		spans[i] = newOTLPSpan(rt)
+	}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttr{stringAttr("service.name", "h2load")}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScopeInfo{Name: otlpScope}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp export: %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of ExportTraceServiceRequest. 64-bit integers
+// are strings and IDs hex, as the encoding requires.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScopeInfo `json:"scope"`
+	Spans []otlpSpan    `json:"spans"`
+}
+
+type otlpScopeInfo struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr  `json:"attributes"`
+	Events            []otlpEvent `json:"events,omitempty"`
+	Status            otlpStatus  `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string `json:"timeUnixNano"`
+	Name         string `json:"name"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+const (
+	otlpSpanKindClient  = 3
+	otlpStatusCodeError = 2
+)
+
+func stringAttr(key, value string) otlpAttr {
+	return otlpAttr{key, map[string]any{"stringValue": value}}
+}
+
+func intAttr(key string, value int64) otlpAttr {
+	return otlpAttr{key, map[string]any{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+func boolAttr(key string, value bool) otlpAttr {
+	return otlpAttr{key, map[string]any{"boolValue": value}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// newOTLPSpan returns the client span of rt, following the HTTP semantic
+// conventions, with the trace's events at their offsets
+func newOTLPSpan(rt RequestTrace) otlpSpan {
+	traceID, spanID := spanIDs(rt.TraceParent)
+	span := otlpSpan{
+		TraceID:           traceID,
+		SpanID:            spanID,
+		Name:              rt.Method,
+		Kind:              otlpSpanKindClient,
+		StartTimeUnixNano: unixNano(rt.Start),
+		EndTimeUnixNano:   unixNano(rt.Start.Add(rt.Total)),
+		Attributes: []otlpAttr{
+			stringAttr("http.request.method", rt.Method),
+			stringAttr("url.full", rt.URL),
+			stringAttr("network.protocol.version", "2"),
+		},
+	}
+	if rt.GotConn > 0 {
+		span.Attributes = append(span.Attributes, boolAttr("h2load.connection.reused", rt.ConnReused))
+	}
+	for _, ev := range []struct {
+		name string
+		at   time.Duration
+	}{
+		{"got connection", rt.GotConn},
+		{"tls handshake done", rt.TLSHandshake},
+		{"wrote headers", rt.WroteHeaders},
+		{"wrote request", rt.WroteRequest},
+		{"first response byte", rt.FirstByte},
+	} {
+		if ev.at > 0 {
+			span.Events = append(span.Events, otlpEvent{unixNano(rt.Start.Add(ev.at)), ev.name})
+		}
+	}
+	switch {
+	case rt.Err != nil:
+		span.Attributes = append(span.Attributes, stringAttr("error.type", fmt.Sprintf("%T", rt.Err)))
+		span.Status = otlpStatus{Code: otlpStatusCodeError, Message: rt.Err.Error()}
+	case rt.Status >= 400:
+		// Client spans fail on 4xx too
+		span.Attributes = append(span.Attributes, intAttr("http.response.status_code", int64(rt.Status)),
+			stringAttr("error.type", strconv.Itoa(rt.Status)))
+		span.Status = otlpStatus{Code: otlpStatusCodeError}
+	default:
+		span.Attributes = append(span.Attributes, intAttr("http.response.status_code", int64(rt.Status)))
+	}
+	return span
+}
+
+// spanIDs returns the trace and span IDs of a request, taken from the
+// traceparent it was sent with, or random without one
+func spanIDs(traceparent string) (traceID, spanID string) {
+	if parts := strings.Split(traceparent, "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		return parts[1], parts[2]
+	}
+	var trace [16]byte
+	var span [8]byte
+	// All zero IDs are invalid
+	for trace == [16]byte{} || span == [8]byte{} {
+		binary.BigEndian.PutUint64(trace[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(trace[8:], rand.Uint64())
+		binary.BigEndian.PutUint64(span[:], rand.Uint64())
+	}
+	return hex.EncodeToString(trace[:]), hex.EncodeToString(span[:])
+}
@@ -0,0 +1,109 @@
+package h2load
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// PacingReport quantifies how closely even-mode sends followed the ideal
+// interval between requests
+type PacingReport struct {
+	Samples      int64         // Inter-send gaps measured
+	Ideal        time.Duration // Ideal gap at the end of the run (1/rps)
+	MeanGap      time.Duration // Average actual gap
+	P50Deviation time.Duration // Median |actual gap - ideal gap|
+	P99Deviation time.Duration // 99th percentile |actual gap - ideal gap|
+	MaxDeviation time.Duration
+}
+
+// String formats the PacingReport as a readable string
+func (p PacingReport) String() string {
+	return fmt.Sprintf(`Pacing Accuracy (even mode, %d gaps):
+Ideal Interval: %v
+Mean Interval: %v
+P50 Deviation: %v
+P99 Deviation: %v
+Max Deviation: %v`,
+		p.Samples,
+		p.Ideal,
+		p.MeanGap,
+		p.P50Deviation,
+		p.P99Deviation,
+		p.MaxDeviation)
+}
+
+// pacingRecorder records the deviation of every gap between sends from the
+// ideal interval of an even-mode limiter
+type pacingRecorder struct {
+	mu       sync.Mutex
+	last     time.Time
+	ideal    time.Duration
+	gapTotal time.Duration
+	hist     *hdrhistogram.Histogram // |gap - ideal| deviations
+}
+
+func newPacingRecorder() *pacingRecorder {
+	return &pacingRecorder{hist: newLatencyHistogram()}
+}
+
+// sent records a send at t, expected ideal after the previous one
+func (p *pacingRecorder) sent(t time.Time, ideal time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last := p.last
+	p.last = t
+	p.ideal = ideal
+	if last.IsZero() {
+		return
+	}
+	gap := t.Sub(last)
+	p.gapTotal += gap
+	deviation := gap - ideal
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	recordLatency(p.hist, deviation)
+}
+
+// restart forgets the previous send so a pause isn't counted as a gap
+func (p *pacingRecorder) restart() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = time.Time{}
+}
+
+// mergePacing combines the recorders of several limiters into one report
+func mergePacing(recorders ...*pacingRecorder) PacingReport {
+	var report PacingReport
+	hist := newLatencyHistogram()
+	var gapTotal, idealTotal time.Duration
+	var limiters int
+	for _, p := range recorders {
+		if p == nil {
+			continue
+		}
+		p.mu.Lock()
+		hist.Merge(p.hist)
+		gapTotal += p.gapTotal
+		if p.ideal > 0 {
+			idealTotal += p.ideal
+			limiters++
+		}
+		p.mu.Unlock()
+	}
+
+	report.Samples = hist.TotalCount()
+	if report.Samples > 0 {
+		report.MeanGap = gapTotal / time.Duration(report.Samples)
+	}
+	if limiters > 0 {
+		report.Ideal = idealTotal / time.Duration(limiters)
+	}
+	report.P50Deviation = latencyPercentile(hist, 50)
+	report.P99Deviation = latencyPercentile(hist, 99)
+	report.MaxDeviation = latencyPercentile(hist, 100)
+	return report
+}
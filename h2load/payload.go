@@ -0,0 +1,252 @@
+package h2load
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SizeDistKind is the shape of a SizeDist
+type SizeDistKind int
+
+const (
+	SizeFixed     SizeDistKind = iota // Always Min
+	SizeUniform                       // Uniform between Min and Max
+	SizeLognormal                     // Lognormal around Median, capped at Max if set
+)
+
+// SizeDist is a distribution of random body sizes, drawn for every request
+type SizeDist struct {
+	Kind   SizeDistKind
+	Min    int64   // Fixed size, or the lower bound of uniform sizes
+	Max    int64   // Upper bound of uniform sizes, cap of lognormal sizes (0 = no cap)
+	Median int64   // Median of lognormal sizes
+	Sigma  float64 // Standard deviation of the log of lognormal sizes (default: 1)
+}
+
+// ParseSizeDist parses fixed:SIZE, uniform:MIN-MAX or
+// lognormal:MEDIAN[,SIGMA[,MAX]], with sizes such as 4KB or 1MiB
+func ParseSizeDist(s string) (SizeDist, error) {
+	kind, args, _ := strings.Cut(s, ":")
+	invalid := fmt.Errorf("invalid size distribution %q, expected fixed:SIZE, uniform:MIN-MAX or lognormal:MEDIAN[,SIGMA[,MAX]]", s)
+	parseSize := func(v string) (int64, error) {
+		var n int64
+		err := (&byteSizeValue{&n}).Set(v)
+		return n, err
+	}
+	var d SizeDist
+	var err error
+	switch strings.ToLower(kind) {
+	case "fixed":
+		d.Kind = SizeFixed
+		d.Min, err = parseSize(args)
+	case "uniform":
+		lo, hi, ok := strings.Cut(args, "-")
+		if !ok {
+			return SizeDist{}, invalid
+		}
+		d.Kind = SizeUniform
+		if d.Min, err = parseSize(lo); err == nil {
+			d.Max, err = parseSize(hi)
+		}
+	case "lognormal":
+		parts := strings.Split(args, ",")
+		if len(parts) > 3 {
+			return SizeDist{}, invalid
+		}
+		d.Kind = SizeLognormal
+		d.Median, err = parseSize(parts[0])
+		if err == nil && len(parts) > 1 {
+			d.Sigma, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+		if err == nil && len(parts) > 2 {
+			d.Max, err = parseSize(parts[2])
+		}
+	default:
+		return SizeDist{}, invalid
+	}
+	if err != nil {
+		return SizeDist{}, invalid
+	}
+	return d, d.Validate()
+}
+
+func (d SizeDist) String() string {
+	switch d.Kind {
+	case SizeUniform:
+		return fmt.Sprintf("uniform:%s-%s", formatBytes(d.Min), formatBytes(d.Max))
+	case SizeLognormal:
+		s := fmt.Sprintf("lognormal:%s,%g", formatBytes(d.Median), d.sigma())
+		if d.Max > 0 {
+			s += "," + formatBytes(d.Max)
+		}
+		return s
+	}
+	return "fixed:" + formatBytes(d.Min)
+}
+
+func (d SizeDist) Validate() error {
+	switch {
+	case d.Min < 0 || d.Max < 0 || d.Median < 0 || d.Sigma < 0:
+		return fmt.Errorf("size distribution %s: sizes must be greater than 0", d)
+	case d.Kind == SizeUniform && d.Max < d.Min:
+		return fmt.Errorf("size distribution %s: max must be greater than min", d)
+	case d.Kind == SizeLognormal && d.Median == 0:
+		return fmt.Errorf("size distribution %s: median must be greater than 0", d)
+	}
+	return nil
+}
+
+func (d SizeDist) sigma() float64 {
+	if d.Sigma == 0 {
+		return 1
+	}
+	return d.Sigma
+}
+
+// sample draws a size from r
+func (d SizeDist) sample(r *lockedRand) int64 {
+	switch d.Kind {
+	case SizeUniform:
+		return d.Min + r.Int64N(d.Max-d.Min+1)
+	case SizeLognormal:
+		n := int64(math.Round(float64(d.Median) * math.Exp(r.NormFloat64()*d.sigma())))
+		if d.Max > 0 {
+			n = min(n, d.Max)
+		}
+		return n
+	}
+	return d.Min
+}
+
+// randomBlock is the random data bodies are cut from. Each body starts at a
+// random offset, so bodies differ and don't compress. The data is the same
+// in every run, only the offsets are drawn.
+var randomBlock = sync.OnceValue(func() []byte {
+	r := rand.New(rand.NewPCG(1, 1))
+	block := make([]byte, 1<<20)
+	for i := 0; i < len(block); i += 8 {
+		v := r.Uint64()
+		for j := 0; j < 8; j++ {
+			block[i+j] = byte(v >> (8 * j))
+		}
+	}
+	return block
+})
+
+// randomReader reads randomBlock cyclically from a random offset
+type randomReader struct {
+	offset int
+}
+
+func (r *randomReader) Read(p []byte) (int, error) {
+	block := randomBlock()
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], block[r.offset:])
+		n += c
+		r.offset = (r.offset + c) % len(block)
+	}
+	return n, nil
+}
+
+// RandomBody returns a random body of n bytes, generated as it is read
+func RandomBody(n int64) io.Reader {
+	return randomBody(n, rand.IntN)
+}
+
+// randomBody returns a random body of n bytes, from an offset drawn by intN
+func randomBody(n int64, intN func(n int) int) io.Reader {
+	return io.LimitReader(&randomReader{offset: intN(len(randomBlock()))}, n)
+}
+
+// RandomRequestFactory returns a request factory for RunRequestsFactory
+// sending method requests to url with random bodies, their sizes drawn from
+// sizes
+func RandomRequestFactory(method, url string, header http.Header, sizes SizeDist) (func() *http.Request, error) {
+	return randomRequestFactory(method, url, header, sizes, newLockedRand(0, randBodies))
+}
+
+// randomRequestFactory is RandomRequestFactory drawing sizes and bodies from r
+func randomRequestFactory(method, url string, header http.Header, sizes SizeDist, r *lockedRand) (func() *http.Request, error) {
+	return newBodyRequestFactory(method, url, header, func() (BodyFactory, int64) {
+		n := sizes.sample(r)
+		return func() io.Reader { return randomBody(n, r.IntN) }, n
+	})
+}
+
+// sizeBucket returns the bucket of a body size, by powers of 4 from 1KiB,
+// and the bucket's lower bound
+func sizeBucket(n int64) (string, int64) {
+	if n < 1<<10 {
+		return "<1KiB", 0
+	}
+	lower := int64(1 << 10)
+	for lower*4 <= n && lower < 1<<40 {
+		lower *= 4
+	}
+	return formatBytes(lower) + "-" + formatBytes(lower*4), lower
+}
+
+// SizeBucketStats are the stats of the requests whose body size falls in
+// one bucket
+type SizeBucketStats struct {
+	Bucket string
+	Lower  int64 // Smallest size in the bucket
+	RequestStats
+}
+
+// sizeCollector is a statsObserver keeping stats per body size bucket across
+// all clients
+type sizeCollector struct {
+	routes *routeCollector // Keyed by bucket
+	mu     sync.Mutex
+	lowers map[string]int64
+}
+
+func newSizeCollector() *sizeCollector {
+	return &sizeCollector{routes: newRouteCollector(), lowers: make(map[string]int64)}
+}
+
+// record is a statsObserver
+func (c *sizeCollector) record(entry LogEntry, success bool) {
+	bucket, lower := sizeBucket(entry.BytesOut)
+	c.mu.Lock()
+	c.lowers[bucket] = lower
+	c.mu.Unlock()
+	entry.Route = bucket
+	c.routes.record(entry, success)
+}
+
+func (c *sizeCollector) reset() {
+	c.routes.reset()
+}
+
+// snapshot returns the stats of every bucket sorted by size, with durations
+// set to duration
+func (c *sizeCollector) snapshot(duration time.Duration) []SizeBucketStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var buckets []SizeBucketStats
+	for _, r := range c.routes.snapshot(duration) {
+		buckets = append(buckets, SizeBucketStats{Bucket: r.Route, Lower: c.lowers[r.Route], RequestStats: r.RequestStats})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Lower < buckets[j].Lower })
+	return buckets
+}
+
+// FormatSizeBucketStats formats per-size-bucket stats as a table
+func FormatSizeBucketStats(buckets []SizeBucketStats) string {
+	rows := make([]RouteStats, len(buckets))
+	for i, b := range buckets {
+		rows[i] = RouteStats{Route: b.Bucket, RequestStats: b.RequestStats}
+	}
+	return formatStatsTable("Per-Body-Size Statistics:", "Body Size", rows)
+}
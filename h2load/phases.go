@@ -0,0 +1,330 @@
+package h2load
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Phase is one stage of a multi-phase run, e.g. a spike, a sustained load or
+// a recovery
+type Phase struct {
+	Name     string
+	Duration time.Duration
+	Rps      int    // Rate shared by the phase's clients (0 = unlimited)
+	Clients  int    // Clients sending, the others are paused (0 = all of the run's)
+	Streams  int    // Concurrent streams per client (0 = the run's)
+	Scenario string // Name of the plan's scenario sent (default: the run's requests)
+}
+
+// Scenario is a request a phase sends instead of the run's
+type Scenario struct {
+	URL      string      // Absolute or relative to the run's URL, on the run's host
+	Method   string      // Request method (default: the run's)
+	Headers  http.Header // Added to the run's headers
+	BodyFile string      // Streamed as the body of every request (default: the run's body)
+}
+
+// PhasePlan is an ordered list of phases run one after the other on the same
+// connections, and the scenarios they send
+type PhasePlan struct {
+	Phases    []Phase
+	Scenarios map[string]Scenario
+}
+
+func (p *PhasePlan) Validate(conf H2loadConf) error {
+	if len(p.Phases) == 0 {
+		return fmt.Errorf("phase plan needs at least one phase")
+	}
+	for _, phase := range p.Phases {
+		if phase.Duration <= 0 {
+			return fmt.Errorf("phase %q: duration must be greater than 0", phase.Name)
+		}
+		if phase.Rps < 0 || phase.Clients < 0 || phase.Streams < 0 {
+			return fmt.Errorf("phase %q: rps, clients and streams must be greater than 0", phase.Name)
+		}
+		if phase.Rps > 0 && phase.Rps < p.clients(phase, conf) {
+			return fmt.Errorf("phase %q: rps must be at least its clients, every client needs a rate", phase.Name)
+		}
+		if _, ok := p.Scenarios[phase.Scenario]; phase.Scenario != "" && !ok {
+			return fmt.Errorf("phase %q: unknown scenario %q", phase.Name, phase.Scenario)
+		}
+	}
+	for name, s := range p.Scenarios {
+		if _, err := s.conf(conf); err != nil {
+			return fmt.Errorf("scenario %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Duration returns the length of the whole plan
+func (p *PhasePlan) Duration() time.Duration {
+	var total time.Duration
+	for _, phase := range p.Phases {
+		total += phase.Duration
+	}
+	return total
+}
+
+// MaxClients returns the clients the plan needs, the most any phase runs
+func (p *PhasePlan) MaxClients(conf H2loadConf) int {
+	clients := 0
+	for _, phase := range p.Phases {
+		clients = max(clients, p.clients(phase, conf))
+	}
+	return clients
+}
+
+// clients returns the clients phase sends from
+func (p *PhasePlan) clients(phase Phase, conf H2loadConf) int {
+	if phase.Clients > 0 {
+		return phase.Clients
+	}
+	return max(conf.Clients, 1)
+}
+
+// result returns the empty result of phase, with the clients and streams it
+// runs
+func (p *PhasePlan) result(phase Phase, conf H2loadConf) PhaseResult {
+	r := PhaseResult{Phase: phase, Clients: p.clients(phase, conf), Streams: max(conf.ConcurrentStreams, 1)}
+	if phase.Streams > 0 {
+		r.Streams = phase.Streams
+	}
+	return r
+}
+
+// conf returns the run's conf sending the scenario's requests
+func (s Scenario) conf(conf H2loadConf) (H2loadConf, error) {
+	url, err := resolveOnHost(conf.URL, s.URL)
+	if err != nil {
+		return conf, err
+	}
+	conf.URL = url
+	if s.Method != "" {
+		if !validToken(s.Method) {
+			return conf, fmt.Errorf("invalid method %q", s.Method)
+		}
+		conf.Method = s.Method
+	}
+	// The scenario replaces the run's URL list
+	conf.URLList = nil
+	conf.Headers = conf.Headers.Clone()
+	if conf.Headers == nil {
+		conf.Headers = make(http.Header)
+	}
+	for name, values := range s.Headers {
+		conf.Headers[http.CanonicalHeaderKey(name)] = values
+	}
+	if s.BodyFile != "" {
+		conf.Body = BodyConf{File: s.BodyFile}
+		conf.GraphQL = GraphQLConf{}
+		if err := conf.Body.Validate(); err != nil {
+			return conf, err
+		}
+	}
+	return conf, nil
+}
+
+// LoadPhasePlan reads a phase plan from a JSON file such as
+//
+//	{
+//	  "scenarios": {"checkout": {"url": "/cart/checkout", "method": "POST", "body_file": "order.json"}},
+//	  "phases": [
+//	    {"name": "spike", "duration": "30s", "rps": 5000, "clients": 20, "streams": 50},
+//	    {"name": "sustain", "duration": "5m", "rps": 1000, "scenario": "checkout"},
+//	    {"name": "recover", "duration": "2m", "rps": 100}
+//	  ]
+//	}
+func LoadPhasePlan(path string) (PhasePlan, error) {
+	var file struct {
+		Scenarios map[string]struct {
+			URL      string            `json:"url"`
+			Method   string            `json:"method"`
+			Headers  map[string]string `json:"headers"`
+			BodyFile string            `json:"body_file"`
+		} `json:"scenarios"`
+		Phases []struct {
+			Name     string `json:"name"`
+			Duration string `json:"duration"`
+			Rps      int    `json:"rps"`
+			Clients  int    `json:"clients"`
+			Streams  int    `json:"streams"`
+			Scenario string `json:"scenario"`
+		} `json:"phases"`
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PhasePlan{}, err
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return PhasePlan{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	plan := PhasePlan{Scenarios: make(map[string]Scenario)}
+	for name, s := range file.Scenarios {
+		scenario := Scenario{URL: s.URL, Method: s.Method, BodyFile: s.BodyFile, Headers: make(http.Header)}
+		for k, v := range s.Headers {
+			scenario.Headers.Set(k, v)
+		}
+		plan.Scenarios[name] = scenario
+	}
+	for i, p := range file.Phases {
+		name := p.Name
+		if name == "" {
+			name = fmt.Sprintf("phase %d", i+1)
+		}
+		duration, err := time.ParseDuration(p.Duration)
+		if err != nil {
+			return PhasePlan{}, fmt.Errorf("%s: phase %q: invalid duration %q", path, name, p.Duration)
+		}
+		plan.Phases = append(plan.Phases, Phase{
+			Name:     name,
+			Duration: duration,
+			Rps:      p.Rps,
+			Clients:  p.Clients,
+			Streams:  p.Streams,
+			Scenario: p.Scenario,
+		})
+	}
+	return plan, nil
+}
+
+// PhaseResult is the outcome of one phase. Requests are counted in the phase
+// they complete in.
+type PhaseResult struct {
+	Phase   Phase
+	Clients int // Clients the phase sent from
+	Streams int // Concurrent streams per client
+	Stats   RequestStats
+}
+
+// String formats the phase as a stats section
+func (r PhaseResult) String() string {
+	return fmt.Sprintf("Phase %s (%s):\n%s", r.Phase.Name, r.summary(), r.Stats)
+}
+
+// summary formats the settings of the phase
+func (r PhaseResult) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v, ", r.Phase.Duration)
+	if r.Phase.Rps > 0 {
+		fmt.Fprintf(&b, "%d/s", r.Phase.Rps)
+	} else {
+		fmt.Fprintf(&b, "unlimited")
+	}
+	fmt.Fprintf(&b, ", %d clients x %d streams", r.Clients, r.Streams)
+	if r.Phase.Scenario != "" {
+		fmt.Fprintf(&b, ", scenario %s", r.Phase.Scenario)
+	}
+	return b.String()
+}
+
+// RunPhases runs the phases of plan one after the other, on the clients and
+// connections of h, and then stops. Every phase retunes the running clients:
+// the ones it doesn't use are paused, the others share its rate. h needs as
+// many clients as the plan's MaxClients, and no TotalRps; the phases set the
+// rate. The stats of every phase are returned by GetPhaseResults.
+func (h *H2loadClient) RunPhases(plan PhasePlan) error {
+	if err := plan.Validate(h.ClientsConf); err != nil {
+		return err
+	}
+	if h.ClientsConf.TotalRps > 0 {
+		return fmt.Errorf("phases set the rate, total rps can't be used with them")
+	}
+	if h.ClientsConf.Spike.enabled() {
+		return fmt.Errorf("phases set the rate, spikes can't be used with them")
+	}
+	if clients := plan.MaxClients(h.ClientsConf); clients > len(h.Clients) {
+		return fmt.Errorf("phase plan needs %d clients, the run has %d", clients, len(h.Clients))
+	}
+
+	def, err := h.requestFactory(h.ClientsConf)
+	if err != nil {
+		return err
+	}
+	factories := map[string]func() *http.Request{"": def}
+	for name, s := range plan.Scenarios {
+		conf, err := s.conf(h.ClientsConf)
+		if err != nil {
+			return fmt.Errorf("scenario %q: %w", name, err)
+		}
+		if factories[name], err = h.requestFactory(conf); err != nil {
+			return fmt.Errorf("scenario %q: %w", name, err)
+		}
+	}
+
+	var current atomic.Pointer[func() *http.Request]
+	window := newStatsWindow()
+	for _, c := range h.Clients {
+		c.addStatsObserver(window.record)
+	}
+	h.phases = nil
+	errCh := make(chan error, 1)
+	for i, phase := range plan.Phases {
+		result := plan.result(phase, h.ClientsConf)
+		factory := factories[phase.Scenario]
+		current.Store(&factory)
+		if err := h.startPhase(result); err != nil {
+			h.Stop()
+			return err
+		}
+		if i == 0 {
+			window.take()
+			go func() {
+				errCh <- h.RunRequestsFactory(func() *http.Request {
+					return (*current.Load())()
+				})
+			}()
+		}
+
+		timer := time.NewTimer(phase.Duration)
+		select {
+		case err := <-errCh:
+			// Stopped or aborted before the plan was done
+			timer.Stop()
+			result.Stats, _ = window.take()
+			h.phases = append(h.phases, result)
+			return err
+		case <-timer.C:
+		}
+		result.Stats, _ = window.take()
+		h.phases = append(h.phases, result)
+	}
+	h.Stop()
+	return <-errCh
+}
+
+// startPhase retunes the clients for the phase of r
+func (h *H2loadClient) startPhase(r PhaseResult) error {
+	for i, c := range h.Clients {
+		if i >= r.Clients {
+			c.Pause()
+			continue
+		}
+		rps := 0
+		if r.Phase.Rps > 0 {
+			rps = r.Phase.Rps / r.Clients
+			if i < r.Phase.Rps%r.Clients {
+				rps++
+			}
+		}
+		if err := c.SetRps(rps); err != nil {
+			return err
+		}
+		if err := c.SetConcurrentStreams(r.Streams); err != nil {
+			return err
+		}
+		c.Resume()
+	}
+	return nil
+}
+
+// GetPhaseResults returns the stats of every phase of the last RunPhases
+func (h *H2loadClient) GetPhaseResults() []PhaseResult {
+	return h.phases
+}
@@ -0,0 +1,47 @@
+package h2load
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofServer serves the generator's own net/http/pprof profiles during a
+// run, e.g. go tool pprof http://localhost:6060/debug/pprof/profile
+type pprofServer struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// listenPprof starts serving the pprof endpoints on addr. The handlers are
+// registered on their own mux, not http.DefaultServeMux.
+func listenPprof(addr string) (*pprofServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for pprof: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s := &pprofServer{listener: l, server: &http.Server{Handler: mux}}
+	go func() {
+		if err := s.server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("pprof server stopped: %v\n", err)
+		}
+	}()
+	return s, nil
+}
+
+// URL returns the index of the profiles
+func (s *pprofServer) URL() string {
+	return fmt.Sprintf("http://%s/debug/pprof/", s.listener.Addr())
+}
+
+func (s *pprofServer) Close() error {
+	return s.server.Close()
+}
@@ -0,0 +1,112 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	urlpkg "net/url"
+	"time"
+)
+
+// defaultPreflightTimeout bounds the pre-flight request, connecting included
+const defaultPreflightTimeout = 10 * time.Second
+
+// PreflightConf sends a single GET before the load starts, so a mistyped
+// URL or a server that is down fails the run at once instead of producing
+// a report of failed requests
+type PreflightConf struct {
+	Path    string        // Path, or URL on the host of the conf's URL, probed (default: the conf's URL)
+	Timeout time.Duration // Bound of the probe, connecting included (default: 10s)
+}
+
+func (c *PreflightConf) Validate(conf H2loadConf) error {
+	if c.Timeout < 0 {
+		return fmt.Errorf("pre-flight timeout must be greater than 0")
+	}
+	_, err := c.url(conf.URL)
+	return err
+}
+
+// url returns the URL probed, which must be on the host of base as it is
+// dialed like the load's
+func (c *PreflightConf) url(base string) (*urlpkg.URL, error) {
+	u, err := urlpkg.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if c.Path == "" {
+		return u, nil
+	}
+	ref, err := urlpkg.Parse(c.Path)
+	if err != nil {
+		return nil, fmt.Errorf("pre-flight: invalid path: %w", err)
+	}
+	resolved := u.ResolveReference(ref)
+	if resolved.Scheme != u.Scheme || resolved.Host != u.Host {
+		return nil, fmt.Errorf("pre-flight path must be on %s://%s", u.Scheme, u.Host)
+	}
+	return resolved, nil
+}
+
+// PreflightResult is the response to the pre-flight request
+type PreflightResult struct {
+	URL     string
+	Status  int
+	Latency time.Duration
+}
+
+// String formats the PreflightResult as a readable string
+func (r PreflightResult) String() string {
+	return fmt.Sprintf("Pre-flight: GET %s returned HTTP %d in %v", r.URL, r.Status, r.Latency)
+}
+
+// Preflight sends the pre-flight request of pf on its own connection, with
+// conf's headers, and fails unless it gets a 2xx or 3xx response
+func Preflight(conf H2loadConf, pf PreflightConf) (PreflightResult, error) {
+	if err := pf.Validate(conf); err != nil {
+		return PreflightResult{}, err
+	}
+	timeout := pf.Timeout
+	if timeout == 0 {
+		timeout = defaultPreflightTimeout
+	}
+	u, _ := pf.url(conf.URL)
+	result := PreflightResult{URL: u.String()}
+
+	conf.Requests, conf.Rps, conf.TotalRps = 0, 0, 0
+	conf.ConcurrentStreams, conf.Clients = 1, 1
+	conf.Retry = RetryConf{}
+	client := NewH2Client(conf)
+	defer func() {
+		// The client never runs DoRequestsFactory, which normally closes them
+		client.closeChannels()
+		client.Close()
+	}()
+	if err := client.Connect(); err != nil {
+		return result, fmt.Errorf("pre-flight GET %s: %w", result.URL, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, result.URL, nil)
+	if err != nil {
+		return result, fmt.Errorf("pre-flight GET %s: %w", result.URL, err)
+	}
+	for k, v := range conf.Headers {
+		req.Header[k] = v
+	}
+	start := time.Now()
+	resp, err := client.DoRequest(req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, fmt.Errorf("pre-flight GET %s: no response within %v", result.URL, timeout)
+		}
+		return result, fmt.Errorf("pre-flight GET %s: %w", result.URL, err)
+	}
+	result.Status = resp.StatusCode
+	if resp.StatusCode >= 400 {
+		return result, fmt.Errorf("pre-flight GET %s returned HTTP %d", result.URL, resp.StatusCode)
+	}
+	return result, nil
+}
@@ -0,0 +1,318 @@
+package h2load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	urlpkg "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"golang.org/x/net/http2"
+)
+
+// PriorityClass is a class of requests sent with the same stream priority
+type PriorityClass struct {
+	Name      string
+	Weight    int    // 1 to 256
+	Parent    string // Class whose latest stream the class's streams depend on, if any
+	Exclusive bool   // Make the streams the only dependency of the parent
+	URL       string // URL or path requested (default: the conf's URL)
+}
+
+// ParsePriorityClass parses name=weight[,parent=class][,exclusive][,url=URL].
+// url comes last, the rest of the value being the URL.
+func ParsePriorityClass(s string) (PriorityClass, error) {
+	var p PriorityClass
+	rest := s
+	if i := strings.Index(rest, ",url="); i >= 0 {
+		p.URL, rest = rest[i+len(",url="):], rest[:i]
+	}
+	parts := strings.Split(rest, ",")
+	name, weight, ok := strings.Cut(parts[0], "=")
+	if !ok || name == "" {
+		return p, fmt.Errorf("priority class %q must start with name=weight", s)
+	}
+	w, err := strconv.Atoi(weight)
+	if err != nil {
+		return p, fmt.Errorf("priority class %q: invalid weight %q", s, weight)
+	}
+	p.Name, p.Weight = name, w
+	for _, part := range parts[1:] {
+		switch key, value, _ := strings.Cut(part, "="); key {
+		case "parent":
+			p.Parent = value
+		case "exclusive":
+			p.Exclusive = true
+		default:
+			return p, fmt.Errorf("priority class %q: unknown option %q", s, part)
+		}
+	}
+	return p, nil
+}
+
+// String formats the class the way ParsePriorityClass parses it
+func (p PriorityClass) String() string {
+	s := fmt.Sprintf("%s=%d", p.Name, p.Weight)
+	if p.Parent != "" {
+		s += ",parent=" + p.Parent
+	}
+	if p.Exclusive {
+		s += ",exclusive"
+	}
+	if p.URL != "" {
+		s += ",url=" + p.URL
+	}
+	return s
+}
+
+// PriorityConf sends GETs of several priority classes on every connection,
+// so the server's handling of PRIORITY shows in the latency of each class.
+// The x/net transport sends no priorities, so every client opens its own
+// connection and sends conf.Requests requests on it, ConcurrentStreams at a
+// time, cycling through the classes.
+type PriorityConf struct {
+	Classes  []PriorityClass
+	Duration time.Duration // How long requests are sent (0 = the conf's Requests per client)
+}
+
+func (c *PriorityConf) Validate(conf H2loadConf) error {
+	if len(c.Classes) == 0 {
+		return fmt.Errorf("priority needs at least one class")
+	}
+	if c.Duration < 0 {
+		return fmt.Errorf("priority duration must be greater than 0")
+	}
+	if c.Duration == 0 && conf.Requests == 0 {
+		return fmt.Errorf("priority needs a duration or a number of requests")
+	}
+	names := make(map[string]bool, len(c.Classes))
+	for _, p := range c.Classes {
+		if names[p.Name] {
+			return fmt.Errorf("duplicate priority class %q", p.Name)
+		}
+		names[p.Name] = true
+	}
+	for _, p := range c.Classes {
+		if p.Weight < 1 || p.Weight > 256 {
+			return fmt.Errorf("priority class %s: weight must be between 1 and 256", p.Name)
+		}
+		if p.Parent != "" && (p.Parent == p.Name || !names[p.Parent]) {
+			return fmt.Errorf("priority class %s: parent must be another class", p.Name)
+		}
+		if p.Exclusive && p.Parent == "" {
+			return fmt.Errorf("priority class %s: exclusive requires a parent", p.Name)
+		}
+		if _, err := p.resolve(conf.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the URL of the class's requests, which must be on the
+// host of base as they share its connection
+func (p PriorityClass) resolve(base string) (*urlpkg.URL, error) {
+	u, err := urlpkg.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if p.URL == "" {
+		return u, nil
+	}
+	ref, err := urlpkg.Parse(p.URL)
+	if err != nil {
+		return nil, fmt.Errorf("priority class %s: invalid URL: %w", p.Name, err)
+	}
+	resolved := u.ResolveReference(ref)
+	if resolved.Scheme != u.Scheme || resolved.Host != u.Host {
+		return nil, fmt.Errorf("priority class %s: URL must be on %s://%s", p.Name, u.Scheme, u.Host)
+	}
+	return resolved, nil
+}
+
+// PriorityClassStats are the requests of one priority class
+type PriorityClassStats struct {
+	Class      PriorityClass
+	Requests   int64
+	Errors     int64
+	Bytes      int64 // DATA bytes of the responses
+	P50Latency time.Duration
+	P90Latency time.Duration
+	P99Latency time.Duration
+	MaxLatency time.Duration
+}
+
+// PriorityStats are the outcome of a priority run, in the order of the
+// classes
+type PriorityStats struct {
+	Classes   []PriorityClassStats
+	Duration  time.Duration
+	LastError string
+}
+
+// String formats the classes as a table of their latencies
+func (s PriorityStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Stream Priority Statistics:\n")
+	fmt.Fprintf(&b, "%12s %6s %12s %10s %10s %12s %12s %12s %12s\n",
+		"Class", "Weight", "Parent", "Requests", "Errors", "P50", "P90", "P99", "Max")
+	for _, c := range s.Classes {
+		parent := c.Class.Parent
+		if parent == "" {
+			parent = "-"
+		} else if c.Class.Exclusive {
+			parent += "!"
+		}
+		fmt.Fprintf(&b, "%12s %6d %12s %10d %10d %12v %12v %12v %12v\n",
+			c.Class.Name, c.Class.Weight, parent, c.Requests, c.Errors,
+			c.P50Latency, c.P90Latency, c.P99Latency, c.MaxLatency)
+	}
+	fmt.Fprintf(&b, "Total Duration: %v", s.Duration)
+	if s.LastError != "" {
+		fmt.Fprintf(&b, "\nLast Error: %s", s.LastError)
+	}
+	return b.String()
+}
+
+// priorityCollector gathers the stats of all connections of a run
+type priorityCollector struct {
+	mu        sync.Mutex
+	classes   []PriorityClassStats
+	hists     []*hdrhistogram.Histogram
+	lastError string
+}
+
+func newPriorityCollector(classes []PriorityClass) *priorityCollector {
+	c := &priorityCollector{
+		classes: make([]PriorityClassStats, len(classes)),
+		hists:   make([]*hdrhistogram.Histogram, len(classes)),
+	}
+	for i, p := range classes {
+		c.classes[i].Class = p
+		c.hists[i] = newLatencyHistogram()
+	}
+	return c
+}
+
+func (c *priorityCollector) request(class int, latency time.Duration, n int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := &c.classes[class]
+	s.Bytes += n
+	if err != nil {
+		s.Errors++
+		c.lastError = err.Error()
+		return
+	}
+	s.Requests++
+	recordLatency(c.hists[class], latency)
+}
+
+func (c *priorityCollector) stats(duration time.Duration) PriorityStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := PriorityStats{Classes: make([]PriorityClassStats, len(c.classes)), Duration: duration, LastError: c.lastError}
+	for i, s := range c.classes {
+		s.P50Latency = latencyPercentile(c.hists[i], 50)
+		s.P90Latency = latencyPercentile(c.hists[i], 90)
+		s.P99Latency = latencyPercentile(c.hists[i], 99)
+		s.MaxLatency = latencyPercentile(c.hists[i], 100)
+		stats.Classes[i] = s
+	}
+	return stats
+}
+
+// RunPriority sends conf's requests with the stream priorities of p's
+// classes
+func RunPriority(conf H2loadConf, p PriorityConf) (PriorityStats, error) {
+	if err := p.Validate(conf); err != nil {
+		return PriorityStats{}, err
+	}
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return PriorityStats{}, err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	if p.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Duration)
+		defer cancel()
+	}
+	c := newPriorityCollector(p.Classes)
+	start := time.Now()
+	errs := RunConcurrent(client.Clients, func(h *H2Client) error {
+		return h.runPriority(ctx, p, c)
+	})
+	if err := JoinIndexedErrors(errs); err != nil {
+		return c.stats(time.Since(start)), fmt.Errorf("connect failed: %w", err)
+	}
+	return c.stats(time.Since(start)), nil
+}
+
+// runPriority opens a connection and sends requests on it, ConcurrentStreams
+// at a time, every stream cycling through the classes from a different one
+func (h *H2Client) runPriority(ctx context.Context, p PriorityConf, c *priorityCollector) error {
+	// Nothing is sent through the request path, its collectors can stop
+	defer h.closeChannels()
+	urls := make([]*urlpkg.URL, len(p.Classes))
+	index := make(map[string]int, len(p.Classes))
+	for i, class := range p.Classes {
+		u, err := class.resolve(h.Conf.URL)
+		if err != nil {
+			return err
+		}
+		urls[i] = u
+		index[class.Name] = i
+	}
+	pc, err := h.dialH2Conn(ctx, nil, false)
+	if err != nil {
+		return err
+	}
+	defer pc.close()
+
+	// Latest stream of every class, the dependency of its child classes.
+	// Called with the connection's write lock, so IDs are set in order.
+	latest := make([]uint32, len(p.Classes))
+	priority := func(class int) func(id uint32) http2.PriorityParam {
+		return func(id uint32) http2.PriorityParam {
+			latest[class] = id
+			param := http2.PriorityParam{Weight: uint8(p.Classes[class].Weight - 1), Exclusive: p.Classes[class].Exclusive}
+			if parent := p.Classes[class].Parent; parent != "" {
+				param.StreamDep = latest[index[parent]]
+			}
+			return param
+		}
+	}
+
+	var claimed int64
+	claim := func() bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		return p.Duration > 0 || atomic.AddInt64(&claimed, 1) <= int64(h.Conf.Requests)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < max(h.Conf.ConcurrentStreams, 1); i++ {
+		wg.Add(1)
+		go func(class int) {
+			defer wg.Done()
+			for ; claim(); class = (class + 1) % len(p.Classes) {
+				start := time.Now()
+				n, err := pc.get(urls[class], h.Conf.Headers, priority(class))
+				c.request(class, time.Since(start), n, err)
+				if errors.Is(err, errH2ConnClosed) {
+					return
+				}
+			}
+		}(i % len(p.Classes))
+	}
+	wg.Wait()
+	return nil
+}
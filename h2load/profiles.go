@@ -0,0 +1,138 @@
+package h2load
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Profile is a named set of run options saved to disk
+type Profile struct {
+	Name string   `json:"name"`
+	Args []string `json:"args"`
+}
+
+// profileDir returns the directory profiles are saved in, ~/.h2load/profiles
+func profileDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".h2load", "profiles"), nil
+}
+
+// profilePath returns the file of the named profile
+func profilePath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	dir, err := profileDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// SaveProfile saves the run options args under name, replacing any profile
+// with the same name
+func SaveProfile(name string, args []string) error {
+	path, err := profilePath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(Profile{Name: name, Args: args}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LoadProfile reads the profile saved under name
+func LoadProfile(name string) (Profile, error) {
+	path, err := profilePath(name)
+	if err != nil {
+		return Profile{}, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Profile{}, fmt.Errorf("profile %q not found, save it with save-profile", name)
+	}
+	if err != nil {
+		return Profile{}, err
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Profile{}, fmt.Errorf("profile %q: %w", name, err)
+	}
+	return p, nil
+}
+
+// expandProfile replaces a -profile option in args with the options saved in
+// the profile. Options given after it on the command line override the
+// profile's, since the last value of a flag wins.
+func expandProfile(args []string) ([]string, error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		trimmed := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "-") || (trimmed != "profile" && !strings.HasPrefix(trimmed, "profile=")) {
+			continue
+		}
+		name, ok := strings.CutPrefix(trimmed, "profile=")
+		end := i + 1
+		if !ok {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("-profile needs a profile name")
+			}
+			name, end = args[i+1], i+2
+		}
+		p, err := LoadProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		expanded := append(append(append([]string{}, args[:i]...), p.Args...), args[end:]...)
+		return expanded, nil
+	}
+	return args, nil
+}
+
+// saveProfileMain saves run options as a named profile
+func saveProfileMain(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(os.Stderr, "Usage: %s save-profile <name> [run options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Saves the run options to ~/.h2load/profiles/<name>.json, to be reused with\n")
+		fmt.Fprintf(os.Stderr, "'run -profile <name>'. Options given after -profile override the profile's.\n")
+		os.Exit(2)
+	}
+	name, runArgs := args[0], args[1:]
+
+	// Check the options the same way run would
+	expanded, err := expandProfile(runArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	config := ParseArgs(expanded)
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Error: unexpected arguments %v\n", flag.Args())
+		os.Exit(1)
+	}
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := SaveProfile(name, expanded); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to save profile: %v\n", err)
+		os.Exit(1)
+	}
+	path, _ := profilePath(name)
+	fmt.Printf("Saved profile %q to %s\n", name, path)
+}
@@ -0,0 +1,208 @@
+package h2load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	urlpkg "net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// PushConf requests the URL with server push enabled, which the HTTP/2
+// transport of regular runs disables. Every client opens its own connection
+// and sends conf.Requests GETs on it, ConcurrentStreams at a time.
+type PushConf struct {
+	Accept   bool          // Read pushed streams, or reset them as soon as they're promised
+	Duration time.Duration // How long requests are sent (0 = the conf's Requests per client)
+}
+
+func (c *PushConf) Validate(conf H2loadConf) error {
+	if c.Duration < 0 {
+		return fmt.Errorf("push duration must be greater than 0")
+	}
+	if c.Duration == 0 && conf.Requests == 0 {
+		return fmt.Errorf("push needs a duration or a number of requests")
+	}
+	return nil
+}
+
+// PushStats are the outcome of a push run
+type PushStats struct {
+	Requests       int64 // Responses received to the requests sent
+	Errors         int64 // Requests that got no response, or whose pushes didn't complete
+	PushesPromised int64 // PUSH_PROMISE frames received
+	PushesAccepted int64 // Pushed streams read to the end
+	PushesRejected int64 // Pushed streams reset by the client
+	PushErrors     int64 // Pushed streams reset by the server, or with an error status
+	ResponseBytes  int64 // DATA bytes of the responses to the requests
+	PushBytes      int64 // DATA bytes of the pushed streams
+	Duration       time.Duration
+
+	// Latency of the responses to the requests
+	P50Latency time.Duration
+	P99Latency time.Duration
+
+	// Push latency, from the PUSH_PROMISE to the end of the pushed stream
+	P50PushLatency time.Duration
+	P90PushLatency time.Duration
+	P99PushLatency time.Duration
+	MaxPushLatency time.Duration
+
+	LastError string
+}
+
+// String formats the PushStats as a readable string
+func (s PushStats) String() string {
+	summary := fmt.Sprintf(`Server Push Statistics:
+Requests: %d (%d errors)
+Response Latency: p50 %v, p99 %v
+Response Bytes: %s
+Pushes Promised: %d (%d accepted, %d rejected, %d failed)
+Push Bytes: %s
+Push Latency: p50 %v, p90 %v, p99 %v, max %v
+Total Duration: %v`,
+		s.Requests, s.Errors,
+		s.P50Latency, s.P99Latency,
+		formatBytes(s.ResponseBytes),
+		s.PushesPromised, s.PushesAccepted, s.PushesRejected, s.PushErrors,
+		formatBytes(s.PushBytes),
+		s.P50PushLatency, s.P90PushLatency, s.P99PushLatency, s.MaxPushLatency,
+		s.Duration)
+	if s.LastError != "" {
+		summary += fmt.Sprintf("\nLast Error: %s", s.LastError)
+	}
+	return summary
+}
+
+// pushCollector gathers the stats of all connections of a run
+type pushCollector struct {
+	promised, accepted, rejected, pushErrors int64
+	responseBytes, pushBytes                 int64
+
+	mu        sync.Mutex
+	requests  int64
+	errors    int64
+	latencies *hdrhistogram.Histogram
+	pushes    *hdrhistogram.Histogram
+	lastError string
+}
+
+func newPushCollector() *pushCollector {
+	return &pushCollector{latencies: newLatencyHistogram(), pushes: newLatencyHistogram()}
+}
+
+func (c *pushCollector) request(latency time.Duration, n int64, err error) {
+	atomic.AddInt64(&c.responseBytes, n)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.errors++
+		c.lastError = err.Error()
+		return
+	}
+	c.requests++
+	recordLatency(c.latencies, latency)
+}
+
+func (c *pushCollector) pushed(latency time.Duration) {
+	atomic.AddInt64(&c.accepted, 1)
+	c.mu.Lock()
+	recordLatency(c.pushes, latency)
+	c.mu.Unlock()
+}
+
+func (c *pushCollector) stats(duration time.Duration) PushStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return PushStats{
+		Requests:       c.requests,
+		Errors:         c.errors,
+		PushesPromised: atomic.LoadInt64(&c.promised),
+		PushesAccepted: atomic.LoadInt64(&c.accepted),
+		PushesRejected: atomic.LoadInt64(&c.rejected),
+		PushErrors:     atomic.LoadInt64(&c.pushErrors),
+		ResponseBytes:  atomic.LoadInt64(&c.responseBytes),
+		PushBytes:      atomic.LoadInt64(&c.pushBytes),
+		Duration:       duration,
+		P50Latency:     latencyPercentile(c.latencies, 50),
+		P99Latency:     latencyPercentile(c.latencies, 99),
+		P50PushLatency: latencyPercentile(c.pushes, 50),
+		P90PushLatency: latencyPercentile(c.pushes, 90),
+		P99PushLatency: latencyPercentile(c.pushes, 99),
+		MaxPushLatency: latencyPercentile(c.pushes, 100),
+		LastError:      c.lastError,
+	}
+}
+
+// RunPush sends conf's requests with server push enabled
+func RunPush(conf H2loadConf, push PushConf) (PushStats, error) {
+	if err := push.Validate(conf); err != nil {
+		return PushStats{}, err
+	}
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return PushStats{}, err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	if push.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, push.Duration)
+		defer cancel()
+	}
+	c := newPushCollector()
+	start := time.Now()
+	errs := RunConcurrent(client.Clients, func(h *H2Client) error {
+		return h.runPush(ctx, push, c)
+	})
+	if err := JoinIndexedErrors(errs); err != nil {
+		return c.stats(time.Since(start)), fmt.Errorf("connect failed: %w", err)
+	}
+	return c.stats(time.Since(start)), nil
+}
+
+// runPush opens a push-enabled connection and sends requests on it,
+// ConcurrentStreams at a time
+func (h *H2Client) runPush(ctx context.Context, push PushConf, c *pushCollector) error {
+	// Nothing is sent through the request path, its collectors can stop
+	defer h.closeChannels()
+	u, err := urlpkg.Parse(h.Conf.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	pc, err := h.dialH2Conn(ctx, c, push.Accept)
+	if err != nil {
+		return err
+	}
+	defer pc.close()
+
+	var claimed int64
+	claim := func() bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		return push.Duration > 0 || atomic.AddInt64(&claimed, 1) <= int64(h.Conf.Requests)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < max(h.Conf.ConcurrentStreams, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for claim() {
+				start := time.Now()
+				n, err := pc.get(u, h.Conf.Headers, nil)
+				c.request(time.Since(start), n, err)
+				if errors.Is(err, errH2ConnClosed) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
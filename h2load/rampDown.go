@@ -0,0 +1,30 @@
+package h2load
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// errRampDownExpired cancels the requests still in flight when the RampDown
+// drain period runs out
+var errRampDownExpired = errors.New("ramp-down expired")
+
+// abandonable returns req cancelled along with drain, cancelled by the run
+// with errRampDownExpired when its ramp-down runs out. release must be
+// called once req is done.
+func abandonable(req *http.Request, drain context.Context) (out *http.Request, release func()) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	stop := context.AfterFunc(drain, func() {
+		cancel(context.Cause(drain))
+	})
+	return req.WithContext(ctx), func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// abandoned reports whether req was cancelled by an expired ramp-down
+func abandoned(req *http.Request) bool {
+	return errors.Is(context.Cause(req.Context()), errRampDownExpired)
+}
@@ -0,0 +1,74 @@
+package h2load
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// randStream separates the random choices of a run seeded with the same
+// seed, so that adding a choice doesn't shift the others
+type randStream uint64
+
+const (
+	randRpsJitter randStream = iota + 1 // Per-client RPS skew and phase
+	randBodies                          // Random body sizes and offsets
+	randCrud                            // IDs picked for CRUD reads and deletes
+	randFrames                          // Streams sampled for frame debugging
+	randIntervals                       // Skews of RpsModeJitter intervals
+	randDelays                          // Send delays drawn from SendDelay
+	randCORS                            // Requests picked for a CORS preflight
+)
+
+// newSeed returns a random seed, never 0 as 0 means unseeded
+func newSeed() uint64 {
+	for {
+		if seed := rand.Uint64(); seed != 0 {
+			return seed
+		}
+	}
+}
+
+// newRand returns the random source of stream for seed, random if seed is 0.
+// It isn't safe for concurrent use.
+func newRand(seed uint64, stream randStream) *rand.Rand {
+	if seed == 0 {
+		seed = newSeed()
+	}
+	return rand.New(rand.NewPCG(seed, uint64(stream)))
+}
+
+// lockedRand is a random source safe for concurrent use. Concurrent users
+// draw the same sequence for the same seed, though in the order they get to
+// it.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed uint64, stream randStream) *lockedRand {
+	return &lockedRand{r: newRand(seed, stream)}
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+func (l *lockedRand) NormFloat64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.NormFloat64()
+}
+
+func (l *lockedRand) IntN(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.IntN(n)
+}
+
+func (l *lockedRand) Int64N(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int64N(n)
+}
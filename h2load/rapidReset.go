@@ -0,0 +1,279 @@
+package h2load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	urlpkg "net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"golang.org/x/net/http2"
+)
+
+// rapidResetRedial is the wait before dialing again after a connection
+// couldn't be opened
+const rapidResetRedial = 100 * time.Millisecond
+
+// RapidResetConf is a security test of the server's HTTP/2 rapid reset
+// mitigations (CVE-2023-44487), to be run only against servers you operate:
+// streams are opened with a GET and reset with CANCEL right after, at a
+// fixed rate, and the server's reaction is reported. Every client keeps one
+// connection, opening a new one when the server closes it.
+type RapidResetConf struct {
+	Rate     int           // Streams opened and reset per second by the whole run
+	Duration time.Duration // How long streams are reset
+}
+
+func (c *RapidResetConf) Validate() error {
+	// A fixed rate keeps the test measured, it's not a flood
+	if c.Rate <= 0 {
+		return fmt.Errorf("rapid reset rate must be greater than 0")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("rapid reset test needs a duration")
+	}
+	return nil
+}
+
+// RapidResetStats are the server's reaction to a rapid reset test
+type RapidResetStats struct {
+	Connections     int64 // Connections opened
+	ConnectFailures int64 // Connections that couldn't be opened
+	StreamsReset    int64 // Streams opened and reset
+	Survived        int64 // Connections still open at the end of the run
+	Dropped         int64 // Connections closed by the server without a GOAWAY
+
+	GoAways      map[http2.ErrCode]int64 // Connections closed by the server with a GOAWAY, by error code
+	ServerResets map[http2.ErrCode]int64 // RST_STREAMs sent by the server, by error code
+	Duration     time.Duration
+
+	// Streams reset on a connection before the server closed it
+	MinResetsBeforeClose int64
+	P50ResetsBeforeClose int64
+	MaxResetsBeforeClose int64
+
+	// How long connections lasted before the server closed them
+	MinClosedAfter time.Duration
+	P50ClosedAfter time.Duration
+	MaxClosedAfter time.Duration
+
+	LastError string
+}
+
+// Rate returns the streams reset per second
+func (s RapidResetStats) Rate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.StreamsReset) / s.Duration.Seconds()
+}
+
+// Closed returns the connections the server closed
+func (s RapidResetStats) Closed() int64 {
+	closed := s.Dropped
+	for _, n := range s.GoAways {
+		closed += n
+	}
+	return closed
+}
+
+// CalmEvents returns the GOAWAYs and RST_STREAMs with ENHANCE_YOUR_CALM
+func (s RapidResetStats) CalmEvents() int64 {
+	return s.GoAways[http2.ErrCodeEnhanceYourCalm] + s.ServerResets[http2.ErrCodeEnhanceYourCalm]
+}
+
+// String formats the RapidResetStats as a readable string
+func (s RapidResetStats) String() string {
+	summary := fmt.Sprintf(`Rapid Reset Test Statistics:
+Streams Reset: %d (%.2f/s)
+Connections: %d opened (%d failed), %d survived
+Closed by Server: %d (GOAWAY: %s; %d dropped)
+Server RST_STREAMs: %s`,
+		s.StreamsReset, s.Rate(),
+		s.Connections, s.ConnectFailures, s.Survived,
+		s.Closed(), formatErrCodes(s.GoAways), s.Dropped, formatErrCodes(s.ServerResets))
+	if s.Closed() > 0 {
+		summary += fmt.Sprintf("\nResets Before Close: min %d, p50 %d, max %d", s.MinResetsBeforeClose, s.P50ResetsBeforeClose, s.MaxResetsBeforeClose)
+		summary += fmt.Sprintf("\nClosed After: min %v, p50 %v, max %v", s.MinClosedAfter, s.P50ClosedAfter, s.MaxClosedAfter)
+	}
+	switch {
+	case s.CalmEvents() > 0 || s.Closed() > 0:
+		summary += fmt.Sprintf("\nMitigation: the server pushed back (%d ENHANCE_YOUR_CALM, %d connections closed)", s.CalmEvents(), s.Closed())
+	case s.StreamsReset > 0:
+		summary += "\nMitigation: none seen, every connection survived without ENHANCE_YOUR_CALM"
+	}
+	summary += fmt.Sprintf("\nTotal Duration: %v", s.Duration)
+	if s.LastError != "" {
+		summary += fmt.Sprintf("\nLast Error: %s", s.LastError)
+	}
+	return summary
+}
+
+// formatErrCodes formats counts by error code, e.g. "1 NO_ERROR, 3 ENHANCE_YOUR_CALM"
+func formatErrCodes(counts map[http2.ErrCode]int64) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	parts := make([]string, 0, len(counts))
+	for _, code := range slices.Sorted(maps.Keys(counts)) {
+		parts = append(parts, fmt.Sprintf("%d %v", counts[code], code))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// rapidResetCollector gathers the stats of all connections of a run
+type rapidResetCollector struct {
+	mu           sync.Mutex
+	stats        RapidResetStats
+	resetsBefore *hdrhistogram.Histogram
+	closedAfter  *hdrhistogram.Histogram
+}
+
+func newRapidResetCollector() *rapidResetCollector {
+	return &rapidResetCollector{
+		stats: RapidResetStats{
+			GoAways:      make(map[http2.ErrCode]int64),
+			ServerResets: make(map[http2.ErrCode]int64),
+		},
+		resetsBefore: newLatencyHistogram(),
+		closedAfter:  newLatencyHistogram(),
+	}
+}
+
+func (c *rapidResetCollector) update(fn func(s *RapidResetStats)) {
+	c.mu.Lock()
+	fn(&c.stats)
+	c.mu.Unlock()
+}
+
+// done records a connection that reset streams until the end of the run,
+// if it survived, or until the server closed it after open
+func (c *rapidResetCollector) done(pc *h2Conn, streams int64, open time.Duration, survived bool) {
+	resets := pc.resetsReceived()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.StreamsReset += streams
+	for code, n := range resets {
+		c.stats.ServerResets[code] += n
+	}
+	if survived {
+		c.stats.Survived++
+		return
+	}
+	err := pc.closeErr()
+	var goAway http2.GoAwayError
+	if errors.As(err, &goAway) {
+		c.stats.GoAways[goAway.ErrCode]++
+	} else {
+		c.stats.Dropped++
+	}
+	c.stats.LastError = err.Error()
+	c.resetsBefore.RecordValue(streams)
+	recordLatency(c.closedAfter, open)
+}
+
+func (c *rapidResetCollector) result(duration time.Duration) RapidResetStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.GoAways = maps.Clone(c.stats.GoAways)
+	s.ServerResets = maps.Clone(c.stats.ServerResets)
+	s.Duration = duration
+	if c.closedAfter.TotalCount() > 0 {
+		s.MinResetsBeforeClose = c.resetsBefore.Min()
+		s.P50ResetsBeforeClose = c.resetsBefore.ValueAtQuantile(50)
+		s.MaxResetsBeforeClose = c.resetsBefore.Max()
+		s.MinClosedAfter = time.Duration(c.closedAfter.Min()) * time.Microsecond
+	}
+	s.P50ClosedAfter = latencyPercentile(c.closedAfter, 50)
+	s.MaxClosedAfter = latencyPercentile(c.closedAfter, 100)
+	return s
+}
+
+// RunRapidReset runs a rapid reset test with conf's clients, resetting
+// rr.Rate streams per second between them for rr.Duration
+func RunRapidReset(conf H2loadConf, rr RapidResetConf) (RapidResetStats, error) {
+	if err := rr.Validate(); err != nil {
+		return RapidResetStats{}, err
+	}
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return RapidResetStats{}, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rr.Duration)
+	defer cancel()
+	limiter := newRpsLimiter(rr.Rate, RpsModeEven, 0)
+	defer limiter.close()
+	c := newRapidResetCollector()
+	start := time.Now()
+	errs := RunConcurrent(client.Clients, func(h *H2Client) error {
+		return h.runRapidReset(ctx, limiter, c)
+	})
+	return c.result(time.Since(start)), JoinIndexedErrors(errs)
+}
+
+// runRapidReset resets streams on a connection until ctx ends, opening a
+// new connection whenever the server closes the last one
+func (h *H2Client) runRapidReset(ctx context.Context, limiter *rpsLimiter, c *rapidResetCollector) error {
+	// Nothing is sent through the request path, its collectors can stop
+	defer h.closeChannels()
+	u, err := urlpkg.Parse(h.Conf.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	for ctx.Err() == nil {
+		pc, err := h.dialH2Conn(ctx, nil, false)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.update(func(s *RapidResetStats) {
+					s.ConnectFailures++
+					s.LastError = err.Error()
+				})
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(rapidResetRedial):
+			}
+			continue
+		}
+		c.update(func(s *RapidResetStats) { s.Connections++ })
+		h.rapidResetConn(ctx, pc, u, limiter, c)
+	}
+	return nil
+}
+
+// rapidResetConn resets streams on pc until ctx ends or the server closes it
+func (h *H2Client) rapidResetConn(ctx context.Context, pc *h2Conn, u *urlpkg.URL, limiter *rpsLimiter, c *rapidResetCollector) {
+	defer pc.close()
+	opened := time.Now()
+	// Stop waiting for the limiter as soon as the server closes the connection
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-pc.closed:
+			cancel()
+		case <-connCtx.Done():
+		}
+	}()
+	var streams int64
+	for limiter.wait(connCtx) && connCtx.Err() == nil {
+		if err := pc.openAndReset(u, h.Conf.Headers); err != nil {
+			break
+		}
+		streams++
+	}
+	survived := ctx.Err() != nil
+	if !survived {
+		// The reason the connection closed is set once its reads end
+		<-pc.closed
+	}
+	c.done(pc, streams, time.Since(opened), survived)
+}
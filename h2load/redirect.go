@@ -0,0 +1,38 @@
+package h2load
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// checkRedirect returns the redirect policy of a client following up to max
+// redirects. With max 0 the 3xx response is returned as it is. Redirects to
+// other hosts are refused, as they would be sent over the client's
+// connection to the tested server.
+func checkRedirect(max int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if max == 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		if req.URL.Host != via[0].URL.Host {
+			return fmt.Errorf("redirect to another host %s is not followed", req.URL.Host)
+		}
+		return nil
+	}
+}
+
+// redirectChain returns the URLs resp was redirected through to its final
+// request, in order, or nil if it was not redirected
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		chain = append(chain, req.URL.String())
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
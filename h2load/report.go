@@ -0,0 +1,573 @@
+package h2load
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report is the machine-readable summary of a run, written by the CLI with
+// -o json or -o yaml. Durations are in milliseconds.
+type Report struct {
+	URL            string                `json:"url"`
+	Start          time.Time             `json:"start"`
+	Seed           uint64                `json:"seed"`
+	Load           LoadModelReport       `json:"load"`
+	AbortReason    string                `json:"abort_reason,omitempty"`
+	ServerSettings map[string]uint32     `json:"server_settings,omitempty"`
+	Total          StatsReport           `json:"total"`
+	Clients        []StatsReport         `json:"clients"`
+	PerSecond      []SeriesReport        `json:"per_second"`
+	Routes         []RouteReport         `json:"routes,omitempty"`
+	Targets        []TargetReport        `json:"targets,omitempty"`
+	Phases         []PhaseReport         `json:"phases,omitempty"`
+	Spike          *SpikeReport          `json:"spike,omitempty"`
+	Users          *UserReport           `json:"users,omitempty"`
+	Revalidation   *RevalidationReport   `json:"revalidation,omitempty"`
+	ExpectContinue *ExpectContinueReport `json:"expect_continue,omitempty"`
+	CORS           *CORSReport           `json:"cors,omitempty"`
+	Connections    []ConnReport          `json:"connections"`
+	Concurrency    ConcurrencyReport     `json:"concurrency"`
+	Schedule       *ScheduleReport       `json:"schedule,omitempty"`
+	Generator      GeneratorReport       `json:"generator"`
+	Close          *CloseReport          `json:"close,omitempty"`
+	Metadata       RunMetadata           `json:"metadata"`
+}
+
+// StatsReport is RequestStats with durations in milliseconds
+type StatsReport struct {
+	Requests   int64   `json:"requests"`
+	Success    int64   `json:"success"`
+	Failed     int64   `json:"failed"`
+	ErrorRate  float64 `json:"error_rate"`
+	Rps        float64 `json:"rps"`
+	DurationMs float64 `json:"duration_ms"`
+	MinMs      float64 `json:"min_ms"`
+	AvgMs      float64 `json:"avg_ms"`
+	P50Ms      float64 `json:"p50_ms"`
+	P90Ms      float64 `json:"p90_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+	QueueAvgMs float64 `json:"queue_avg_ms"`
+	QueueMaxMs float64 `json:"queue_max_ms"`
+	Abandoned  int64   `json:"abandoned,omitempty"`
+}
+
+// SeriesReport is a TimeSeriesPoint with durations in milliseconds
+type SeriesReport struct {
+	ElapsedMs float64 `json:"elapsed_ms"`
+	Requests  int64   `json:"requests"`
+	Failed    int64   `json:"failed"`
+	Rps       float64 `json:"rps"`
+	P50Ms     float64 `json:"p50_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	InFlight  float64 `json:"in_flight"`
+	MaxFlight int64   `json:"max_in_flight"`
+}
+
+// LoadModelReport is how the run decided when to send: at OfferedRps in the open
+// loop, or from Workers back-to-back in the closed loop, where the server's
+// latency sets the rate
+type LoadModelReport struct {
+	Model       string  `json:"model"`                   // LoadModelOpen or LoadModelClosed
+	OfferedRps  int     `json:"offered_rps,omitempty"`   // Open loop: rate configured for all clients, the starting one with a latency target
+	Workers     int     `json:"workers,omitempty"`       // Closed loop: Clients × ConcurrentStreams
+	ThinkTimeMs float64 `json:"think_time_ms,omitempty"` // Closed loop: pause of a worker between a response and its next request
+}
+
+// ScheduleReport is ScheduleStats with durations in milliseconds, present
+// with a strict schedule
+type ScheduleReport struct {
+	Sends     int64   `json:"sends"`
+	Late      int64   `json:"late"`
+	LateRate  float64 `json:"late_rate"`
+	AvgSlipMs float64 `json:"avg_slip_ms"`
+	MaxSlipMs float64 `json:"max_slip_ms"`
+}
+
+// ConcurrencyReport is ConcurrencyStats
+type ConcurrencyReport struct {
+	Cap   int64   `json:"cap"`
+	Avg   float64 `json:"avg"`
+	Max   int64   `json:"max"`
+	AtCap float64 `json:"at_cap"`
+}
+
+// GeneratorReport is GeneratorStats with durations in milliseconds, and CPU
+// shares of GOMAXPROCS. CPU fields are omitted where the platform doesn't
+// report the process CPU time.
+type GeneratorReport struct {
+	Procs          int      `json:"procs"`
+	AvgCPU         *float64 `json:"avg_cpu,omitempty"`
+	PeakCPU        *float64 `json:"peak_cpu,omitempty"`
+	SaturatedMs    float64  `json:"saturated_ms"`
+	PeakMemory     int64    `json:"peak_memory_bytes"`
+	PeakGoroutines int      `json:"peak_goroutines"`
+	GCCycles       uint32   `json:"gc_cycles"`
+	GCPauseMs      float64  `json:"gc_pause_ms"`
+	Saturated      bool     `json:"saturated"`
+}
+
+// CloseReport is CloseStats with durations in milliseconds, present with a
+// graceful close
+type CloseReport struct {
+	Connections int64   `json:"connections"`
+	Clean       int64   `json:"clean"`
+	GoAways     int64   `json:"goaways_received"`
+	AvgMs       float64 `json:"avg_ms"`
+	MaxMs       float64 `json:"max_ms"`
+}
+
+// ConnReport is ConnectionStats with durations in milliseconds
+type ConnReport struct {
+	ID             uint64     `json:"id"`
+	ClientID       int        `json:"client"`
+	RemoteAddr     string     `json:"remote_addr"`
+	Family         string     `json:"family"` // IPv4 or IPv6
+	Opened         time.Time  `json:"opened"`
+	Closed         *time.Time `json:"closed,omitempty"`
+	Streams        int64      `json:"streams"`
+	Completed      int64      `json:"completed"`
+	ResetsReceived int64      `json:"resets_received"`
+	ResetsSent     int64      `json:"resets_sent"`
+	BytesIn        int64      `json:"bytes_in"`
+	BytesOut       int64      `json:"bytes_out"`
+	AvgMs          float64    `json:"avg_ms"`
+	MaxMs          float64    `json:"max_ms"`
+	LifetimeMs     float64    `json:"lifetime_ms"`
+}
+
+// PhaseReport is a PhaseResult with durations in milliseconds
+type PhaseReport struct {
+	Name       string      `json:"name"`
+	DurationMs float64     `json:"duration_ms"`
+	Rps        int         `json:"rps"` // Rate of the phase, 0 if unlimited
+	Clients    int         `json:"clients"`
+	Streams    int         `json:"streams"`
+	Scenario   string      `json:"scenario,omitempty"`
+	Stats      StatsReport `json:"stats"`
+}
+
+// TargetReport is TargetStats with durations in milliseconds
+type TargetReport struct {
+	Target  string      `json:"target"`
+	Clients int         `json:"clients"`
+	Stats   StatsReport `json:"stats"`
+}
+
+// SpikeReport is SpikeStats with durations in milliseconds, present with
+// Spike
+type SpikeReport struct {
+	Rps         int         `json:"rps"` // Rate added to the baseline during a spike
+	DurationMs  float64     `json:"duration_ms"`
+	EveryMs     float64     `json:"every_ms"`
+	Spikes      int         `json:"spikes"`
+	SpikeTimeMs float64     `json:"spike_time_ms"`
+	Baseline    StatsReport `json:"baseline"`
+	Spike       StatsReport `json:"spike"`
+}
+
+// UserReport is UserStats, present with Users
+type UserReport struct {
+	Users       int               `json:"users"`
+	MinRequests int64             `json:"min_requests"`
+	MaxRequests int64             `json:"max_requests"`
+	CookiesSet  int64             `json:"cookies_set"`
+	Extractors  []ExtractorReport `json:"extractors,omitempty"`
+}
+
+// RevalidationReport is RevalidationStats, present with Revalidate
+type RevalidationReport struct {
+	Conditional int64   `json:"conditional"`
+	NotModified int64   `json:"not_modified"`
+	Modified    int64   `json:"modified"`
+	HitRate     float64 `json:"hit_rate"`
+	BytesSaved  int64   `json:"bytes_saved"`
+}
+
+// ExpectContinueReport is ExpectContinueStats with durations in
+// milliseconds, present with ExpectContinue
+type ExpectContinueReport struct {
+	Requests   int64   `json:"requests"`
+	Continued  int64   `json:"continued"`
+	Rejected   int64   `json:"rejected"`
+	NoContinue int64   `json:"no_continue"`
+	Failed     int64   `json:"failed"`
+	AvgMs      float64 `json:"avg_continue_ms"`
+	P50Ms      float64 `json:"p50_continue_ms"`
+	P99Ms      float64 `json:"p99_continue_ms"`
+	MaxMs      float64 `json:"max_continue_ms"`
+}
+
+// CORSReport is CORSStats with durations in milliseconds, present with
+// CORS
+type CORSReport struct {
+	Preflights int64   `json:"preflights"`
+	Allowed    int64   `json:"allowed"`
+	Denied     int64   `json:"denied"`
+	Failed     int64   `json:"failed"`
+	AvgMs      float64 `json:"avg_ms"`
+	P50Ms      float64 `json:"p50_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+// ExtractorReport is ExtractorStats
+type ExtractorReport struct {
+	Extractor string `json:"extractor"`
+	Hits      int64  `json:"hits"`
+	Misses    int64  `json:"misses"`
+}
+
+// RouteReport is RouteStats with durations in milliseconds
+type RouteReport struct {
+	Route string      `json:"route"`
+	Stats StatsReport `json:"stats"`
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// NewStatsReport converts stats to a StatsReport
+func NewStatsReport(s RequestStats) StatsReport {
+	r := StatsReport{
+		Requests:   s.TotalRequests,
+		Success:    s.SuccessRequests,
+		Failed:     s.FailedRequests,
+		ErrorRate:  s.ErrorRate(),
+		Rps:        s.Rps(),
+		DurationMs: millis(s.Duration),
+		MinMs:      millis(s.MinLatency),
+		P50Ms:      millis(s.P50Latency),
+		P90Ms:      millis(s.P90Latency),
+		P99Ms:      millis(s.P99Latency),
+		MaxMs:      millis(s.MaxLatency),
+		QueueAvgMs: millis(s.AvgQueueDelay()),
+		QueueMaxMs: millis(s.MaxQueueDelay),
+		Abandoned:  s.Abandoned,
+	}
+	if s.TotalRequests > 0 {
+		r.AvgMs = millis(s.TotalLatency / time.Duration(s.TotalRequests))
+	}
+	return r
+}
+
+// NewReport builds the Report of the last run of h, which ends now
+func NewReport(h *H2loadClient, start time.Time) Report {
+	r := Report{
+		Metadata:    NewRunMetadata(start, time.Now()),
+		URL:         h.ClientsConf.URL,
+		Start:       start,
+		Seed:        h.ClientsConf.Seed,
+		AbortReason: h.AbortReason(),
+		Total:       NewStatsReport(h.GetTotalStats()),
+		Clients:     []StatsReport{},
+		PerSecond:   []SeriesReport{},
+		Connections: []ConnReport{},
+	}
+	if s := h.GetServerSettings(); s != nil {
+		r.ServerSettings = s.Map()
+	}
+	conf := h.ClientsConf
+	r.Load.Model = conf.loadModel()
+	switch {
+	case conf.TotalRps > 0:
+		r.Load.OfferedRps = conf.TotalRps
+	case conf.Rps > 0:
+		r.Load.OfferedRps = conf.Rps * len(h.Clients)
+	default:
+		r.Load.Workers = len(h.Clients) * max(conf.ConcurrentStreams, 1)
+		r.Load.ThinkTimeMs = millis(conf.ClosedLoop.ThinkTime)
+	}
+	for _, s := range h.GetAllClientStats() {
+		r.Clients = append(r.Clients, NewStatsReport(s))
+	}
+	for _, p := range h.GetTimeSeries() {
+		r.PerSecond = append(r.PerSecond, SeriesReport{
+			ElapsedMs: millis(p.Elapsed),
+			Requests:  p.Requests,
+			Failed:    p.Failed,
+			Rps:       p.Rps,
+			P50Ms:     millis(p.P50Latency),
+			P99Ms:     millis(p.P99Latency),
+			MaxMs:     millis(p.MaxLatency),
+			InFlight:  p.InFlight,
+			MaxFlight: p.MaxFlight,
+		})
+	}
+	c := h.GetConcurrencyStats()
+	r.Concurrency = ConcurrencyReport{Cap: c.Cap, Avg: c.Avg, Max: c.Max, AtCap: c.AtCap}
+	if conf.StrictSchedule {
+		s := h.GetScheduleStats()
+		r.Schedule = &ScheduleReport{
+			Sends:     s.Sends,
+			Late:      s.Late,
+			LateRate:  s.LateRate(),
+			AvgSlipMs: millis(s.AvgSlip()),
+			MaxSlipMs: millis(s.MaxSlip),
+		}
+	}
+	g := h.GetGeneratorStats()
+	r.Generator = GeneratorReport{
+		Procs:          g.Procs,
+		SaturatedMs:    millis(g.SaturatedTime),
+		PeakMemory:     g.PeakMemory,
+		PeakGoroutines: g.PeakGoroutines,
+		GCCycles:       g.GCCycles,
+		GCPauseMs:      millis(g.GCPauseTotal),
+		Saturated:      g.Saturated(),
+	}
+	if g.CPUKnown {
+		r.Generator.AvgCPU, r.Generator.PeakCPU = &g.AvgCPU, &g.PeakCPU
+	}
+	if h.ClientsConf.Close.Graceful {
+		c := h.GetCloseStats()
+		r.Close = &CloseReport{
+			Connections: c.Connections,
+			Clean:       c.Clean,
+			GoAways:     c.GoAways,
+			AvgMs:       millis(c.AvgLatency()),
+			MaxMs:       millis(c.MaxLatency),
+		}
+	}
+	for _, c := range h.GetConnectionStats() {
+		cr := ConnReport{
+			ID:             c.ID,
+			ClientID:       c.ClientID,
+			RemoteAddr:     c.RemoteAddr,
+			Family:         c.Family,
+			Opened:         c.Opened,
+			Streams:        c.Streams,
+			Completed:      c.Completed,
+			ResetsReceived: c.ResetsReceived,
+			ResetsSent:     c.ResetsSent,
+			BytesIn:        c.BytesIn,
+			BytesOut:       c.BytesOut,
+			AvgMs:          millis(c.AvgLatency()),
+			MaxMs:          millis(c.MaxLatency),
+			LifetimeMs:     millis(c.Lifetime()),
+		}
+		if !c.Closed.IsZero() {
+			cr.Closed = &c.Closed
+		}
+		r.Connections = append(r.Connections, cr)
+	}
+	for _, rs := range h.GetRouteStats() {
+		r.Routes = append(r.Routes, RouteReport{Route: rs.Route, Stats: NewStatsReport(rs.RequestStats)})
+	}
+	for _, t := range h.GetTargetStats() {
+		r.Targets = append(r.Targets, TargetReport{Target: t.Target, Clients: t.Clients, Stats: NewStatsReport(t.RequestStats)})
+	}
+	for _, p := range h.GetPhaseResults() {
+		r.Phases = append(r.Phases, PhaseReport{
+			Name:       p.Phase.Name,
+			DurationMs: millis(p.Phase.Duration),
+			Rps:        p.Phase.Rps,
+			Clients:    p.Clients,
+			Streams:    p.Streams,
+			Scenario:   p.Phase.Scenario,
+			Stats:      NewStatsReport(p.Stats),
+		})
+	}
+	if s, ok := h.GetSpikeStats(); ok {
+		r.Spike = &SpikeReport{
+			Rps:         s.Conf.Rps,
+			DurationMs:  millis(s.Conf.Duration),
+			EveryMs:     millis(s.Conf.Every),
+			Spikes:      s.Spikes,
+			SpikeTimeMs: millis(s.SpikeTime),
+			Baseline:    NewStatsReport(s.Baseline),
+			Spike:       NewStatsReport(s.Spike),
+		}
+	}
+	if u, ok := h.GetUserStats(); ok {
+		r.Users = &UserReport{Users: u.Users, MinRequests: u.MinRequests, MaxRequests: u.MaxRequests, CookiesSet: u.CookiesSet}
+		for _, e := range u.Extractors {
+			r.Users.Extractors = append(r.Users.Extractors, ExtractorReport(e))
+		}
+	}
+	if s, ok := h.GetRevalidationStats(); ok {
+		r.Revalidation = &RevalidationReport{
+			Conditional: s.Conditional,
+			NotModified: s.NotModified,
+			Modified:    s.Modified,
+			HitRate:     s.HitRate(),
+			BytesSaved:  s.BytesSaved,
+		}
+	}
+	if s, ok := h.GetExpectContinueStats(); ok {
+		r.ExpectContinue = &ExpectContinueReport{
+			Requests:   s.Requests,
+			Continued:  s.Continued,
+			Rejected:   s.Rejected,
+			NoContinue: s.NoContinue,
+			Failed:     s.Failed,
+			AvgMs:      millis(s.AvgContinue),
+			P50Ms:      millis(s.P50Continue),
+			P99Ms:      millis(s.P99Continue),
+			MaxMs:      millis(s.MaxContinue),
+		}
+	}
+	if s, ok := h.GetCORSStats(); ok {
+		r.CORS = &CORSReport{
+			Preflights: s.Preflights,
+			Allowed:    s.Allowed,
+			Denied:     s.Denied,
+			Failed:     s.Failed,
+			AvgMs:      millis(s.AvgLatency),
+			P50Ms:      millis(s.P50Latency),
+			P99Ms:      millis(s.P99Latency),
+			MaxMs:      millis(s.MaxLatency),
+		}
+	}
+	return r
+}
+
+// WriteReport writes r to w as a single JSON or YAML document
+func WriteReport(w io.Writer, r Report, format string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "json":
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case "yaml":
+		return writeYAML(w, data)
+	}
+	return fmt.Errorf("unknown output format %q, expected json or yaml", format)
+}
+
+// writeYAML converts a JSON document to YAML, keeping the key order.
+// Strings are double-quoted, which YAML reads the same way as JSON.
+func writeYAML(w io.Writer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := readYAMLNode(dec)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("---\n")
+	if v, ok := node.inline(); ok {
+		b.WriteString(v + "\n")
+	} else {
+		node.write(&b, 0)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// yamlNode is a JSON value with its object keys in document order
+type yamlNode struct {
+	kind   byte // '{', '[' or 0 for scalars
+	keys   []string
+	items  []*yamlNode
+	scalar string
+}
+
+func readYAMLNode(dec *json.Decoder) (*yamlNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		n := &yamlNode{kind: byte(v)}
+		for dec.More() {
+			if n.kind == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+			item, err := readYAMLNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+		}
+		_, err := dec.Token() // Closing delimiter
+		return n, err
+	case string:
+		return &yamlNode{scalar: strconv.Quote(v)}, nil
+	case json.Number:
+		return &yamlNode{scalar: v.String()}, nil
+	case bool:
+		return &yamlNode{scalar: strconv.FormatBool(v)}, nil
+	}
+	return &yamlNode{scalar: "null"}, nil
+}
+
+// empty returns the flow form of an empty object or list, or "" otherwise
+func (n *yamlNode) empty() string {
+	if len(n.items) > 0 {
+		return ""
+	}
+	switch n.kind {
+	case '{':
+		return "{}"
+	case '[':
+		return "[]"
+	}
+	return ""
+}
+
+// inline returns the value written on the same line as its key or dash
+func (n *yamlNode) inline() (string, bool) {
+	if n.kind == 0 {
+		return n.scalar, true
+	}
+	if e := n.empty(); e != "" {
+		return e, true
+	}
+	return "", false
+}
+
+// write writes the entries of an object or list at indent
+func (n *yamlNode) write(b *strings.Builder, indent int) {
+	pad := strings.Repeat("  ", indent)
+	for i, item := range n.items {
+		prefix := pad + "- "
+		if n.kind == '{' {
+			prefix = pad + yamlKey(n.keys[i]) + ":"
+		}
+		if v, ok := item.inline(); ok {
+			if n.kind == '{' {
+				prefix += " "
+			}
+			b.WriteString(prefix + v + "\n")
+			continue
+		}
+		if n.kind == '[' && item.kind == '{' {
+			// Start the object on the dash line
+			var sub strings.Builder
+			item.write(&sub, indent+1)
+			b.WriteString(prefix + strings.TrimPrefix(sub.String(), pad+"  "))
+			continue
+		}
+		b.WriteString(strings.TrimRight(prefix, " ") + "\n")
+		item.write(b, indent+1)
+	}
+}
+
+// yamlKey quotes keys that aren't plain identifiers
+func yamlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, r := range key {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
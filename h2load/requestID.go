@@ -0,0 +1,90 @@
+package h2load
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// RequestIDConf adds generated IDs to every request, recorded in its log
+// entry, so the generator's log can be joined with the server's access logs
+// and traces. IDs are random even in seeded runs, they must not repeat
+// across runs.
+type RequestIDConf struct {
+	Header      string // Header carrying a random UUID, e.g. X-Request-Id (default: none)
+	TraceParent bool   // Send a W3C traceparent header starting a new sampled trace
+}
+
+func (c *RequestIDConf) Validate() error {
+	if c.Header == "" {
+		return nil
+	}
+	if !httpguts.ValidHeaderFieldName(c.Header) {
+		return fmt.Errorf("invalid request ID header name %q", c.Header)
+	}
+	if strings.EqualFold(c.Header, "traceparent") {
+		return fmt.Errorf("request ID header can't be traceparent, it is sent with trace parent")
+	}
+	return nil
+}
+
+// set returns req with the IDs added, unless it already has them, and the
+// request ID and trace ID sent. req itself is left as is, it may be sent
+// again, e.g. by RunRequests.
+func (c *RequestIDConf) set(req *http.Request) (out *http.Request, requestID, traceID string) {
+	if c.Header == "" && !c.TraceParent {
+		return req, "", ""
+	}
+	r := *req
+	r.Header = req.Header.Clone()
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	req = &r
+	if c.Header != "" {
+		if requestID = req.Header.Get(c.Header); requestID == "" {
+			requestID = newUUID()
+			req.Header.Set(c.Header, requestID)
+		}
+	}
+	if c.TraceParent {
+		traceparent := req.Header.Get("Traceparent")
+		if traceparent == "" {
+			traceparent = newTraceParent()
+			req.Header.Set("Traceparent", traceparent)
+		}
+		if parts := strings.Split(traceparent, "-"); len(parts) == 4 {
+			traceID = parts[1]
+		}
+	}
+	return req, requestID, traceID
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(b[8:], rand.Uint64())
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// newTraceParent returns a W3C traceparent of a new sampled trace, with
+// random trace and parent IDs
+func newTraceParent() string {
+	var trace [16]byte
+	var parent [8]byte
+	// All zero IDs are invalid
+	for trace == [16]byte{} || parent == [8]byte{} {
+		binary.BigEndian.PutUint64(trace[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(trace[8:], rand.Uint64())
+		binary.BigEndian.PutUint64(parent[:], rand.Uint64())
+	}
+	return "00-" + hex.EncodeToString(trace[:]) + "-" + hex.EncodeToString(parent[:]) + "-01"
+}
@@ -0,0 +1,118 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Resolve pins the addresses a host and port resolve to, like curl's
+// --resolve, so a test can hit specific IPs without touching the system
+// hosts file. The URL, and so the :authority and TLS server name, stay
+// unchanged. Port "*" matches any port.
+type Resolve struct {
+	Host  string
+	Port  string
+	Addrs []string // Tried in order until one connects
+}
+
+// ParseResolve parses HOST:PORT:ADDR[,ADDR]..., with IPv6 addresses in
+// brackets
+func ParseResolve(s string) (Resolve, error) {
+	parts := splitHostFields(s)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return Resolve{}, fmt.Errorf("invalid resolve %q, expected HOST:PORT:ADDR[,ADDR]...", s)
+	}
+	r := Resolve{Host: unbracketHost(parts[0]), Port: parts[1]}
+	for _, addr := range strings.Split(parts[2], ",") {
+		addr = unbracketHost(strings.TrimSpace(addr))
+		if net.ParseIP(addr) == nil {
+			return Resolve{}, fmt.Errorf("invalid resolve %q: %q is not an IP address", s, addr)
+		}
+		r.Addrs = append(r.Addrs, addr)
+	}
+	return r, nil
+}
+
+func (r Resolve) String() string {
+	addrs := make([]string, len(r.Addrs))
+	for i, addr := range r.Addrs {
+		addrs[i] = bracketHost(addr)
+	}
+	return bracketHost(r.Host) + ":" + r.Port + ":" + strings.Join(addrs, ",")
+}
+
+// resolveAddrs returns the addresses to dial for addr, pinned by the first
+// matching Resolve, or addr itself
+func resolveAddrs(addr string, resolve []Resolve) []string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}
+	}
+	for _, r := range resolve {
+		if strings.EqualFold(r.Host, host) && (r.Port == "*" || r.Port == port) {
+			addrs := make([]string, len(r.Addrs))
+			for i, ip := range r.Addrs {
+				addrs[i] = net.JoinHostPort(ip, port)
+			}
+			return addrs
+		}
+	}
+	return []string{addr}
+}
+
+// dialFirst dials addrs on network in order, returning the first connection
+// made
+func dialFirst(ctx context.Context, addrs []string, network string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	var err error
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dial(ctx, network, addr); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// newResolver returns a resolver querying server, with port 53 if none is
+// given, or the system resolver if server is empty
+func newResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// lookupAddrs resolves the host names of addrs with resolver, timing each
+// lookup into traffic. IP addresses are kept as they are.
+func lookupAddrs(ctx context.Context, resolver *net.Resolver, addrs []string, traffic *trafficCounter) ([]string, error) {
+	var resolved []string
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			resolved = append(resolved, addr)
+			continue
+		}
+		start := time.Now()
+		ips, err := resolver.LookupIPAddr(ctx, host)
+		traffic.lookedUp(time.Since(start))
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			resolved = append(resolved, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	return resolved, nil
+}
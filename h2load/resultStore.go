@@ -0,0 +1,214 @@
+package h2load
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sqliteDrivers are the database/sql driver names of the common SQLite
+// drivers (modernc.org/sqlite and github.com/mattn/go-sqlite3)
+var sqliteDrivers = []string{"sqlite", "sqlite3"}
+
+// resultSchema creates the tables of a ResultStore
+const resultSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at   TEXT NOT NULL,
+	url          TEXT NOT NULL,
+	args         TEXT NOT NULL,
+	abort_reason TEXT NOT NULL,
+	requests     INTEGER NOT NULL,
+	success      INTEGER NOT NULL,
+	failed       INTEGER NOT NULL,
+	error_rate   REAL NOT NULL,
+	rps          REAL NOT NULL,
+	duration_ms  REAL NOT NULL,
+	min_ms       REAL NOT NULL,
+	avg_ms       REAL NOT NULL,
+	p50_ms       REAL NOT NULL,
+	p90_ms       REAL NOT NULL,
+	p99_ms       REAL NOT NULL,
+	max_ms       REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS series (
+	run_id     INTEGER NOT NULL REFERENCES runs(id),
+	elapsed_ms REAL NOT NULL,
+	requests   INTEGER NOT NULL,
+	failed     INTEGER NOT NULL,
+	rps        REAL NOT NULL,
+	p50_ms     REAL NOT NULL,
+	p99_ms     REAL NOT NULL,
+	max_ms     REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS series_run ON series(run_id);
+`
+
+// StoredRun is a run saved in a ResultStore
+type StoredRun struct {
+	ID     int64
+	Args   []string // Command line options of the run
+	Report Report
+}
+
+// String formats the StoredRun as a history line
+func (r StoredRun) String() string {
+	status := "completed"
+	if r.Report.AbortReason != "" {
+		status = "aborted"
+	}
+	t := r.Report.Total
+	return fmt.Sprintf("%5d  %s  %-9s %8.2f rps  p50 %8.2fms  p99 %8.2fms  err %6.2f%%  %s",
+		r.ID, r.Report.Start.Local().Format("2006-01-02 15:04"), status,
+		t.Rps, t.P50Ms, t.P99Ms, t.ErrorRate*100, r.Report.URL)
+}
+
+// ResultStore keeps the history of runs, their config, stats and per-second
+// series, in a SQL database such as SQLite
+type ResultStore struct {
+	db *sql.DB
+}
+
+// OpenResultStore opens or creates the SQLite database at path. A SQLite
+// driver must be linked into the binary, as the CLI links modernc.org/sqlite.
+func OpenResultStore(path string) (*ResultStore, error) {
+	registered := sql.Drivers()
+	for _, driver := range sqliteDrivers {
+		if slices.Contains(registered, driver) {
+			db, err := sql.Open(driver, path)
+			if err != nil {
+				return nil, err
+			}
+			s, err := NewResultStore(db)
+			if err != nil {
+				db.Close()
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no SQLite driver is linked into this binary, import modernc.org/sqlite or github.com/mattn/go-sqlite3")
+}
+
+// NewResultStore uses db as a ResultStore, creating its tables if needed
+func NewResultStore(db *sql.DB) (*ResultStore, error) {
+	for _, stmt := range strings.Split(resultSchema, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &ResultStore{db: db}, nil
+}
+
+// Close closes the database
+func (s *ResultStore) Close() error {
+	return s.db.Close()
+}
+
+// SaveRun appends a run and returns its ID
+func (s *ResultStore) SaveRun(args []string, r Report) (int64, error) {
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	t := r.Total
+	res, err := tx.Exec(`INSERT INTO runs (started_at, url, args, abort_reason, requests, success, failed,
+		error_rate, rps, duration_ms, min_ms, avg_ms, p50_ms, p90_ms, p99_ms, max_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Start.UTC().Format(time.RFC3339Nano), r.URL, string(argsJSON), r.AbortReason,
+		t.Requests, t.Success, t.Failed, t.ErrorRate, t.Rps, t.DurationMs,
+		t.MinMs, t.AvgMs, t.P50Ms, t.P90Ms, t.P99Ms, t.MaxMs)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range r.PerSecond {
+		if _, err := tx.Exec(`INSERT INTO series (run_id, elapsed_ms, requests, failed, rps, p50_ms, p99_ms, max_ms)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, p.ElapsedMs, p.Requests, p.Failed, p.Rps, p.P50Ms, p.P99Ms, p.MaxMs); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+const runColumns = `id, started_at, url, args, abort_reason, requests, success, failed,
+	error_rate, rps, duration_ms, min_ms, avg_ms, p50_ms, p90_ms, p99_ms, max_ms`
+
+// History returns the most recent runs, newest first, without their series
+func (s *ResultStore) History(limit int) ([]StoredRun, error) {
+	rows, err := s.db.Query(`SELECT `+runColumns+` FROM runs ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []StoredRun
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// LoadRun returns the run with the given ID, including its series
+func (s *ResultStore) LoadRun(id int64) (StoredRun, error) {
+	run, err := scanRun(s.db.QueryRow(`SELECT `+runColumns+` FROM runs WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return StoredRun{}, fmt.Errorf("run %d not found", id)
+	}
+	if err != nil {
+		return StoredRun{}, err
+	}
+
+	rows, err := s.db.Query(`SELECT elapsed_ms, requests, failed, rps, p50_ms, p99_ms, max_ms
+		FROM series WHERE run_id = ? ORDER BY elapsed_ms`, id)
+	if err != nil {
+		return StoredRun{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p SeriesReport
+		if err := rows.Scan(&p.ElapsedMs, &p.Requests, &p.Failed, &p.Rps, &p.P50Ms, &p.P99Ms, &p.MaxMs); err != nil {
+			return StoredRun{}, err
+		}
+		run.Report.PerSecond = append(run.Report.PerSecond, p)
+	}
+	return run, rows.Err()
+}
+
+func scanRun(row interface{ Scan(...any) error }) (StoredRun, error) {
+	var run StoredRun
+	var started, args string
+	r, t := &run.Report, &run.Report.Total
+	err := row.Scan(&run.ID, &started, &r.URL, &args, &r.AbortReason,
+		&t.Requests, &t.Success, &t.Failed, &t.ErrorRate, &t.Rps, &t.DurationMs,
+		&t.MinMs, &t.AvgMs, &t.P50Ms, &t.P90Ms, &t.P99Ms, &t.MaxMs)
+	if err != nil {
+		return StoredRun{}, err
+	}
+	if r.Start, err = time.Parse(time.RFC3339Nano, started); err != nil {
+		return StoredRun{}, fmt.Errorf("run %d: %w", run.ID, err)
+	}
+	if err := json.Unmarshal([]byte(args), &run.Args); err != nil {
+		return StoredRun{}, fmt.Errorf("run %d: %w", run.ID, err)
+	}
+	return run, nil
+}
@@ -0,0 +1,216 @@
+package h2load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// RetryOn is a set of failures that are retried
+type RetryOn uint8
+
+const (
+	RetryOn5xx     RetryOn = 1 << iota // Responses with a 5xx status
+	RetryOnTimeout                     // Attempts exceeding RetryConf.Timeout, or other timeouts
+	RetryOnReset                       // Stream resets, GOAWAYs and dropped connections
+
+	RetryOnAll = RetryOn5xx | RetryOnTimeout | RetryOnReset
+)
+
+var retryOnNames = []struct {
+	name string
+	on   RetryOn
+}{
+	{"5xx", RetryOn5xx}, {"timeout", RetryOnTimeout}, {"reset", RetryOnReset},
+}
+
+// ParseRetryOn parses a comma-separated list of 5xx, timeout and reset
+func ParseRetryOn(s string) (RetryOn, error) {
+	var on RetryOn
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		found := false
+		for _, n := range retryOnNames {
+			if strings.EqualFold(part, n.name) {
+				on |= n.on
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid retry-on %q, expected 5xx, timeout or reset", part)
+		}
+	}
+	return on, nil
+}
+
+func (r RetryOn) String() string {
+	var names []string
+	for _, n := range retryOnNames {
+		if r&n.on != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// RetryConf retries failed requests inside DoRequest, the way production
+// clients do. Stats record the outcome of the last attempt, with the latency
+// of all attempts and backoffs.
+type RetryConf struct {
+	Max     int           // Retries per request (0 = don't retry)
+	Backoff time.Duration // Delay before the first retry, doubled before each next one
+	On      RetryOn       // Failures retried (default: RetryOnAll)
+	Timeout time.Duration // Timeout of each attempt (0 = none)
+}
+
+func (c *RetryConf) Validate() error {
+	if c.Max < 0 {
+		return fmt.Errorf("retries must be greater than 0")
+	}
+	if c.Backoff < 0 {
+		return fmt.Errorf("retry backoff must be greater than 0")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("retry timeout must be greater than 0")
+	}
+	return nil
+}
+
+func (c *RetryConf) on() RetryOn {
+	if c.On == 0 {
+		return RetryOnAll
+	}
+	return c.On
+}
+
+// retryable reports whether an attempt ending with resp or err is retried
+func (c *RetryConf) retryable(resp *http.Response, err error) bool {
+	on := c.on()
+	switch {
+	case err == nil:
+		return on&RetryOn5xx != 0 && resp.StatusCode >= 500
+	case isTimeout(err):
+		return on&RetryOnTimeout != 0
+	case isReset(err):
+		return on&RetryOnReset != 0
+	}
+	return false
+}
+
+// backoff returns the delay before retry n, counted from 0
+func (c *RetryConf) backoff(n int) time.Duration {
+	return c.Backoff << min(n, 16)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isReset(err error) bool {
+	var streamErr http2.StreamError
+	var goAway http2.GoAwayError
+	return errors.As(err, &streamErr) || errors.As(err, &goAway) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryRequest returns a copy of req to send again, or false if its body
+// can't be replayed
+func retryRequest(req *http.Request) (*http.Request, bool) {
+	clone := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return clone, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	clone.Body = body
+	return clone, true
+}
+
+// cancelBody cancels the timeout of an attempt once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// sleepContext sleeps for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// do sends req once, bounded by Conf.Retry.Timeout, counting the body bytes
+// sent
+func (h *H2Client) do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &uploadBody{ReadCloser: req.Body, sent: &h.bytesOut}
+	}
+	if h.Conf.Retry.Timeout <= 0 {
+		return h.client.Do(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), h.Conf.Retry.Timeout)
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// doWithRetries sends req, retrying it as Conf.Retry allows, and returns the
+// outcome of the last attempt with the number of retries made
+func (h *H2Client) doWithRetries(req *http.Request) (*http.Response, int, error) {
+	resp, err := h.do(req)
+	retries := 0
+	for retries < h.Conf.Retry.Max && h.Conf.Retry.retryable(resp, err) {
+		next, ok := retryRequest(req)
+		if !ok {
+			break
+		}
+		if err != nil {
+			if isEnhanceYourCalm(err) {
+				h.enhanceYourCalm()
+			}
+			h.handleStreamError(err)
+		} else {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if !sleepContext(req.Context(), h.Conf.Retry.backoff(retries)) {
+			return nil, retries, req.Context().Err()
+		}
+		retries++
+		req = next
+		resp, err = h.do(req)
+	}
+	return resp, retries, err
+}
@@ -0,0 +1,162 @@
+package h2load
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// maxCachedValidators bounds the URLs a client keeps validators for, the
+// ones seen after it is full are always fetched in full
+const maxCachedValidators = 10000
+
+// cachedValidators are the validators of a URL's last full response
+type cachedValidators struct {
+	etag         string
+	lastModified string
+	size         int64 // Body bytes of the response
+}
+
+// validatorCache keeps the ETag and Last-Modified of the responses of a
+// client by URL, as a browser cache would, and counts the revalidations
+type validatorCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedValidators
+
+	conditional, notModified, modified, bytesSaved int64 // Atomic counters
+}
+
+func newValidatorCache() *validatorCache {
+	return &validatorCache{entries: make(map[string]cachedValidators)}
+}
+
+// revalidatable reports whether req's responses can be revalidated
+func revalidatable(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// setValidators returns req with If-None-Match and If-Modified-Since set from
+// the validators cached for its URL, as a copy, or req itself without any
+func (c *validatorCache) setValidators(req *http.Request) *http.Request {
+	if !revalidatable(req) || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return req
+	}
+	c.mu.Lock()
+	v, ok := c.entries[req.URL.String()]
+	c.mu.Unlock()
+	if !ok {
+		return req
+	}
+	// Factories may hand out the same request every time
+	req = req.Clone(req.Context())
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+	return req
+}
+
+// record counts the response to req, whose body was size bytes, and caches
+// the validators of full responses
+func (c *validatorCache) record(req *http.Request, status int, header http.Header, size int64) {
+	if !revalidatable(req) {
+		return
+	}
+	key := req.URL.String()
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+	if conditional {
+		atomic.AddInt64(&c.conditional, 1)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case status == http.StatusNotModified:
+		if conditional {
+			atomic.AddInt64(&c.notModified, 1)
+			if req.Method != http.MethodHead {
+				atomic.AddInt64(&c.bytesSaved, c.entries[key].size)
+			}
+		}
+	case status/100 == 2:
+		if conditional {
+			atomic.AddInt64(&c.modified, 1)
+		}
+		v := cachedValidators{etag: header.Get("ETag"), lastModified: header.Get("Last-Modified"), size: size}
+		old, cached := c.entries[key]
+		if req.Method == http.MethodHead {
+			// HEAD responses have no body, keep the size of the GET's
+			v.size = old.size
+		}
+		switch {
+		case v.etag == "" && v.lastModified == "":
+			delete(c.entries, key)
+		case cached || len(c.entries) < maxCachedValidators:
+			c.entries[key] = v
+		}
+	}
+}
+
+func (c *validatorCache) stats() RevalidationStats {
+	return RevalidationStats{
+		Conditional: atomic.LoadInt64(&c.conditional),
+		NotModified: atomic.LoadInt64(&c.notModified),
+		Modified:    atomic.LoadInt64(&c.modified),
+		BytesSaved:  atomic.LoadInt64(&c.bytesSaved),
+	}
+}
+
+func (c *validatorCache) reset() {
+	for _, n := range []*int64{&c.conditional, &c.notModified, &c.modified, &c.bytesSaved} {
+		atomic.StoreInt64(n, 0)
+	}
+}
+
+// RevalidationStats count the conditional requests of a run with Revalidate
+type RevalidationStats struct {
+	Conditional int64 // Requests sent with If-None-Match or If-Modified-Since
+	NotModified int64 // Answered with 304 Not Modified
+	Modified    int64 // Answered with a full 2xx response
+	BytesSaved  int64 // Body bytes of the cached responses 304s stood for
+}
+
+func (s *RevalidationStats) merge(other RevalidationStats) {
+	s.Conditional += other.Conditional
+	s.NotModified += other.NotModified
+	s.Modified += other.Modified
+	s.BytesSaved += other.BytesSaved
+}
+
+// HitRate returns the fraction of conditional requests answered with 304
+func (s RevalidationStats) HitRate() float64 {
+	if s.Conditional == 0 {
+		return 0
+	}
+	return float64(s.NotModified) / float64(s.Conditional)
+}
+
+// String formats the RevalidationStats as a readable string
+func (s RevalidationStats) String() string {
+	return fmt.Sprintf(`Cache Revalidation:
+Conditional Requests: %d
+Not Modified (304): %d
+Modified (2xx): %d
+304 Hit Rate: %.2f%%
+Bandwidth Saved: %s`,
+		s.Conditional, s.NotModified, s.Modified, s.HitRate()*100, formatBytes(s.BytesSaved))
+}
+
+// GetRevalidationStats returns the conditional requests of all clients, and
+// false without Revalidate
+func (h *H2loadClient) GetRevalidationStats() (RevalidationStats, bool) {
+	if !h.ClientsConf.Revalidate {
+		return RevalidationStats{}, false
+	}
+	var total RevalidationStats
+	for _, c := range h.Clients {
+		total.merge(c.validators.stats())
+	}
+	return total, true
+}
@@ -0,0 +1,123 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// maxRoutes bounds the number of routes tracked separately, requests on any
+// further route are counted under otherRoute
+const maxRoutes = 100
+
+const otherRoute = "(other)"
+
+type routeKey struct{}
+
+// WithRoute names the route req is counted under in the per-route stats,
+// e.g. "GET /items/{id}", instead of its method and path. Use it to group
+// requests whose paths contain IDs.
+func WithRoute(req *http.Request, route string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), routeKey{}, route))
+}
+
+// requestRoute returns the route req is counted under
+func requestRoute(req *http.Request) string {
+	if route, ok := req.Context().Value(routeKey{}).(string); ok {
+		return route
+	}
+	return req.Method + " " + req.URL.Path
+}
+
+// RouteStats are the stats of the requests on one route
+type RouteStats struct {
+	Route string
+	RequestStats
+}
+
+// routeCollector is a statsObserver keeping stats per route across all clients
+type routeCollector struct {
+	mu     sync.Mutex
+	routes map[string]*routeEntry
+}
+
+type routeEntry struct {
+	stats RequestStats
+	hist  *hdrhistogram.Histogram
+}
+
+func newRouteCollector() *routeCollector {
+	return &routeCollector{routes: make(map[string]*routeEntry)}
+}
+
+// record is a statsObserver
+func (c *routeCollector) record(entry LogEntry, success bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	route, ok := c.routes[entry.Route]
+	if !ok {
+		if len(c.routes) >= maxRoutes {
+			entry.Route = otherRoute
+		}
+		if route, ok = c.routes[entry.Route]; !ok {
+			route = &routeEntry{hist: newLatencyHistogram()}
+			c.routes[entry.Route] = route
+		}
+	}
+	route.stats.record(entry, success)
+	recordLatency(route.hist, entry.Latency)
+}
+
+func (c *routeCollector) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes = make(map[string]*routeEntry)
+}
+
+// snapshot returns the stats of every route sorted by route, with durations
+// set to duration
+func (c *routeCollector) snapshot(duration time.Duration) []RouteStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	routes := make([]RouteStats, 0, len(c.routes))
+	for name, route := range c.routes {
+		stats := route.stats
+		stats.setPercentiles(route.hist)
+		stats.Duration = duration
+		routes = append(routes, RouteStats{Route: name, RequestStats: stats})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+	return routes
+}
+
+// FormatRouteStats formats per-route stats as a table
+func FormatRouteStats(routes []RouteStats) string {
+	return formatStatsTable("Per-Route Statistics:", "Route", routes)
+}
+
+// formatStatsTable formats stats as a table under title, with the row names
+// in a column named column
+func formatStatsTable(title, column string, rows []RouteStats) string {
+	width := len(column)
+	for _, r := range rows {
+		width = max(width, len(r.Route))
+	}
+	var b strings.Builder
+	b.WriteString(title + "\n")
+	fmt.Fprintf(&b, "%-*s %10s %10s %8s %12s %12s %12s\n", width, column, "Requests", "Req/sec", "Errors", "Avg", "P50", "P99")
+	for _, r := range rows {
+		var avg time.Duration
+		if r.TotalRequests > 0 {
+			avg = r.TotalLatency / time.Duration(r.TotalRequests)
+		}
+		fmt.Fprintf(&b, "%-*s %10d %10.2f %7.2f%% %12v %12v %12v\n", width, r.Route,
+			r.TotalRequests, r.Rps(), r.ErrorRate()*100, avg, r.P50Latency, r.P99Latency)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
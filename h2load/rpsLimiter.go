@@ -0,0 +1,273 @@
+package h2load
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// rpsLimiter is a token bucket that issues up to rps request tokens per second.
+// A single limiter can be shared by several clients to enforce a total rate,
+// and its rate can be changed while clients are waiting on it.
+type rpsLimiter struct {
+	mu        sync.Mutex
+	rps       int
+	mode      RpsMode
+	paused    bool
+	tokens    chan struct{}
+	changed   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	pacing    *pacingRecorder // Gaps between sends, even mode only
+	schedule  *sendSchedule   // Send times of a strict schedule, which replace the tokens
+	jitter    float64         // Random skew of every interval in RpsModeJitter, as a fraction
+	jitterR   *lockedRand     // Draws the skews, nil for none
+}
+
+// newRpsLimiter starts a limiter whose first refill is delayed by phase
+func newRpsLimiter(rps int, mode RpsMode, phase time.Duration) *rpsLimiter {
+	return newJitteredRpsLimiter(rps, mode, phase, 0, nil)
+}
+
+// newJitteredRpsLimiter starts a limiter whose first refill is delayed by
+// phase, and whose intervals in RpsModeJitter are skewed by a random ±jitter
+// drawn from r
+func newJitteredRpsLimiter(rps int, mode RpsMode, phase time.Duration, jitter float64, r *lockedRand) *rpsLimiter {
+	l := &rpsLimiter{
+		rps:     rps,
+		mode:    mode,
+		tokens:  make(chan struct{}, rps),
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		jitter:  jitter,
+		jitterR: r,
+	}
+	if mode == RpsModeEven {
+		l.pacing = newPacingRecorder()
+	}
+	go l.run(phase)
+	return l
+}
+
+// refillPeriod returns the interval between refills for rps and mode
+func refillPeriod(rps int, mode RpsMode) time.Duration {
+	if mode.spaced() {
+		return time.Second / time.Duration(rps)
+	}
+	return time.Second
+}
+
+func (l *rpsLimiter) run(phase time.Duration) {
+	if phase > 0 {
+		timer := time.NewTimer(phase)
+		select {
+		case <-l.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	if l.mode == RpsModeJitter {
+		// Add one token at skewed intervals, each timed from the previous
+		// one's due time so they average out to the rate
+		next := time.Now().Add(l.interval())
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-l.changed:
+				next = time.Now().Add(l.interval())
+			case <-timer.C:
+				l.addTokens(1)
+				next = next.Add(l.interval())
+			}
+			timer.Reset(time.Until(next))
+		}
+	}
+
+	if l.mode == RpsModeEven {
+		// Add one token at even intervals
+		ticker := time.NewTicker(refillPeriod(l.getRate(), l.mode))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-l.changed:
+				ticker.Reset(refillPeriod(l.getRate(), l.mode))
+			case <-ticker.C:
+				l.addTokens(1)
+			}
+		}
+	}
+
+	// Burst mode: fill the bucket all at once every second, a rate change
+	// takes effect at the next refill
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	l.addTokens(l.getRate())
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.changed:
+		case <-ticker.C:
+			l.addTokens(l.getRate())
+		}
+	}
+}
+
+func (l *rpsLimiter) addTokens(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.paused {
+		return
+	}
+	for i := 0; i < n && len(l.tokens) < l.rps; i++ {
+		select {
+		case l.tokens <- struct{}{}:
+		default:
+			// Bucket is full, skip the remaining tokens
+			return
+		}
+	}
+}
+
+// interval returns the next interval between tokens, skewed in
+// RpsModeJitter
+func (l *rpsLimiter) interval() time.Duration {
+	period := refillPeriod(l.getRate(), l.mode)
+	return time.Duration(float64(period) * (1 + l.skew()))
+}
+
+// skew returns a random fraction within ±jitter in RpsModeJitter, 0 in the
+// other modes
+func (l *rpsLimiter) skew() float64 {
+	if l.mode != RpsModeJitter || l.jitter == 0 {
+		return 0
+	}
+	var u float64
+	if l.jitterR != nil {
+		u = l.jitterR.Float64()
+	} else {
+		u = rand.Float64()
+	}
+	return (u*2 - 1) * l.jitter
+}
+
+func (l *rpsLimiter) getRate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rps
+}
+
+// setRate changes the rate of the limiter while it is running
+func (l *rpsLimiter) setRate(rps int) {
+	if rps < 1 {
+		rps = 1
+	}
+	l.mu.Lock()
+	if rps == l.rps {
+		l.mu.Unlock()
+		return
+	}
+	l.rps = rps
+	if l.schedule != nil {
+		// Carry on from the next send time at the new rate
+		s := l.schedule
+		s.restart(s.at(s.claimed, l.rps, l.mode))
+		l.mu.Unlock()
+		return
+	}
+	if rps > cap(l.tokens) {
+		// Grow the bucket, waiters on the old one are woken up by the close
+		old := l.tokens
+		l.tokens = make(chan struct{}, rps)
+		close(old)
+	}
+	// Drop the tokens stored up at a higher rate
+	for drained := false; len(l.tokens) > rps && !drained; {
+		select {
+		case <-l.tokens:
+		default:
+			drained = true
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case l.changed <- struct{}{}:
+	default:
+	}
+}
+
+// setPaused stops or restarts token issuance. Pausing drains the bucket so
+// resuming doesn't release the tokens stored up before the pause.
+func (l *rpsLimiter) setPaused(paused bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.schedule != nil && l.paused != paused {
+		// A new schedule from the resume, the pause doesn't make sends late
+		l.schedule.restart(time.Now())
+	}
+	l.paused = paused
+	if !paused {
+		if l.pacing != nil {
+			l.pacing.restart()
+		}
+		return
+	}
+	for {
+		select {
+		case <-l.tokens:
+		default:
+			return
+		}
+	}
+}
+
+// wait blocks until a token is available, returning false if ctx is done first.
+// A closed limiter no longer limits, so its waiters are let through.
+func (l *rpsLimiter) wait(ctx context.Context) bool {
+	if l.schedule != nil {
+		_, ok := l.waitScheduled(ctx)
+		return ok
+	}
+	for {
+		l.mu.Lock()
+		tokens := l.tokens
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-l.done:
+			return true
+		case _, ok := <-tokens:
+			if ok {
+				return true
+			}
+			// The bucket was replaced, wait on the new one
+		}
+	}
+}
+
+// sent records that a request scheduled at scheduled was sent at t with a
+// token from this limiter. scheduled is only known for a strict schedule.
+func (l *rpsLimiter) sent(scheduled, t time.Time) {
+	if l.schedule != nil {
+		l.schedule.recorder.sent(scheduled, t)
+	}
+	if l.pacing == nil {
+		return
+	}
+	l.pacing.sent(t, refillPeriod(l.getRate(), l.mode))
+}
+
+func (l *rpsLimiter) close() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
@@ -0,0 +1,79 @@
+package h2load
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// toolName names the tool in run metadata
+const toolName = "h2loadGo"
+
+// sensitiveHeaders are the headers whose values are redacted from run
+// metadata, which is saved and shared along with the results
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// RunMetadata records the tool, machine and options of a run, so its results
+// can be interpreted long after
+type RunMetadata struct {
+	Tool      string            `json:"tool"`
+	Version   string            `json:"version"`            // Module version the tool was built as, "(devel)" for local builds
+	Revision  string            `json:"revision,omitempty"` // VCS revision the tool was built from, "-dirty" if modified
+	GoVersion string            `json:"go_version"`
+	Hostname  string            `json:"hostname"`
+	Start     time.Time         `json:"start"`
+	End       time.Time         `json:"end"`
+	Config    map[string]string `json:"config,omitempty"` // Effective options by name, set by the CLI
+}
+
+// NewRunMetadata returns the metadata of a run on this machine from start to
+// end
+func NewRunMetadata(start, end time.Time) RunMetadata {
+	build := ReadBuildInfo()
+	hostname, _ := os.Hostname()
+	return RunMetadata{
+		Tool:      toolName,
+		Version:   build.Version,
+		Revision:  build.Revision,
+		GoVersion: build.GoVersion,
+		Hostname:  hostname,
+		Start:     start,
+		End:       end,
+	}
+}
+
+// WriteCSVComments writes the metadata as "# key: value" lines, for the top
+// of a CSV file. Most CSV readers skip them with a comment option, e.g.
+// pandas' comment='#'.
+func (m RunMetadata) WriteCSVComments(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# tool: %s %s", m.Tool, m.Version)
+	if m.Revision != "" {
+		fmt.Fprintf(&b, " (%s)", m.Revision)
+	}
+	fmt.Fprintf(&b, ", %s\n", m.GoVersion)
+	fmt.Fprintf(&b, "# hostname: %s\n", m.Hostname)
+	fmt.Fprintf(&b, "# start: %s\n", m.Start.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "# end: %s\n", m.End.UTC().Format(time.RFC3339Nano))
+	for _, name := range slices.Sorted(maps.Keys(m.Config)) {
+		fmt.Fprintf(&b, "# config.%s: %s\n", name, m.Config[name])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// redactHeaders returns header with the values of sensitiveHeaders replaced
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := redacted[name]; ok {
+			redacted[name] = []string{"[redacted]"}
+		}
+	}
+	return redacted
+}
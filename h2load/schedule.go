@@ -0,0 +1,187 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// lateSendSlack is how far behind its scheduled time a send may go before it
+// counts as late, timer and scheduling noise rather than the generator
+// falling behind
+const lateSendSlack = 5 * time.Millisecond
+
+// ScheduleStats are how far the sends of a strict schedule slipped behind
+// their scheduled times. A late send means the generator fell behind the
+// offered rate, held up by its stream slots, its CPUs or a slow connection,
+// and caught up by sending back-to-back.
+type ScheduleStats struct {
+	Sends     int64
+	Late      int64         // Sends more than lateSendSlack behind their scheduled time
+	TotalSlip time.Duration // Of the late sends
+	MaxSlip   time.Duration
+}
+
+// LateRate returns the share of sends that were late
+func (s ScheduleStats) LateRate() float64 {
+	if s.Sends == 0 {
+		return 0
+	}
+	return float64(s.Late) / float64(s.Sends)
+}
+
+// AvgSlip returns the average slip of the late sends
+func (s ScheduleStats) AvgSlip() time.Duration {
+	if s.Late == 0 {
+		return 0
+	}
+	return s.TotalSlip / time.Duration(s.Late)
+}
+
+// String formats the ScheduleStats as a readable string
+func (s ScheduleStats) String() string {
+	summary := fmt.Sprintf("Schedule: %d of %d sends late (%.1f%%), slip avg %v, max %v",
+		s.Late, s.Sends, s.LateRate()*100, s.AvgSlip(), s.MaxSlip)
+	if s.Late > 0 {
+		summary += "\nWarning: the generator fell behind the schedule, late requests were sent back-to-back to keep the offered rate; raise -s or spread the load over more machines"
+	}
+	return summary
+}
+
+// scheduleRecorder records the slip of every send of a strict schedule
+type scheduleRecorder struct {
+	mu    sync.Mutex
+	stats ScheduleStats
+}
+
+// sent records a send at t, scheduled at scheduled
+func (r *scheduleRecorder) sent(scheduled, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Sends++
+	if slip := t.Sub(scheduled); slip > lateSendSlack {
+		r.stats.Late++
+		r.stats.TotalSlip += slip
+		r.stats.MaxSlip = max(r.stats.MaxSlip, slip)
+	}
+}
+
+// mergeSchedules combines the recorders of several limiters
+func mergeSchedules(recorders ...*scheduleRecorder) ScheduleStats {
+	var merged ScheduleStats
+	for _, r := range recorders {
+		if r == nil {
+			continue
+		}
+		r.mu.Lock()
+		merged.Sends += r.stats.Sends
+		merged.Late += r.stats.Late
+		merged.TotalSlip += r.stats.TotalSlip
+		merged.MaxSlip = max(merged.MaxSlip, r.stats.MaxSlip)
+		r.mu.Unlock()
+	}
+	return merged
+}
+
+// sendSchedule computes the send time of every request of a strict
+// schedule ahead, from its start and rate. A rate change or a pause starts a
+// new schedule, voiding the send times claimed from the old one. It is
+// guarded by its limiter's mu.
+type sendSchedule struct {
+	start       time.Time
+	claimed     int64         // Send times claimed since start
+	rescheduled chan struct{} // Closed when a new schedule starts
+	recorder    *scheduleRecorder
+}
+
+// at returns the scheduled time of the n-th send at rps: evenly spread in
+// even and jitter mode, all at the start of their second in burst mode
+func (s *sendSchedule) at(n int64, rps int, mode RpsMode) time.Time {
+	if mode.spaced() {
+		return s.start.Add(time.Duration(n) * time.Second / time.Duration(rps))
+	}
+	return s.start.Add(time.Duration(n/int64(rps)) * time.Second)
+}
+
+// restart starts a new schedule at start
+func (s *sendSchedule) restart(start time.Time) {
+	s.start = start
+	s.claimed = 0
+	close(s.rescheduled)
+	s.rescheduled = make(chan struct{})
+}
+
+// newScheduledLimiter starts a limiter that schedules every send ahead, the
+// first at phase from now, instead of issuing tokens. Sends are never
+// skipped: when the generator falls behind they are let through late, and
+// counted as such, until it catches up. In RpsModeJitter every send time is
+// skewed by a random ±jitter of the interval, drawn from r.
+func newScheduledLimiter(rps int, mode RpsMode, phase time.Duration, jitter float64, r *lockedRand) *rpsLimiter {
+	l := &rpsLimiter{
+		rps:     rps,
+		mode:    mode,
+		done:    make(chan struct{}),
+		jitter:  jitter,
+		jitterR: r,
+		schedule: &sendSchedule{
+			start:       time.Now().Add(phase),
+			rescheduled: make(chan struct{}),
+			recorder:    &scheduleRecorder{},
+		},
+	}
+	if mode == RpsModeEven {
+		l.pacing = newPacingRecorder()
+	}
+	return l
+}
+
+// waitScheduled claims the next send time of the schedule and blocks until
+// it, returning false if ctx is done first. A send time in the past is
+// returned at once. Without a schedule it waits for a token, with no send
+// time.
+func (l *rpsLimiter) waitScheduled(ctx context.Context) (time.Time, bool) {
+	if l.schedule == nil {
+		return time.Time{}, l.wait(ctx)
+	}
+	for {
+		l.mu.Lock()
+		s := l.schedule
+		rescheduled := s.rescheduled
+		if l.paused {
+			l.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return time.Time{}, false
+			case <-l.done:
+				return time.Now(), true
+			case <-rescheduled:
+			}
+			continue
+		}
+		at := s.at(s.claimed, l.rps, l.mode)
+		s.claimed++
+		rps := l.rps
+		l.mu.Unlock()
+		at = at.Add(time.Duration(float64(refillPeriod(rps, l.mode)) * l.skew()))
+
+		wait := time.Until(at)
+		if wait <= 0 {
+			return at, true
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Time{}, false
+		case <-l.done:
+			timer.Stop()
+			return time.Now(), true
+		case <-rescheduled:
+			// The send time claimed was voided, claim one of the new schedule
+			timer.Stop()
+		case <-timer.C:
+			return at, true
+		}
+	}
+}
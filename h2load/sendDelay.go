@@ -0,0 +1,133 @@
+package h2load
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DelayDist is a distribution of random delays, drawn for every request. It
+// has the shapes of SizeDist, with durations for sizes.
+type DelayDist struct {
+	Kind   SizeDistKind
+	Min    time.Duration // Fixed delay, or the lower bound of uniform delays
+	Max    time.Duration // Upper bound of uniform delays, cap of lognormal delays (0 = no cap)
+	Median time.Duration // Median of lognormal delays
+	Sigma  float64       // Standard deviation of the log of lognormal delays (default: 1)
+}
+
+// ParseDelayDist parses fixed:DELAY, uniform:MIN-MAX or
+// lognormal:MEDIAN[,SIGMA[,MAX]], with delays such as 40ms or 1.5s
+func ParseDelayDist(s string) (DelayDist, error) {
+	kind, args, _ := strings.Cut(s, ":")
+	invalid := fmt.Errorf("invalid delay distribution %q, expected fixed:DELAY, uniform:MIN-MAX or lognormal:MEDIAN[,SIGMA[,MAX]]", s)
+	parseDelay := func(v string) (time.Duration, error) {
+		return time.ParseDuration(strings.TrimSpace(v))
+	}
+	var d DelayDist
+	var err error
+	switch strings.ToLower(kind) {
+	case "fixed":
+		d.Kind = SizeFixed
+		d.Min, err = parseDelay(args)
+	case "uniform":
+		lo, hi, ok := strings.Cut(args, "-")
+		if !ok {
+			return DelayDist{}, invalid
+		}
+		d.Kind = SizeUniform
+		if d.Min, err = parseDelay(lo); err == nil {
+			d.Max, err = parseDelay(hi)
+		}
+	case "lognormal":
+		parts := strings.Split(args, ",")
+		if len(parts) > 3 {
+			return DelayDist{}, invalid
+		}
+		d.Kind = SizeLognormal
+		d.Median, err = parseDelay(parts[0])
+		if err == nil && len(parts) > 1 {
+			d.Sigma, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+		if err == nil && len(parts) > 2 {
+			d.Max, err = parseDelay(parts[2])
+		}
+	default:
+		return DelayDist{}, invalid
+	}
+	if err != nil {
+		return DelayDist{}, invalid
+	}
+	return d, d.Validate()
+}
+
+func (d DelayDist) String() string {
+	switch d.Kind {
+	case SizeUniform:
+		return fmt.Sprintf("uniform:%v-%v", d.Min, d.Max)
+	case SizeLognormal:
+		s := fmt.Sprintf("lognormal:%v,%g", d.Median, d.sigma())
+		if d.Max > 0 {
+			s += fmt.Sprintf(",%v", d.Max)
+		}
+		return s
+	}
+	return fmt.Sprintf("fixed:%v", d.Min)
+}
+
+func (d DelayDist) Validate() error {
+	switch {
+	case d.Min < 0 || d.Max < 0 || d.Median < 0 || d.Sigma < 0:
+		return fmt.Errorf("delay distribution %s: delays must be greater than 0", d)
+	case d.Kind == SizeUniform && d.Max < d.Min:
+		return fmt.Errorf("delay distribution %s: max must be greater than min", d)
+	case d.Kind == SizeLognormal && d.Median == 0:
+		return fmt.Errorf("delay distribution %s: median must be greater than 0", d)
+	}
+	return nil
+}
+
+func (d DelayDist) sigma() float64 {
+	if d.Sigma == 0 {
+		return 1
+	}
+	return d.Sigma
+}
+
+// sample draws a delay from r
+func (d DelayDist) sample(r *lockedRand) time.Duration {
+	switch d.Kind {
+	case SizeUniform:
+		return d.Min + time.Duration(r.Int64N(int64(d.Max-d.Min)+1))
+	case SizeLognormal:
+		delay := time.Duration(math.Round(float64(d.Median) * math.Exp(r.NormFloat64()*d.sigma())))
+		if d.Max > 0 {
+			delay = min(delay, d.Max)
+		}
+		return delay
+	}
+	return d.Min
+}
+
+// delaySend holds a request back for a delay drawn from SendDelay before it
+// is sent, keeping its stream slot as a client on a slow link would. It
+// returns false if the client is stopped first.
+func (h *H2Client) delaySend() bool {
+	if h.Conf.SendDelay == nil {
+		return true
+	}
+	delay := h.Conf.SendDelay.sample(h.delayRand)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-h.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
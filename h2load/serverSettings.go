@@ -0,0 +1,65 @@
+package h2load
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// ServerSettings are the SETTINGS a server sent first on a connection, in
+// the order they were sent. Settings it didn't send keep their defaults.
+type ServerSettings []http2.Setting
+
+// parseSettings parses the payload of a SETTINGS frame
+func parseSettings(payload []byte) ServerSettings {
+	s := ServerSettings{}
+	for ; len(payload) >= 6; payload = payload[6:] {
+		s = append(s, http2.Setting{
+			ID:  http2.SettingID(binary.BigEndian.Uint16(payload)),
+			Val: binary.BigEndian.Uint32(payload[2:]),
+		})
+	}
+	return s
+}
+
+// Get returns the value of a setting, and whether the server sent it
+func (s ServerSettings) Get(id http2.SettingID) (uint32, bool) {
+	val, ok := uint32(0), false
+	// A setting sent twice takes its last value
+	for _, setting := range s {
+		if setting.ID == id {
+			val, ok = setting.Val, true
+		}
+	}
+	return val, ok
+}
+
+// MaxConcurrentStreams returns the server's stream limit, and false if it
+// has none
+func (s ServerSettings) MaxConcurrentStreams() (uint32, bool) {
+	return s.Get(http2.SettingMaxConcurrentStreams)
+}
+
+// Map returns the settings by name, for reports
+func (s ServerSettings) Map() map[string]uint32 {
+	m := make(map[string]uint32, len(s))
+	for _, setting := range s {
+		m[setting.ID.String()] = setting.Val
+	}
+	return m
+}
+
+// String formats the settings as NAME=value pairs, noting an unlimited
+// MAX_CONCURRENT_STREAMS
+func (s ServerSettings) String() string {
+	parts := make([]string, 0, len(s)+1)
+	for _, setting := range s {
+		parts = append(parts, fmt.Sprintf("%s=%d", setting.ID, setting.Val))
+	}
+	if _, ok := s.MaxConcurrentStreams(); !ok {
+		parts = append(parts, "MAX_CONCURRENT_STREAMS=unlimited")
+	}
+	return strings.Join(parts, ", ")
+}
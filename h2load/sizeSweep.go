@@ -0,0 +1,135 @@
+package h2load
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SizePlaceholder in the URL is replaced by the size of each sweep step in
+// bytes, e.g. https://example.com/payload?bytes={size}, to sweep response sizes
+const SizePlaceholder = "{size}"
+
+// SizeSweepConf runs the same test at a series of payload sizes
+type SizeSweepConf struct {
+	Sizes        []int64       // Sizes tried, in order
+	Body         bool          // Send a generated request body of each size
+	StepDuration time.Duration // How long each size is run (0 = the conf's Requests per client)
+}
+
+func (c *SizeSweepConf) Validate(conf H2loadConf) error {
+	if len(c.Sizes) == 0 {
+		return fmt.Errorf("size sweep needs at least one size")
+	}
+	for _, size := range c.Sizes {
+		if size < 0 {
+			return fmt.Errorf("size sweep sizes must be greater than 0")
+		}
+	}
+	if !c.Body && !strings.Contains(conf.URL, SizePlaceholder) {
+		return fmt.Errorf("size sweep needs a request body or a %s placeholder in the URL", SizePlaceholder)
+	}
+	if c.StepDuration < 0 {
+		return fmt.Errorf("size sweep step duration must be greater than 0")
+	}
+	if c.StepDuration == 0 && conf.Requests == 0 {
+		return fmt.Errorf("size sweep needs a step duration or a number of requests")
+	}
+	return nil
+}
+
+// ParseSizes parses a comma-separated list of sizes, e.g. 1KB,10KB,1MB
+func ParseSizes(s string) ([]int64, error) {
+	var sizes []int64
+	for _, part := range strings.Split(s, ",") {
+		var n int64
+		if err := (&byteSizeValue{&n}).Set(part); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
+
+// SizeSweepStep is the outcome of running one size
+type SizeSweepStep struct {
+	Size    int64
+	Stats   RequestStats
+	Traffic TrafficStats
+}
+
+// DownloadThroughput returns the response DATA bytes received per second
+func (s SizeSweepStep) DownloadThroughput() float64 {
+	if s.Stats.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Traffic.BytesData) / s.Stats.Duration.Seconds()
+}
+
+// SizeSweepResult is the outcome of a size sweep
+type SizeSweepResult struct {
+	Steps []SizeSweepStep
+}
+
+// String formats the steps as a table of throughput and latency per size
+func (r SizeSweepResult) String() string {
+	var b strings.Builder
+	b.WriteString("Payload Size Sweep:\n")
+	fmt.Fprintf(&b, "%10s %10s %10s %10s %10s %8s %12s %12s %12s\n",
+		"Size", "Requests", "Req/sec", "Up MB/s", "Down MB/s", "Errors", "Avg", "P50", "P99")
+	for _, s := range r.Steps {
+		var avg time.Duration
+		if s.Stats.TotalRequests > 0 {
+			avg = s.Stats.TotalLatency / time.Duration(s.Stats.TotalRequests)
+		}
+		fmt.Fprintf(&b, "%10s %10d %10.2f %10.2f %10.2f %7.2f%% %12v %12v %12v\n",
+			formatBytes(s.Size), s.Stats.TotalRequests, s.Stats.Rps(),
+			s.Stats.UploadThroughput()/1e6, s.DownloadThroughput()/1e6,
+			s.Stats.ErrorRate()*100, avg, s.Stats.P50Latency, s.Stats.P99Latency)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// SweepSizes runs conf once for every size of sweep, with a fresh
+// H2loadClient each time, sending bodies of the size and replacing
+// SizePlaceholder in the URL with it
+func SweepSizes(conf H2loadConf, sweep SizeSweepConf) (SizeSweepResult, error) {
+	var result SizeSweepResult
+	if err := sweep.Validate(conf); err != nil {
+		return result, err
+	}
+	for _, size := range sweep.Sizes {
+		step, err := runSizeSweepStep(conf, sweep, size)
+		if err != nil {
+			return result, err
+		}
+		result.Steps = append(result.Steps, step)
+	}
+	return result, nil
+}
+
+func runSizeSweepStep(conf H2loadConf, sweep SizeSweepConf, size int64) (SizeSweepStep, error) {
+	conf.URL = strings.ReplaceAll(conf.URL, SizePlaceholder, strconv.FormatInt(size, 10))
+	if sweep.Body {
+		conf.Body = BodyConf{Size: size}
+	}
+	if sweep.StepDuration > 0 {
+		conf.Requests = 0
+	}
+
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return SizeSweepStep{}, err
+	}
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		return SizeSweepStep{}, fmt.Errorf("connect failed at size %s: %w", formatBytes(size), err)
+	}
+	if sweep.StepDuration > 0 {
+		client.runFor(sweep.StepDuration, 0, client.Run)
+	} else {
+		client.Run()
+	}
+	return SizeSweepStep{Size: size, Stats: client.GetTotalStats(), Traffic: client.GetTrafficStats()}, nil
+}
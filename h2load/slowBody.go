@@ -0,0 +1,351 @@
+package h2load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	urlpkg "net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	"golang.org/x/net/http2"
+)
+
+// SlowBodyConf sends request bodies a few bytes at a time on many streams,
+// to check the server's read timeouts and its protection against slow
+// clients holding its resources. Every client keeps one connection with
+// ConcurrentStreams slow streams on it, opening new streams as the server
+// ends them and a new connection when the server closes it.
+type SlowBodyConf struct {
+	Interval time.Duration // Time between two chunks of a body
+	Chunk    int           // Bytes sent every Interval on each stream
+	Size     int64         // Bytes of each body, announced as its content-length (0 = sent until the server ends the stream)
+	Method   string        // Method of the requests (default: POST)
+	Duration time.Duration // How long the streams are kept
+}
+
+func (c *SlowBodyConf) Validate() error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("slow body interval must be greater than 0")
+	}
+	if c.Chunk <= 0 {
+		return fmt.Errorf("slow body chunk must be greater than 0")
+	}
+	if c.Size < 0 {
+		return fmt.Errorf("slow body size must be greater than 0")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("slow body test needs a duration")
+	}
+	return nil
+}
+
+// SlowBodyStats are the outcome of a slow body run
+type SlowBodyStats struct {
+	Connections     int64 // Connections opened
+	ConnectFailures int64 // Connections that couldn't be opened
+	Survived        int64 // Connections still open at the end of the run
+	Dropped         int64 // Connections closed by the server without a GOAWAY
+
+	GoAways map[http2.ErrCode]int64 // Connections closed by the server with a GOAWAY, by error code
+
+	// How long connections lasted before the server closed them
+	MinConnClosedAfter time.Duration
+	P50ConnClosedAfter time.Duration
+	MaxConnClosedAfter time.Duration
+
+	Streams   int64 // Streams opened
+	Completed int64 // Streams whose whole body was sent and answered
+	Held      int64 // Streams still open at the end of the run
+	Answered  int64 // Streams answered before their whole body was sent
+	LostConn  int64 // Streams ended by their connection closing
+
+	Resets   map[http2.ErrCode]int64 // Streams reset by the server, by error code
+	Statuses map[int]int64           // Status of the streams answered early, by code
+
+	// How long the streams the server ended early, answered or reset, lasted
+	MinCutAfter time.Duration
+	P50CutAfter time.Duration
+	MaxCutAfter time.Duration
+
+	BytesSent int64
+	Duration  time.Duration
+	LastError string
+}
+
+// Cut returns the streams the server ended before their whole body was sent
+func (s SlowBodyStats) Cut() int64 {
+	cut := s.Answered
+	for _, n := range s.Resets {
+		cut += n
+	}
+	return cut
+}
+
+// ConnsClosed returns the connections the server closed
+func (s SlowBodyStats) ConnsClosed() int64 {
+	closed := s.Dropped
+	for _, n := range s.GoAways {
+		closed += n
+	}
+	return closed
+}
+
+// String formats the SlowBodyStats as a readable string
+func (s SlowBodyStats) String() string {
+	summary := fmt.Sprintf(`Slow Body Statistics:
+Connections: %d opened (%d failed), %d survived
+Connections Closed by Server: %d (GOAWAY: %s; %d dropped)`,
+		s.Connections, s.ConnectFailures, s.Survived,
+		s.ConnsClosed(), formatErrCodes(s.GoAways), s.Dropped)
+	if s.ConnsClosed() > 0 {
+		summary += fmt.Sprintf("\nConnection Closed After: min %v, p50 %v, max %v", s.MinConnClosedAfter, s.P50ConnClosedAfter, s.MaxConnClosedAfter)
+	}
+	summary += fmt.Sprintf(`
+Streams: %d opened, %d completed, %d held to the end, %d lost with their connection
+Streams Cut by Server: %d (%d answered early%s; reset: %s)`,
+		s.Streams, s.Completed, s.Held, s.LostConn,
+		s.Cut(), s.Answered, formatStatuses(s.Statuses), formatErrCodes(s.Resets))
+	if s.Cut() > 0 {
+		summary += fmt.Sprintf("\nStream Cut After: min %v, p50 %v, max %v", s.MinCutAfter, s.P50CutAfter, s.MaxCutAfter)
+	}
+	summary += fmt.Sprintf("\nBody Bytes Sent: %s\nTotal Duration: %v", formatBytes(s.BytesSent), s.Duration)
+	if s.LastError != "" {
+		summary += fmt.Sprintf("\nLast Error: %s", s.LastError)
+	}
+	return summary
+}
+
+// formatStatuses formats counts by status code, e.g. ": 12 HTTP 408"
+func formatStatuses(counts map[int]int64) string {
+	if len(counts) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(counts))
+	for _, status := range slices.Sorted(maps.Keys(counts)) {
+		parts = append(parts, fmt.Sprintf("%d HTTP %d", counts[status], status))
+	}
+	return ": " + strings.Join(parts, ", ")
+}
+
+// slowBodyCollector gathers the stats of all streams and connections of a
+// run
+type slowBodyCollector struct {
+	mu              sync.Mutex
+	stats           SlowBodyStats
+	connClosedAfter *hdrhistogram.Histogram
+	cutAfter        *hdrhistogram.Histogram
+}
+
+func newSlowBodyCollector() *slowBodyCollector {
+	return &slowBodyCollector{
+		stats: SlowBodyStats{
+			GoAways:  make(map[http2.ErrCode]int64),
+			Resets:   make(map[http2.ErrCode]int64),
+			Statuses: make(map[int]int64),
+		},
+		connClosedAfter: newLatencyHistogram(),
+		cutAfter:        newLatencyHistogram(),
+	}
+}
+
+func (c *slowBodyCollector) update(fn func(s *SlowBodyStats)) {
+	c.mu.Lock()
+	fn(&c.stats)
+	c.mu.Unlock()
+}
+
+// connClosed records a connection the server closed after open, with err
+func (c *slowBodyCollector) connClosed(open time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var goAway http2.GoAwayError
+	if errors.As(err, &goAway) {
+		c.stats.GoAways[goAway.ErrCode]++
+	} else {
+		c.stats.Dropped++
+	}
+	c.stats.LastError = err.Error()
+	recordLatency(c.connClosedAfter, open)
+}
+
+// streamDone records a stream that ended after open, having sent sent bytes
+// of its body, or all of it if whole is set
+func (c *slowBodyCollector) streamDone(req *h2Request, open time.Duration, sent int64, whole bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.BytesSent += sent
+	var streamErr http2.StreamError
+	switch {
+	case errors.As(req.err, &streamErr):
+		c.stats.Resets[streamErr.Code]++
+		recordLatency(c.cutAfter, open)
+	case req.err != nil:
+		c.stats.LostConn++
+	case whole:
+		c.stats.Completed++
+	default:
+		c.stats.Answered++
+		c.stats.Statuses[req.status]++
+		recordLatency(c.cutAfter, open)
+	}
+}
+
+func (c *slowBodyCollector) result(duration time.Duration) SlowBodyStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.GoAways = maps.Clone(c.stats.GoAways)
+	s.Resets = maps.Clone(c.stats.Resets)
+	s.Statuses = maps.Clone(c.stats.Statuses)
+	s.Duration = duration
+	if c.connClosedAfter.TotalCount() > 0 {
+		s.MinConnClosedAfter = time.Duration(c.connClosedAfter.Min()) * time.Microsecond
+	}
+	s.P50ConnClosedAfter = latencyPercentile(c.connClosedAfter, 50)
+	s.MaxConnClosedAfter = latencyPercentile(c.connClosedAfter, 100)
+	if c.cutAfter.TotalCount() > 0 {
+		s.MinCutAfter = time.Duration(c.cutAfter.Min()) * time.Microsecond
+	}
+	s.P50CutAfter = latencyPercentile(c.cutAfter, 50)
+	s.MaxCutAfter = latencyPercentile(c.cutAfter, 100)
+	return s
+}
+
+// RunSlowBody runs slow streams with conf's clients for sb.Duration
+func RunSlowBody(conf H2loadConf, sb SlowBodyConf) (SlowBodyStats, error) {
+	if err := sb.Validate(); err != nil {
+		return SlowBodyStats{}, err
+	}
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return SlowBodyStats{}, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sb.Duration)
+	defer cancel()
+	c := newSlowBodyCollector()
+	start := time.Now()
+	errs := RunConcurrent(client.Clients, func(h *H2Client) error {
+		return h.runSlowBody(ctx, sb, c)
+	})
+	return c.result(time.Since(start)), JoinIndexedErrors(errs)
+}
+
+// runSlowBody keeps slow streams on a connection until ctx ends, opening a
+// new connection whenever the server closes the last one
+func (h *H2Client) runSlowBody(ctx context.Context, sb SlowBodyConf, c *slowBodyCollector) error {
+	// Nothing is sent through the request path, its collectors can stop
+	defer h.closeChannels()
+	u, err := urlpkg.Parse(h.Conf.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	header := h.Conf.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if sb.Size > 0 {
+		header.Set("Content-Length", strconv.FormatInt(sb.Size, 10))
+	}
+	for ctx.Err() == nil {
+		pc, err := h.dialH2Conn(ctx, nil, false)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.update(func(s *SlowBodyStats) {
+					s.ConnectFailures++
+					s.LastError = err.Error()
+				})
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(sb.Interval):
+			}
+			continue
+		}
+		c.update(func(s *SlowBodyStats) { s.Connections++ })
+		h.slowBodyConn(ctx, pc, u, header, sb, c)
+	}
+	return nil
+}
+
+// slowBodyConn keeps ConcurrentStreams slow streams on pc until ctx ends or
+// the server closes it
+func (h *H2Client) slowBodyConn(ctx context.Context, pc *h2Conn, u *urlpkg.URL, header http.Header, sb SlowBodyConf, c *slowBodyCollector) {
+	defer pc.close()
+	opened := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < max(h.Conf.ConcurrentStreams, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && slowStream(ctx, pc, u, header, sb, c) {
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		c.update(func(s *SlowBodyStats) { s.Survived++ })
+		return
+	}
+	<-pc.closed
+	c.connClosed(time.Since(opened), pc.closeErr())
+}
+
+// slowStream sends a request whose body is sent a chunk every sb.Interval,
+// until the server ends the stream or ctx ends. It returns false once the
+// connection is closed.
+func slowStream(ctx context.Context, pc *h2Conn, u *urlpkg.URL, header http.Header, sb SlowBodyConf, c *slowBodyCollector) bool {
+	method := sb.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	id, req, err := pc.start(method, u, header, true, nil)
+	if err != nil {
+		return false
+	}
+	c.update(func(s *SlowBodyStats) { s.Streams++ })
+	start := time.Now()
+	ticker := time.NewTicker(sb.Interval)
+	defer ticker.Stop()
+	body := &patternReader{}
+	chunk := make([]byte, sb.Chunk)
+	var sent int64
+	whole := false
+	for {
+		select {
+		case <-req.done:
+			c.streamDone(req, time.Since(start), sent, whole)
+			return !errors.Is(req.err, errH2ConnClosed)
+		case <-ctx.Done():
+			c.update(func(s *SlowBodyStats) {
+				s.Held++
+				s.BytesSent += sent
+			})
+			return false
+		case <-ticker.C:
+			if whole {
+				continue
+			}
+			p := chunk
+			if sb.Size > 0 {
+				p = p[:min(int64(len(p)), sb.Size-sent)]
+			}
+			body.Read(p)
+			n, err := pc.sendData(id, p, sb.Size > 0 && sent+int64(len(p)) == sb.Size)
+			if err != nil {
+				// The stream's end, or the connection's, is waited for
+				continue
+			}
+			sent += int64(n)
+			whole = sb.Size > 0 && sent == sb.Size
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SocketConf sets options on the socket of every connection dialed, since
+// transport tuning is part of what a run measures. The zero value keeps Go's
+// defaults: Nagle's algorithm off and the OS's buffer sizes.
+type SocketConf struct {
+	Nagle      bool  // Enable Nagle's algorithm, clearing TCP_NODELAY
+	SendBuffer int64 // SO_SNDBUF in bytes (0 = the OS's)
+	RecvBuffer int64 // SO_RCVBUF in bytes (0 = the OS's), set before connecting so it sets the window scale
+	DSCP       int   // DSCP class packets are marked with, 0-63, e.g. 46 for EF (0 = unmarked)
+}
+
+func (s *SocketConf) Validate() error {
+	if s.SendBuffer < 0 || s.RecvBuffer < 0 {
+		return fmt.Errorf("socket buffer sizes must be greater than 0")
+	}
+	if s.DSCP < 0 || s.DSCP > 63 {
+		return fmt.Errorf("DSCP must be between 0 and 63")
+	}
+	return nil
+}
+
+// String formats the options set as a readable string, empty if none are
+func (s SocketConf) String() string {
+	var opts []string
+	if s.Nagle {
+		opts = append(opts, "Nagle on")
+	}
+	if s.SendBuffer > 0 {
+		opts = append(opts, "SO_SNDBUF "+formatBytes(s.SendBuffer))
+	}
+	if s.RecvBuffer > 0 {
+		opts = append(opts, "SO_RCVBUF "+formatBytes(s.RecvBuffer))
+	}
+	if s.DSCP > 0 {
+		opts = append(opts, fmt.Sprintf("DSCP %d", s.DSCP))
+	}
+	return strings.Join(opts, ", ")
+}
+
+// dialer returns the func dialing TCP connections with the options of s
+func (s *SocketConf) dialer() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{}
+	if s.SendBuffer > 0 || s.RecvBuffer > 0 || s.DSCP > 0 {
+		d.Control = s.control
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		// Go sets TCP_NODELAY once connected, so Nagle is turned back on after
+		if tcp, ok := conn.(*net.TCPConn); ok && s.Nagle {
+			if err := tcp.SetNoDelay(false); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("failed to enable Nagle's algorithm: %w", err)
+			}
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package h2load
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// control fails on platforms without setsockopt, socket buffer sizes and
+// DSCP can't be set there
+func (s *SocketConf) control(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("socket buffer sizes and DSCP are only supported on unix")
+}
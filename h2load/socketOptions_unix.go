@@ -0,0 +1,46 @@
+//go:build unix
+
+package h2load
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// control sets the buffer sizes and DSCP class of s on a socket before it
+// connects
+func (s *SocketConf) control(network, address string, c syscall.RawConn) error {
+	var err error
+	controlErr := c.Control(func(fd uintptr) {
+		sock := int(fd)
+		if s.SendBuffer > 0 {
+			if err = syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, syscall.SO_SNDBUF, int(s.SendBuffer)); err != nil {
+				err = fmt.Errorf("failed to set SO_SNDBUF: %w", err)
+				return
+			}
+		}
+		if s.RecvBuffer > 0 {
+			if err = syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, syscall.SO_RCVBUF, int(s.RecvBuffer)); err != nil {
+				err = fmt.Errorf("failed to set SO_RCVBUF: %w", err)
+				return
+			}
+		}
+		if s.DSCP > 0 {
+			// DSCP is the upper 6 bits of the TOS byte, the traffic class in IPv6
+			tos := s.DSCP << 2
+			if strings.HasSuffix(network, "6") {
+				err = syscall.SetsockoptInt(sock, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+			} else {
+				err = syscall.SetsockoptInt(sock, syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+			}
+			if err != nil {
+				err = fmt.Errorf("failed to set DSCP: %w", err)
+			}
+		}
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
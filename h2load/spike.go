@@ -0,0 +1,252 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpikeConf injects periodic bursts of load on top of the baseline rate of
+// Rps or TotalRps: Every, the rate is raised by Rps for Duration. Requests
+// sent during a spike are counted apart from the baseline ones.
+type SpikeConf struct {
+	Rps      int           // Rate added to the baseline during a spike (0 = no spikes)
+	Duration time.Duration // Length of every spike
+	Every    time.Duration // Time from the start of one spike to the next, the first starts after it
+}
+
+func (s *SpikeConf) enabled() bool {
+	return s.Rps > 0
+}
+
+func (s *SpikeConf) Validate() error {
+	if !s.enabled() {
+		return nil
+	}
+	if s.Duration <= 0 || s.Every <= 0 {
+		return fmt.Errorf("spike duration and every must be greater than 0")
+	}
+	if s.Duration >= s.Every {
+		return fmt.Errorf("spike duration must be shorter than every, spikes can't overlap")
+	}
+	return nil
+}
+
+// String formats the spike as a readable string
+func (s SpikeConf) String() string {
+	return fmt.Sprintf("+%d/s for %v every %v", s.Rps, s.Duration, s.Every)
+}
+
+// ParseSpike parses a spike such as "rps=5000,duration=10s,every=2m"
+func ParseSpike(s string) (SpikeConf, error) {
+	var spike SpikeConf
+	for _, part := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return spike, fmt.Errorf("invalid spike %q, expected rps=N,duration=D,every=D", s)
+		}
+		var err error
+		switch key {
+		case "rps":
+			spike.Rps, err = strconv.Atoi(value)
+			if err == nil && spike.Rps <= 0 {
+				err = fmt.Errorf("must be greater than 0")
+			}
+		case "duration":
+			spike.Duration, err = time.ParseDuration(value)
+		case "every":
+			spike.Every, err = time.ParseDuration(value)
+		default:
+			return spike, fmt.Errorf("unknown spike option %q, expected rps, duration or every", key)
+		}
+		if err != nil {
+			return spike, fmt.Errorf("invalid spike %s %q: %w", key, value, err)
+		}
+	}
+	if spike.Rps == 0 {
+		return spike, fmt.Errorf("spike %q needs rps", s)
+	}
+	return spike, spike.Validate()
+}
+
+type spikeKey struct{}
+
+// withSpike marks req as sent during a spike
+func withSpike(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), spikeKey{}, true))
+}
+
+// inSpike reports whether req was sent during a spike
+func inSpike(req *http.Request) bool {
+	spike, _ := req.Context().Value(spikeKey{}).(bool)
+	return spike
+}
+
+// SpikeStats are the stats of the requests sent during spikes and of the
+// baseline ones, each over the time spent in it
+type SpikeStats struct {
+	Conf      SpikeConf
+	Spikes    int           // Spikes started, the last may have been cut short by the end of the run
+	SpikeTime time.Duration // Time spent in spikes
+	Baseline  RequestStats
+	Spike     RequestStats
+}
+
+// String formats the SpikeStats as a table
+func (s SpikeStats) String() string {
+	title := fmt.Sprintf("Spike Statistics: %d spikes of %v, %v in spikes:", s.Spikes, s.Conf, s.SpikeTime)
+	return formatStatsTable(title, "Load", []RouteStats{
+		{Route: "baseline", RequestStats: s.Baseline},
+		{Route: "spike", RequestStats: s.Spike},
+	})
+}
+
+// spiker raises the rate of a run during its spikes, and keeps the stats of
+// the requests sent in and out of them
+type spiker struct {
+	conf   SpikeConf
+	active atomic.Bool // Set during a spike, requests sent are marked withSpike
+
+	mu         sync.Mutex
+	spikes     int
+	spikeTime  time.Duration
+	spikeStart time.Time // Start of the spike under way, or of its part after a reset
+	baseline   *routeEntry
+	spike      *routeEntry
+}
+
+func newSpiker(conf SpikeConf) *spiker {
+	return &spiker{
+		conf:     conf,
+		baseline: &routeEntry{hist: newLatencyHistogram()},
+		spike:    &routeEntry{hist: newLatencyHistogram()},
+	}
+}
+
+// record is a statsObserver
+func (s *spiker) record(entry LogEntry, success bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.baseline
+	if entry.Spike {
+		e = s.spike
+	}
+	e.stats.record(entry, success)
+	recordLatency(e.hist, entry.Latency)
+}
+
+// run starts a spike every conf.Every until done is closed, calling raise
+// with true at its start and false at its end
+func (s *spiker) run(done <-chan struct{}, raise func(spiking bool)) {
+	timer := time.NewTimer(s.conf.Every)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+		s.mu.Lock()
+		s.spikes++
+		s.spikeStart = time.Now()
+		s.mu.Unlock()
+		// Marked before the rate is raised, so every request of the spike is
+		s.active.Store(true)
+		raise(true)
+
+		timer.Reset(s.conf.Duration)
+		select {
+		case <-done:
+		case <-timer.C:
+		}
+		raise(false)
+		s.active.Store(false)
+		s.mu.Lock()
+		s.spikeTime += time.Since(s.spikeStart)
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+		timer.Reset(s.conf.Every - s.conf.Duration)
+	}
+}
+
+// reset zeroes the stats of the spikes, the one under way included
+func (s *spiker) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spikes, s.spikeTime = 0, 0
+	if s.active.Load() {
+		s.spikes, s.spikeStart = 1, time.Now()
+	}
+	s.baseline = &routeEntry{hist: newLatencyHistogram()}
+	s.spike = &routeEntry{hist: newLatencyHistogram()}
+}
+
+// snapshot returns the stats of the spikes of a run lasting duration
+func (s *spiker) snapshot(duration time.Duration) SpikeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := SpikeStats{
+		Conf:      s.conf,
+		Spikes:    s.spikes,
+		SpikeTime: s.spikeTime,
+		Baseline:  s.baseline.stats,
+		Spike:     s.spike.stats,
+	}
+	stats.Baseline.setPercentiles(s.baseline.hist)
+	stats.Baseline.Duration = max(duration-s.spikeTime, 0)
+	stats.Spike.setPercentiles(s.spike.hist)
+	stats.Spike.Duration = s.spikeTime
+	return stats
+}
+
+// spikeRaiser returns the function raising the rate of h's clients by
+// conf.Rps during a spike: the shared limiter's with TotalRps, split across
+// the clients like TotalRps otherwise
+func (h *H2loadClient) spikeRaiser(limiter *rpsLimiter) func(bool) {
+	spike := h.ClientsConf.Spike.Rps
+	if limiter != nil {
+		base := h.ClientsConf.TotalRps
+		return func(spiking bool) {
+			if spiking {
+				limiter.setRate(base + spike)
+			} else {
+				limiter.setRate(base)
+			}
+		}
+	}
+	bases := make([]int, len(h.Clients))
+	for i, c := range h.Clients {
+		bases[i] = c.Conf.Rps
+	}
+	return func(spiking bool) {
+		for i, c := range h.Clients {
+			rps := bases[i]
+			if spiking {
+				rps += spike / len(h.Clients)
+				if i < spike%len(h.Clients) {
+					rps++
+				}
+			}
+			_ = c.SetRps(rps)
+		}
+	}
+}
+
+// GetSpikeStats returns the stats of the last run's spikes, and false
+// without Spike
+func (h *H2loadClient) GetSpikeStats() (SpikeStats, bool) {
+	if h.spiker == nil {
+		return SpikeStats{}, false
+	}
+	return h.spiker.snapshot(h.GetTotalStats().Duration), true
+}
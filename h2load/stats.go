@@ -12,7 +12,97 @@ type RequestStats struct {
 	MinLatency      time.Duration
 	MaxLatency      time.Duration
 	TotalLatency    time.Duration
+	P50Latency      time.Duration
+	P90Latency      time.Duration
+	P99Latency      time.Duration
 	Duration        time.Duration
+	StatusClasses   [6]int64 // Requests by status class, e.g. [2] counts 2xx; [0] counts requests without a response
+	BytesOut        int64    // Request body bytes sent
+
+	RecycledConnections int64 // Connections recycled by the stream error budget
+	EnhanceYourCalm     int64 // GOAWAY/RST_STREAM with ENHANCE_YOUR_CALM received
+	RedirectsFollowed   int64 // Redirects followed, with FollowRedirects set
+	Retries             int64 // Retries sent, with Retry set
+	SucceededAfterRetry int64 // Requests that succeeded after at least one retry
+	TrailerMismatches   int64 // Responses failed for not having the expected trailers
+	GraphQLErrors       int64 // 2xx responses failed for GraphQL errors
+	ShedLogLines        int64 // Log lines dropped to stay under the memory cap
+	Abandoned           int64 // Requests cancelled in flight when the RampDown ran out, not counted as requests
+
+	StalledRequests int64         // Requests blocked on flow control windows, with FlowStalls set
+	StalledTime     time.Duration // Time those requests were blocked
+
+	QueuedRequests int64         // Requests whose HEADERS were written
+	QueueDelay     time.Duration // Total time those requests waited inside the generator, from eligible to written
+	MaxQueueDelay  time.Duration
+
+	Compression CompressionStats // Responses received with a content coding
+}
+
+// record adds a single request outcome to the stats
+func (r *RequestStats) record(entry LogEntry, success bool) {
+	r.TotalRequests++
+	if success {
+		r.SuccessRequests++
+	} else {
+		r.FailedRequests++
+	}
+	class := entry.Status / 100
+	if class < 1 || class >= len(r.StatusClasses) {
+		class = 0
+	}
+	r.StatusClasses[class]++
+
+	if r.TotalRequests == 1 {
+		r.MinLatency = entry.Latency
+		r.MaxLatency = entry.Latency
+	} else {
+		if entry.Latency < r.MinLatency {
+			r.MinLatency = entry.Latency
+		}
+		if entry.Latency > r.MaxLatency {
+			r.MaxLatency = entry.Latency
+		}
+	}
+	r.TotalLatency += entry.Latency
+	if entry.Queue > 0 {
+		r.QueuedRequests++
+		r.QueueDelay += entry.Queue
+		r.MaxQueueDelay = max(r.MaxQueueDelay, entry.Queue)
+	}
+}
+
+// AvgQueueDelay returns the average time requests waited inside the
+// generator between becoming eligible and being written to the wire
+func (r RequestStats) AvgQueueDelay() time.Duration {
+	if r.QueuedRequests == 0 {
+		return 0
+	}
+	return r.QueueDelay / time.Duration(r.QueuedRequests)
+}
+
+// Rps returns the achieved requests per second
+func (r RequestStats) Rps() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.TotalRequests) / r.Duration.Seconds()
+}
+
+// UploadThroughput returns the request body bytes sent per second
+func (r RequestStats) UploadThroughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.BytesOut) / r.Duration.Seconds()
+}
+
+// ErrorRate returns the fraction of requests that failed
+func (r RequestStats) ErrorRate() float64 {
+	if r.TotalRequests == 0 {
+		return 0
+	}
+	return float64(r.FailedRequests) / float64(r.TotalRequests)
 }
 
 // String formats the RequestStats as a readable string
@@ -22,12 +112,7 @@ func (r RequestStats) String() string {
 		avgLatency = r.TotalLatency / time.Duration(r.TotalRequests)
 	}
 
-	var rps float64
-	if r.Duration > 0 {
-		rps = float64(r.TotalRequests) / r.Duration.Seconds()
-	}
-
-	return fmt.Sprintf(`Statistics:
+	summary := fmt.Sprintf(`Statistics:
 Total Requests: %d
 Successful Requests: %d
 Failed Requests: %d
@@ -35,13 +120,62 @@ Requests/sec: %.2f
 Min Latency: %v
 Max Latency: %v
 Average Latency: %v
+P50 Latency: %v
+P90 Latency: %v
+P99 Latency: %v
 Total Duration: %v`,
 		r.TotalRequests,
 		r.SuccessRequests,
 		r.FailedRequests,
-		rps,
+		r.Rps(),
 		r.MinLatency,
 		r.MaxLatency,
 		avgLatency,
+		r.P50Latency,
+		r.P90Latency,
+		r.P99Latency,
 		r.Duration)
+
+	// Optional counters are only shown when they have something to report
+	if r.BytesOut > 0 {
+		summary += fmt.Sprintf("\nUpload Throughput: %.2f MB/s (%s sent)", r.UploadThroughput()/1e6, formatBytes(r.BytesOut))
+	}
+	if r.RecycledConnections > 0 {
+		summary += fmt.Sprintf("\nRecycled Connections: %d", r.RecycledConnections)
+	}
+	if r.StatusClasses[3] > 0 {
+		summary += fmt.Sprintf("\n3xx Responses: %d", r.StatusClasses[3])
+	}
+	if r.RedirectsFollowed > 0 {
+		summary += fmt.Sprintf("\nRedirects Followed: %d", r.RedirectsFollowed)
+	}
+	if r.Retries > 0 {
+		summary += fmt.Sprintf("\nRetries: %d (%d requests succeeded after a retry)", r.Retries, r.SucceededAfterRetry)
+	}
+	if r.TrailerMismatches > 0 {
+		summary += fmt.Sprintf("\nTrailer Mismatches: %d", r.TrailerMismatches)
+	}
+	if r.GraphQLErrors > 0 {
+		summary += fmt.Sprintf("\nGraphQL Errors: %d", r.GraphQLErrors)
+	}
+	if r.QueuedRequests > 0 {
+		summary += fmt.Sprintf("\nQueue Delay: avg %v, max %v (eligible to written, inside the generator)", r.AvgQueueDelay(), r.MaxQueueDelay)
+	}
+	if r.StalledRequests > 0 {
+		summary += fmt.Sprintf("\nFlow Control Stalls: %d requests, %v stalled (avg %v)",
+			r.StalledRequests, r.StalledTime, r.StalledTime/time.Duration(r.StalledRequests))
+	}
+	if r.Compression.Responses > 0 {
+		summary += fmt.Sprintf("\nCompressed Responses: %s", r.Compression)
+	}
+	if r.EnhanceYourCalm > 0 {
+		summary += fmt.Sprintf("\nENHANCE_YOUR_CALM Received: %d", r.EnhanceYourCalm)
+	}
+	if r.Abandoned > 0 {
+		summary += fmt.Sprintf("\nAbandoned (ramp-down ran out): %d", r.Abandoned)
+	}
+	if r.ShedLogLines > 0 {
+		summary += fmt.Sprintf("\nLog Lines Shed (memory cap): %d", r.ShedLogLines)
+	}
+	return summary
 }
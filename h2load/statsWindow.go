@@ -0,0 +1,54 @@
+package h2load
+
+import (
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// statsWindow accumulates request outcomes from one or more clients between
+// calls to take, for consumers that need recent rather than cumulative stats
+type statsWindow struct {
+	mu    sync.Mutex
+	start time.Time
+	stats RequestStats
+	hist  *hdrhistogram.Histogram
+}
+
+func newStatsWindow() *statsWindow {
+	return &statsWindow{start: time.Now(), hist: newLatencyHistogram()}
+}
+
+// record is a statsObserver
+func (w *statsWindow) record(entry LogEntry, success bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.record(entry, success)
+	recordLatency(w.hist, entry.Latency)
+}
+
+// take returns the stats and latency histogram collected since the previous
+// call and starts a new window
+func (w *statsWindow) take() (RequestStats, *hdrhistogram.Histogram) {
+	now := time.Now()
+	w.mu.Lock()
+	stats, hist := w.stats, w.hist
+	w.stats = RequestStats{}
+	w.hist = newLatencyHistogram()
+	stats.Duration = now.Sub(w.start)
+	w.start = now
+	w.mu.Unlock()
+
+	stats.setPercentiles(hist)
+	return stats, hist
+}
+
+// reset drops what was collected and starts a new window
+func (w *statsWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats = RequestStats{}
+	w.hist = newLatencyHistogram()
+	w.start = time.Now()
+}
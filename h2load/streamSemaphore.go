@@ -0,0 +1,61 @@
+package h2load
+
+import (
+	"context"
+	"sync"
+)
+
+// streamSemaphore bounds the number of in-flight streams. Unlike a buffered
+// channel its limit can be changed while callers are waiting on it.
+type streamSemaphore struct {
+	mu      sync.Mutex
+	limit   int
+	inUse   int
+	changed chan struct{} // Closed when a slot is released or the limit changes
+}
+
+func newStreamSemaphore(limit int) *streamSemaphore {
+	return &streamSemaphore{limit: max(limit, 1), changed: make(chan struct{})}
+}
+
+// acquire blocks until a slot is free, returning false if ctx is done first
+func (s *streamSemaphore) acquire(ctx context.Context) bool {
+	for {
+		s.mu.Lock()
+		if s.inUse < s.limit {
+			s.inUse++
+			s.mu.Unlock()
+			return true
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+func (s *streamSemaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse--
+	s.notify()
+}
+
+// setLimit changes the number of slots. Lowering it doesn't interrupt streams
+// already in flight, new ones wait until the count drops below the new limit.
+func (s *streamSemaphore) setLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = max(limit, 1)
+	s.notify()
+}
+
+// notify wakes up all waiters, must be called with mu held
+func (s *streamSemaphore) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,296 @@
+package h2load
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// subcommand is a mode of the CLI, selected by the first argument
+type subcommand struct {
+	name    string
+	usage   string // Arguments, shown after the name
+	summary string
+	run     func(args []string)
+}
+
+// subcommands lists the CLI modes, the first is the default
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{"run", "[options]", "Run a load test (default, see options below)", runMain},
+		{"save-profile", "<name> [options]", "Save run options as a named profile for 'run -profile <name>'", saveProfileMain},
+		{"history", "[-store <path>] [-n <int>]", "List the runs saved with -store", historyMain},
+		{"compare", "[options] <base> <current>", "Compare two runs saved with -store or -o json, failing on regressions", compareMain},
+		{"report", "[options] <run>", "Write a run saved with -store or -o json as a json or yaml summary", reportMain},
+		{"h2load", "[options] <URI>", "Run with nghttp2 h2load's options (-n total, -m streams) and output", h2loadMain},
+		{"serve-test", "[options]", "Start a local h2/h2c server with injected latency and errors, for testing", serveTestMain},
+		{"merge-logs", "-o <path> <log files...>", "Merge sharded log files by request start time", mergeLogsMain},
+		{"help", "", "Show this help", func([]string) { runMain([]string{"-help"}) }},
+	}
+}
+
+// CLIMain runs the subcommand named by the first argument. Without one, the
+// arguments are run options, so `h2load-cli -url ...` runs a load test.
+// Invoked as h2load, e.g. through a symlink, it takes nghttp2 h2load options.
+func CLIMain() {
+	args := os.Args[1:]
+	if strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == "h2load" {
+		h2loadMain(args)
+		return
+	}
+	name := subcommands[0].name
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for _, cmd := range subcommands {
+		if cmd.name == name {
+			cmd.run(args)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", name)
+	printSubcommands()
+	os.Exit(2)
+}
+
+// printSubcommands writes the list of subcommands to stderr
+func printSubcommands() {
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-12s %-26s %s\n", cmd.name, cmd.usage, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+}
+
+// mergeLogsMain merges the log files written with -log-shards
+func mergeLogsMain(args []string) {
+	fs := flag.NewFlagSet("merge-logs", flag.ExitOnError)
+	out := fs.String("o", "", "Merged log file, compressed if it ends in .gz (required)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s merge-logs -o <path> <log files...>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Merges log files written with -log-shards into one file ordered by request\n")
+		fmt.Fprintf(os.Stderr, "start time. Inputs ending in .gz are decompressed.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *out == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := MergeLogFiles(*out, fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to merge logs: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Merged %d log files into %s\n", fs.NArg(), *out)
+}
+
+// serveTestMain runs a TestServer until interrupted
+func serveTestMain(args []string) {
+	fs := flag.NewFlagSet("serve-test", flag.ExitOnError)
+	conf := TestServerConf{}
+	fs.StringVar(&conf.Addr, "addr", ":8080", "Address listened on")
+	fs.BoolVar(&conf.TLS, "tls", false, "Serve h2 over TLS, with a self-signed certificate unless -cert and -key are given (default: h2c)")
+	fs.StringVar(&conf.CertFile, "cert", "", "PEM certificate served with -tls")
+	fs.StringVar(&conf.KeyFile, "key", "", "PEM key of -cert")
+	fs.DurationVar(&conf.Latency, "latency", 0, "Delay before every response")
+	fs.DurationVar(&conf.LatencyJitter, "latency-jitter", 0, "Random extra delay, up to this long")
+	fs.Var(&byteSizeValue{&conf.Size}, "size", "Response body size, e.g. 10KB")
+	fs.Var(newPercentValue(&conf.ErrorRate, 0), "error-rate", "Share of requests answered with -error-status, e.g. 1%")
+	fs.IntVar(&conf.ErrorStatus, "error-status", 503, "Status of failed requests")
+	fs.Var(newPercentValue(&conf.ResetRate, 0), "reset-rate", "Share of requests whose stream is reset with INTERNAL_ERROR")
+	maxStreams := fs.Uint("max-streams", 250, "MAX_CONCURRENT_STREAMS advertised")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s serve-test [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Starts a local HTTP/2 server answering every path with a generated body,\n")
+		fmt.Fprintf(os.Stderr, "for benchmarking the generator and for tests without an external server.\n")
+		fmt.Fprintf(os.Stderr, "Requests can override the options with the query parameters latency,\n")
+		fmt.Fprintf(os.Stderr, "size and status, e.g. /?latency=50ms&size=10KB&status=503.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	conf.MaxStreams = uint32(*maxStreams)
+
+	server, err := NewTestServer(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	fmt.Printf("Serving on %s (Ctrl-C to stop)\n", server.URL())
+	if err := server.Serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(server.Stats())
+}
+
+// historyMain lists the runs saved with -store
+func historyMain(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	path := fs.String("store", "results.db", "Results database")
+	limit := fs.Int("n", 20, "Number of runs to list")
+	fs.Parse(args)
+
+	store, err := OpenResultStore(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+	runs, err := store.History(*limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to read history: %v\n", err)
+		os.Exit(1)
+	}
+	if len(runs) == 0 {
+		fmt.Printf("No runs saved in %s\n", *path)
+		return
+	}
+	for _, run := range runs {
+		fmt.Println(run)
+	}
+}
+
+// compareMain prints the differences between two runs, each given as a run
+// ID in the results database or a summary file written with -o json, and
+// exits with 1 if the current run regressed
+func compareMain(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	path := fs.String("store", "results.db", "Results database holding runs given by ID")
+	var t RegressionThresholds
+	fs.Var(newPercentValue(&t.MaxRpsDrop, 10), "max-rps-drop", "Largest acceptable RPS drop (0 = not checked)")
+	fs.Var(newPercentValue(&t.MaxP50Increase, 10), "max-p50-increase", "Largest acceptable p50 latency increase (0 = not checked)")
+	fs.Var(newPercentValue(&t.MaxP99Increase, 10), "max-p99-increase", "Largest acceptable p99 latency increase (0 = not checked)")
+	fs.Var(newPercentValue(&t.MaxErrorRateIncrease, 1), "max-error-rate-increase", "Largest acceptable error rate increase in points (0 = not checked)")
+	junit := fs.String("junit", "", "Write the threshold checks to this JUnit XML file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s compare [options] <baseline> <current>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Runs are given as IDs in the -store database or as summary files written\n")
+		fmt.Fprintf(os.Stderr, "with -o json. Exits with 1 when the current run regressed.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	runs := runLoader{path: *path}
+	defer runs.close()
+	var reports [2]Report
+	for i, arg := range fs.Args() {
+		var err error
+		if reports[i], err = runs.load(arg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	start := time.Now()
+	c := CompareReports(fs.Arg(0), reports[0], fs.Arg(1), reports[1])
+	checks := c.CheckRegressions(t)
+	fmt.Println(c)
+	if *junit != "" {
+		if err := WriteJUnitFile(*junit, "h2load compare", start, time.Since(start), checks); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	var regressions []CheckResult
+	for _, check := range checks {
+		if !check.Passed() {
+			regressions = append(regressions, check)
+		}
+	}
+	if len(regressions) > 0 {
+		fmt.Printf("\nRegressions:\n")
+		for _, r := range regressions {
+			fmt.Printf("  %s (limit: %s)\n", r.Failure, r.Name)
+		}
+		runs.close()
+		os.Exit(1)
+	}
+	fmt.Printf("\nNo regressions\n")
+}
+
+// reportMain writes a saved run as a summary, as -o would have
+func reportMain(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	path := fs.String("store", "results.db", "Results database holding runs given by ID")
+	format := fs.String("o", "json", "Summary format, json or yaml")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s report [options] <run>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "The run is given as an ID in the -store database or as a summary file\n")
+		fmt.Fprintf(os.Stderr, "written with -o json.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	runs := runLoader{path: *path}
+	defer runs.close()
+	report, err := runs.load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := WriteReport(os.Stdout, report, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+// runLoader loads the runs given to compare and report, opening the results
+// database only once a run is given by ID
+type runLoader struct {
+	path  string
+	store *ResultStore
+}
+
+// load returns the report of a run ID in the results database or of a
+// summary file written with -o json
+func (l *runLoader) load(arg string) (Report, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		// Not an ID, a summary file
+		return LoadReport(arg)
+	}
+	if l.store == nil {
+		if l.store, err = OpenResultStore(l.path); err != nil {
+			return Report{}, err
+		}
+	}
+	run, err := l.store.LoadRun(id)
+	if err != nil {
+		return Report{}, err
+	}
+	return run.Report, nil
+}
+
+func (l *runLoader) close() {
+	if l.store != nil {
+		l.store.Close()
+		l.store = nil
+	}
+}
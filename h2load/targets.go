@@ -0,0 +1,68 @@
+package h2load
+
+import (
+	"fmt"
+	"net"
+)
+
+// TargetStats are the stats of the clients sharded onto one of Targets
+type TargetStats struct {
+	Target  string
+	Clients int
+	RequestStats
+}
+
+// validateTargets checks the addresses of Targets, which replace
+// ServerAddress, ConnectTo and the mesh sidecar
+func (h *H2loadConf) validateTargets() error {
+	if len(h.Targets) == 0 {
+		return nil
+	}
+	if h.ServerAddress != "" || len(h.ConnectTo) > 0 || h.Mesh.Enabled {
+		return fmt.Errorf("targets set the address every client dials, server address, connect-to and mesh can't be used with them")
+	}
+	for _, target := range h.Targets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("invalid target %q, expected host:port", target)
+		}
+	}
+	if h.Clients < len(h.Targets) {
+		return fmt.Errorf("%d targets need at least as many clients, got %d", len(h.Targets), h.Clients)
+	}
+	return nil
+}
+
+// clientTarget returns the address client i dials, empty without Targets
+func (h *H2loadConf) clientTarget(i int) string {
+	if len(h.Targets) == 0 {
+		return ""
+	}
+	return h.Targets[i%len(h.Targets)]
+}
+
+// GetTargetStats returns the stats of every target, in the order of
+// Targets, combining the clients sharded onto it. It is nil without Targets.
+func (h *H2loadClient) GetTargetStats() []TargetStats {
+	targets := h.ClientsConf.Targets
+	if len(targets) == 0 {
+		return nil
+	}
+	shards := make([][]*H2Client, len(targets))
+	for i, c := range h.Clients {
+		shards[i%len(targets)] = append(shards[i%len(targets)], c)
+	}
+	stats := make([]TargetStats, len(targets))
+	for i, target := range targets {
+		stats[i] = TargetStats{Target: target, Clients: len(shards[i]), RequestStats: snapshotClients(shards[i]).Stats}
+	}
+	return stats
+}
+
+// FormatTargetStats formats per-target stats as a table
+func FormatTargetStats(targets []TargetStats) string {
+	rows := make([]RouteStats, len(targets))
+	for i, t := range targets {
+		rows[i] = RouteStats{Route: t.Target, RequestStats: t.RequestStats}
+	}
+	return formatStatsTable("Per-Target Statistics:", "Target", rows)
+}
@@ -0,0 +1,254 @@
+package h2load
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	mathrand "math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// TestServerConf is a local HTTP/2 server for benchmarking the generator
+// itself and for integration tests without an external server. Responses
+// are generated bodies, delayed and failed as configured. A request can
+// override the conf with the query parameters latency (e.g. 50ms), size
+// (e.g. 10KB) and status (e.g. 503).
+type TestServerConf struct {
+	Addr          string        // Address listened on, e.g. :8080 or 127.0.0.1:0 for any free port
+	TLS           bool          // Serve h2 over TLS, with CertFile and KeyFile or a self-signed certificate (default: h2c)
+	CertFile      string        // PEM certificate served with TLS
+	KeyFile       string        // PEM key of CertFile
+	Latency       time.Duration // Delay before every response
+	LatencyJitter time.Duration // Random extra delay, up to this long
+	Size          int64         // Response body size in bytes
+	ErrorRate     float64       // Fraction of requests answered with ErrorStatus
+	ErrorStatus   int           // Status of failed requests (default: 503)
+	ResetRate     float64       // Fraction of requests whose stream is reset with INTERNAL_ERROR
+	MaxStreams    uint32        // MAX_CONCURRENT_STREAMS advertised (default: 250)
+}
+
+func (c *TestServerConf) Validate() error {
+	if c.Latency < 0 || c.LatencyJitter < 0 {
+		return fmt.Errorf("test server latency must be greater than 0")
+	}
+	if c.Size < 0 {
+		return fmt.Errorf("test server response size must be greater than 0")
+	}
+	if c.ErrorRate < 0 || c.ResetRate < 0 || c.ErrorRate+c.ResetRate > 1 {
+		return fmt.Errorf("test server error and reset rates must be between 0 and 1, together")
+	}
+	if c.ErrorStatus != 0 && (c.ErrorStatus < 100 || c.ErrorStatus > 999) {
+		return fmt.Errorf("test server error status must be a 3-digit status code")
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("test server certificate and key must be given together")
+	}
+	if c.CertFile != "" && !c.TLS {
+		return fmt.Errorf("test server certificate requires TLS")
+	}
+	return nil
+}
+
+// TestServerStats are the requests a TestServer answered
+type TestServerStats struct {
+	Requests  int64 // Requests received
+	Errors    int64 // Requests failed with the error status
+	Resets    int64 // Streams reset
+	BytesSent int64 // Response body bytes written
+}
+
+// String formats the TestServerStats as a readable string
+func (s TestServerStats) String() string {
+	return fmt.Sprintf("Served %d requests (%d errors, %d resets), %s of response bodies",
+		s.Requests, s.Errors, s.Resets, formatBytes(s.BytesSent))
+}
+
+// TestServer serves the responses of a TestServerConf
+type TestServer struct {
+	conf     TestServerConf
+	listener net.Listener
+	server   *http.Server
+
+	requests  atomic.Int64
+	errors    atomic.Int64
+	resets    atomic.Int64
+	bytesSent atomic.Int64
+}
+
+// NewTestServer listens on conf.Addr, so URL is known before Serve is
+// called
+func NewTestServer(conf TestServerConf) (*TestServer, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	if conf.ErrorStatus == 0 {
+		conf.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if conf.MaxStreams == 0 {
+		conf.MaxStreams = 250
+	}
+	s := &TestServer{conf: conf}
+	h2 := &http2.Server{MaxConcurrentStreams: conf.MaxStreams}
+	s.server = &http.Server{Handler: http.HandlerFunc(s.handle)}
+
+	listener, err := net.Listen("tcp", conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if !conf.TLS {
+		s.server.Handler = h2c.NewHandler(s.server.Handler, h2)
+		s.listener = listener
+		return s, nil
+	}
+	cert, err := testServerCertificate(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	s.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if err := http2.ConfigureServer(s.server, h2); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	s.listener = tls.NewListener(listener, s.server.TLSConfig)
+	return s, nil
+}
+
+// testServerCertificate loads the certificate at certFile, or generates a
+// self-signed one for localhost if none is given
+func testServerCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile != "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "h2load test server"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// URL returns the base URL of the server, e.g. http://127.0.0.1:8080/
+func (s *TestServer) URL() string {
+	scheme := "http"
+	if s.conf.TLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/", scheme, s.listener.Addr())
+}
+
+// Serve answers requests until Close is called
+func (s *TestServer) Serve() error {
+	if err := s.server.Serve(s.listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close stops the server, closing its connections
+func (s *TestServer) Close() error {
+	return s.server.Close()
+}
+
+// Shutdown stops the server once the requests in flight are answered, or
+// ctx ends
+func (s *TestServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Stats returns the requests answered so far
+func (s *TestServer) Stats() TestServerStats {
+	return TestServerStats{
+		Requests:  s.requests.Load(),
+		Errors:    s.errors.Load(),
+		Resets:    s.resets.Load(),
+		BytesSent: s.bytesSent.Load(),
+	}
+}
+
+func (s *TestServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	// Request bodies are read, so uploads are timed like against a real server
+	io.Copy(io.Discard, r.Body)
+
+	latency, size, status := s.conf.Latency, s.conf.Size, http.StatusOK
+	if s.conf.LatencyJitter > 0 {
+		latency += mathrand.N(s.conf.LatencyJitter)
+	}
+	switch n := mathrand.Float64(); {
+	case n < s.conf.ResetRate:
+		s.resets.Add(1)
+		// Resets the stream with INTERNAL_ERROR, without logging
+		panic(http.ErrAbortHandler)
+	case n < s.conf.ResetRate+s.conf.ErrorRate:
+		status = s.conf.ErrorStatus
+	}
+
+	query := r.URL.Query()
+	if v := query.Get("latency"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid latency %q", v), http.StatusBadRequest)
+			return
+		}
+		latency = d
+	}
+	if v := query.Get("size"); v != "" {
+		if err := (&byteSizeValue{&size}).Set(v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid size %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("status"); v != "" {
+		code, err := strconv.Atoi(v)
+		if err != nil || code < 200 || code > 999 {
+			http.Error(w, fmt.Sprintf("invalid status %q", v), http.StatusBadRequest)
+			return
+		}
+		status = code
+	}
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status >= 400 {
+		s.errors.Add(1)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(status)
+	n, _ := io.Copy(w, io.LimitReader(&patternReader{}, size))
+	s.bytesSent.Add(n)
+}
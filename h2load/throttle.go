@@ -0,0 +1,262 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetworkProfile is the bandwidth of a typical constrained link, in bytes
+// per second
+type NetworkProfile struct {
+	Name      string
+	ReadRate  int64 // Downlink
+	WriteRate int64 // Uplink
+}
+
+// NetworkProfiles are the links ThrottleConf.Profile can name, after the
+// presets of browser devtools and WebPageTest
+var NetworkProfiles = []NetworkProfile{
+	{"gprs", 50_000 / 8, 20_000 / 8},
+	{"2g", 250_000 / 8, 50_000 / 8},
+	{"3g", 750_000 / 8, 250_000 / 8},
+	{"fast-3g", 1_600_000 / 8, 768_000 / 8},
+	{"4g", 9_000_000 / 8, 9_000_000 / 8},
+	{"dsl", 1_500_000 / 8, 384_000 / 8},
+	{"cable", 5_000_000 / 8, 1_000_000 / 8},
+}
+
+// networkProfile returns the profile named name
+func networkProfile(name string) (NetworkProfile, bool) {
+	for _, p := range NetworkProfiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return NetworkProfile{}, false
+}
+
+// networkProfileNames returns the names of NetworkProfiles, for messages
+func networkProfileNames() string {
+	names := make([]string, len(NetworkProfiles))
+	for i, p := range NetworkProfiles {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// ThrottleConf caps the bandwidth of every connection, each direction with
+// its own token bucket, to emulate constrained clients. Rates set override
+// the profile's. The bytes throttled are the ones on the wire, TLS records
+// included.
+type ThrottleConf struct {
+	Profile   string // Name of a NetworkProfile, e.g. "3g" (default: none)
+	ReadRate  int64  // Bytes per second read from the server (0 = the profile's, unlimited without one)
+	WriteRate int64  // Bytes per second written to the server (0 = the profile's, unlimited without one)
+}
+
+func (t *ThrottleConf) Validate() error {
+	if t.ReadRate < 0 || t.WriteRate < 0 {
+		return fmt.Errorf("throttle rates must be greater than 0")
+	}
+	if _, ok := networkProfile(t.Profile); t.Profile != "" && !ok {
+		return fmt.Errorf("unknown network profile %q, expected one of %s", t.Profile, networkProfileNames())
+	}
+	return nil
+}
+
+func (t *ThrottleConf) enabled() bool {
+	read, write := t.rates()
+	return read > 0 || write > 0
+}
+
+// rates returns the read and write rates of t, from its profile unless set
+func (t *ThrottleConf) rates() (read, write int64) {
+	p, _ := networkProfile(t.Profile)
+	read, write = p.ReadRate, p.WriteRate
+	if t.ReadRate > 0 {
+		read = t.ReadRate
+	}
+	if t.WriteRate > 0 {
+		write = t.WriteRate
+	}
+	return read, write
+}
+
+// String formats the throttle as a readable string
+func (t ThrottleConf) String() string {
+	read, write := t.rates()
+	rate := func(r int64) string {
+		if r == 0 {
+			return "unlimited"
+		}
+		return FormatBitRate(r)
+	}
+	s := fmt.Sprintf("%s down, %s up per connection", rate(read), rate(write))
+	if t.Profile != "" {
+		s = t.Profile + ", " + s
+	}
+	return s
+}
+
+// bitRateUnits are the suffixes of ParseBitRate, in bits per second
+var bitRateUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"gbit", 1e9}, {"mbit", 1e6}, {"kbit", 1e3}, {"bit", 1},
+	{"gbps", 1e9}, {"mbps", 1e6}, {"kbps", 1e3}, {"bps", 1},
+}
+
+// ParseBitRate parses a link speed in bits per second, such as 750kbit or
+// 1.5mbps, into bytes per second
+func ParseBitRate(s string) (int64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	factor := 1.0
+	for _, u := range bitRateUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, factor = strings.TrimSpace(v[:len(v)-len(u.suffix)]), u.factor
+			break
+		}
+	}
+	bits, err := strconv.ParseFloat(v, 64)
+	if err != nil || bits < 0 {
+		return 0, fmt.Errorf("invalid rate %q, expected bits per second such as 750kbit or 1.5mbit", s)
+	}
+	return int64(bits * factor / 8), nil
+}
+
+// FormatBitRate formats a rate in bytes per second as bits per second
+func FormatBitRate(bytesPerSec int64) string {
+	bits := float64(bytesPerSec) * 8
+	switch {
+	case bits >= 1e9:
+		return strconv.FormatFloat(bits/1e9, 'f', -1, 64) + "Gbit/s"
+	case bits >= 1e6:
+		return strconv.FormatFloat(bits/1e6, 'f', -1, 64) + "Mbit/s"
+	case bits >= 1e3:
+		return strconv.FormatFloat(bits/1e3, 'f', -1, 64) + "kbit/s"
+	}
+	return strconv.FormatFloat(bits, 'f', -1, 64) + "bit/s"
+}
+
+// byteBucket is a token bucket of bytes, refilled at rate bytes per second
+// up to burst
+type byteBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newByteBucket(rate int64) *byteBucket {
+	// A tenth of a second of traffic at most passes at once, so the rate
+	// holds over short windows too
+	burst := max(int(rate/10), 1024)
+	return &byteBucket{rate: float64(rate), burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// take takes n bytes from the bucket, which may go into debt, and returns
+// how long to wait for the debt to be paid off
+func (b *byteBucket) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, float64(b.burst))
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttledConn is a net.Conn whose reads and writes are paced by byte
+// buckets. Reads are paced after the fact: the bytes are held back until the
+// bucket has paid for them, so the connection's receive window fills and
+// TCP slows the server down.
+type throttledConn struct {
+	net.Conn
+	read, write *byteBucket // nil = unlimited
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// throttleDialer returns dial with the connections it dials throttled by t
+func throttleDialer(t ThrottleConf, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tc := &throttledConn{Conn: conn, closed: make(chan struct{})}
+		read, write := t.rates()
+		if read > 0 {
+			tc.read = newByteBucket(read)
+		}
+		if write > 0 {
+			tc.write = newByteBucket(write)
+		}
+		return tc, nil
+	}
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if c.read == nil {
+		return c.Conn.Read(p)
+	}
+	if len(p) > c.read.burst {
+		p = p[:c.read.burst]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.pause(c.read.take(n))
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	if c.write == nil {
+		return c.Conn.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.write.burst)]
+		if !c.pause(c.write.take(len(chunk))) {
+			return written, net.ErrClosed
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// pause waits for d, returning false if the connection is closed first
+func (c *throttledConn) pause(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.closed:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (c *throttledConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,109 @@
+package h2load
+
+import (
+	"sync"
+	"time"
+)
+
+// TimeSeriesPoint summarizes the requests completed in one interval of a run
+type TimeSeriesPoint struct {
+	Elapsed    time.Duration // Time since the start of the run at the end of the interval
+	Requests   int64
+	Failed     int64
+	Rps        float64
+	P50Latency time.Duration
+	P99Latency time.Duration
+	MaxLatency time.Duration
+	InFlight   float64 // Average requests in flight
+	MaxFlight  int64   // Most requests in flight
+}
+
+// timeSeries samples windowed stats every interval during a run
+type timeSeries struct {
+	window   *statsWindow
+	gauge    *concurrencyGauge // Requests in flight, if set
+	interval time.Duration
+	start    time.Time
+	stop     chan struct{}
+	stopped  chan struct{}
+
+	mu     sync.Mutex
+	points []TimeSeriesPoint
+}
+
+func newTimeSeries(interval time.Duration) *timeSeries {
+	return &timeSeries{
+		window:   newStatsWindow(),
+		interval: interval,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// run samples every interval until finish is called
+func (t *timeSeries) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.sample(false)
+		}
+	}
+}
+
+// sample appends the current window. A partial window is skipped when it is
+// empty, or too short for a meaningful rate after other samples.
+func (t *timeSeries) sample(partial bool) {
+	stats, _ := t.window.take()
+	if partial && stats.TotalRequests == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if partial && stats.Duration < t.interval/2 && len(t.points) > 0 {
+		return
+	}
+	p := TimeSeriesPoint{
+		Elapsed:    time.Since(t.start),
+		Requests:   stats.TotalRequests,
+		Failed:     stats.FailedRequests,
+		Rps:        stats.Rps(),
+		P50Latency: stats.P50Latency,
+		P99Latency: stats.P99Latency,
+		MaxLatency: stats.MaxLatency,
+	}
+	if t.gauge != nil {
+		p.InFlight, p.MaxFlight = t.gauge.takeWindow()
+	}
+	t.points = append(t.points, p)
+}
+
+// finish stops sampling and records the last, partial interval
+func (t *timeSeries) finish() {
+	close(t.stop)
+	<-t.stopped
+	t.sample(true)
+}
+
+// reset drops the points sampled so far and starts the series over
+func (t *timeSeries) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.window.reset()
+	if t.gauge != nil {
+		t.gauge.takeWindow()
+	}
+	t.points = nil
+	t.start = time.Now()
+}
+
+func (t *timeSeries) getPoints() []TimeSeriesPoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TimeSeriesPoint(nil), t.points...)
+}
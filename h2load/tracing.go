@@ -0,0 +1,105 @@
+package h2load
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// RequestTrace holds the full timing breakdown of a single sampled request.
+// All offsets are relative to Start; a zero offset means the event never happened.
+type RequestTrace struct {
+	Method       string
+	URL          string
+	TraceParent  string // W3C traceparent the request was sent with, if any
+	Status       int
+	Err          error
+	Start        time.Time
+	ConnReused   bool
+	GotConn      time.Duration
+	TLSHandshake time.Duration
+	WroteHeaders time.Duration
+	WroteRequest time.Duration
+	FirstByte    time.Duration
+	Total        time.Duration
+}
+
+// traceSampler hands out at most limit trace slots per minute.
+// A limit of 0 means every request is traced.
+type traceSampler struct {
+	mu          sync.Mutex
+	limit       int
+	windowStart time.Time
+	taken       int
+}
+
+func newTraceSampler(limit int) *traceSampler {
+	return &traceSampler{limit: limit}
+}
+
+// allow reports whether the current request should be traced
+func (s *traceSampler) allow() bool {
+	if s.limit == 0 {
+		return true
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart = now
+		s.taken = 0
+	}
+	if s.taken >= s.limit {
+		return false
+	}
+	s.taken++
+	return true
+}
+
+// requestTracer collects a RequestTrace from httptrace callbacks, which the
+// transport may invoke from its own goroutines.
+type requestTracer struct {
+	mu sync.Mutex
+	rt RequestTrace
+}
+
+// attach returns a copy of req that reports its events to the tracer
+func (t *requestTracer) attach(req *http.Request, start time.Time) *http.Request {
+	t.rt = RequestTrace{Method: req.Method, URL: req.URL.String(), TraceParent: req.Header.Get("Traceparent"), Start: start}
+	mark := func(f func(d time.Duration)) {
+		d := time.Since(start)
+		t.mu.Lock()
+		f(d)
+		t.mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			mark(func(d time.Duration) { t.rt.GotConn, t.rt.ConnReused = d, info.Reused })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mark(func(d time.Duration) { t.rt.TLSHandshake = d })
+		},
+		WroteHeaders: func() {
+			mark(func(d time.Duration) { t.rt.WroteHeaders = d })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mark(func(d time.Duration) { t.rt.WroteRequest = d })
+		},
+		GotFirstResponseByte: func() {
+			mark(func(d time.Duration) { t.rt.FirstByte = d })
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// finish records the outcome and returns a copy of the collected trace
+func (t *requestTracer) finish(status int, err error, total time.Duration) RequestTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rt.Status = status
+	t.rt.Err = err
+	t.rt.Total = total
+	return t.rt
+}
@@ -0,0 +1,136 @@
+package h2load
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// TrafficStats counts the bytes a client received and times its connections,
+// the way nghttp2's h2load reports them
+type TrafficStats struct {
+	BytesTotal      int64           // All bytes read from the connections
+	BytesHeaders    int64           // HEADERS and CONTINUATION payloads, HPACK-compressed
+	BytesHeadersRaw int64           // Response header names and values, decompressed
+	BytesData       int64           // DATA payloads
+	ConnectTimes    []time.Duration // Time to connect, including the TLS handshake, of each connection
+	FirstByteTimes  []time.Duration // Time from dialing to the first byte read of each connection
+	LookupTimes     []time.Duration // Time of each DNS lookup, one per connection to a host name
+}
+
+// HeaderSpaceSavings returns the fraction of header bytes saved by HPACK
+func (t TrafficStats) HeaderSpaceSavings() float64 {
+	if t.BytesHeadersRaw == 0 {
+		return 0
+	}
+	return 1 - float64(t.BytesHeaders)/float64(t.BytesHeadersRaw)
+}
+
+// merge adds the counts and samples of other to t
+func (t *TrafficStats) merge(other TrafficStats) {
+	t.BytesTotal += other.BytesTotal
+	t.BytesHeaders += other.BytesHeaders
+	t.BytesHeadersRaw += other.BytesHeadersRaw
+	t.BytesData += other.BytesData
+	t.ConnectTimes = append(t.ConnectTimes, other.ConnectTimes...)
+	t.FirstByteTimes = append(t.FirstByteTimes, other.FirstByteTimes...)
+	t.LookupTimes = append(t.LookupTimes, other.LookupTimes...)
+}
+
+// trafficCounter collects the TrafficStats of a client's connections
+type trafficCounter struct {
+	total      int64 // Atomic counters
+	headers    int64
+	headersRaw int64
+	data       int64
+
+	mu         sync.Mutex // Guards the samples
+	connects   []time.Duration
+	firstBytes []time.Duration
+	lookups    []time.Duration
+}
+
+// readBytes counts n bytes read from a connection
+func (t *trafficCounter) readBytes(n int) {
+	atomic.AddInt64(&t.total, int64(n))
+}
+
+// frame counts the payload of a frame read, by its type
+func (t *trafficCounter) frame(typ http2.FrameType, length int) {
+	switch typ {
+	case http2.FrameHeaders, http2.FrameContinuation:
+		atomic.AddInt64(&t.headers, int64(length))
+	case http2.FrameData:
+		atomic.AddInt64(&t.data, int64(length))
+	}
+}
+
+// response counts the decompressed size of a response's header fields
+func (t *trafficCounter) response(resp *http.Response) {
+	n := len(":status") + 3
+	for name, values := range resp.Header {
+		for _, v := range values {
+			n += len(name) + len(v)
+		}
+	}
+	atomic.AddInt64(&t.headersRaw, int64(n))
+}
+
+func (t *trafficCounter) connected(d time.Duration) {
+	t.mu.Lock()
+	t.connects = append(t.connects, d)
+	t.mu.Unlock()
+}
+
+func (t *trafficCounter) firstByte(d time.Duration) {
+	t.mu.Lock()
+	t.firstBytes = append(t.firstBytes, d)
+	t.mu.Unlock()
+}
+
+func (t *trafficCounter) lookedUp(d time.Duration) {
+	t.mu.Lock()
+	t.lookups = append(t.lookups, d)
+	t.mu.Unlock()
+}
+
+func (t *trafficCounter) stats() TrafficStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TrafficStats{
+		BytesTotal:      atomic.LoadInt64(&t.total),
+		BytesHeaders:    atomic.LoadInt64(&t.headers),
+		BytesHeadersRaw: atomic.LoadInt64(&t.headersRaw),
+		BytesData:       atomic.LoadInt64(&t.data),
+		ConnectTimes:    append([]time.Duration(nil), t.connects...),
+		FirstByteTimes:  append([]time.Duration(nil), t.firstBytes...),
+		LookupTimes:     append([]time.Duration(nil), t.lookups...),
+	}
+}
+
+// reset zeroes the byte counts. Connection times are kept, as they are only
+// measured once per connection.
+func (t *trafficCounter) reset() {
+	atomic.StoreInt64(&t.total, 0)
+	atomic.StoreInt64(&t.headers, 0)
+	atomic.StoreInt64(&t.headersRaw, 0)
+	atomic.StoreInt64(&t.data, 0)
+}
+
+// LookupSummary formats the count and latency of the DNS lookups
+func (t TrafficStats) LookupSummary() string {
+	if len(t.LookupTimes) == 0 {
+		return "DNS Lookups: 0"
+	}
+	var total, slowest time.Duration
+	for _, d := range t.LookupTimes {
+		total += d
+		slowest = max(slowest, d)
+	}
+	return fmt.Sprintf("DNS Lookups: %d (avg %v, max %v)",
+		len(t.LookupTimes), total/time.Duration(len(t.LookupTimes)), slowest)
+}
@@ -0,0 +1,98 @@
+package h2load
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TrailerConf sets the trailers sent after request bodies and checks the
+// trailers of responses, where gRPC-style and streaming APIs put the real
+// status, e.g. grpc-status
+type TrailerConf struct {
+	Send   http.Header // Trailers sent after each request body, only with a body
+	Expect http.Header // Response trailers required, with one of the given values
+}
+
+func (c *TrailerConf) Validate() error {
+	for name := range c.Send {
+		if !validTrailer(name) {
+			return fmt.Errorf("trailer %s is not allowed", name)
+		}
+	}
+	return nil
+}
+
+// validTrailer reports whether name may be sent as a trailer. Framing,
+// routing and authentication fields are not.
+func validTrailer(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Cache-Control", "Content-Encoding", "Content-Length", "Content-Range",
+		"Content-Type", "Expect", "Host", "Max-Forwards", "Pragma", "Te", "Trailer",
+		"Transfer-Encoding", "Proxy-Authorization", "Range", "Set-Cookie":
+		return false
+	}
+	return true
+}
+
+// setTrailers returns req declaring c.Send as its trailers, as a copy, or req
+// itself when it declares trailers of its own or c has none. They are sent
+// once its body is, and dropped by the transport if it has none.
+func (c *TrailerConf) setTrailers(req *http.Request) *http.Request {
+	if len(c.Send) == 0 || req.Trailer != nil {
+		return req
+	}
+	// Factories may hand out the same request every time
+	req = req.Clone(req.Context())
+	req.Trailer = c.Send.Clone()
+	return req
+}
+
+// check returns an error if trailer lacks an expected trailer, or has none of
+// its expected values
+func (c *TrailerConf) check(trailer http.Header) error {
+	names := make([]string, 0, len(c.Expect))
+	for name := range c.Expect {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		want := c.Expect[name]
+		got, ok := trailer[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return fmt.Errorf("trailer %s: missing, want %s", strings.ToLower(name), strings.Join(want, " or "))
+		}
+		if !matchesAny(got, want) {
+			return fmt.Errorf("trailer %s: got %q, want %s", strings.ToLower(name), strings.Join(got, ", "), strings.Join(want, " or "))
+		}
+	}
+	return nil
+}
+
+func matchesAny(got, want []string) bool {
+	for _, g := range got {
+		for _, w := range want {
+			if g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// responseTrailers returns the trailers of a drained response, nil if it
+// had none
+func responseTrailers(resp *http.Response) http.Header {
+	trailer := http.Header{}
+	for k, v := range resp.Trailer {
+		// Declared trailers that never arrived are kept with no values
+		if len(v) > 0 {
+			trailer[k] = v
+		}
+	}
+	if len(trailer) == 0 {
+		return nil
+	}
+	return trailer
+}
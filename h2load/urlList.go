@@ -0,0 +1,174 @@
+package h2load
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	urlpkg "net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// ListedRequest is a request of a URL list, sent with its own method
+type ListedRequest struct {
+	Method string // Any method, e.g. GET, HEAD, OPTIONS or PURGE (default: GET)
+	URL    string // Absolute, or relative to the run's URL
+}
+
+func (r ListedRequest) method() string {
+	if r.Method == "" {
+		return http.MethodGet
+	}
+	return r.Method
+}
+
+// String formats the request as ParseListedRequest parses it
+func (r ListedRequest) String() string {
+	return r.method() + " " + r.URL
+}
+
+// ParseListedRequest parses "[METHOD] URL", e.g. "HEAD /health"
+func ParseListedRequest(s string) (ListedRequest, error) {
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		return ListedRequest{Method: http.MethodGet, URL: fields[0]}, nil
+	case 2:
+		if !validToken(fields[0]) {
+			return ListedRequest{}, fmt.Errorf("invalid method %q", fields[0])
+		}
+		return ListedRequest{Method: fields[0], URL: fields[1]}, nil
+	}
+	return ListedRequest{}, fmt.Errorf("invalid request %q, expected [METHOD] URL", s)
+}
+
+// LoadURLList reads a URL list, one "[METHOD] URL" per line. Blank lines and
+// lines starting with # are skipped.
+func LoadURLList(path string) ([]ListedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var list []ListedRequest
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		r, err := ParseListedRequest(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		list = append(list, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%s: no requests", path)
+	}
+	return list, nil
+}
+
+// validToken reports whether s is an HTTP token, as methods, custom ones
+// included, and header names are
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c >= 0x7f || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveOnHost resolves ref against base, which it must stay on
+func resolveOnHost(base, ref string) (string, error) {
+	baseURL, err := urlpkg.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	refURL, err := urlpkg.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	url := baseURL.ResolveReference(refURL)
+	if url.Scheme != baseURL.Scheme || url.Host != baseURL.Host {
+		return "", fmt.Errorf("URL %s is not on the run's host %s", url, baseURL.Host)
+	}
+	return url.String(), nil
+}
+
+// takesBody reports whether requests of method carry the run's body
+func takesBody(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+// urlListFactory returns the factory of the requests of conf's URL list, in
+// turn across all clients. POST, PUT and PATCH requests carry conf's Body
+// if it has one.
+func urlListFactory(conf H2loadConf) (func() *http.Request, error) {
+	factories := make([]func() *http.Request, len(conf.URLList))
+	for i, r := range conf.URLList {
+		url, err := resolveOnHost(conf.URL, r.URL)
+		if err != nil {
+			return nil, err
+		}
+		if conf.Body.enabled() && takesBody(r.method()) {
+			if factories[i], err = conf.Body.requestFactory(r.method(), url, conf.Headers, conf.Seed); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		req, err := http.NewRequest(r.method(), url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range conf.Headers {
+			req.Header[k] = v
+		}
+		factories[i] = func() *http.Request { return req }
+	}
+	var next uint64
+	return func() *http.Request {
+		return factories[(atomic.AddUint64(&next, 1)-1)%uint64(len(factories))]()
+	}, nil
+}
+
+// hasResponseBody reports whether resp to req can have a body: responses to
+// HEAD, 1xx, 204 and 304 never do, whatever their Content-Length and
+// Content-Encoding say
+func hasResponseBody(req *http.Request, resp *http.Response) bool {
+	switch {
+	case req.Method == http.MethodHead:
+		return false
+	case resp.StatusCode/100 == 1, resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// describeURLList summarizes list as its requests by method, e.g.
+// "5 requests (GET 3, HEAD 1, OPTIONS 1)"
+func describeURLList(list []ListedRequest) string {
+	counts := make(map[string]int)
+	var methods []string
+	for _, r := range list {
+		if counts[r.method()] == 0 {
+			methods = append(methods, r.method())
+		}
+		counts[r.method()]++
+	}
+	sort.Strings(methods)
+	parts := make([]string, len(methods))
+	for i, m := range methods {
+		parts[i] = fmt.Sprintf("%s %d", m, counts[m])
+	}
+	return fmt.Sprintf("%d requests (%s)", len(list), strings.Join(parts, ", "))
+}
@@ -0,0 +1,87 @@
+package h2load
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit identify the build, set at build time with e.g.
+//
+//	go build -ldflags "-X github.com/galbarnahum/h2loadGo/h2load.Version=v1.4.0 -X github.com/galbarnahum/h2loadGo/h2load.Commit=$(git rev-parse HEAD)"
+//
+// Left empty, the module version and VCS revision recorded by the Go
+// toolchain are used.
+var (
+	Version string
+	Commit  string
+)
+
+// http2Module is the module implementing the HTTP/2 client
+const http2Module = "golang.org/x/net"
+
+// BuildInfo identifies the build of the tool
+type BuildInfo struct {
+	Version      string // Semantic version, "(devel)" for local builds
+	Revision     string // VCS revision built from, "-dirty" if modified
+	Time         string // Commit time of Revision
+	GoVersion    string
+	HTTP2Version string // Version of http2Module
+}
+
+// ReadBuildInfo returns the build of the running binary, as far as it was
+// recorded
+func ReadBuildInfo() BuildInfo {
+	b := BuildInfo{Version: "unknown", GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		b.Version = info.Main.Version
+		var modified bool
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				b.Revision = s.Value[:min(len(s.Value), 12)]
+			case "vcs.time":
+				b.Time = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if b.Revision != "" && modified {
+			b.Revision += "-dirty"
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == http2Module {
+				if dep.Replace != nil {
+					dep = dep.Replace
+				}
+				b.HTTP2Version = dep.Version
+			}
+		}
+	}
+	if Version != "" {
+		b.Version = Version
+	}
+	if Commit != "" {
+		b.Revision = Commit[:min(len(Commit), 12)]
+		b.Time = ""
+	}
+	return b
+}
+
+// String formats the BuildInfo as printed by -version
+func (b BuildInfo) String() string {
+	s := fmt.Sprintf("%s %s\n", toolName, b.Version)
+	if b.Revision != "" {
+		s += fmt.Sprintf("Commit: %s", b.Revision)
+		if b.Time != "" {
+			s += fmt.Sprintf(" (%s)", b.Time)
+		}
+		s += "\n"
+	}
+	s += fmt.Sprintf("Go: %s %s/%s\n", b.GoVersion, runtime.GOOS, runtime.GOARCH)
+	if b.HTTP2Version != "" {
+		s += fmt.Sprintf("HTTP/2: %s %s\n", http2Module, b.HTTP2Version)
+	}
+	return s
+}
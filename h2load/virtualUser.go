@@ -0,0 +1,268 @@
+package h2load
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"sync"
+	"sync/atomic"
+)
+
+// VirtualUser is a simulated user with state of its own: the cookies its
+// responses set, variables and counters. A user sends one request at a time,
+// so a request can use what the user's previous response left.
+type VirtualUser struct {
+	ID int // Index of the user within the run
+
+	jar      http.CookieJar
+	requests int64 // Requests sent
+	cookies  int64 // Cookies set by responses
+
+	mu       sync.Mutex
+	vars     map[string]string
+	counters map[string]int64
+}
+
+func newVirtualUser(id int) *VirtualUser {
+	// Never fails without options
+	jar, _ := cookiejar.New(nil)
+	return &VirtualUser{ID: id, jar: jar, vars: make(map[string]string), counters: make(map[string]int64)}
+}
+
+// Get returns the variable name, empty if unset
+func (u *VirtualUser) Get(name string) string {
+	value, _ := u.Lookup(name)
+	return value
+}
+
+// Lookup returns the variable name, and false if unset
+func (u *VirtualUser) Lookup(name string) (string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	value, ok := u.vars[name]
+	return value, ok
+}
+
+// Set sets the variable name
+func (u *VirtualUser) Set(name, value string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.vars[name] = value
+}
+
+// Vars returns a copy of the user's variables
+func (u *VirtualUser) Vars() map[string]string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	vars := make(map[string]string, len(u.vars))
+	for k, v := range u.vars {
+		vars[k] = v
+	}
+	return vars
+}
+
+// Add adds delta to the counter name and returns its new value
+func (u *VirtualUser) Add(name string, delta int64) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.counters[name] += delta
+	return u.counters[name]
+}
+
+// Counter returns the counter name, 0 if never added to
+func (u *VirtualUser) Counter(name string) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.counters[name]
+}
+
+// Requests returns how many requests the user sent
+func (u *VirtualUser) Requests() int64 {
+	return atomic.LoadInt64(&u.requests)
+}
+
+// Cookies returns the cookies the user sends to the URL of req
+func (u *VirtualUser) Cookies(req *http.Request) []*http.Cookie {
+	return u.jar.Cookies(req.URL)
+}
+
+// UserScenario is what the virtual users of RunUsers send
+type UserScenario struct {
+	Next       func(u *VirtualUser) *http.Request                           // Builds the user's next request from its state
+	OnResponse func(u *VirtualUser, req *http.Request, resp *http.Response) // Updates its state, before the body is drained (optional)
+}
+
+type userKey struct{}
+
+// requestUser returns the user sending req, nil if none
+func requestUser(req *http.Request) *VirtualUser {
+	u, _ := req.Context().Value(userKey{}).(*VirtualUser)
+	return u
+}
+
+// userPool hands a client's virtual users out, one request at a time each
+type userPool struct {
+	users    []*VirtualUser
+	free     chan *VirtualUser
+	scenario *UserScenario // Builds the users' requests, nil for the run's requests
+	extract  *extractorSet // Extractors of the run, shared by its pools, nil without Extract
+}
+
+func newUserPool(users []*VirtualUser) *userPool {
+	p := &userPool{users: users, free: make(chan *VirtualUser, len(users))}
+	for _, u := range users {
+		p.free <- u
+	}
+	return p
+}
+
+// acquire waits for a free user, returning nil if ctx is done first
+func (p *userPool) acquire(ctx context.Context) *VirtualUser {
+	select {
+	case u := <-p.free:
+		return u
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (p *userPool) release(u *VirtualUser) {
+	p.free <- u
+}
+
+// request returns u's next request, from the scenario or else factory, as a
+// copy carrying u and its cookies
+func (p *userPool) request(u *VirtualUser, factory func() *http.Request) *http.Request {
+	var req *http.Request
+	if p.scenario != nil {
+		req = p.scenario.Next(u)
+	} else {
+		req = factory()
+	}
+	// Factories may hand out the same request every time
+	req = req.Clone(context.WithValue(req.Context(), userKey{}, u))
+	if p.extract != nil {
+		req = p.extract.substitute(u, req)
+	}
+	for _, c := range u.jar.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+	atomic.AddInt64(&u.requests, 1)
+	return req
+}
+
+// observe stores the cookies resp sets for the user of req and the values
+// of the extractors, and passes it to the scenario
+func (p *userPool) observe(req *http.Request, resp *http.Response) {
+	u := requestUser(req)
+	if u == nil {
+		return
+	}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		u.jar.SetCookies(req.URL, cookies)
+		atomic.AddInt64(&u.cookies, int64(len(cookies)))
+	}
+	if p.extract != nil {
+		p.extract.apply(u, resp)
+	}
+	if p.scenario != nil && p.scenario.OnResponse != nil {
+		p.scenario.OnResponse(u, req, resp)
+	}
+}
+
+// UserStats summarizes the virtual users of a run
+type UserStats struct {
+	Users       int
+	MinRequests int64 // Fewest requests sent by a user
+	MaxRequests int64 // Most requests sent by a user
+	CookiesSet  int64 // Cookies set by responses, across all users
+	Extractors  []ExtractorStats
+}
+
+// String formats the UserStats as a readable string
+func (s UserStats) String() string {
+	str := fmt.Sprintf(`Virtual Users:
+Users: %d
+Requests Per User: %d-%d
+Cookies Set: %d`,
+		s.Users, s.MinRequests, s.MaxRequests, s.CookiesSet)
+	for _, e := range s.Extractors {
+		str += fmt.Sprintf("\nExtractor %s: %d hits, %d misses", e.Extractor, e.Hits, e.Misses)
+	}
+	return str
+}
+
+// newUserPools deals users virtual users out to clients, user i to client i
+// mod clients, so each user sticks to one connection as a browser would
+func newUserPools(users, clients int, extractors []Extractor) []*userPool {
+	var extract *extractorSet
+	if len(extractors) > 0 {
+		extract = newExtractorSet(extractors)
+	}
+	shards := make([][]*VirtualUser, clients)
+	for i := 0; i < users; i++ {
+		shards[i%clients] = append(shards[i%clients], newVirtualUser(i))
+	}
+	pools := make([]*userPool, clients)
+	for i, shard := range shards {
+		pools[i] = newUserPool(shard)
+		pools[i].extract = extract
+	}
+	return pools
+}
+
+// RunUsers runs the test with the virtual users of Users sending the
+// requests of scenario instead of the run's
+func (h *H2loadClient) RunUsers(scenario UserScenario) error {
+	if h.ClientsConf.Users == 0 {
+		return fmt.Errorf("RunUsers needs users")
+	}
+	if scenario.Next == nil {
+		return fmt.Errorf("user scenario needs a Next function")
+	}
+	for _, c := range h.Clients {
+		c.users.scenario = &scenario
+	}
+	defer func() {
+		for _, c := range h.Clients {
+			c.users.scenario = nil
+		}
+	}()
+	// Every request comes from the scenario
+	return h.RunRequestsFactory(nil)
+}
+
+// GetUsers returns the virtual users of the run by ID, nil without Users
+func (h *H2loadClient) GetUsers() []*VirtualUser {
+	if h.ClientsConf.Users == 0 {
+		return nil
+	}
+	users := make([]*VirtualUser, h.ClientsConf.Users)
+	for _, c := range h.Clients {
+		for _, u := range c.users.users {
+			users[u.ID] = u
+		}
+	}
+	return users
+}
+
+// GetUserStats returns the stats of the virtual users, and false without
+// Users
+func (h *H2loadClient) GetUserStats() (UserStats, bool) {
+	users := h.GetUsers()
+	if len(users) == 0 {
+		return UserStats{}, false
+	}
+	stats := UserStats{Users: len(users), MinRequests: users[0].Requests()}
+	for _, u := range users {
+		n := u.Requests()
+		stats.MinRequests = min(stats.MinRequests, n)
+		stats.MaxRequests = max(stats.MaxRequests, n)
+		stats.CookiesSet += atomic.LoadInt64(&u.cookies)
+	}
+	if extract := h.Clients[0].users.extract; extract != nil {
+		stats.Extractors = extract.stats()
+	}
+	return stats, true
+}
@@ -0,0 +1,89 @@
+package h2load
+
+import (
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// WaitTimeoutError is returned by WaitTimeout when the workers don't finish
+// in time
+type WaitTimeoutError struct {
+	Timeout     time.Duration
+	Diagnostics string // Requests and streams still in flight, and the stacks of all goroutines
+}
+
+func (e *WaitTimeoutError) Error() string {
+	return fmt.Sprintf("workers still running after %v", e.Timeout)
+}
+
+// WaitTimeout is Wait bounded by d. When the client's workers don't finish
+// in time, e.g. on a wedged stream, it returns a *WaitTimeoutError
+// describing what is still running; the workers are left running.
+func (h *H2Client) WaitTimeout(d time.Duration) error {
+	if waitTimeout(h.Wait, d) {
+		return nil
+	}
+	return &WaitTimeoutError{Timeout: d, Diagnostics: h.diagnostics() + "\n" + goroutineDump()}
+}
+
+// WaitTimeout is Wait bounded by d, see H2Client.WaitTimeout
+func (h *H2loadClient) WaitTimeout(d time.Duration) error {
+	if waitTimeout(h.Wait, d) {
+		return nil
+	}
+	var b strings.Builder
+	for _, c := range h.Clients {
+		b.WriteString(c.diagnostics())
+	}
+	b.WriteString("\n" + goroutineDump())
+	return &WaitTimeoutError{Timeout: d, Diagnostics: b.String()}
+}
+
+// waitTimeout calls wait, giving up on it after d. It reports whether wait
+// returned in time.
+func waitTimeout(wait func(), d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// diagnostics describes the client's requests and connection, one line
+func (h *H2Client) diagnostics() string {
+	line := fmt.Sprintf("client %d: %d requests in flight", h.ID, atomic.LoadInt64(&h.inFlight))
+	if h.pool == nil {
+		return line + ", not connected\n"
+	}
+	state, ok := h.pool.state()
+	if !ok {
+		return line + ", no connection\n"
+	}
+	line += fmt.Sprintf(", connection: %d active streams, %d pending, max %d",
+		state.StreamsActive, state.StreamsPending, state.MaxConcurrentStreams)
+	switch {
+	case state.Closed:
+		line += ", closed"
+	case state.Closing:
+		line += ", closing"
+	}
+	return line + "\n"
+}
+
+// goroutineDump returns the stacks of all goroutines
+func goroutineDump() string {
+	var b strings.Builder
+	pprof.Lookup("goroutine").WriteTo(&b, 2)
+	return b.String()
+}
@@ -0,0 +1,489 @@
+package h2load
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	urlpkg "net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// WebSocket opcodes (RFC 6455 section 5.2)
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+const (
+	// wsTimestampSize is the send time at the start of every message payload
+	wsTimestampSize = 8
+	// wsMaxFrame bounds the payload of frames read from the server
+	wsMaxFrame = 16 << 20
+	// wsCloseTimeout bounds the wait for the server's close frame, and the
+	// echoes still in flight before it, once a stream is done sending
+	wsCloseTimeout = 2 * time.Second
+)
+
+// WebSocketConf soaks WebSockets bootstrapped over HTTP/2 (RFC 8441): every
+// client opens ConcurrentStreams extended CONNECT streams on its connection
+// and sends binary messages on each. Messages start with their send time, so
+// the round trip is measured when the server echoes them back.
+type WebSocketConf struct {
+	Rate        int           // Messages per second per stream (0 = as fast as the stream allows)
+	Size        int64         // Payload bytes per message, at least 8 for the send time
+	Messages    int           // Messages per stream (0 = until Duration)
+	Duration    time.Duration // How long streams stay open (0 = until Messages are sent)
+	Subprotocol string        // Sec-WebSocket-Protocol requested, if any
+}
+
+func (c *WebSocketConf) Validate() error {
+	if c.Rate < 0 {
+		return fmt.Errorf("websocket message rate must be greater than 0")
+	}
+	if c.Size < wsTimestampSize {
+		return fmt.Errorf("websocket message size must be at least %d bytes", wsTimestampSize)
+	}
+	if c.Size > wsMaxFrame {
+		return fmt.Errorf("websocket message size must be at most %s", formatBytes(wsMaxFrame))
+	}
+	if c.Messages < 0 {
+		return fmt.Errorf("websocket messages must be greater than 0")
+	}
+	if c.Duration < 0 {
+		return fmt.Errorf("websocket duration must be greater than 0")
+	}
+	if c.Messages == 0 && c.Duration == 0 {
+		return fmt.Errorf("websocket needs a number of messages or a duration")
+	}
+	return nil
+}
+
+// IsWebSocketURL reports whether url has a ws or wss scheme
+func IsWebSocketURL(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
+
+// webSocketHTTPURL maps a ws or wss URL to the http or https URL the
+// extended CONNECT is sent to
+func webSocketHTTPURL(url string) string {
+	u, err := urlpkg.Parse(url)
+	if err != nil {
+		return url
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// WebSocketStats are the outcome of a WebSocket run
+type WebSocketStats struct {
+	Streams          int64 // Streams opened
+	FailedStreams    int64 // Extended CONNECTs refused or failed
+	ClosedEarly      int64 // Streams closed by the server or an error before they were done
+	MessagesSent     int64
+	MessagesReceived int64
+	BytesSent        int64 // Payload bytes of the messages sent
+	BytesReceived    int64 // Payload bytes of the messages received
+	Duration         time.Duration
+	AvgConnect       time.Duration // Average time to the response of the extended CONNECT
+	P50Latency       time.Duration // Message round trips, measured on echoed messages
+	P90Latency       time.Duration
+	P99Latency       time.Duration
+	MaxLatency       time.Duration
+	LastError        string // Last error that failed or closed a stream
+}
+
+// String formats the WebSocketStats as a readable string
+func (s WebSocketStats) String() string {
+	rate := func(n int64) float64 {
+		if s.Duration <= 0 {
+			return 0
+		}
+		return float64(n) / s.Duration.Seconds()
+	}
+	summary := fmt.Sprintf(`WebSocket Statistics:
+Streams Opened: %d (%d failed, %d closed early)
+Average Connect Time: %v
+Messages Sent: %d (%.2f/s, %s)
+Messages Received: %d (%.2f/s, %s)
+Message Latency: p50 %v, p90 %v, p99 %v, max %v
+Total Duration: %v`,
+		s.Streams, s.FailedStreams, s.ClosedEarly,
+		s.AvgConnect,
+		s.MessagesSent, rate(s.MessagesSent), formatBytes(s.BytesSent),
+		s.MessagesReceived, rate(s.MessagesReceived), formatBytes(s.BytesReceived),
+		s.P50Latency, s.P90Latency, s.P99Latency, s.MaxLatency,
+		s.Duration)
+	if s.LastError != "" {
+		summary += fmt.Sprintf("\nLast Error: %s", s.LastError)
+	}
+	return summary
+}
+
+// wsCollector gathers the stats of all streams of a run
+type wsCollector struct {
+	streams, failed, closedEarly int64
+	sent, received               int64
+	bytesSent, bytesReceived     int64
+	connectTotal                 int64 // Nanoseconds
+
+	mu        sync.Mutex
+	hist      *hdrhistogram.Histogram // Message round trips
+	lastError string
+}
+
+func (c *wsCollector) fail(err error) {
+	c.mu.Lock()
+	c.lastError = err.Error()
+	c.mu.Unlock()
+}
+
+func (c *wsCollector) recordLatency(d time.Duration) {
+	c.mu.Lock()
+	recordLatency(c.hist, d)
+	c.mu.Unlock()
+}
+
+func (c *wsCollector) stats(duration time.Duration) WebSocketStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := WebSocketStats{
+		Streams:          atomic.LoadInt64(&c.streams),
+		FailedStreams:    atomic.LoadInt64(&c.failed),
+		ClosedEarly:      atomic.LoadInt64(&c.closedEarly),
+		MessagesSent:     atomic.LoadInt64(&c.sent),
+		MessagesReceived: atomic.LoadInt64(&c.received),
+		BytesSent:        atomic.LoadInt64(&c.bytesSent),
+		BytesReceived:    atomic.LoadInt64(&c.bytesReceived),
+		Duration:         duration,
+		P50Latency:       latencyPercentile(c.hist, 50),
+		P90Latency:       latencyPercentile(c.hist, 90),
+		P99Latency:       latencyPercentile(c.hist, 99),
+		MaxLatency:       latencyPercentile(c.hist, 100),
+		LastError:        c.lastError,
+	}
+	if stats.Streams > 0 {
+		stats.AvgConnect = time.Duration(atomic.LoadInt64(&c.connectTotal) / stats.Streams)
+	}
+	return stats
+}
+
+// RunWebSocket runs a WebSocket soak with conf's clients, streams and URL,
+// which may be ws://, wss://, http:// or https://
+func RunWebSocket(conf H2loadConf, ws WebSocketConf) (WebSocketStats, error) {
+	if err := ws.Validate(); err != nil {
+		return WebSocketStats{}, err
+	}
+	conf.URL = webSocketHTTPURL(conf.URL)
+	client, err := NewH2loadClient(conf)
+	if err != nil {
+		return WebSocketStats{}, err
+	}
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		return WebSocketStats{}, fmt.Errorf("connect failed: %w", err)
+	}
+
+	ctx := context.Background()
+	if ws.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ws.Duration)
+		defer cancel()
+	}
+	c := &wsCollector{hist: newLatencyHistogram()}
+
+	start := time.Now()
+	RunConcurrent(client.Clients, func(h *H2Client) error {
+		h.runWebSockets(ctx, ws, c)
+		return nil
+	})
+	return c.stats(time.Since(start)), nil
+}
+
+// runWebSockets opens ConcurrentStreams WebSocket streams on the client's
+// connection and waits for them to finish
+func (h *H2Client) runWebSockets(ctx context.Context, ws WebSocketConf, c *wsCollector) {
+	// Nothing is sent through the request path, its collectors can stop
+	defer h.closeChannels()
+	var wg sync.WaitGroup
+	for i := 0; i < max(h.Conf.ConcurrentStreams, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.runWebSocket(ctx, ws, c)
+		}()
+	}
+	wg.Wait()
+}
+
+// runWebSocket opens one WebSocket stream on the client's connection and
+// sends messages on it until ws is done or ctx ends
+func (h *H2Client) runWebSocket(ctx context.Context, ws WebSocketConf, c *wsCollector) {
+	streamCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodConnect, h.Conf.URL, pr)
+	if err != nil {
+		atomic.AddInt64(&c.failed, 1)
+		c.fail(err)
+		return
+	}
+	for k, v := range h.Conf.Headers {
+		req.Header[k] = v
+	}
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if ws.Subprotocol != "" {
+		req.Header.Set("Sec-WebSocket-Protocol", ws.Subprotocol)
+	}
+
+	start := time.Now()
+	resp, err := h.client.Do(req)
+	if err != nil {
+		atomic.AddInt64(&c.failed, 1)
+		c.fail(fmt.Errorf("extended CONNECT: %w", err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		atomic.AddInt64(&c.failed, 1)
+		c.fail(fmt.Errorf("extended CONNECT: %s", resp.Status))
+		return
+	}
+	atomic.AddInt64(&c.streams, 1)
+	atomic.AddInt64(&c.connectTotal, int64(time.Since(start)))
+
+	conn := &wsConn{w: pw}
+	var closing atomic.Bool
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		r := &wsReader{r: bufio.NewReader(resp.Body)}
+		for {
+			op, payload, err := r.next()
+			if err != nil {
+				if !closing.Load() {
+					atomic.AddInt64(&c.closedEarly, 1)
+					c.fail(err)
+				}
+				return
+			}
+			switch op {
+			case wsOpPing:
+				conn.writeFrame(wsOpPong, payload)
+			case wsOpPong:
+			case wsOpClose:
+				if !closing.Swap(true) {
+					atomic.AddInt64(&c.closedEarly, 1)
+					c.fail(fmt.Errorf("closed by the server: %s", wsCloseReason(payload)))
+					conn.writeFrame(wsOpClose, payload)
+				}
+				return
+			default:
+				atomic.AddInt64(&c.received, 1)
+				atomic.AddInt64(&c.bytesReceived, int64(len(payload)))
+				if len(payload) >= wsTimestampSize {
+					sent := int64(binary.BigEndian.Uint64(payload))
+					if now := time.Now().UnixNano(); sent >= start.UnixNano() && sent <= now {
+						c.recordLatency(time.Duration(now - sent))
+					}
+				}
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if ws.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(ws.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	payload := make([]byte, ws.Size)
+	(&patternReader{}).Read(payload[wsTimestampSize:])
+send:
+	for sent := 0; ws.Messages == 0 || sent < ws.Messages; sent++ {
+		if tick == nil {
+			select {
+			case <-ctx.Done():
+				break send
+			case <-readerDone:
+				break send
+			default:
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				break send
+			case <-readerDone:
+				break send
+			case <-tick:
+			}
+		}
+		binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+		if err := conn.writeFrame(wsOpBinary, payload); err != nil {
+			break
+		}
+		atomic.AddInt64(&c.sent, 1)
+		atomic.AddInt64(&c.bytesSent, int64(len(payload)))
+	}
+
+	// Close the stream the way RFC 6455 does, waiting for the server's close
+	// frame so echoes still in flight are counted
+	if !closing.Swap(true) {
+		conn.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, 1000))
+	}
+	select {
+	case <-readerDone:
+	case <-time.After(wsCloseTimeout):
+	}
+}
+
+// wsCloseReason formats the status code and reason of a close frame payload
+func wsCloseReason(payload []byte) string {
+	if len(payload) < 2 {
+		return "no status"
+	}
+	code := binary.BigEndian.Uint16(payload)
+	if reason := string(payload[2:]); reason != "" {
+		return fmt.Sprintf("%d %s", code, reason)
+	}
+	return fmt.Sprint(code)
+}
+
+// wsConn writes masked client frames, serializing the sender and the pongs
+// of the reader
+type wsConn struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf = appendFrame(c.buf[:0], op, payload, rand.Uint32())
+	_, err := c.w.Write(c.buf)
+	return err
+}
+
+// appendFrame appends a final frame of payload masked with key, as clients
+// must send them
+func appendFrame(buf []byte, op byte, payload []byte, key uint32) []byte {
+	buf = append(buf, 0x80|op)
+	n := len(payload)
+	switch {
+	case n < 126:
+		buf = append(buf, 0x80|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 0x80|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0x80|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, key)
+	mask := buf[len(buf)-4:]
+	start := len(buf)
+	buf = append(buf, payload...)
+	for i := range buf[start:] {
+		buf[start+i] ^= mask[i%4]
+	}
+	return buf
+}
+
+// wsReader reads messages from the server, reassembling fragmented ones.
+// Control frames are returned as they arrive, even between fragments.
+type wsReader struct {
+	r   *bufio.Reader
+	op  byte
+	buf []byte
+}
+
+// next returns the next message or control frame. The payload is only
+// valid until the next call.
+func (r *wsReader) next() (byte, []byte, error) {
+	for {
+		fin, op, payload, err := readFrame(r.r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if op >= wsOpClose {
+			return op, payload, nil
+		}
+		if op != wsOpContinuation {
+			r.op = op
+			r.buf = r.buf[:0]
+		}
+		r.buf = append(r.buf, payload...)
+		if fin {
+			return r.op, r.buf, nil
+		}
+	}
+}
+
+var errWSFrameTooLarge = errors.New("websocket frame too large")
+
+// readFrame reads a single frame, unmasking it if the server masked it
+func readFrame(r *bufio.Reader) (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxFrame {
+		err = fmt.Errorf("%w: %d bytes", errWSFrameTooLarge, n)
+		return
+	}
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(r, key[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"fmt"
-	"h2loadGo/h2load"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/galbarnahum/h2loadGo/h2load"
 )
 
 func main() {
@@ -0,0 +1,4 @@
+package main
+
+// Links a pure-Go SQLite driver for -store, history and compare
+import _ "modernc.org/sqlite"